@@ -43,10 +43,16 @@ func (p *Program) String() string {
 }
 
 // LetStatement let statement
+// LetStatement let Name = Value，或解构赋值let Pattern = Value，此时Name为nil。
+// Pattern为ArrayPattern或HashPattern，值不匹配模式时运行时出错。
+// Token为var时定义的变量可以重新赋值，见Mutable。
+// Type为let name: Type = Value中的类型注解，没有注解时为nil
 type LetStatement struct {
-	Token token.Token
-	Name  *Identifier
-	Value Expression
+	Token   token.Token
+	Name    *Identifier
+	Type    Type
+	Pattern Expression
+	Value   Expression
 }
 
 func (ls *LetStatement) StatementNode() {}
@@ -55,11 +61,23 @@ func (ls *LetStatement) TokenLiteral() string {
 	return ls.Token.Literal
 }
 
+// Mutable 语句为var时返回true，定义的变量可以用AssignExpression重新赋值
+func (ls *LetStatement) Mutable() bool {
+	return ls.Token.Type == token.VAR
+}
+
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
 	out.WriteString(ls.TokenLiteral() + " ")
-	out.WriteString(ls.Name.String())
+	if ls.Pattern != nil {
+		out.WriteString(ls.Pattern.String())
+	} else {
+		out.WriteString(ls.Name.String())
+	}
+	if ls.Type != nil {
+		out.WriteString(": " + ls.Type.String())
+	}
 	out.WriteString("=")
 
 	if ls.Value != nil {
@@ -241,6 +259,7 @@ func (ie *IfExpression) String() string {
 type BlockStatement struct {
 	Token      token.Token
 	Statements []Statement
+	Rbrace     token.Token // 结束代码块的右大括号
 }
 
 func (bs *BlockStatement) ExpressionNode() {
@@ -262,7 +281,20 @@ func (bs *BlockStatement) String() string {
 type FunctionLiteral struct {
 	Token      token.Token
 	Parameters []*Identifier
-	Body       *BlockStatement
+	// ParameterTypes 参数的类型注解，与Parameters一一对应，没有注解的参数为nil；
+	// 所有参数都没有注解时整个切片为nil，见ParameterType
+	ParameterTypes []Type
+	ReturnType     Type // 返回值的类型注解，可以为nil
+	Body           *BlockStatement
+	Name           string // 通过let绑定时的变量名，用于递归调用和错误信息
+}
+
+// ParameterType 返回第i个参数的类型注解，没有注解时返回nil
+func (fl *FunctionLiteral) ParameterType(i int) Type {
+	if i < len(fl.ParameterTypes) {
+		return fl.ParameterTypes[i]
+	}
+	return nil
 }
 
 func (fl *FunctionLiteral) ExpressionNode() {
@@ -278,11 +310,18 @@ func (fl *FunctionLiteral) String() string {
 	var params []string
 	out.WriteString("fn")
 	out.WriteString("(")
-	for _, param := range fl.Parameters {
-		params = append(params, param.TokenLiteral())
+	for i, param := range fl.Parameters {
+		if t := fl.ParameterType(i); t != nil {
+			params = append(params, param.TokenLiteral()+": "+t.String())
+		} else {
+			params = append(params, param.TokenLiteral())
+		}
 	}
 	out.WriteString(strings.Join(params, ","))
 	out.WriteString(")")
+	if fl.ReturnType != nil {
+		out.WriteString(": " + fl.ReturnType.String())
+	}
 	out.WriteString("{ ")
 	out.WriteString(fl.Body.String())
 	out.WriteString(" }")
@@ -387,9 +426,35 @@ func (ie *IndexExpression) String() string {
 	return out.String()
 }
 
+// AssignExpression 赋值表达式Target = Value，值为赋的值。
+// Target为var定义的变量，或数组、哈希的下标表达式
+type AssignExpression struct {
+	Token  token.Token // =
+	Target Expression
+	Value  Expression
+}
+
+func (ae *AssignExpression) ExpressionNode() {}
+
+func (ae *AssignExpression) TokenLiteral() string {
+	return ae.Token.Literal
+}
+
+func (ae *AssignExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(ae.Target.String())
+	out.WriteString(" = ")
+	out.WriteString(ae.Value.String())
+	out.WriteString(")")
+	return out.String()
+}
+
 type HashLiteral struct {
 	Token token.Token
 	Pairs map[Expression]Expression
+	Keys  []Expression // 键在源码中出现的顺序
 }
 
 func (hl *HashLiteral) ExpressionNode() {
@@ -413,3 +478,158 @@ func (hl *HashLiteral) String() string {
 
 	return out.String()
 }
+
+// TryExpression try { Block } catch (Param) { Handler }。
+// Block出错时把错误绑定到Param并执行Handler，表达式的值为执行的最后一个代码块的值
+type TryExpression struct {
+	Token   token.Token
+	Block   *BlockStatement
+	Param   *Identifier
+	Handler *BlockStatement
+}
+
+func (te *TryExpression) ExpressionNode() {
+
+}
+
+func (te *TryExpression) TokenLiteral() string {
+	return te.Token.Literal
+}
+
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("try")
+	out.WriteString(fmt.Sprintf(" { %v }", te.Block))
+	out.WriteString(fmt.Sprintf(" catch ( %v )", te.Param))
+	out.WriteString(fmt.Sprintf(" { %v }", te.Handler))
+	return out.String()
+}
+
+// MatchExpression match (Subject) { Arms }：按顺序尝试每个分支，值为第一个匹配的分支的Body，
+// 没有分支匹配时为null
+type MatchExpression struct {
+	Token   token.Token
+	Subject Expression
+	Arms    []*MatchArm
+}
+
+func (me *MatchExpression) ExpressionNode() {
+
+}
+
+func (me *MatchExpression) TokenLiteral() string {
+	return me.Token.Literal
+}
+
+func (me *MatchExpression) String() string {
+	var arms []string
+	for _, arm := range me.Arms {
+		arms = append(arms, arm.String())
+	}
+	return fmt.Sprintf("match ( %v ) { %s }", me.Subject, strings.Join(arms, ", "))
+}
+
+// MatchArm match的一个分支：Pattern if Guard => Body，Guard可以为nil。
+// 模式匹配后先绑定其中的变量，再对Guard求值，Guard为假时继续尝试后面的分支
+type MatchArm struct {
+	Token   token.Token // 模式的第一个词法单元
+	Pattern Expression
+	Guard   Expression
+	Body    Expression
+}
+
+func (ma *MatchArm) TokenLiteral() string {
+	return ma.Token.Literal
+}
+
+func (ma *MatchArm) String() string {
+	var out bytes.Buffer
+	out.WriteString(ma.Pattern.String())
+	if ma.Guard != nil {
+		out.WriteString(" if " + ma.Guard.String())
+	}
+	out.WriteString(" => ")
+	out.WriteString(ma.Body.String())
+	return out.String()
+}
+
+// ArrayPattern 数组模式[a, b, ...rest]：匹配长度与Elements相同的数组，
+// 有Rest时长度不少于Elements，其余的元素组成新数组绑定到Rest
+type ArrayPattern struct {
+	Token    token.Token
+	Elements []Expression
+	Rest     *Identifier
+}
+
+func (ap *ArrayPattern) ExpressionNode() {
+
+}
+
+func (ap *ArrayPattern) TokenLiteral() string {
+	return ap.Token.Literal
+}
+
+func (ap *ArrayPattern) String() string {
+	var elements []string
+	for _, element := range ap.Elements {
+		elements = append(elements, element.String())
+	}
+	if ap.Rest != nil {
+		elements = append(elements, "..."+ap.Rest.String())
+	}
+	return "[" + strings.Join(elements, ",") + "]"
+}
+
+// HashPattern 哈希模式{"kind": k}：匹配包含所有Keys的哈希，且每个键对应的值匹配Values中的模式。
+// 键为字符串、整数或布尔字面量
+type HashPattern struct {
+	Token  token.Token
+	Keys   []Expression
+	Values []Expression
+}
+
+func (hp *HashPattern) ExpressionNode() {
+
+}
+
+func (hp *HashPattern) TokenLiteral() string {
+	return hp.Token.Literal
+}
+
+func (hp *HashPattern) String() string {
+	var pairs []string
+	for i, key := range hp.Keys {
+		pairs = append(pairs, fmt.Sprintf("%v:%v", key, hp.Values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Wildcard 模式中匹配任意值但不绑定的名字
+const Wildcard = "_"
+
+// PatternBindings 按源码顺序返回模式中绑定的变量，不包括_
+func PatternBindings(pattern Expression) []*Identifier {
+	var names []*Identifier
+	var collect func(pattern Expression)
+	collect = func(pattern Expression) {
+		switch pattern := pattern.(type) {
+		case *Identifier:
+			if pattern.Value != Wildcard {
+				names = append(names, pattern)
+			}
+		case *ArrayPattern:
+			for _, element := range pattern.Elements {
+				collect(element)
+			}
+			if pattern.Rest != nil {
+				collect(pattern.Rest)
+			}
+		case *HashPattern:
+			for _, value := range pattern.Values {
+				collect(value)
+			}
+		}
+	}
+	collect(pattern)
+	return names
+}
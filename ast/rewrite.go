@@ -0,0 +1,226 @@
+package ast
+
+import "fmt"
+
+// Rewrite 以后序遍历改写语法树：先改写node的子节点，再调用f(node)，
+// 用f的返回值替换该节点，最终返回改写后的根节点。
+// 子节点会被原地替换；f返回nil时，该节点会从所在的列表中删除，单个子节点则被置为nil。
+// 替换后的节点必须能放在原来的位置上（例如表达式只能替换为表达式），否则会panic
+func Rewrite(node Node, f func(Node) Node) Node {
+	if isNil(node) {
+		return node
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		n.Statements = rewriteStatements(n.Statements, f)
+	case *LetStatement:
+		n.Name = rewriteIdentifier(n.Name, f)
+		n.Type = rewriteType(n.Type, f)
+		n.Pattern = rewriteExpression(n.Pattern, f)
+		n.Value = rewriteExpression(n.Value, f)
+	case *ReturnStatement:
+		n.ReturnValue = rewriteExpression(n.ReturnValue, f)
+	case *ExpressionStatement:
+		n.Expression = rewriteExpression(n.Expression, f)
+	case *BlockStatement:
+		n.Statements = rewriteStatements(n.Statements, f)
+	case *PrefixExpression:
+		n.Right = rewriteExpression(n.Right, f)
+	case *InfixExpression:
+		n.Left = rewriteExpression(n.Left, f)
+		n.Right = rewriteExpression(n.Right, f)
+	case *IfExpression:
+		n.Condition = rewriteExpression(n.Condition, f)
+		n.Consequence = rewriteBlock(n.Consequence, f)
+		n.Alternative = rewriteBlock(n.Alternative, f)
+	case *MatchExpression:
+		n.Subject = rewriteExpression(n.Subject, f)
+		var arms []*MatchArm
+		for _, arm := range n.Arms {
+			if arm == nil {
+				continue
+			}
+			replaced := Rewrite(arm, f)
+			if isNil(replaced) {
+				continue
+			}
+			a, ok := replaced.(*MatchArm)
+			if !ok {
+				panic(fmt.Sprintf("ast.Rewrite: cannot replace match arm with %T", replaced))
+			}
+			arms = append(arms, a)
+		}
+		n.Arms = arms
+	case *MatchArm:
+		n.Pattern = rewriteExpression(n.Pattern, f)
+		n.Guard = rewriteExpression(n.Guard, f)
+		n.Body = rewriteExpression(n.Body, f)
+	case *ArrayPattern:
+		n.Elements = rewriteExpressions(n.Elements, f)
+		n.Rest = rewriteIdentifier(n.Rest, f)
+	case *HashPattern:
+		var keys, values []Expression
+		for i, key := range n.Keys {
+			value := rewriteExpression(n.Values[i], f)
+			key = rewriteExpression(key, f)
+			if key == nil {
+				continue
+			}
+			keys = append(keys, key)
+			values = append(values, value)
+		}
+		n.Keys, n.Values = keys, values
+	case *TryExpression:
+		n.Block = rewriteBlock(n.Block, f)
+		n.Param = rewriteIdentifier(n.Param, f)
+		n.Handler = rewriteBlock(n.Handler, f)
+	case *FunctionLiteral:
+		var params []*Identifier
+		var types []Type
+		for i, param := range n.Parameters {
+			// 删除参数时一并删除其类型注解，保持两者一一对应
+			t := rewriteType(n.ParameterType(i), f)
+			if param = rewriteIdentifier(param, f); param != nil {
+				params = append(params, param)
+				types = append(types, t)
+			}
+		}
+		n.Parameters = params
+		if n.ParameterTypes != nil {
+			n.ParameterTypes = types
+		}
+		n.ReturnType = rewriteType(n.ReturnType, f)
+		n.Body = rewriteBlock(n.Body, f)
+	case *ArrayType:
+		n.Element = rewriteType(n.Element, f)
+	case *HashType:
+		n.Key = rewriteType(n.Key, f)
+		n.Value = rewriteType(n.Value, f)
+	case *FunctionType:
+		var params []Type
+		for _, param := range n.Parameters {
+			if param = rewriteType(param, f); param != nil {
+				params = append(params, param)
+			}
+		}
+		n.Parameters = params
+		n.Return = rewriteType(n.Return, f)
+	case *CallExpression:
+		n.Function = rewriteExpression(n.Function, f)
+		n.Arguments = rewriteExpressions(n.Arguments, f)
+	case *ArrayLiteral:
+		n.Elements = rewriteExpressions(n.Elements, f)
+	case *IndexExpression:
+		n.Left = rewriteExpression(n.Left, f)
+		n.Index = rewriteExpression(n.Index, f)
+	case *AssignExpression:
+		n.Target = rewriteExpression(n.Target, f)
+		n.Value = rewriteExpression(n.Value, f)
+	case *HashLiteral:
+		pairs := make(map[Expression]Expression)
+		var keys []Expression
+		for _, key := range n.OrderedKeys() {
+			value := rewriteExpression(n.Pairs[key], f)
+			key = rewriteExpression(key, f)
+			if key == nil {
+				continue
+			}
+			pairs[key] = value
+			keys = append(keys, key)
+		}
+		n.Pairs = pairs
+		n.Keys = keys
+	}
+
+	return f(node)
+}
+
+func rewriteStatements(list []Statement, f func(Node) Node) []Statement {
+	var result []Statement
+	for _, stmt := range list {
+		if isNil(stmt) {
+			continue
+		}
+		replaced := Rewrite(stmt, f)
+		if isNil(replaced) {
+			continue
+		}
+		s, ok := replaced.(Statement)
+		if !ok {
+			panic(fmt.Sprintf("ast.Rewrite: cannot replace statement with %T", replaced))
+		}
+		result = append(result, s)
+	}
+	return result
+}
+
+func rewriteExpressions(list []Expression, f func(Node) Node) []Expression {
+	var result []Expression
+	for _, exp := range list {
+		if exp = rewriteExpression(exp, f); exp != nil {
+			result = append(result, exp)
+		}
+	}
+	return result
+}
+
+func rewriteExpression(exp Expression, f func(Node) Node) Expression {
+	if isNil(exp) {
+		return exp
+	}
+	replaced := Rewrite(exp, f)
+	if isNil(replaced) {
+		return nil
+	}
+	e, ok := replaced.(Expression)
+	if !ok {
+		panic(fmt.Sprintf("ast.Rewrite: cannot replace expression with %T", replaced))
+	}
+	return e
+}
+
+func rewriteIdentifier(ident *Identifier, f func(Node) Node) *Identifier {
+	if ident == nil {
+		return nil
+	}
+	replaced := Rewrite(ident, f)
+	if isNil(replaced) {
+		return nil
+	}
+	i, ok := replaced.(*Identifier)
+	if !ok {
+		panic(fmt.Sprintf("ast.Rewrite: cannot replace identifier with %T", replaced))
+	}
+	return i
+}
+
+func rewriteType(t Type, f func(Node) Node) Type {
+	if isNil(t) {
+		return nil
+	}
+	replaced := Rewrite(t, f)
+	if isNil(replaced) {
+		return nil
+	}
+	r, ok := replaced.(Type)
+	if !ok {
+		panic(fmt.Sprintf("ast.Rewrite: cannot replace type with %T", replaced))
+	}
+	return r
+}
+
+func rewriteBlock(block *BlockStatement, f func(Node) Node) *BlockStatement {
+	if block == nil {
+		return nil
+	}
+	replaced := Rewrite(block, f)
+	if isNil(replaced) {
+		return nil
+	}
+	b, ok := replaced.(*BlockStatement)
+	if !ok {
+		panic(fmt.Sprintf("ast.Rewrite: cannot replace block with %T", replaced))
+	}
+	return b
+}
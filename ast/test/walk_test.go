@@ -0,0 +1,159 @@
+package test
+
+import (
+	"Monkey/ast"
+	"Monkey/lexer"
+	"Monkey/parser"
+	"Monkey/token"
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	parser.CheckErrors(t, p)
+	return program
+}
+
+func TestInspect(t *testing.T) {
+	program := parse(t, `let f = fn(x) { if (x > 1) { [x, {"k": -x}] } else { f(x)[0] } };`)
+
+	var visited []string
+	ast.Inspect(program, func(node ast.Node) bool {
+		if node != nil {
+			visited = append(visited, fmt.Sprintf("%T", node))
+		}
+		return true
+	})
+
+	expected := []string{
+		"*ast.Program", "*ast.LetStatement", "*ast.Identifier", "*ast.FunctionLiteral",
+		"*ast.Identifier", "*ast.BlockStatement", "*ast.ExpressionStatement", "*ast.IfExpression",
+		"*ast.InfixExpression", "*ast.Identifier", "*ast.IntegerLiteral",
+		"*ast.BlockStatement", "*ast.ExpressionStatement", "*ast.ArrayLiteral", "*ast.Identifier",
+		"*ast.HashLiteral", "*ast.StringLiteral", "*ast.PrefixExpression", "*ast.Identifier",
+		"*ast.BlockStatement", "*ast.ExpressionStatement", "*ast.IndexExpression", "*ast.CallExpression",
+		"*ast.Identifier", "*ast.Identifier", "*ast.IntegerLiteral",
+	}
+	if len(visited) != len(expected) {
+		t.Fatalf("wrong number of nodes visited. want=%d, got=%d\n%v", len(expected), len(visited), visited)
+	}
+	for i := range expected {
+		if visited[i] != expected[i] {
+			t.Errorf("node %d wrong. want=%s, got=%s", i, expected[i], visited[i])
+		}
+	}
+}
+
+func TestInspectMatch(t *testing.T) {
+	program := parse(t, `match (x) { [a, ...r] if a => 1, {"k": b} => b }`)
+
+	var visited []string
+	ast.Inspect(program, func(node ast.Node) bool {
+		if node != nil {
+			visited = append(visited, fmt.Sprintf("%T", node))
+		}
+		return true
+	})
+
+	expected := []string{
+		"*ast.Program", "*ast.ExpressionStatement", "*ast.MatchExpression", "*ast.Identifier",
+		"*ast.MatchArm", "*ast.ArrayPattern", "*ast.Identifier", "*ast.Identifier",
+		"*ast.Identifier", "*ast.IntegerLiteral",
+		"*ast.MatchArm", "*ast.HashPattern", "*ast.StringLiteral", "*ast.Identifier", "*ast.Identifier",
+	}
+	if fmt.Sprint(visited) != fmt.Sprint(expected) {
+		t.Fatalf("wrong nodes visited.\nwant=%v\ngot =%v", expected, visited)
+	}
+}
+
+func TestInspectSkipsChildren(t *testing.T) {
+	program := parse(t, `let a = fn(x) { x }; a(1);`)
+
+	identifiers := 0
+	ast.Inspect(program, func(node ast.Node) bool {
+		if _, ok := node.(*ast.Identifier); ok {
+			identifiers++
+		}
+		_, isFunction := node.(*ast.FunctionLiteral)
+		return !isFunction
+	})
+
+	// a, a；函数字面量内部的x不会被访问
+	if identifiers != 2 {
+		t.Errorf("wrong number of identifiers. want=2, got=%d", identifiers)
+	}
+}
+
+func TestInspectPartialTree(t *testing.T) {
+	p := parser.New(lexer.New("let x = ; if (y { 1 }"))
+	program := p.ParseProgram()
+
+	count := 0
+	ast.Inspect(program, func(node ast.Node) bool {
+		count++
+		return true
+	})
+	if count == 0 {
+		t.Fatalf("nothing visited")
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	program := parse(t, `let x = 1 + 2; fn(a) { a * (3 + 4) }; [1 + 1, {"k": 2 + 2}]`)
+
+	// 折叠整数常量的加法
+	ast.Rewrite(program, func(node ast.Node) ast.Node {
+		infix, ok := node.(*ast.InfixExpression)
+		if !ok || infix.Operator != "+" {
+			return node
+		}
+		left, ok1 := infix.Left.(*ast.IntegerLiteral)
+		right, ok2 := infix.Right.(*ast.IntegerLiteral)
+		if !ok1 || !ok2 {
+			return node
+		}
+		value := left.Value + right.Value
+		literal := strconv.FormatInt(value, 10)
+		return &ast.IntegerLiteral{Token: token.Token{Type: token.INT, Literal: literal}, Value: value}
+	})
+
+	expected := `let x=3;fn(a){ (a * 7) }[2,{k:4}]`
+	if program.String() != expected {
+		t.Fatalf("wrong rewrite result.\nwant=%s\ngot =%s", expected, program.String())
+	}
+}
+
+func TestRewriteRemovesStatements(t *testing.T) {
+	program := parse(t, `let a = 1; 2; let b = 3;`)
+
+	ast.Rewrite(program, func(node ast.Node) ast.Node {
+		if _, ok := node.(*ast.ExpressionStatement); ok {
+			return nil
+		}
+		return node
+	})
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("wrong number of statements. want=2, got=%d", len(program.Statements))
+	}
+}
+
+func TestRewritePanicsOnInvalidReplacement(t *testing.T) {
+	program := parse(t, `1 + 2`)
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected panic")
+		}
+	}()
+	ast.Rewrite(program, func(node ast.Node) ast.Node {
+		if _, ok := node.(*ast.IntegerLiteral); ok {
+			return &ast.LetStatement{}
+		}
+		return node
+	})
+}
@@ -0,0 +1,92 @@
+package ast
+
+import (
+	"Monkey/token"
+	"bytes"
+	"strings"
+)
+
+// Type 类型注解，只用于静态类型检查，求值器和编译器忽略
+type Type interface {
+	Node
+	TypeNode()
+}
+
+// NamedType 具名类型：int、string、bool、null或any
+type NamedType struct {
+	Token token.Token
+	Name  string
+}
+
+func (nt *NamedType) TypeNode() {}
+
+func (nt *NamedType) TokenLiteral() string {
+	return nt.Token.Literal
+}
+
+func (nt *NamedType) String() string {
+	return nt.Name
+}
+
+// ArrayType 元素类型为Element的数组：[Element]
+type ArrayType struct {
+	Token   token.Token // [
+	Element Type
+}
+
+func (at *ArrayType) TypeNode() {}
+
+func (at *ArrayType) TokenLiteral() string {
+	return at.Token.Literal
+}
+
+func (at *ArrayType) String() string {
+	return "[" + at.Element.String() + "]"
+}
+
+// HashType 键和值的类型分别为Key和Value的哈希：{Key: Value}
+type HashType struct {
+	Token token.Token // {
+	Key   Type
+	Value Type
+}
+
+func (ht *HashType) TypeNode() {}
+
+func (ht *HashType) TokenLiteral() string {
+	return ht.Token.Literal
+}
+
+func (ht *HashType) String() string {
+	return "{" + ht.Key.String() + ": " + ht.Value.String() + "}"
+}
+
+// FunctionType 函数类型：fn(Parameters): Return，没有注明返回值类型时Return为nil
+type FunctionType struct {
+	Token      token.Token // fn
+	Parameters []Type
+	Return     Type
+}
+
+func (ft *FunctionType) TypeNode() {}
+
+func (ft *FunctionType) TokenLiteral() string {
+	return ft.Token.Literal
+}
+
+func (ft *FunctionType) String() string {
+	var out bytes.Buffer
+
+	var params []string
+	for _, param := range ft.Parameters {
+		params = append(params, param.String())
+	}
+	out.WriteString("fn(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(")")
+	if ft.Return != nil {
+		out.WriteString(": ")
+		out.WriteString(ft.Return.String())
+	}
+	return out.String()
+}
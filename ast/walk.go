@@ -0,0 +1,161 @@
+package ast
+
+import (
+	"reflect"
+	"sort"
+)
+
+// Visitor Walk遍历到每个节点时调用Visit。
+// 返回的Visitor w不为nil时，Walk用w遍历node的子节点，最后调用w.Visit(nil)
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk 以深度优先的顺序遍历语法树，用法与go/ast.Walk一致。
+// 解析出错时语法树中可能存在nil子节点，Walk会跳过它们
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		walkStatements(v, n.Statements)
+	case *LetStatement:
+		walkIfNotNil(v, n.Name)
+		walkIfNotNil(v, n.Type)
+		walkIfNotNil(v, n.Pattern)
+		walkIfNotNil(v, n.Value)
+	case *ReturnStatement:
+		walkIfNotNil(v, n.ReturnValue)
+	case *ExpressionStatement:
+		walkIfNotNil(v, n.Expression)
+	case *BlockStatement:
+		walkStatements(v, n.Statements)
+	case *PrefixExpression:
+		walkIfNotNil(v, n.Right)
+	case *InfixExpression:
+		walkIfNotNil(v, n.Left)
+		walkIfNotNil(v, n.Right)
+	case *IfExpression:
+		walkIfNotNil(v, n.Condition)
+		walkIfNotNil(v, n.Consequence)
+		walkIfNotNil(v, n.Alternative)
+	case *MatchExpression:
+		walkIfNotNil(v, n.Subject)
+		for _, arm := range n.Arms {
+			walkIfNotNil(v, arm)
+		}
+	case *MatchArm:
+		walkIfNotNil(v, n.Pattern)
+		walkIfNotNil(v, n.Guard)
+		walkIfNotNil(v, n.Body)
+	case *ArrayPattern:
+		walkExpressions(v, n.Elements)
+		walkIfNotNil(v, n.Rest)
+	case *HashPattern:
+		for i, key := range n.Keys {
+			walkIfNotNil(v, key)
+			walkIfNotNil(v, n.Values[i])
+		}
+	case *TryExpression:
+		walkIfNotNil(v, n.Block)
+		walkIfNotNil(v, n.Param)
+		walkIfNotNil(v, n.Handler)
+	case *FunctionLiteral:
+		for i, param := range n.Parameters {
+			walkIfNotNil(v, param)
+			walkIfNotNil(v, n.ParameterType(i))
+		}
+		walkIfNotNil(v, n.ReturnType)
+		walkIfNotNil(v, n.Body)
+	case *ArrayType:
+		walkIfNotNil(v, n.Element)
+	case *HashType:
+		walkIfNotNil(v, n.Key)
+		walkIfNotNil(v, n.Value)
+	case *FunctionType:
+		for _, param := range n.Parameters {
+			walkIfNotNil(v, param)
+		}
+		walkIfNotNil(v, n.Return)
+	case *CallExpression:
+		walkIfNotNil(v, n.Function)
+		walkExpressions(v, n.Arguments)
+	case *ArrayLiteral:
+		walkExpressions(v, n.Elements)
+	case *IndexExpression:
+		walkIfNotNil(v, n.Left)
+		walkIfNotNil(v, n.Index)
+	case *AssignExpression:
+		walkIfNotNil(v, n.Target)
+		walkIfNotNil(v, n.Value)
+	case *HashLiteral:
+		for _, key := range n.OrderedKeys() {
+			walkIfNotNil(v, key)
+			walkIfNotNil(v, n.Pairs[key])
+		}
+	case *Identifier, *IntegerLiteral, *Boolean, *StringLiteral, *NamedType:
+		// 叶子节点
+	}
+
+	v.Visit(nil)
+}
+
+func walkStatements(v Visitor, list []Statement) {
+	for _, stmt := range list {
+		walkIfNotNil(v, stmt)
+	}
+}
+
+func walkExpressions(v Visitor, list []Expression) {
+	for _, exp := range list {
+		walkIfNotNil(v, exp)
+	}
+}
+
+// walkIfNotNil 跳过nil接口以及包含nil指针的接口
+func walkIfNotNil(v Visitor, node Node) {
+	if !isNil(node) {
+		Walk(v, node)
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect 以深度优先的顺序遍历语法树，对每个节点调用f(node)；
+// f返回true时继续遍历其子节点，之后调用f(nil)
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+// OrderedKeys 按源码顺序返回哈希字面量的键；
+// 手工构造、没有记录顺序的字面量按键的字符串形式排序
+func (hl *HashLiteral) OrderedKeys() []Expression {
+	if len(hl.Keys) == len(hl.Pairs) {
+		return hl.Keys
+	}
+	keys := make([]Expression, 0, len(hl.Pairs))
+	for key := range hl.Pairs {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+	return keys
+}
+
+func isNil(node Node) bool {
+	if node == nil {
+		return true
+	}
+	v := reflect.ValueOf(node)
+	return v.Kind() == reflect.Pointer && v.IsNil()
+}
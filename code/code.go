@@ -29,6 +29,37 @@ const (
 	OpNull
 	OpSetGlobal
 	OpGetGlobal
+	OpArray
+	OpHash
+	OpIndex
+	OpCall
+	OpReturnValue
+	OpReturn
+	OpGetLocal
+	OpSetLocal
+	OpGetBuiltin
+	OpClosure
+	OpGetFree
+	OpCurrentClosure
+	OpImport
+	OpModule
+	OpAddConst
+	OpSubConst
+	OpJumpNotEqual
+	OpJumpEqual
+	OpJumpNotGreater
+	OpIsArray
+	OpIsHash
+	OpHasKey
+	OpSliceFrom
+	OpCheckArray
+	OpCheckHash
+	OpCheckKey
+	OpCheckEqual
+	OpSetIndex
+	OpMakeCell
+	OpLoadCell
+	OpStoreCell
 )
 
 type Definition struct {
@@ -36,6 +67,15 @@ type Definition struct {
 	OperandWidths []int
 }
 
+// Width 返回所有操作数的总字节数
+func (d *Definition) Width() int {
+	width := 0
+	for _, w := range d.OperandWidths {
+		width += w
+	}
+	return width
+}
+
 var definitions = map[Opcode]*Definition{
 	OpConstant:      {"OpConstant", []int{2}},
 	OpAdd:           {"OpAdd", []int{}},
@@ -55,6 +95,44 @@ var definitions = map[Opcode]*Definition{
 	OpNull:          {"OpNull", []int{}},
 	OpSetGlobal:     {"OpSetGlobal", []int{2}},
 	OpGetGlobal:     {"OpGetGlobal", []int{2}},
+	OpArray:         {"OpArray", []int{2}}, // 操作数为数组元素个数
+	OpHash:          {"OpHash", []int{2}},  // 操作数为键和值的总个数
+	OpIndex:         {"OpIndex", []int{}},
+	// 函数调用
+	OpCall:           {"OpCall", []int{2}}, // 操作数为参数个数
+	OpReturnValue:    {"OpReturnValue", []int{}},
+	OpReturn:         {"OpReturn", []int{}},
+	OpGetLocal:       {"OpGetLocal", []int{2}},
+	OpSetLocal:       {"OpSetLocal", []int{2}},
+	OpGetBuiltin:     {"OpGetBuiltin", []int{1}},
+	OpClosure:        {"OpClosure", []int{2, 2}}, // 操作数为函数在常量池中的索引、自由变量个数
+	OpGetFree:        {"OpGetFree", []int{2}},
+	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+	// 模块
+	OpImport: {"OpImport", []int{2, 2}}, // 操作数为模块函数在常量池中的索引、缓存模块的全局变量索引
+	OpModule: {"OpModule", []int{2}},    // 操作数为模块路径在常量池中的索引，用栈顶的哈希构造模块
+	// 超级指令，由编译器在优化级别3下把常见的指令序列合并而成
+	OpAddConst:       {"OpAddConst", []int{2}},       // OpConstant+OpAdd，操作数为右操作数在常量池中的索引
+	OpSubConst:       {"OpSubConst", []int{2}},       // OpConstant+OpSub
+	OpJumpNotEqual:   {"OpJumpNotEqual", []int{2}},   // OpEqual+OpJumpNotTruthy，栈顶两个值不相等时跳转
+	OpJumpEqual:      {"OpJumpEqual", []int{2}},      // OpNotEqual+OpJumpNotTruthy，栈顶两个值相等时跳转
+	OpJumpNotGreater: {"OpJumpNotGreater", []int{2}}, // OpGreaterThan+OpJumpNotTruthy
+	// 模式匹配
+	OpIsArray:   {"OpIsArray", []int{2, 1}}, // 操作数为元素个数、是否有...rest；栈顶是长度为n（有rest时不少于n）的数组时压入true
+	OpIsHash:    {"OpIsHash", []int{}},
+	OpHasKey:    {"OpHasKey", []int{}},     // 弹出哈希和键，哈希包含该键时压入true
+	OpSliceFrom: {"OpSliceFrom", []int{2}}, // 弹出数组，压入从操作数开始的元素组成的新数组
+	// 解构赋值，弹出检查的值，不符合要求时出错
+	OpCheckArray: {"OpCheckArray", []int{2, 1}}, // 操作数与OpIsArray相同
+	OpCheckHash:  {"OpCheckHash", []int{}},
+	OpCheckKey:   {"OpCheckKey", []int{}},   // 弹出哈希和键
+	OpCheckEqual: {"OpCheckEqual", []int{}}, // 弹出值和字面量
+	// 赋值
+	OpSetIndex: {"OpSetIndex", []int{}}, // 弹出容器、下标和值，修改容器后压入值
+	// 闭包可能修改的var局部变量保存在单元中，闭包捕获单元本身，与外层函数共享同一个值
+	OpMakeCell:  {"OpMakeCell", []int{}},  // 弹出值，压入保存该值的单元
+	OpLoadCell:  {"OpLoadCell", []int{}},  // 弹出单元，压入其中的值
+	OpStoreCell: {"OpStoreCell", []int{}}, // 弹出单元和值，把值保存到单元中
 }
 
 // Lookup 传入opcode的byte
@@ -67,7 +145,8 @@ func Lookup(op byte) (*Definition, error) {
 	return res, nil
 }
 
-// Make 传入操作码、操作数，得到字节码,其中操作数使用大端编码
+// Make 传入操作码、操作数，得到字节码,其中操作数使用大端编码，宽度可以为1、2或4字节。
+// 超出宽度的操作数被截断，需要检查时使用MakeChecked
 //
 //	 for example :
 //		Make(OpConstant, []int{65534})
@@ -78,27 +157,64 @@ func Make(op Opcode, operand ...int) []byte {
 		return []byte{}
 	}
 
-	var instruction []byte
-	if len(def.OperandWidths) == 0 {
-		instruction = make([]byte, 1)
-		instruction[0] = byte(op)
-	} else {
-		instructionLen := def.OperandWidths[0] + 1
-		instruction = make([]byte, instructionLen)
-		instruction[0] = byte(op)
-	}
+	instruction := make([]byte, 1+def.Width())
+	instruction[0] = byte(op)
+
 	offset := 1
 	for i, o := range operand {
 		with := def.OperandWidths[i]
 		switch with {
+		case 1:
+			instruction[offset] = byte(o)
 		case 2:
 			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 4:
+			binary.BigEndian.PutUint32(instruction[offset:], uint32(o))
 		}
 		offset += with
 	}
 	return instruction
 }
 
+// OperandError 操作数超出了指令定义的宽度能表示的范围
+type OperandError struct {
+	Op      Opcode
+	Index   int // 第几个操作数，从0开始
+	Operand int
+}
+
+func (e *OperandError) Error() string {
+	return fmt.Sprintf("operand %d of %s out of range: %d not in [0, %d]", e.Index, e.Op, e.Operand, e.Max())
+}
+
+// Max 返回该操作数允许的最大值
+func (e *OperandError) Max() int {
+	return MaxOperand(definitions[e.Op].OperandWidths[e.Index])
+}
+
+// MaxOperand 返回width字节宽的操作数能表示的最大值
+func MaxOperand(width int) int {
+	return 1<<(8*width) - 1
+}
+
+// MakeChecked 与Make相同，但操作码未定义、操作数个数不对或超出范围时返回错误，
+// 超出范围时为*OperandError
+func MakeChecked(op Opcode, operand ...int) ([]byte, error) {
+	def, ok := definitions[op]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+	if len(operand) != len(def.OperandWidths) {
+		return nil, fmt.Errorf("%s takes %d operands, got %d", op, len(def.OperandWidths), len(operand))
+	}
+	for i, o := range operand {
+		if o < 0 || o > MaxOperand(def.OperandWidths[i]) {
+			return nil, &OperandError{Op: op, Index: i, Operand: o}
+		}
+	}
+	return Make(op, operand...), nil
+}
+
 // ReadOperands : Make 的逆过程
 // 传入opcode的定义、字节码指令
 // 返回字节码的操作数operands和指令长度
@@ -108,14 +224,28 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 
 	for i, width := range def.OperandWidths {
 		switch width {
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
 		case 2:
 			operands[i] = int(ReadUnit16(ins[offset:]))
+		case 4:
+			operands[i] = int(ReadUint32(ins[offset:]))
 		}
 		offset += width
 	}
 	return operands, offset
 }
 
+// ReadUint8 读取1字节的操作数
+func ReadUint8(ins []byte) uint8 {
+	return ins[0]
+}
+
+// ReadUint32 读取4字节的操作数
+func ReadUint32(ins []byte) uint32 {
+	return binary.BigEndian.Uint32(ins)
+}
+
 // ReadUnit16 辅助函数
 // 将[]byte转化为uint16
 func ReadUnit16(ins []byte) uint16 {
@@ -129,6 +259,7 @@ func (ins Instructions) String() string {
 		def, err := Lookup(ins[i])
 		if err != nil {
 			fmt.Fprintf(&out, "Error:%s\n", err)
+			i++
 			continue
 		}
 
@@ -139,6 +270,25 @@ func (ins Instructions) String() string {
 	return out.String()
 }
 
+// Format 格式化从ip开始的一条指令，如"OpConstant 1"
+func (ins Instructions) Format(ip int) string {
+	def, err := Lookup(ins[ip])
+	if err != nil {
+		return fmt.Sprintf("Error:%s", err)
+	}
+	operands, _ := ReadOperands(def, ins[ip+1:])
+	return ins.fmtInstruction(def, operands)
+}
+
+// String 返回操作码的名字，如"OpConstant"
+func (op Opcode) String() string {
+	def, ok := definitions[op]
+	if !ok {
+		return fmt.Sprintf("Opcode(%d)", byte(op))
+	}
+	return def.name
+}
+
 // fmtInstruction 辅助函数，用于将指令instructions格式化输出
 func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
 	operandsCount := len(def.OperandWidths)
@@ -151,6 +301,8 @@ func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
 		return def.name
 	case 1:
 		return fmt.Sprintf("%s %d", def.name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.name, operands[0], operands[1])
 	}
 
 	return fmt.Sprintf("ERROR:unhandled operandCount for %s\n", def.name)
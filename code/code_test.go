@@ -2,6 +2,13 @@ package code
 
 import "testing"
 
+// opWide 只用于测试的操作码，操作数宽度为1和4字节
+const opWide Opcode = 254
+
+func init() {
+	definitions[opWide] = &Definition{"opWide", []int{1, 4}}
+}
+
 func TestMake(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -19,6 +26,9 @@ func TestMake(t *testing.T) {
 		{"OpFalse", OpFalse, []int{}, []byte{byte(OpFalse)}},
 		{"OpMinus", OpMinus, []int{}, []byte{byte(OpMinus)}},
 		{"OpBang", OpBang, []int{}, []byte{byte(OpBang)}},
+		{"OpGetBuiltin", OpGetBuiltin, []int{255}, []byte{byte(OpGetBuiltin), 255}},
+		{"OpIsArray", OpIsArray, []int{2, 1}, []byte{byte(OpIsArray), 0, 2, 1}},
+		{"wide", opWide, []int{200, 65536}, []byte{byte(opWide), 200, 0, 1, 0, 0}},
 	}
 
 	for _, tt := range tests {
@@ -48,6 +58,8 @@ func TestReadOperand(t *testing.T) {
 		bytesRead int
 	}{
 		{OpConstant, []int{65535}, 2},
+		{OpGetBuiltin, []int{255}, 1},
+		{opWide, []int{255, 4294967295}, 5},
 	}
 
 	for _, tt := range tests {
@@ -107,3 +119,54 @@ func TestInstructionsString(t *testing.T) {
 		t.Fatalf("instruction wrongly formatted.\nwant=%q\ngot=%q", expected, concatted.String())
 	}
 }
+
+func TestFormat(t *testing.T) {
+	ins := Instructions{}
+	ins = append(ins, Make(OpAdd)...)
+	ins = append(ins, Make(OpClosure, 65535, 255)...)
+
+	if got := ins.Format(0); got != "OpAdd" {
+		t.Errorf("wrong format. want=%q, got=%q", "OpAdd", got)
+	}
+	if got := ins.Format(1); got != "OpClosure 65535 255" {
+		t.Errorf("wrong format. want=%q, got=%q", "OpClosure 65535 255", got)
+	}
+	if got := OpGetLocal.String(); got != "OpGetLocal" {
+		t.Errorf("wrong opcode name. want=%q, got=%q", "OpGetLocal", got)
+	}
+	if got := Opcode(255).String(); got != "Opcode(255)" {
+		t.Errorf("wrong name for undefined opcode. got=%q", got)
+	}
+}
+
+func TestMakeChecked(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operands []int
+		expected string
+	}{
+		{OpConstant, []int{65535}, ""},
+		{OpConstant, []int{65536}, "operand 0 of OpConstant out of range: 65536 not in [0, 65535]"},
+		{OpConstant, []int{-1}, "operand 0 of OpConstant out of range: -1 not in [0, 65535]"},
+		{OpGetBuiltin, []int{256}, "operand 0 of OpGetBuiltin out of range: 256 not in [0, 255]"},
+		{OpClosure, []int{1, 70000}, "operand 1 of OpClosure out of range: 70000 not in [0, 65535]"},
+		{opWide, []int{1, 4294967295}, ""},
+		{OpConstant, []int{}, "OpConstant takes 1 operands, got 0"},
+		{Opcode(253), []int{}, "opcode 253 undefined"},
+	}
+
+	for _, tt := range tests {
+		ins, err := MakeChecked(tt.op, tt.operands...)
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("%s %v: unexpected error: %s", tt.op, tt.operands, err)
+			} else if string(ins) != string(Make(tt.op, tt.operands...)) {
+				t.Errorf("%s %v: differs from Make", tt.op, tt.operands)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%s %v: wrong error. want=%q, got=%v", tt.op, tt.operands, tt.expected, err)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package code
+
+// Handler 异常表中的一项，对应一个try：执行[Start, End)中的指令出错时，
+// 虚拟机把操作数栈恢复为Depth个值（不包括局部变量），压入错误，再从Target继续执行。
+// 异常表中内层的try排在外层之前
+type Handler struct {
+	Start  int
+	End    int
+	Target int
+	Depth  int
+}
+
+// FindHandler 返回异常表中覆盖偏移ip的最内层的一项
+func FindHandler(handlers []Handler, ip int) (Handler, bool) {
+	for _, h := range handlers {
+		if ip >= h.Start && ip < h.End {
+			return h, true
+		}
+	}
+	return Handler{}, false
+}
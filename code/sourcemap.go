@@ -0,0 +1,68 @@
+package code
+
+import "sort"
+
+// SourcePos 一条语句的第一条指令的偏移及该语句在源码中的位置
+type SourcePos struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+// SourceMap 一段指令序列的调试信息：每条语句对应一项位置（按Offset升序），
+// 以及局部变量和自由变量的名字（按索引，未使用的槽位为空）
+type SourceMap struct {
+	File      string
+	Positions []SourcePos
+	Locals    []string
+	Free      []string
+}
+
+// Add 记录从offset开始的指令属于line:column处的语句。
+// 同一偏移的前一项没有生成指令，被新的一项替换
+func (m *SourceMap) Add(offset, line, column int) {
+	pos := SourcePos{Offset: offset, Line: line, Column: column}
+	if n := len(m.Positions); n > 0 && m.Positions[n-1].Offset == offset {
+		m.Positions[n-1] = pos
+		return
+	}
+	m.Positions = append(m.Positions, pos)
+}
+
+// Truncate 删除偏移不小于length的项，用于编译器删除末尾指令之后
+func (m *SourceMap) Truncate(length int) {
+	for len(m.Positions) > 0 && m.Positions[len(m.Positions)-1].Offset >= length {
+		m.Positions = m.Positions[:len(m.Positions)-1]
+	}
+}
+
+// Lookup 返回偏移为ip的指令所属语句的位置，m为nil时返回false
+func (m *SourceMap) Lookup(ip int) (SourcePos, bool) {
+	if m == nil {
+		return SourcePos{}, false
+	}
+	i := sort.Search(len(m.Positions), func(i int) bool { return m.Positions[i].Offset > ip })
+	if i == 0 {
+		return SourcePos{}, false
+	}
+	return m.Positions[i-1], true
+}
+
+// StatementAt 判断偏移为ip的指令是否为某条语句的第一条指令
+func (m *SourceMap) StatementAt(ip int) (SourcePos, bool) {
+	pos, ok := m.Lookup(ip)
+	return pos, ok && pos.Offset == ip
+}
+
+// HasLine 判断line上是否有语句
+func (m *SourceMap) HasLine(line int) bool {
+	if m == nil {
+		return false
+	}
+	for _, pos := range m.Positions {
+		if pos.Line == line {
+			return true
+		}
+	}
+	return false
+}
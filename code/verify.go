@@ -0,0 +1,292 @@
+package code
+
+import "fmt"
+
+// ConstantKind 校验时需要区分的常量种类
+type ConstantKind int
+
+const (
+	ConstantOther ConstantKind = iota
+	ConstantInteger
+	ConstantString
+	ConstantFunction
+)
+
+// Bounds 一段指令所在的环境，决定操作数的合法范围
+type Bounds struct {
+	Constants []ConstantKind // 常量池中每个常量的种类
+	Globals   int            // 全局变量的个数
+	Builtins  int            // 内置函数的个数
+	Locals    int            // 函数的局部变量个数，包括参数
+	Free      int            // 函数的自由变量个数
+	Main      bool           // 主程序可以执行到指令末尾结束，函数必须以OpReturnValue或OpReturn结束
+	Handlers  []Handler      // 指令的异常表
+}
+
+// VerifyError 指令校验失败的原因，Offset为出错指令的位置
+type VerifyError struct {
+	Offset int
+	Op     Opcode
+	Msg    string
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("%04d %s: %s", e.Offset, e.Op, e.Msg)
+}
+
+// instruction 解码后的指令
+type instruction struct {
+	op       Opcode
+	operands []int
+	next     int // 下一条指令的位置
+}
+
+// Verify 校验ins可以被虚拟机安全地执行：操作码有定义，操作数完整且在bounds的范围内，
+// 跳转目标在指令边界上，每条指令执行前栈上有足够的值，并且从不同路径到达同一条指令时栈的深度相同。
+// 异常表中的范围和入口必须在指令边界上，Depth必须与try开始处的栈深度一致。
+// 返回的错误为*VerifyError
+func Verify(ins Instructions, bounds Bounds) error {
+	decoded, offsets, err := decode(ins)
+	if err != nil {
+		return err
+	}
+
+	for _, ip := range offsets {
+		in := decoded[ip]
+		if msg := checkOperands(in, decoded, len(ins), bounds); msg != "" {
+			return &VerifyError{Offset: ip, Op: in.op, Msg: msg}
+		}
+	}
+	for i, h := range bounds.Handlers {
+		if msg := checkHandler(h, decoded, len(ins)); msg != "" {
+			op := Opcode(0)
+			if h.Start >= 0 && h.Start < len(ins) {
+				op = Opcode(ins[h.Start])
+			}
+			return &VerifyError{Offset: h.Start, Op: op, Msg: fmt.Sprintf("exception handler %d: %s", i, msg)}
+		}
+	}
+
+	depths, err := stackDepths(decoded, len(ins), bounds)
+	if err != nil {
+		return err
+	}
+	for i, h := range bounds.Handlers {
+		if depth, ok := depths[h.Start]; ok && depth != h.Depth {
+			return &VerifyError{Offset: h.Start, Op: decoded[h.Start].op, Msg: fmt.Sprintf("exception handler %d: stack depth %d does not match %d in the table", i, depth, h.Depth)}
+		}
+	}
+	return nil
+}
+
+// StackDepths 返回ins中每条可以执行到的指令执行前操作数栈的深度，不包括局部变量。
+// handlers为ins的异常表，其中的Depth不需要填写。编译器用它计算异常表中的Depth
+func StackDepths(ins Instructions, handlers []Handler) (map[int]int, error) {
+	decoded, _, err := decode(ins)
+	if err != nil {
+		return nil, err
+	}
+	return stackDepths(decoded, len(ins), Bounds{Main: true, Handlers: handlers})
+}
+
+// decode 解码ins中的所有指令，返回按偏移索引的指令和按顺序排列的偏移
+func decode(ins Instructions) (map[int]*instruction, []int, error) {
+	decoded := make(map[int]*instruction)
+	var offsets []int
+	for ip := 0; ip < len(ins); {
+		def, err := Lookup(ins[ip])
+		if err != nil {
+			return nil, nil, &VerifyError{Offset: ip, Op: Opcode(ins[ip]), Msg: "undefined opcode"}
+		}
+		width := def.Width()
+		if ip+1+width > len(ins) {
+			return nil, nil, &VerifyError{Offset: ip, Op: Opcode(ins[ip]), Msg: fmt.Sprintf("truncated: needs %d operand bytes, %d left", width, len(ins)-ip-1)}
+		}
+		operands, read := ReadOperands(def, ins[ip+1:])
+		decoded[ip] = &instruction{op: Opcode(ins[ip]), operands: operands, next: ip + 1 + read}
+		offsets = append(offsets, ip)
+		ip += 1 + read
+	}
+	return decoded, offsets, nil
+}
+
+// checkHandler 检查异常表中的一项，返回错误信息，没有错误时返回空字符串
+func checkHandler(h Handler, decoded map[int]*instruction, end int) string {
+	if _, ok := decoded[h.Start]; !ok {
+		return fmt.Sprintf("start %d is not the start of an instruction", h.Start)
+	}
+	if _, ok := decoded[h.End]; !ok && h.End != end {
+		return fmt.Sprintf("end %d is not the start of an instruction", h.End)
+	}
+	if h.End <= h.Start {
+		return fmt.Sprintf("empty range %d-%d", h.Start, h.End)
+	}
+	if _, ok := decoded[h.Target]; !ok {
+		return fmt.Sprintf("target %d is not the start of an instruction", h.Target)
+	}
+	return ""
+}
+
+// checkOperands 检查操作数的范围，返回错误信息，没有错误时返回空字符串
+func checkOperands(in *instruction, decoded map[int]*instruction, end int, bounds Bounds) string {
+	index := func(what string, i, n int) string {
+		if i >= n {
+			return fmt.Sprintf("%s %d out of range, have %d", what, i, n)
+		}
+		return ""
+	}
+	constant := func(i int, want ConstantKind, kind string) string {
+		if msg := index("constant", i, len(bounds.Constants)); msg != "" {
+			return msg
+		}
+		if want != ConstantOther && bounds.Constants[i] != want {
+			return fmt.Sprintf("constant %d is not %s", i, kind)
+		}
+		return ""
+	}
+
+	switch in.op {
+	case OpConstant:
+		return constant(in.operands[0], ConstantOther, "")
+	case OpAddConst, OpSubConst:
+		return constant(in.operands[0], ConstantInteger, "an integer")
+	case OpModule:
+		return constant(in.operands[0], ConstantString, "a string")
+	case OpClosure:
+		return constant(in.operands[0], ConstantFunction, "a function")
+	case OpImport:
+		if msg := constant(in.operands[0], ConstantFunction, "a function"); msg != "" {
+			return msg
+		}
+		return index("global", in.operands[1], bounds.Globals)
+	case OpGetGlobal, OpSetGlobal:
+		return index("global", in.operands[0], bounds.Globals)
+	case OpGetLocal, OpSetLocal:
+		return index("local", in.operands[0], bounds.Locals)
+	case OpGetFree:
+		return index("free variable", in.operands[0], bounds.Free)
+	case OpGetBuiltin:
+		return index("builtin", in.operands[0], bounds.Builtins)
+	case OpReturnValue, OpReturn:
+		if bounds.Main {
+			return "return outside of a function"
+		}
+	case OpHash:
+		if in.operands[0]%2 != 0 {
+			return fmt.Sprintf("odd number of keys and values: %d", in.operands[0])
+		}
+	case OpIsArray, OpCheckArray:
+		if in.operands[1] > 1 {
+			return fmt.Sprintf("rest flag must be 0 or 1, got %d", in.operands[1])
+		}
+	case OpJump, OpJumpNotTruthy, OpJumpNotEqual, OpJumpEqual, OpJumpNotGreater:
+		target := in.operands[0]
+		if _, ok := decoded[target]; !ok && target != end {
+			return fmt.Sprintf("jump target %d is not the start of an instruction", target)
+		}
+	}
+	return ""
+}
+
+// stackEffect 返回指令执行前栈上至少需要的值的个数，以及执行后栈深度的变化
+func stackEffect(in *instruction) (need, delta int) {
+	switch in.op {
+	case OpConstant, OpTrue, OpFalse, OpNull, OpGetGlobal, OpGetLocal, OpGetBuiltin, OpGetFree, OpCurrentClosure, OpImport:
+		return 0, 1
+	case OpAdd, OpSub, OpMul, OpDiv, OpEqual, OpNotEqual, OpGreaterThan, OpIndex, OpHasKey:
+		return 2, -1
+	case OpMinus, OpBang, OpAddConst, OpSubConst, OpModule, OpIsArray, OpIsHash, OpSliceFrom, OpMakeCell, OpLoadCell:
+		return 1, 0
+	case OpPop, OpSetGlobal, OpSetLocal, OpJumpNotTruthy, OpCheckArray, OpCheckHash:
+		return 1, -1
+	case OpJumpNotEqual, OpJumpEqual, OpJumpNotGreater, OpCheckKey, OpCheckEqual, OpStoreCell:
+		return 2, -2
+	case OpSetIndex:
+		return 3, -2
+	case OpArray, OpHash:
+		return in.operands[0], 1 - in.operands[0]
+	case OpCall:
+		// 弹出函数和参数，压入返回值
+		return in.operands[0] + 1, -in.operands[0]
+	case OpClosure:
+		return in.operands[1], 1 - in.operands[1]
+	case OpReturnValue:
+		return 1, -1
+	}
+	return 0, 0
+}
+
+// stackDepths 从第一条指令开始沿所有可能的路径计算每条指令执行前的栈深度。
+// 执行到try的开始处时，对应的异常处理入口也可以执行到，入口处的栈比开始处多一个错误
+func stackDepths(decoded map[int]*instruction, end int, bounds Bounds) (map[int]int, error) {
+	depths := make(map[int]int)
+	var work []int
+	handlers := make(map[int][]Handler)
+	for _, h := range bounds.Handlers {
+		handlers[h.Start] = append(handlers[h.Start], h)
+	}
+	// reach 记录到达target时的栈深度，from为跳转或顺序执行到target的指令
+	var reach func(from *instruction, fromIP, target, depth int) error
+	reach = func(from *instruction, fromIP, target, depth int) error {
+		if target == end {
+			if !bounds.Main {
+				return &VerifyError{Offset: fromIP, Op: from.op, Msg: "function ends without returning"}
+			}
+			return nil
+		}
+		if d, ok := depths[target]; ok {
+			if d != depth {
+				return &VerifyError{Offset: fromIP, Op: from.op, Msg: fmt.Sprintf("stack depth %d at %04d does not match %d from another path", depth, target, d)}
+			}
+			return nil
+		}
+		depths[target] = depth
+		work = append(work, target)
+		for _, h := range handlers[target] {
+			if err := reach(decoded[target], target, h.Target, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if end == 0 {
+		if !bounds.Main {
+			return nil, &VerifyError{Msg: "function ends without returning"}
+		}
+		return depths, nil
+	}
+	if err := reach(nil, 0, 0, 0); err != nil {
+		return nil, err
+	}
+	for len(work) > 0 {
+		ip := work[len(work)-1]
+		work = work[:len(work)-1]
+		in := decoded[ip]
+		depth := depths[ip]
+
+		need, delta := stackEffect(in)
+		if depth < need {
+			return nil, &VerifyError{Offset: ip, Op: in.op, Msg: fmt.Sprintf("stack underflow: needs %d values, have %d", need, depth)}
+		}
+		depth += delta
+
+		switch in.op {
+		case OpReturnValue, OpReturn:
+			continue
+		case OpJump:
+			if err := reach(in, ip, in.operands[0], depth); err != nil {
+				return nil, err
+			}
+			continue
+		case OpJumpNotTruthy, OpJumpNotEqual, OpJumpEqual, OpJumpNotGreater:
+			if err := reach(in, ip, in.operands[0], depth); err != nil {
+				return nil, err
+			}
+		}
+		if err := reach(in, ip, in.next, depth); err != nil {
+			return nil, err
+		}
+	}
+	return depths, nil
+}
@@ -0,0 +1,216 @@
+package code
+
+import "testing"
+
+func TestVerify(t *testing.T) {
+	main := Bounds{
+		Constants: []ConstantKind{ConstantInteger, ConstantString, ConstantFunction},
+		Globals:   2,
+		Builtins:  1,
+		Main:      true,
+	}
+	function := main
+	function.Main = false
+	function.Locals = 1
+	function.Free = 1
+	withHandler := func(h Handler) Bounds {
+		b := main
+		b.Handlers = []Handler{h}
+		return b
+	}
+	try := []Instructions{
+		Make(OpConstant, 0),
+		Make(OpConstant, 0),
+		Make(OpJump, 15),
+		Make(OpSetGlobal, 0),
+		Make(OpGetGlobal, 0),
+		Make(OpAdd),
+		Make(OpPop),
+	}
+
+	tests := []struct {
+		name         string
+		instructions []Instructions
+		bounds       Bounds
+		expected     string
+	}{
+		{
+			"valid if",
+			[]Instructions{
+				Make(OpTrue),
+				Make(OpJumpNotTruthy, 10),
+				Make(OpConstant, 0),
+				Make(OpJump, 11),
+				Make(OpNull),
+				Make(OpPop),
+			},
+			main, "",
+		},
+		{
+			"valid function",
+			[]Instructions{
+				Make(OpGetLocal, 0),
+				Make(OpGetFree, 0),
+				Make(OpAdd),
+				Make(OpReturnValue),
+			},
+			function, "",
+		},
+		{
+			"undefined opcode",
+			[]Instructions{Make(OpTrue), {253}},
+			main, "0001 Opcode(253): undefined opcode",
+		},
+		{
+			"truncated operand",
+			[]Instructions{Make(OpTrue), Make(OpConstant, 0)[:2]},
+			main, "0001 OpConstant: truncated: needs 2 operand bytes, 1 left",
+		},
+		{
+			"constant out of range",
+			[]Instructions{Make(OpConstant, 3), Make(OpPop)},
+			main, "0000 OpConstant: constant 3 out of range, have 3",
+		},
+		{
+			"closure of non-function",
+			[]Instructions{Make(OpClosure, 1, 0), Make(OpPop)},
+			main, "0000 OpClosure: constant 1 is not a function",
+		},
+		{
+			"add non-integer constant",
+			[]Instructions{Make(OpTrue), Make(OpAddConst, 1), Make(OpPop)},
+			main, "0001 OpAddConst: constant 1 is not an integer",
+		},
+		{
+			"global out of range",
+			[]Instructions{Make(OpTrue), Make(OpSetGlobal, 2)},
+			main, "0001 OpSetGlobal: global 2 out of range, have 2",
+		},
+		{
+			"local in main",
+			[]Instructions{Make(OpGetLocal, 0), Make(OpPop)},
+			main, "0000 OpGetLocal: local 0 out of range, have 0",
+		},
+		{
+			"free variable out of range",
+			[]Instructions{Make(OpGetFree, 1), Make(OpReturnValue)},
+			function, "0000 OpGetFree: free variable 1 out of range, have 1",
+		},
+		{
+			"builtin out of range",
+			[]Instructions{Make(OpGetBuiltin, 1), Make(OpPop)},
+			main, "0000 OpGetBuiltin: builtin 1 out of range, have 1",
+		},
+		{
+			"odd hash",
+			[]Instructions{Make(OpTrue), Make(OpHash, 1), Make(OpPop)},
+			main, "0001 OpHash: odd number of keys and values: 1",
+		},
+		{
+			"array pattern rest flag",
+			[]Instructions{Make(OpTrue), Make(OpIsArray, 1, 2), Make(OpPop)},
+			main, "0001 OpIsArray: rest flag must be 0 or 1, got 2",
+		},
+		{
+			"jump into operand",
+			[]Instructions{Make(OpJump, 2), Make(OpConstant, 0), Make(OpPop)},
+			main, "0000 OpJump: jump target 2 is not the start of an instruction",
+		},
+		{
+			"jump past end",
+			[]Instructions{Make(OpJump, 4)},
+			main, "0000 OpJump: jump target 4 is not the start of an instruction",
+		},
+		{
+			"return in main",
+			[]Instructions{Make(OpTrue), Make(OpReturnValue)},
+			main, "0001 OpReturnValue: return outside of a function",
+		},
+		{
+			"stack underflow",
+			[]Instructions{Make(OpTrue), Make(OpAdd), Make(OpPop)},
+			main, "0001 OpAdd: stack underflow: needs 2 values, have 1",
+		},
+		{
+			"call without function",
+			[]Instructions{Make(OpTrue), Make(OpCall, 1), Make(OpPop)},
+			main, "0001 OpCall: stack underflow: needs 2 values, have 1",
+		},
+		{
+			"unbalanced branches",
+			[]Instructions{
+				Make(OpTrue),
+				Make(OpJumpNotTruthy, 7),
+				Make(OpTrue),
+				Make(OpTrue),
+				Make(OpNull),
+				Make(OpPop),
+			},
+			main, "0006 OpNull: stack depth 3 at 0007 does not match 0 from another path",
+		},
+		{
+			"function without return",
+			[]Instructions{Make(OpGetLocal, 0), Make(OpPop)},
+			function, "0003 OpPop: function ends without returning",
+		},
+		{
+			"valid try",
+			try,
+			withHandler(Handler{Start: 3, End: 6, Target: 9, Depth: 1}), "",
+		},
+		{
+			"handler depth mismatch",
+			try,
+			withHandler(Handler{Start: 3, End: 6, Target: 9, Depth: 0}),
+			"0003 OpConstant: exception handler 0: stack depth 1 does not match 0 in the table",
+		},
+		{
+			"handler target inside operand",
+			try,
+			withHandler(Handler{Start: 3, End: 6, Target: 10, Depth: 1}),
+			"0003 OpConstant: exception handler 0: target 10 is not the start of an instruction",
+		},
+		{
+			"empty handler range",
+			try,
+			withHandler(Handler{Start: 3, End: 3, Target: 9, Depth: 1}),
+			"0003 OpConstant: exception handler 0: empty range 3-3",
+		},
+		{
+			"handler entry is checked for depth",
+			[]Instructions{Make(OpTrue), Make(OpJump, 5), Make(OpAdd), Make(OpPop)},
+			withHandler(Handler{Start: 0, End: 1, Target: 4, Depth: 0}),
+			"0004 OpAdd: stack underflow: needs 2 values, have 1",
+		},
+		{
+			"unreachable code is not checked for depth",
+			[]Instructions{
+				Make(OpReturn),
+				Make(OpPop),
+			},
+			function, "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ins := Instructions{}
+			for _, in := range tt.instructions {
+				ins = append(ins, in...)
+			}
+			err := Verify(ins, tt.bounds)
+			if tt.expected == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected error %q, got none", tt.expected)
+			}
+			if err.Error() != tt.expected {
+				t.Fatalf("wrong error. want=%q, got=%q", tt.expected, err.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package compiler
+
+import (
+	"Monkey/ast"
+	"Monkey/code"
+	"fmt"
+)
+
+// compileAssign 编译赋值表达式，赋的值留在栈上作为表达式的值。
+// 给变量赋值时在编译期检查变量是否由var定义；
+// 下标赋值依次计算容器、下标和值，由OpSetIndex修改容器
+func (c *Compiler) compileAssign(node *ast.AssignExpression) error {
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		symbol, err := c.symbolTable.ResolveAssign(target.Value)
+		if err != nil {
+			return err
+		}
+		err = c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		if symbol.boxed() {
+			c.loadSlot(symbol)
+			c.emit(code.OpStoreCell)
+		} else {
+			c.storeSymbol(symbol)
+		}
+		c.loadSymbol(symbol)
+	case *ast.IndexExpression:
+		err := c.Compile(target.Left)
+		if err != nil {
+			return err
+		}
+		err = c.Compile(target.Index)
+		if err != nil {
+			return err
+		}
+		err = c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpSetIndex)
+	default:
+		return fmt.Errorf("cannot assign to %s", node.Target)
+	}
+	return nil
+}
@@ -4,15 +4,38 @@ import (
 	"Monkey/ast"
 	"Monkey/code"
 	"Monkey/object"
+	"Monkey/token"
 	"fmt"
+	"sort"
 )
 
 type Compiler struct {
+	constants   []object.Object // 常量池
+	symbolTable *SymbolTable    // 符号表
+	globals     *SymbolTable    // 最外层符号表，缓存模块的全局变量定义在这里
+
+	scopes     []CompilationScope // 每个函数体对应一个编译作用域
+	scopeIndex int
+
+	dir  string // 导入路径相对的目录
+	file string // 正在编译的文件，记录在SourceMap中
+
+	optimization    int                 // 优化级别，见SetOptimization
+	constantIndexes map[constantKey]int // 优化时用于合并常量池中相同的常量
+	modules         map[string]int      // 已编译的模块：绝对路径 -> 模块函数在常量池中的索引
+	importing       []string            // 正在编译的模块，用于检测循环导入
+	host            *object.Host        // 编译期加载模块所在的宿主
+
+	err error // 生成指令时遇到的第一个超出编译器限制的错误
+}
+
+// CompilationScope 编译函数体时使用的独立指令序列
+type CompilationScope struct {
 	instructions        code.Instructions
-	constants           []object.Object    // 常量池
 	lastInstruction     EmittedInstruction // 最后一条发出的指令
 	previousInstruction EmittedInstruction // 倒数第二条发出的指令
-	symbolTable         *SymbolTable       // 符号表
+	sourceMap           *code.SourceMap
+	handlers            []code.Handler // try的异常表，Depth在leaveScope或Bytecode时计算
 }
 
 type EmittedInstruction struct {
@@ -21,21 +44,66 @@ type EmittedInstruction struct {
 }
 
 func New() *Compiler {
-	return &Compiler{
+	mainScope := CompilationScope{
 		instructions: code.Instructions{},
-		constants:    []object.Object{},
-		symbolTable:  NewSymbolTable(),
+		sourceMap:    &code.SourceMap{},
+	}
+
+	symbolTable := newBuiltinSymbolTable()
+	return &Compiler{
+		constants:   []object.Object{},
+		symbolTable: symbolTable,
+		globals:     symbolTable,
+		scopes:      []CompilationScope{mainScope},
+		modules:     make(map[string]int),
+		host:        object.DefaultHost,
 	}
 }
 
 func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
 	compiler := New()
 	compiler.symbolTable = s
+	compiler.globals = s
 	compiler.constants = constants
 	return compiler
 }
 
+// SetHost 设置编译期加载模块所在的宿主，宿主未授予CapabilityFS时不能import；默认为object.DefaultHost
+func (c *Compiler) SetHost(host *object.Host) {
+	c.host = host
+}
+
+// SetDir 设置import中相对路径所相对的目录，通常为被编译文件所在的目录；默认为当前工作目录
+func (c *Compiler) SetDir(dir string) {
+	c.dir = dir
+}
+
+// SetFile 设置被编译文件的绝对路径，记录在SourceMap中供调试器使用。
+// 该文件同时视为正在编译的模块，它参与的循环导入也能被检测到
+func (c *Compiler) SetFile(file string) {
+	c.file = file
+	c.importing = []string{file}
+	c.scopes[c.scopeIndex].sourceMap.File = file
+}
+
+// newBuiltinSymbolTable 创建只定义了内置函数的最外层符号表
+func newBuiltinSymbolTable() *SymbolTable {
+	symbolTable := NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+	return symbolTable
+}
+
+// Compile 编译node。常量、变量的个数或跳转距离等超出指令操作数能表示的范围时返回错误
 func (c *Compiler) Compile(node ast.Node) error {
+	if err := c.compile(node); err != nil {
+		return err
+	}
+	return c.err
+}
+
+func (c *Compiler) compile(node ast.Node) error {
 	switch node := node.(type) {
 	case *ast.Program:
 		for _, s := range node.Statements {
@@ -45,6 +113,13 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 		}
 	case *ast.ExpressionStatement:
+		c.markStatement(node.Token)
+		if node.Expression == nil {
+			// 解析失败的表达式没有值，按null处理，保证OpPop不会使栈下溢
+			c.emit(code.OpNull)
+			c.emit(code.OpPop)
+			return nil
+		}
 		err := c.Compile(node.Expression)
 		if err != nil {
 			return err
@@ -54,6 +129,9 @@ func (c *Compiler) Compile(node ast.Node) error {
 		integer := &object.Integer{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(integer))
 	case *ast.PrefixExpression:
+		if c.compileFolded(node) {
+			return nil
+		}
 		err := c.Compile(node.Right)
 		if err != nil {
 			return err
@@ -65,6 +143,12 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpBang)
 		}
 	case *ast.InfixExpression:
+		if c.compileFolded(node) {
+			return nil
+		}
+		if fused, err := c.compileFusedInfix(node); fused {
+			return err
+		}
 		if node.Operator == "<" {
 			err := c.Compile(node.Right)
 			if err != nil {
@@ -117,21 +201,23 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 		}
 	case *ast.IfExpression:
-		err := c.Compile(node.Condition)
+		if folded, err := c.compileConstantIf(node); folded {
+			return err
+		}
+		jumpNotTruthyPos, err := c.compileCondition(node.Condition)
 		if err != nil {
 			return err
 		}
-		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
 
 		err = c.Compile(node.Consequence)
 		if err != nil {
 			return err
 		}
-		if c.lastInstructionIsPop() {
+		if c.lastInstructionIs(code.OpPop) {
 			c.removeLastPop()
 		}
 		jumpPos := c.emit(code.OpJump, 9999)
-		afterConsequencePos := len(c.instructions)
+		afterConsequencePos := len(c.currentInstructions())
 		c.changeOperand(jumpNotTruthyPos, afterConsequencePos)
 		if node.Alternative != nil {
 
@@ -139,29 +225,151 @@ func (c *Compiler) Compile(node ast.Node) error {
 			if err != nil {
 				return err
 			}
-			if c.lastInstructionIsPop() {
+			if c.lastInstructionIs(code.OpPop) {
 				c.removeLastPop()
 			}
 		} else {
 			// 设置真正的偏移量
 			c.emit(code.OpNull)
 		}
-		afterAlternativePos := len(c.instructions)
+		afterAlternativePos := len(c.currentInstructions())
 		c.changeOperand(jumpPos, afterAlternativePos)
+	case *ast.TryExpression:
+		return c.compileTry(node)
+	case *ast.MatchExpression:
+		return c.compileMatch(node)
 	case *ast.LetStatement:
+		c.markStatement(node.Token)
 		err := c.Compile(node.Value)
 		if err != nil {
 			return err
 		}
-		symbol := c.symbolTable.Define(node.Name.Value)
-		c.emit(code.OpSetGlobal, symbol.Index)
+		if node.Pattern != nil {
+			return c.compileDestructuring(node.Pattern, node.Mutable())
+		}
+		var symbol Symbol
+		if node.Mutable() {
+			symbol = c.symbolTable.DefineMutable(node.Name.Value)
+		} else {
+			symbol = c.symbolTable.Define(node.Name.Value)
+		}
+		c.initSymbol(symbol)
+	case *ast.AssignExpression:
+		return c.compileAssign(node)
 	case *ast.Identifier:
 		name := node.Value
 		symbol, ok := c.symbolTable.Resolve(name)
 		if !ok {
 			return fmt.Errorf("undefined variable: %s", name)
 		}
-		c.emit(code.OpGetGlobal, symbol.Index)
+		c.loadSymbol(symbol)
+	case *ast.FunctionLiteral:
+		c.enterScope()
+
+		if node.Name != "" {
+			c.symbolTable.DefineFunctionName(node.Name)
+		}
+		for _, p := range node.Parameters {
+			c.symbolTable.Define(p.Value)
+		}
+
+		err := c.Compile(node.Body)
+		if err != nil {
+			return err
+		}
+		// 函数体最后一条表达式语句的值作为隐式返回值
+		if c.lastInstructionIs(code.OpPop) {
+			c.replaceLastPopWithReturn()
+		}
+		if !c.lastInstructionIs(code.OpReturnValue) {
+			c.emit(code.OpReturn)
+		}
+
+		freeSymbols := c.symbolTable.FreeSymbols
+		numLocals := c.symbolTable.NumDefinitions()
+		sourceMap := c.currentSourceMap()
+		handlers := c.scopes[c.scopeIndex].handlers
+		instructions := c.leaveScope()
+
+		// 将捕获的变量压栈，由OpClosure收集；保存在单元中的变量捕获单元本身
+		for _, s := range freeSymbols {
+			c.loadSlot(s)
+		}
+
+		compiledFn := &object.CompiledFunction{
+			Instructions:  instructions,
+			NumLocals:     numLocals,
+			NumParameters: len(node.Parameters),
+			Name:          node.Name,
+			SourceMap:     sourceMap,
+			Handlers:      handlers,
+		}
+		fnIndex := c.addConstant(compiledFn)
+		c.emit(code.OpClosure, fnIndex, len(freeSymbols))
+	case *ast.ReturnStatement:
+		c.markStatement(node.Token)
+		err := c.Compile(node.ReturnValue)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+	case *ast.CallExpression:
+		if c.isImport(node) {
+			return c.compileImport(node)
+		}
+		err := c.Compile(node.Function)
+		if err != nil {
+			return err
+		}
+		for _, a := range node.Arguments {
+			err := c.Compile(a)
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpCall, len(node.Arguments))
+	case *ast.StringLiteral:
+		str := &object.String{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(str))
+	case *ast.ArrayLiteral:
+		for _, element := range node.Elements {
+			err := c.Compile(element)
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpArray, len(node.Elements))
+	case *ast.HashLiteral:
+		// 按键的字符串形式排序，保证每次编译生成的指令顺序一致
+		var keys []ast.Expression
+		for k := range node.Pairs {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+
+		for _, k := range keys {
+			err := c.Compile(k)
+			if err != nil {
+				return err
+			}
+			err = c.Compile(node.Pairs[k])
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpHash, len(node.Pairs)*2)
+	case *ast.IndexExpression:
+		err := c.Compile(node.Left)
+		if err != nil {
+			return err
+		}
+		err = c.Compile(node.Index)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpIndex)
 	}
 
 	return nil
@@ -171,17 +379,32 @@ func (c *Compiler) Compile(node ast.Node) error {
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	SourceMap    *code.SourceMap // Instructions的调试信息，函数的调试信息在各自的CompiledFunction中
+	NumGlobals   int             // 最外层符号表中定义的全局变量个数，虚拟机据此分配全局变量
+	Handlers     []code.Handler  // Instructions的异常表，函数的异常表在各自的CompiledFunction中
 }
 
 func (c *Compiler) Bytecode() *Bytecode {
+	if c.optimization >= OptimizeJumps {
+		collapseJumps(c.currentInstructions())
+	}
+	c.resolveHandlers()
 	return &Bytecode{
-		Instructions: c.instructions,
+		Instructions: c.currentInstructions(),
 		Constants:    c.constants,
+		SourceMap:    c.scopes[c.scopeIndex].sourceMap,
+		NumGlobals:   c.globals.NumDefinitions(),
+		Handlers:     c.scopes[c.scopeIndex].handlers,
 	}
 }
 
 // addConstant 辅助函数，往常量池添加常量，并返回索引
 func (c *Compiler) addConstant(constant object.Object) int {
+	if c.optimization >= OptimizeConstant {
+		if i, ok := c.dedupeConstant(constant); ok {
+			return i
+		}
+	}
 	c.constants = append(c.constants, constant)
 	return len(c.constants) - 1
 }
@@ -189,7 +412,7 @@ func (c *Compiler) addConstant(constant object.Object) int {
 // emit 生成指令，并将其添加至内存
 // 返回指令的位置
 func (c *Compiler) emit(op code.Opcode, operands ...int) int {
-	inst := code.Make(op, operands...)
+	inst := c.makeInstruction(op, operands...)
 	pos := c.addInstruction(inst)
 
 	c.setLastInstruction(op, pos)
@@ -197,47 +420,150 @@ func (c *Compiler) emit(op code.Opcode, operands ...int) int {
 }
 
 // addInstruction 辅助函数
-// 用于将指令添加到内存中
+// 用于将指令添加到当前作用域的内存中
 func (c *Compiler) addInstruction(inst code.Instructions) int {
-	posNewInstruction := len(c.instructions)
-	c.instructions = append(c.instructions, inst...)
+	posNewInstruction := len(c.currentInstructions())
+	c.scopes[c.scopeIndex].instructions = append(c.currentInstructions(), inst...)
 	return posNewInstruction
 }
 
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
 // setLastInstruction
 // 设置最后一条发出的指令和倒数第二条发出的指令
 func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
-	previous := c.lastInstruction
+	previous := c.scopes[c.scopeIndex].lastInstruction
 	last := EmittedInstruction{Opcode: op, Position: pos}
-	c.previousInstruction = previous
-	c.lastInstruction = last
+	c.scopes[c.scopeIndex].previousInstruction = previous
+	c.scopes[c.scopeIndex].lastInstruction = last
 }
 
-// lastInstructionIsPop
-// 辅助函数，用于确认最后一条指令是否为opPop
-func (c *Compiler) lastInstructionIsPop() bool {
-	return c.lastInstruction.Opcode == code.OpPop
+// lastInstructionIs
+// 辅助函数，用于确认当前作用域最后一条指令是否为op
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
 }
 
 // removeLastPop
 // 用于移除instructions的最后一条指令
 // 并将倒数第二条指令设置为最后一条指令
 func (c *Compiler) removeLastPop() {
-	c.instructions = c.instructions[:c.lastInstruction.Position]
-	c.lastInstruction = c.previousInstruction
+	last := c.scopes[c.scopeIndex].lastInstruction
+	previous := c.scopes[c.scopeIndex].previousInstruction
+
+	c.scopes[c.scopeIndex].instructions = c.currentInstructions()[:last.Position]
+	c.scopes[c.scopeIndex].lastInstruction = previous
+	c.scopes[c.scopeIndex].sourceMap.Truncate(last.Position)
+}
+
+// replaceLastPopWithReturn 把函数体末尾的OpPop替换为OpReturnValue
+func (c *Compiler) replaceLastPopWithReturn() {
+	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
+	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
+	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
 }
 
 // changOperand
 // 通过使用新操作数创建指令，从而改变操作数
 func (c *Compiler) changeOperand(opPos int, operand int) {
-	op := code.Opcode(c.instructions[opPos])
-	newInstruction := code.Make(op, operand)
+	op := code.Opcode(c.currentInstructions()[opPos])
+	newInstruction := c.makeInstruction(op, operand)
 
 	c.replaceInstruction(opPos, newInstruction)
 }
 
 func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
 	for i := 0; i < len(newInstruction); i++ {
-		c.instructions[pos+i] = newInstruction[i]
+		ins[pos+i] = newInstruction[i]
+	}
+}
+
+// enterScope 开始编译函数体，进入新的编译作用域和符号表
+func (c *Compiler) enterScope() {
+	scope := CompilationScope{
+		instructions: code.Instructions{},
+		sourceMap:    &code.SourceMap{File: c.file},
+	}
+	c.scopes = append(c.scopes, scope)
+	c.scopeIndex++
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+// leaveScope 结束函数体的编译，返回其指令
+func (c *Compiler) leaveScope() code.Instructions {
+	instructions := c.currentInstructions()
+	if c.optimization >= OptimizeJumps {
+		collapseJumps(instructions)
+	}
+	c.resolveHandlers()
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+	c.symbolTable = c.symbolTable.Outer
+	return instructions
+}
+
+// markStatement 在SourceMap中记录下一条指令开始的语句位置，tok为语句的第一个词法单元
+func (c *Compiler) markStatement(tok token.Token) {
+	c.scopes[c.scopeIndex].sourceMap.Add(len(c.currentInstructions()), tok.Line, tok.Column)
+}
+
+// currentSourceMap 补全当前作用域的变量名，在leaveScope之前调用
+func (c *Compiler) currentSourceMap() *code.SourceMap {
+	sourceMap := c.scopes[c.scopeIndex].sourceMap
+	sourceMap.Locals = make([]string, c.symbolTable.NumDefinitions())
+	for _, symbol := range c.symbolTable.Symbols() {
+		sourceMap.Locals[symbol.Index] = symbol.Name
+	}
+	for _, symbol := range c.symbolTable.FreeSymbols {
+		sourceMap.Free = append(sourceMap.Free, symbol.Name)
+	}
+	return sourceMap
+}
+
+// loadSymbol 根据作用域生成读取变量的值的指令
+func (c *Compiler) loadSymbol(s Symbol) {
+	c.loadSlot(s)
+	if s.boxed() {
+		c.emit(code.OpLoadCell)
+	}
+}
+
+// loadSlot 生成读取变量所在位置的指令，变量保存在单元中时读取的是单元
+func (c *Compiler) loadSlot(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case BuiltinScope:
+		c.emit(code.OpGetBuiltin, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
+
+// initSymbol 生成用栈顶的值初始化新定义的变量的指令
+func (c *Compiler) initSymbol(s Symbol) {
+	if s.boxed() {
+		c.emit(code.OpMakeCell)
+	}
+	c.storeSymbol(s)
+}
+
+// storeSymbol 生成把栈顶的值保存到全局变量或局部变量的指令
+func (c *Compiler) storeSymbol(s Symbol) {
+	if s.Scope == GlobalScope {
+		c.emit(code.OpSetGlobal, s.Index)
+	} else {
+		c.emit(code.OpSetLocal, s.Index)
 	}
 }
@@ -7,6 +7,8 @@ import (
 	"Monkey/object"
 	"Monkey/parser"
 	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -157,6 +159,20 @@ func testConstants(expected []any, actual []object.Object) error {
 			if err != nil {
 				return fmt.Errorf("constant %d - testIntegerObject failed: %s", i, err)
 			}
+		case string:
+			err := testStringObject(constant, actual[i])
+			if err != nil {
+				return fmt.Errorf("constant %d - testStringObject failed: %s", i, err)
+			}
+		case []code.Instructions:
+			fn, ok := actual[i].(*object.CompiledFunction)
+			if !ok {
+				return fmt.Errorf("constant %d - not a function: %T", i, actual[i])
+			}
+			err := testInstructions(constant, fn.Instructions)
+			if err != nil {
+				return fmt.Errorf("constant %d - testInstructions failed: %s", i, err)
+			}
 		}
 	}
 
@@ -175,6 +191,18 @@ func testIntegerObject(expected int64, actual object.Object) error {
 	return nil
 }
 
+func testStringObject(expected string, actual object.Object) error {
+	result, ok := actual.(*object.String)
+	if !ok {
+		return fmt.Errorf("object is not String. got=%T (%+v)", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%q, want=%q", result.Value, expected)
+	}
+	return nil
+}
+
 func parse(input string) *ast.Program {
 	l := lexer.New(input)
 	p := parser.New(l)
@@ -235,3 +263,958 @@ func TestGlobalLetStatements(t *testing.T) {
 		})
 	}
 }
+
+func TestStringExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `"monkey"`,
+			expectedConstants: []any{"monkey"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"a" == "b"`,
+			expectedConstants: []any{"a", "b"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpEqual),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			runCompilerTest(t, tt)
+		})
+	}
+}
+
+func TestArrayLiterals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "[]",
+			expectedConstants: []any{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "[1, 2 + 3]",
+			expectedConstants: []any{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpAdd),
+				code.Make(code.OpArray, 2),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			runCompilerTest(t, tt)
+		})
+	}
+}
+
+func TestHashLiterals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "{}",
+			expectedConstants: []any{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpHash, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "{3: 4, 1: 2}",
+			expectedConstants: []any{1, 2, 3, 4},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpHash, 4),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			runCompilerTest(t, tt)
+		})
+	}
+}
+
+func TestIndexExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "{1: 2}[1]",
+			expectedConstants: []any{1, 2, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpHash, 2),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpIndex),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			runCompilerTest(t, tt)
+		})
+	}
+}
+
+func TestFunctions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `fn() { return 5 + 10 }`,
+			expectedConstants: []any{5, 10, []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpReturnValue),
+			}},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn() { 1; 2 }`,
+			expectedConstants: []any{1, 2, []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpReturnValue),
+			}},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn() { }`,
+			expectedConstants: []any{[]code.Instructions{
+				code.Make(code.OpReturn),
+			}},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			runCompilerTest(t, tt)
+		})
+	}
+}
+
+func TestFunctionCalls(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `let oneArg = fn(a) { a }; oneArg(24);`,
+			expectedConstants: []any{[]code.Instructions{
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpReturnValue),
+			}, 24},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `len([]); push([], 1);`,
+			expectedConstants: []any{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, 0),
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetBuiltin, 4),
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpCall, 2),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			runCompilerTest(t, tt)
+		})
+	}
+}
+
+func TestClosures(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `fn(a) { fn(b) { a + b } }`,
+			expectedConstants: []any{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `let countDown = fn(x) { countDown(x - 1) };`,
+			expectedConstants: []any{1, []code.Instructions{
+				code.Make(code.OpCurrentClosure),
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSub),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpReturnValue),
+			}},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			runCompilerTest(t, tt)
+		})
+	}
+}
+
+func TestSourceMap(t *testing.T) {
+	input := `let a = 1;
+let f = fn(x) {
+  let y = x + a;
+  if (y > 1) { y } else { 0 }
+};
+f(a);`
+
+	comp := New()
+	comp.SetFile("main.mk")
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := comp.Bytecode()
+
+	main := bytecode.SourceMap
+	if main.File != "main.mk" {
+		t.Errorf("wrong file. want=%q, got=%q", "main.mk", main.File)
+	}
+	wantMain := []code.SourcePos{{Offset: 0, Line: 1, Column: 1}, {Offset: 6, Line: 2, Column: 1}, {Offset: 14, Line: 6, Column: 1}}
+	if !reflect.DeepEqual(main.Positions, wantMain) {
+		t.Errorf("wrong main positions.\nwant=%+v\ngot =%+v", wantMain, main.Positions)
+	}
+
+	fn, ok := bytecode.Constants[len(bytecode.Constants)-1].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("last constant is not a function. got=%T", bytecode.Constants[len(bytecode.Constants)-1])
+	}
+	var lines []int
+	for _, pos := range fn.SourceMap.Positions {
+		lines = append(lines, pos.Line)
+	}
+	// 函数体的let、if表达式以及两个分支中的语句
+	if !reflect.DeepEqual(lines, []int{3, 4, 4, 4}) {
+		t.Errorf("wrong function lines. got=%v", lines)
+	}
+	if !reflect.DeepEqual(fn.SourceMap.Locals, []string{"x", "y"}) {
+		t.Errorf("wrong locals. got=%q", fn.SourceMap.Locals)
+	}
+
+	for ip := range fn.Instructions {
+		if _, ok := fn.SourceMap.Lookup(ip); !ok {
+			t.Errorf("instruction %d has no position", ip)
+		}
+	}
+}
+
+func runOptimizedTest(t *testing.T, level int, tt compilerTestCase) {
+	t.Helper()
+
+	compiler := New()
+	compiler.SetOptimization(level)
+	if err := compiler.Compile(parse(tt.input)); err != nil {
+		t.Fatalf("compiler error:%s", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	if err := testInstructions(tt.expectedInstructions, bytecode.Instructions); err != nil {
+		t.Fatalf("testInstructions fail: %v", err)
+	}
+	if err := testConstants(tt.expectedConstants, bytecode.Constants); err != nil {
+		t.Fatalf("testConstants fail: %v", err)
+	}
+}
+
+func TestConstantFolding(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 + 2 * 3",
+			expectedConstants: []any{7},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "-(10 / 3) < 1 == !false",
+			expectedConstants: []any{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"a" == "a"; "a" != "b"; 1 == true`,
+			expectedConstants: []any{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"mon" + "key" + "!"`,
+			expectedConstants: []any{"monkey!"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// 运行时出错的表达式不折叠
+			input:             "1 / 0; -true",
+			expectedConstants: []any{1, 0},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDiv),
+				code.Make(code.OpPop),
+				code.Make(code.OpTrue),
+				code.Make(code.OpMinus),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let x = 2; x * (3 + 4)",
+			expectedConstants: []any{2, 7},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMul),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			runOptimizedTest(t, OptimizeConstant, tt)
+		})
+	}
+}
+
+func TestConstantDedupe(t *testing.T) {
+	runOptimizedTest(t, OptimizeConstant, compilerTestCase{
+		input:             `let a = 5; let b = "x"; [a, 5, "x", 5]`,
+		expectedConstants: []any{5, "x"},
+		expectedInstructions: []code.Instructions{
+			code.Make(code.OpConstant, 0),
+			code.Make(code.OpSetGlobal, 0),
+			code.Make(code.OpConstant, 1),
+			code.Make(code.OpSetGlobal, 1),
+			code.Make(code.OpGetGlobal, 0),
+			code.Make(code.OpConstant, 0),
+			code.Make(code.OpConstant, 1),
+			code.Make(code.OpConstant, 0),
+			code.Make(code.OpArray, 4),
+			code.Make(code.OpPop),
+		},
+	})
+}
+
+func TestDeadBranches(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "if (true) { 10 } else { 20 }; 3333",
+			expectedConstants: []any{10, 20, 3333},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "if (1 > 2) { 10 }",
+			expectedConstants: []any{10},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// 死分支中定义的变量仍然存在，和不优化时一致
+			input:             "if (false) { let a = 1; } else { 2 }; a",
+			expectedConstants: []any{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			runOptimizedTest(t, OptimizeConstant, tt)
+		})
+	}
+}
+
+func TestCollapseJumps(t *testing.T) {
+	runOptimizedTest(t, OptimizeJumps, compilerTestCase{
+		input:             "let a = true; if (a) { if (a) { 1 } else { 2 } } else { 3 }",
+		expectedConstants: []any{1, 2, 3},
+		expectedInstructions: []code.Instructions{
+			// 0000
+			code.Make(code.OpTrue),
+			// 0001
+			code.Make(code.OpSetGlobal, 0),
+			// 0004
+			code.Make(code.OpGetGlobal, 0),
+			// 0007
+			code.Make(code.OpJumpNotTruthy, 28),
+			// 0010
+			code.Make(code.OpGetGlobal, 0),
+			// 0013
+			code.Make(code.OpJumpNotTruthy, 22),
+			// 0016
+			code.Make(code.OpConstant, 0),
+			// 0019 原本跳到0025的OpJump，直接跳到0031
+			code.Make(code.OpJump, 31),
+			// 0022
+			code.Make(code.OpConstant, 1),
+			// 0025
+			code.Make(code.OpJump, 31),
+			// 0028
+			code.Make(code.OpConstant, 2),
+			// 0031
+			code.Make(code.OpPop),
+		},
+	})
+}
+
+func TestSuperinstructions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let x = 1; x + 2; x - (1 + 2); 2 + x",
+			expectedConstants: []any{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpAddConst, 1),
+				code.Make(code.OpPop),
+				// 右操作数折叠后为常量
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpSubConst, 2),
+				code.Make(code.OpPop),
+				// 左操作数为常量时不合并
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let x = 1; if (x < 2) { 3 }; if (x == 2) { 3 } else { 4 }",
+			expectedConstants: []any{1, 2, 3, 4},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpSetGlobal, 0),
+				// 0006 x < 2 与OpGreaterThan一样交换操作数
+				code.Make(code.OpConstant, 1),
+				// 0009
+				code.Make(code.OpGetGlobal, 0),
+				// 0012
+				code.Make(code.OpJumpNotGreater, 21),
+				// 0015
+				code.Make(code.OpConstant, 2),
+				// 0018
+				code.Make(code.OpJump, 22),
+				// 0021
+				code.Make(code.OpNull),
+				// 0022
+				code.Make(code.OpPop),
+				// 0023
+				code.Make(code.OpGetGlobal, 0),
+				// 0026
+				code.Make(code.OpConstant, 1),
+				// 0029
+				code.Make(code.OpJumpNotEqual, 38),
+				// 0032
+				code.Make(code.OpConstant, 2),
+				// 0035
+				code.Make(code.OpJump, 41),
+				// 0038
+				code.Make(code.OpConstant, 3),
+				// 0041
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// 不在if条件中的比较不合并
+			input:             "let x = 1; let y = x != 2; if (y) { 3 }",
+			expectedConstants: []any{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpNotEqual),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpJumpNotTruthy, 28),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpJump, 29),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		runOptimizedTest(t, OptimizeFused, tt)
+	}
+}
+
+func TestMatchExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `match ([1]) { [a] => a, _ => 0 }`,
+			expectedConstants: []any{1, 0, 0},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpArray, 1),
+				// 0006
+				code.Make(code.OpSetGlobal, 0),
+				// 0009
+				code.Make(code.OpGetGlobal, 0),
+				// 0012
+				code.Make(code.OpIsArray, 1, 0),
+				// 0016
+				code.Make(code.OpJumpNotTruthy, 32),
+				// 0019
+				code.Make(code.OpGetGlobal, 0),
+				// 0022
+				code.Make(code.OpConstant, 1),
+				// 0025
+				code.Make(code.OpIndex),
+				// 0026
+				code.Make(code.OpSetGlobal, 1),
+				// 0029
+				code.Make(code.OpJump, 35),
+				// 0032
+				code.Make(code.OpJump, 41),
+				// 0035
+				code.Make(code.OpGetGlobal, 1),
+				// 0038
+				code.Make(code.OpJump, 48),
+				// 0041
+				code.Make(code.OpConstant, 2),
+				// 0044
+				code.Make(code.OpJump, 48),
+				// 0047
+				code.Make(code.OpNull),
+				// 0048
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// 两个分支共用数组长度的测试，长度不是1时直接跳转到nomatch
+			input:             `match ([1]) { [1] => 10, [a] => a }`,
+			expectedConstants: []any{1, 0, 1, 0, 10},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpArray, 1),
+				// 0006
+				code.Make(code.OpSetGlobal, 0),
+				// 0009
+				code.Make(code.OpGetGlobal, 0),
+				// 0012
+				code.Make(code.OpIsArray, 1, 0),
+				// 0016
+				code.Make(code.OpJumpNotTruthy, 49),
+				// 0019
+				code.Make(code.OpGetGlobal, 0),
+				// 0022
+				code.Make(code.OpConstant, 1),
+				// 0025
+				code.Make(code.OpIndex),
+				// 0026
+				code.Make(code.OpConstant, 2),
+				// 0029
+				code.Make(code.OpEqual),
+				// 0030
+				code.Make(code.OpJumpNotTruthy, 36),
+				// 0033
+				code.Make(code.OpJump, 52),
+				// 0036
+				code.Make(code.OpGetGlobal, 0),
+				// 0039
+				code.Make(code.OpConstant, 3),
+				// 0042
+				code.Make(code.OpIndex),
+				// 0043
+				code.Make(code.OpSetGlobal, 1),
+				// 0046
+				code.Make(code.OpJump, 58),
+				// 0049
+				code.Make(code.OpJump, 64),
+				// 0052
+				code.Make(code.OpConstant, 4),
+				// 0055
+				code.Make(code.OpJump, 65),
+				// 0058
+				code.Make(code.OpGetGlobal, 1),
+				// 0061
+				code.Make(code.OpJump, 65),
+				// 0064
+				code.Make(code.OpNull),
+				// 0065
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			runCompilerTest(t, tt)
+		})
+	}
+}
+
+func TestDestructuringLet(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `let [a, b] = [1, 2];`,
+			expectedConstants: []any{1, 2, 0, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpArray, 2),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpCheckArray, 2, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpIndex),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpIndex),
+				code.Make(code.OpSetGlobal, 2),
+			},
+		},
+		{
+			input:             `let {"n": n} = {};`,
+			expectedConstants: []any{"n", "n"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpHash, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpCheckHash),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpCheckKey),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpIndex),
+				code.Make(code.OpSetGlobal, 1),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			runCompilerTest(t, tt)
+		})
+	}
+}
+
+func TestAssignment(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `var x = 1; x = 2;`,
+			expectedConstants: []any{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// 闭包修改的var局部变量保存在单元中，闭包捕获单元
+			input: `fn() { var n = 0; fn() { n = n + 1 } }`,
+			expectedConstants: []any{
+				0,
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpLoadCell),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpStoreCell),
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpLoadCell),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpMakeCell),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpClosure, 2, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `let xs = [1]; xs[0] = 2;`,
+			expectedConstants: []any{1, 0, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			runCompilerTest(t, tt)
+		})
+	}
+}
+
+func TestAssignmentErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 1; x = 2;", "cannot assign to immutable variable: x"},
+		{"fn(a) { a = 1 }", "cannot assign to immutable variable: a"},
+		{"let f = fn() { f = 1 }", "cannot assign to immutable variable: f"},
+		{"len = 1", "cannot assign to immutable variable: len"},
+		{"try { 1 } catch (e) { e = 1 }", "cannot assign to immutable variable: e"},
+		{"var x = 1; let x = 2; x = 3", "cannot assign to immutable variable: x"},
+		{"y = 1", "undefined variable: y"},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+		err := compiler.Compile(parse(tt.input))
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%s: wrong error. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestTryExpressions(t *testing.T) {
+	tests := []struct {
+		compilerTestCase
+		expectedHandlers []code.Handler
+	}{
+		{
+			compilerTestCase{input: `1 + try { 2 } catch (e) { e }`, expectedConstants: []any{1, 2}, expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpConstant, 1),
+				// 0006
+				code.Make(code.OpJump, 15),
+				// 0009
+				code.Make(code.OpSetGlobal, 0),
+				// 0012
+				code.Make(code.OpGetGlobal, 0),
+				// 0015
+				code.Make(code.OpAdd),
+				// 0016
+				code.Make(code.OpPop),
+			}},
+			[]code.Handler{{Start: 3, End: 6, Target: 9, Depth: 1}},
+		},
+		{
+			compilerTestCase{input: `try { try { 1 } catch (a) { 2 } } catch (b) { }`, expectedConstants: []any{1, 2}, expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpJump, 12),
+				// 0006
+				code.Make(code.OpSetGlobal, 0),
+				// 0009
+				code.Make(code.OpConstant, 1),
+				// 0012
+				code.Make(code.OpJump, 19),
+				// 0015
+				code.Make(code.OpSetGlobal, 1),
+				// 0018
+				code.Make(code.OpNull),
+				// 0019
+				code.Make(code.OpPop),
+			}},
+			[]code.Handler{{Start: 0, End: 3, Target: 6, Depth: 0}, {Start: 0, End: 12, Target: 15, Depth: 0}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			runCompilerTest(t, tt.compilerTestCase)
+
+			compiler := New()
+			if err := compiler.Compile(parse(tt.input)); err != nil {
+				t.Fatalf("compiler error:%s", err)
+			}
+			handlers := compiler.Bytecode().Handlers
+			if !reflect.DeepEqual(handlers, tt.expectedHandlers) {
+				t.Fatalf("wrong handlers.\nwant=%+v\ngot =%+v", tt.expectedHandlers, handlers)
+			}
+		})
+	}
+}
+
+func TestTryInFunction(t *testing.T) {
+	compiler := New()
+	if err := compiler.Compile(parse(`fn(x) { try { x } catch (e) { } }`)); err != nil {
+		t.Fatalf("compiler error:%s", err)
+	}
+	fn := compiler.Bytecode().Constants[0].(*object.CompiledFunction)
+
+	expected := concatInstructions([]code.Instructions{
+		// 0000
+		code.Make(code.OpGetLocal, 0),
+		// 0003
+		code.Make(code.OpJump, 10),
+		// 0006
+		code.Make(code.OpSetLocal, 1),
+		// 0009
+		code.Make(code.OpNull),
+		// 0010
+		code.Make(code.OpReturnValue),
+	})
+	if err := testInstructions([]code.Instructions{expected}, fn.Instructions); err != nil {
+		t.Fatalf("testInstructions fail: %v", err)
+	}
+	if fn.NumLocals != 2 {
+		t.Fatalf("wrong NumLocals. want=2, got=%d", fn.NumLocals)
+	}
+	want := []code.Handler{{Start: 0, End: 3, Target: 6, Depth: 0}}
+	if !reflect.DeepEqual(fn.Handlers, want) {
+		t.Fatalf("wrong handlers.\nwant=%+v\ngot =%+v", want, fn.Handlers)
+	}
+}
+
+func TestCompilerLimits(t *testing.T) {
+	// 标识符中不能有数字，用字母给变量编号
+	name := func(i int) string {
+		var out []byte
+		for ; i > 0 || len(out) == 0; i /= 26 {
+			out = append(out, byte('a'+i%26))
+		}
+		return string(out)
+	}
+	repeat := func(format, sep string, n int) string {
+		items := make([]string, n)
+		for i := range items {
+			items[i] = fmt.Sprintf(format, name(i))
+		}
+		return strings.Join(items, sep)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"[" + repeat("\"%s\"", ",", 65537) + "]",
+			"compiler limit exceeded: more than 65536 constants",
+		},
+		{
+			repeat("let x%s = true", ";", 65537),
+			"compiler limit exceeded: more than 65536 global variables",
+		},
+		{
+			"len(" + strings.Repeat("true, ", 65535) + "true)",
+			"compiler limit exceeded: more than 65535 arguments in a call",
+		},
+		{
+			"let x = 1; if (x) {" + strings.Repeat("x;", 20000) + "}",
+			"compiler limit exceeded: more than 65536 bytes of instructions in a function",
+		},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+		err := compiler.Compile(parse(tt.input))
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%v", tt.expected, err)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package compiler
+
+import (
+	"Monkey/ast"
+	"Monkey/code"
+	"Monkey/lexer"
+	"Monkey/object"
+	"Monkey/parser"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// isImport 判断调用是否为import。import不是内置函数，被同名变量遮蔽时按普通调用处理
+func (c *Compiler) isImport(node *ast.CallExpression) bool {
+	ident, ok := node.Function.(*ast.Identifier)
+	if !ok || ident.Value != "import" {
+		return false
+	}
+	_, defined := c.symbolTable.Resolve(ident.Value)
+	return !defined
+}
+
+// compileImport 编译import。模块在编译期加载，编译为一个无参函数；
+// 运行时OpImport在首次执行时调用该函数，并把得到的模块缓存在一个隐藏的全局变量中。
+// 模块加载失败时不中止编译，执行到import时才报告错误，与求值器一样可以被catch捕获；
+// 只有宿主不允许读取模块时编译失败
+func (c *Compiler) compileImport(node *ast.CallExpression) error {
+	if len(node.Arguments) != 1 {
+		return fmt.Errorf("wrong number of arguments to import. got=%d, want=1", len(node.Arguments))
+	}
+	lit, ok := node.Arguments[0].(*ast.StringLiteral)
+	if !ok {
+		return fmt.Errorf("import path must be a string literal")
+	}
+
+	path, err := object.ResolveImportPath(c.dir, lit.Value)
+	if err != nil {
+		return fmt.Errorf("import %q: %s", lit.Value, err)
+	}
+	slot := c.moduleSlot(path)
+
+	fnIndex, ok := c.modules[path]
+	if !ok {
+		fnIndex, err = c.compileModule(lit.Value, path, slot)
+		var permission *object.PermissionError
+		switch {
+		case errors.As(err, &permission):
+			return err
+		case err != nil:
+			// 循环导入等错误与导入的位置有关，不缓存
+			fnIndex = c.compileLoadError(lit.Value, err)
+		default:
+			c.modules[path] = fnIndex
+		}
+	}
+
+	c.emit(code.OpImport, fnIndex, slot.Index)
+	return nil
+}
+
+// moduleSlot 返回缓存模块的全局变量。变量名包含空格，脚本中无法引用
+func (c *Compiler) moduleSlot(path string) Symbol {
+	name := "import " + path
+	if symbol, ok := c.globals.Resolve(name); ok {
+		return symbol
+	}
+	return c.globals.Define(name)
+}
+
+// compileModule 把模块文件编译为函数，返回其在常量池中的索引。
+// 模块的顶层绑定是该函数的局部变量，外层只有内置函数，看不到导入方的变量
+func (c *Compiler) compileModule(name string, path string, slot Symbol) (int, error) {
+	for i, loading := range c.importing {
+		if loading == path {
+			cycle := append(append([]string{}, c.importing[i:]...), path)
+			return 0, &object.ImportCycleError{Cycle: cycle}
+		}
+	}
+
+	src, err := c.host.ReadModule(path)
+	if err != nil {
+		return 0, fmt.Errorf("import %q: %w", name, err)
+	}
+	p := parser.New(lexer.New(string(src)))
+	program := p.ParseProgram()
+	if errors := p.Errors(); len(errors) != 0 {
+		return 0, fmt.Errorf("import %q: parse error:\n\t%s", name, strings.Join(errors, "\n\t"))
+	}
+	for _, stmt := range program.Statements {
+		if _, ok := stmt.(*ast.ReturnStatement); ok {
+			return 0, fmt.Errorf("import %q: return at module top level", name)
+		}
+	}
+
+	c.importing = append(c.importing, path)
+	dir, file := c.dir, c.file
+	c.dir, c.file = filepath.Dir(path), path
+	defer func() {
+		c.importing = c.importing[:len(c.importing)-1]
+		c.dir, c.file = dir, file
+	}()
+
+	outer := c.symbolTable
+	c.enterScope()
+	c.symbolTable = NewEnclosedSymbolTable(newBuiltinSymbolTable())
+
+	for _, stmt := range program.Statements {
+		err := c.Compile(stmt)
+		if err != nil {
+			c.leaveScope()
+			c.symbolTable = outer
+			return 0, fmt.Errorf("import %q: %w", name, err)
+		}
+	}
+
+	// 用导出的绑定构造模块，缓存后作为返回值
+	var exports []Symbol
+	for _, symbol := range c.symbolTable.Symbols() {
+		if object.IsExported(symbol.Name) {
+			exports = append(exports, symbol)
+		}
+	}
+	for _, symbol := range exports {
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: symbol.Name}))
+		c.emit(code.OpGetLocal, symbol.Index)
+	}
+	c.emit(code.OpHash, len(exports)*2)
+	c.emit(code.OpModule, c.addConstant(&object.String{Value: name}))
+	c.emit(code.OpSetGlobal, slot.Index)
+	c.emit(code.OpGetGlobal, slot.Index)
+	c.emit(code.OpReturnValue)
+
+	numLocals := c.symbolTable.NumDefinitions()
+	sourceMap := c.currentSourceMap()
+	instructions := c.leaveScope()
+	c.symbolTable = outer
+
+	compiledFn := &object.CompiledFunction{
+		Instructions: instructions,
+		NumLocals:    numLocals,
+		Name:         name,
+		SourceMap:    sourceMap,
+	}
+	return c.addConstant(compiledFn), nil
+}
+
+// compileLoadError 把加载失败的模块编译为抛出err的函数，返回其在常量池中的索引。
+// 抛出的哈希与虚拟机报告的运行时错误一样，type为RuntimeError
+func (c *Compiler) compileLoadError(name string, err error) int {
+	throw, _ := newBuiltinSymbolTable().Resolve("throw")
+	c.enterScope()
+	c.loadSymbol(throw)
+	for _, s := range []string{"message", err.Error(), "type", object.RuntimeErrorType} {
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: s}))
+	}
+	c.emit(code.OpHash, 4)
+	c.emit(code.OpCall, 1)
+	c.emit(code.OpReturnValue)
+
+	sourceMap := c.currentSourceMap()
+	instructions := c.leaveScope()
+	compiledFn := &object.CompiledFunction{
+		Instructions: instructions,
+		Name:         name,
+		SourceMap:    sourceMap,
+	}
+	return c.addConstant(compiledFn)
+}
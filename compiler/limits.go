@@ -0,0 +1,77 @@
+package compiler
+
+import (
+	"Monkey/code"
+	"errors"
+	"fmt"
+)
+
+// operandLimit 指令的一个操作数所代表的编译器限制
+type operandLimit struct {
+	what  string // 超出限制时报告的内容
+	index bool   // 操作数为索引时，个数的上限比操作数的最大值多1
+}
+
+var (
+	constantLimit = operandLimit{"constants", true}
+	globalLimit   = operandLimit{"global variables", true}
+	localLimit    = operandLimit{"local variables in a function", true}
+	freeLimit     = operandLimit{"captured variables in a function", true}
+	jumpLimit     = operandLimit{"bytes of instructions in a function", true}
+)
+
+// operandLimits 按操作码和操作数的位置
+var operandLimits = map[code.Opcode][]operandLimit{
+	code.OpConstant:       {constantLimit},
+	code.OpAddConst:       {constantLimit},
+	code.OpSubConst:       {constantLimit},
+	code.OpModule:         {constantLimit},
+	code.OpClosure:        {constantLimit, {"captured variables in a function", false}},
+	code.OpImport:         {constantLimit, globalLimit},
+	code.OpSetGlobal:      {globalLimit},
+	code.OpGetGlobal:      {globalLimit},
+	code.OpSetLocal:       {localLimit},
+	code.OpGetLocal:       {localLimit},
+	code.OpGetFree:        {freeLimit},
+	code.OpGetBuiltin:     {{"builtins", true}},
+	code.OpCall:           {{"arguments in a call", false}},
+	code.OpArray:          {{"elements in an array literal", false}},
+	code.OpHash:           {{"keys and values in a hash literal", false}},
+	code.OpJump:           {jumpLimit},
+	code.OpJumpNotTruthy:  {jumpLimit},
+	code.OpJumpNotEqual:   {jumpLimit},
+	code.OpJumpEqual:      {jumpLimit},
+	code.OpJumpNotGreater: {jumpLimit},
+	code.OpIsArray:        {{"elements in an array pattern", false}, {"rest flags", false}},
+	code.OpSliceFrom:      {{"elements in an array pattern", false}},
+	code.OpCheckArray:     {{"elements in an array pattern", false}, {"rest flags", false}},
+}
+
+// makeInstruction 生成指令，操作数超出范围时记录说明超出了哪项限制的错误，由Compile返回
+func (c *Compiler) makeInstruction(op code.Opcode, operands ...int) []byte {
+	ins, err := code.MakeChecked(op, operands...)
+	if err == nil {
+		return ins
+	}
+	if c.err == nil {
+		c.err = limitError(err)
+	}
+	return code.Make(op, operands...)
+}
+
+func limitError(err error) error {
+	var operandErr *code.OperandError
+	if !errors.As(err, &operandErr) {
+		return err
+	}
+	limits := operandLimits[operandErr.Op]
+	if operandErr.Index >= len(limits) {
+		return err
+	}
+	limit := limits[operandErr.Index]
+	max := operandErr.Max()
+	if limit.index {
+		max++
+	}
+	return fmt.Errorf("compiler limit exceeded: more than %d %s", max, limit.what)
+}
@@ -0,0 +1,486 @@
+package compiler
+
+import (
+	"Monkey/ast"
+	"Monkey/code"
+	"Monkey/object"
+	"fmt"
+	"strings"
+)
+
+// match表达式编译为决策树。每个分支的模式展开为对子值的一组测试，称为一行；
+// 每次取第一行的第一个测试生成指令，再在测试成立和不成立两个分支上分别化简所有行：
+// 由已知结果能推出成立的测试删除，推出不成立的行整行删除。
+// 第一行没有剩余测试时该分支的模式匹配，绑定变量后检查guard，guard不成立时继续匹配剩下的行。
+// 这样同一个子值的类型、数组长度和字面量在一条路径上最多测试一次。
+//
+// 生成的指令：
+//
+//	        保存match的值到隐藏变量
+//	        决策树，每个叶子跳转到对应的分支体或nomatch
+//	body_i: 分支体，值留在栈上
+//	        OpJump end
+//	nomatch:OpNull
+//	end:
+
+// stepKind 访问子值的一步
+type stepKind int
+
+const (
+	stepIndex stepKind = iota // 数组的第index个元素
+	stepKey                   // 哈希中key对应的值
+	stepSlice                 // 数组从第index个开始的元素组成的新数组，即...rest
+)
+
+type pathStep struct {
+	kind  stepKind
+	index int
+	key   object.Object
+}
+
+// matchPath 从match的值到子值的访问路径
+type matchPath []pathStep
+
+// with 返回在p之后增加一步的新路径，不修改p
+func (p matchPath) with(step pathStep) matchPath {
+	return append(p[:len(p):len(p)], step)
+}
+
+func (p matchPath) String() string {
+	var out strings.Builder
+	for _, step := range p {
+		switch step.kind {
+		case stepIndex:
+			fmt.Fprintf(&out, "[%d]", step.index)
+		case stepKey:
+			fmt.Fprintf(&out, "[%s]", describeValue(step.key))
+		case stepSlice:
+			fmt.Fprintf(&out, "[%d:]", step.index)
+		}
+	}
+	return out.String()
+}
+
+type testKind int
+
+const (
+	testArray testKind = iota // 是长度为n的数组，rest为true时长度不少于n
+	testHash                  // 是哈希
+	testKey                   // 是包含键value的哈希
+	testEqual                 // 等于字面量value
+)
+
+type matchTest struct {
+	path  matchPath
+	kind  testKind
+	n     int
+	rest  bool
+	value object.Object
+	id    string // 路径和测试内容，相同的测试id相同
+}
+
+func newMatchTest(path matchPath, kind testKind, n int, rest bool, value object.Object) *matchTest {
+	t := &matchTest{path: path, kind: kind, n: n, rest: rest, value: value}
+	t.id = fmt.Sprintf("%s %d %d %t", path, kind, n, rest)
+	if value != nil {
+		t.id += " " + describeValue(value)
+	}
+	return t
+}
+
+// restOperand OpIsArray和OpCheckArray表示是否有...rest的操作数
+func (t *matchTest) restOperand() int {
+	if t.rest {
+		return 1
+	}
+	return 0
+}
+
+// describeValue 区分类型的字面量表示，1和"1"不同
+func describeValue(value object.Object) string {
+	return fmt.Sprintf("%s %q", value.Type(), value.Inspect())
+}
+
+// matchBinding 模式匹配后把path处的子值保存到symbol
+type matchBinding struct {
+	symbol Symbol
+	path   matchPath
+}
+
+// matchRow 一个分支中还需要测试的内容
+type matchRow struct {
+	arm      int
+	tests    []*matchTest
+	bindings []matchBinding
+}
+
+type matchCompiler struct {
+	c       *Compiler
+	arms    []*ast.MatchArm
+	symbols []map[string]Symbol // 每个分支的模式绑定的变量
+	subject Symbol
+	bodies  [][]int // 每个分支中跳转到分支体的OpJump的位置
+	noMatch []int   // 没有分支匹配时跳转到nomatch的OpJump的位置
+}
+
+func (c *Compiler) compileMatch(node *ast.MatchExpression) error {
+	err := c.Compile(node.Subject)
+	if err != nil {
+		return err
+	}
+	m := &matchCompiler{c: c, arms: node.Arms, bodies: make([][]int, len(node.Arms))}
+	// 变量名包含空格，脚本中无法引用
+	m.subject = c.symbolTable.Define("match subject")
+	c.storeSymbol(m.subject)
+
+	m.defineBindings()
+	rows := make([]*matchRow, len(node.Arms))
+	for i, arm := range node.Arms {
+		rows[i], err = m.row(i, arm.Pattern, m.symbols[i])
+		if err != nil {
+			return err
+		}
+	}
+	err = m.compileTree(rows)
+	if err != nil {
+		return err
+	}
+
+	var ends []int
+	for i, arm := range node.Arms {
+		if len(m.bodies[i]) == 0 {
+			// 前面的分支总能匹配，这个分支执行不到，仍然编译以报告其中的错误
+			err := m.inArm(i, func() error {
+				if arm.Guard != nil {
+					if err := c.compileDiscarded(arm.Guard); err != nil {
+						return err
+					}
+				}
+				return c.compileDiscarded(arm.Body)
+			})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		m.patch(m.bodies[i])
+		if err := m.inArm(i, func() error { return c.Compile(arm.Body) }); err != nil {
+			return err
+		}
+		ends = append(ends, c.emit(code.OpJump, 9999))
+	}
+	m.patch(m.noMatch)
+	c.emit(code.OpNull)
+	m.patch(ends)
+	return nil
+}
+
+// compileDestructuring 把栈顶的值按pattern解构。依次检查模式展开得到的每个测试，
+// 不满足时虚拟机报告不匹配的错误；全部通过后与let一样为每个名字定义新的变量，
+// mutable为true时定义的变量可以重新赋值
+func (c *Compiler) compileDestructuring(pattern ast.Expression, mutable bool) error {
+	m := &matchCompiler{c: c}
+	// 变量名包含空格，脚本中无法引用
+	m.subject = c.symbolTable.Define("destructuring value")
+	c.storeSymbol(m.subject)
+
+	symbols := make(map[string]Symbol)
+	for _, ident := range ast.PatternBindings(pattern) {
+		if mutable {
+			symbols[ident.Value] = c.symbolTable.DefineMutable(ident.Value)
+		} else {
+			symbols[ident.Value] = c.symbolTable.Define(ident.Value)
+		}
+	}
+	row, err := m.row(0, pattern, symbols)
+	if err != nil {
+		return err
+	}
+
+	for _, test := range row.tests {
+		m.load(test.path)
+		switch test.kind {
+		case testArray:
+			c.emit(code.OpCheckArray, test.n, test.restOperand())
+		case testHash:
+			c.emit(code.OpCheckHash)
+		case testKey:
+			m.emitValue(test.value)
+			c.emit(code.OpCheckKey)
+		case testEqual:
+			m.emitValue(test.value)
+			c.emit(code.OpCheckEqual)
+		}
+	}
+	for _, binding := range row.bindings {
+		m.load(binding.path)
+		c.initSymbol(binding.symbol)
+	}
+	return nil
+}
+
+// defineBindings 为每个分支的模式中绑定的名字分别定义变量。
+// 变量只在所在分支的guard和分支体中可见，见inArm
+func (m *matchCompiler) defineBindings() {
+	saved := m.c.symbolTable.snapshot()
+	m.symbols = make([]map[string]Symbol, len(m.arms))
+	for i, arm := range m.arms {
+		m.symbols[i] = make(map[string]Symbol)
+		for _, ident := range ast.PatternBindings(arm.Pattern) {
+			m.symbols[i][ident.Value] = m.c.symbolTable.Define(ident.Value)
+		}
+	}
+	m.c.symbolTable.restore(saved)
+}
+
+// inArm 在第arm个分支的作用域中执行compile：该分支的模式绑定的名字可见，
+// 结束后恢复原来的名字，分支中的绑定和定义不会影响其他分支和match之后的代码，与求值器一致
+func (m *matchCompiler) inArm(arm int, compile func() error) error {
+	saved := m.c.symbolTable.snapshot()
+	for name, symbol := range m.symbols[arm] {
+		m.c.symbolTable.store[name] = symbol
+	}
+	err := compile()
+	m.c.symbolTable.restore(saved)
+	return err
+}
+
+// row 把模式展开为测试和绑定，测试按先外后内的顺序排列，
+// 测试子值之前总是先测试了包含它的数组或哈希
+func (m *matchCompiler) row(arm int, pattern ast.Expression, symbols map[string]Symbol) (*matchRow, error) {
+	row := &matchRow{arm: arm}
+	var flatten func(pattern ast.Expression, path matchPath) error
+	flatten = func(pattern ast.Expression, path matchPath) error {
+		switch pattern := pattern.(type) {
+		case *ast.Identifier:
+			if pattern.Value != ast.Wildcard {
+				row.bindings = append(row.bindings, matchBinding{symbols[pattern.Value], path})
+			}
+		case *ast.IntegerLiteral, *ast.StringLiteral, *ast.Boolean:
+			row.tests = append(row.tests, newMatchTest(path, testEqual, 0, false, patternValue(pattern)))
+		case *ast.ArrayPattern:
+			n := len(pattern.Elements)
+			row.tests = append(row.tests, newMatchTest(path, testArray, n, pattern.Rest != nil, nil))
+			for i, element := range pattern.Elements {
+				if err := flatten(element, path.with(pathStep{kind: stepIndex, index: i})); err != nil {
+					return err
+				}
+			}
+			if pattern.Rest != nil {
+				return flatten(pattern.Rest, path.with(pathStep{kind: stepSlice, index: n}))
+			}
+		case *ast.HashPattern:
+			row.tests = append(row.tests, newMatchTest(path, testHash, 0, false, nil))
+			for i, key := range pattern.Keys {
+				value := patternValue(key)
+				if value == nil {
+					return fmt.Errorf("unsupported hash pattern key: %s", key)
+				}
+				row.tests = append(row.tests, newMatchTest(path, testKey, 0, false, value))
+				if err := flatten(pattern.Values[i], path.with(pathStep{kind: stepKey, key: value})); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("unsupported pattern: %s", pattern)
+		}
+		return nil
+	}
+	return row, flatten(pattern, nil)
+}
+
+// patternValue 返回模式中字面量对应的对象，不是字面量时返回nil
+func patternValue(pattern ast.Expression) object.Object {
+	switch pattern := pattern.(type) {
+	case *ast.IntegerLiteral:
+		return &object.Integer{Value: pattern.Value}
+	case *ast.StringLiteral:
+		return &object.String{Value: pattern.Value}
+	case *ast.Boolean:
+		return &object.Boolean{Value: pattern.Value}
+	}
+	return nil
+}
+
+func (m *matchCompiler) compileTree(rows []*matchRow) error {
+	if len(rows) == 0 {
+		m.noMatch = append(m.noMatch, m.c.emit(code.OpJump, 9999))
+		return nil
+	}
+	if len(rows[0].tests) == 0 {
+		return m.compileLeaf(rows)
+	}
+
+	test := rows[0].tests[0]
+	jump := m.compileTest(test)
+	err := m.compileTree(specialize(rows, test, true))
+	if err != nil {
+		return err
+	}
+	// 成立的分支总是以跳转结束
+	m.patch([]int{jump})
+	return m.compileTree(specialize(rows, test, false))
+}
+
+// compileLeaf 第一行已经匹配：绑定变量，guard成立时跳转到分支体，否则继续匹配剩下的行
+func (m *matchCompiler) compileLeaf(rows []*matchRow) error {
+	row := rows[0]
+	for _, binding := range row.bindings {
+		m.load(binding.path)
+		m.c.storeSymbol(binding.symbol)
+	}
+
+	guard := m.arms[row.arm].Guard
+	if guard == nil {
+		m.bodies[row.arm] = append(m.bodies[row.arm], m.c.emit(code.OpJump, 9999))
+		return nil
+	}
+	var jump int
+	err := m.inArm(row.arm, func() (err error) {
+		jump, err = m.c.compileCondition(guard)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	m.bodies[row.arm] = append(m.bodies[row.arm], m.c.emit(code.OpJump, 9999))
+	m.patch([]int{jump})
+	return m.compileTree(rows[1:])
+}
+
+// compileTest 生成测试的指令，返回测试不成立时跳转的指令的位置
+func (m *matchCompiler) compileTest(test *matchTest) int {
+	m.load(test.path)
+	switch test.kind {
+	case testArray:
+		m.c.emit(code.OpIsArray, test.n, test.restOperand())
+	case testHash:
+		m.c.emit(code.OpIsHash)
+	case testKey:
+		m.emitValue(test.value)
+		m.c.emit(code.OpHasKey)
+	case testEqual:
+		m.emitValue(test.value)
+		if m.c.optimization >= OptimizeFused {
+			return m.c.emit(code.OpJumpNotEqual, 9999)
+		}
+		m.c.emit(code.OpEqual)
+	}
+	return m.c.emit(code.OpJumpNotTruthy, 9999)
+}
+
+// load 把path处的子值压栈
+func (m *matchCompiler) load(path matchPath) {
+	m.c.loadSymbol(m.subject)
+	for _, step := range path {
+		switch step.kind {
+		case stepIndex:
+			m.emitValue(&object.Integer{Value: int64(step.index)})
+			m.c.emit(code.OpIndex)
+		case stepKey:
+			m.emitValue(step.key)
+			m.c.emit(code.OpIndex)
+		case stepSlice:
+			m.c.emit(code.OpSliceFrom, step.index)
+		}
+	}
+}
+
+func (m *matchCompiler) emitValue(value object.Object) {
+	switch value := value.(type) {
+	case *object.Boolean:
+		if value.Value {
+			m.c.emit(code.OpTrue)
+		} else {
+			m.c.emit(code.OpFalse)
+		}
+	default:
+		m.c.emit(code.OpConstant, m.c.addConstant(value))
+	}
+}
+
+// patch 把jumps中的跳转指向当前位置
+func (m *matchCompiler) patch(jumps []int) {
+	target := len(m.c.currentInstructions())
+	for _, pos := range jumps {
+		m.c.changeOperand(pos, target)
+	}
+}
+
+// specialize 已知测试known的结果为outcome时化简每一行
+func specialize(rows []*matchRow, known *matchTest, outcome bool) []*matchRow {
+	var result []*matchRow
+next:
+	for _, row := range rows {
+		var tests []*matchTest
+		for _, t := range row.tests {
+			value, ok := implies(known, outcome, t)
+			if !ok {
+				tests = append(tests, t)
+				continue
+			}
+			if !value {
+				continue next
+			}
+		}
+		result = append(result, &matchRow{arm: row.arm, tests: tests, bindings: row.bindings})
+	}
+	return result
+}
+
+// implies 已知测试known的结果为outcome时推断测试t的结果，无法推断时ok为false
+func implies(known *matchTest, outcome bool, t *matchTest) (result bool, ok bool) {
+	if known.id == t.id {
+		return outcome, true
+	}
+	if known.path.String() != t.path.String() {
+		return false, false
+	}
+
+	if !outcome {
+		switch {
+		case known.kind == testHash && t.kind == testKey:
+			return false, true
+		case known.kind == testArray && known.rest && t.kind == testArray && t.n >= known.n:
+			// 不是长度不少于n的数组，也就不是更长的数组
+			return false, true
+		}
+		return false, false
+	}
+
+	switch known.kind {
+	case testArray:
+		if t.kind != testArray {
+			return false, true
+		}
+		if !known.rest {
+			if t.rest {
+				return known.n >= t.n, true
+			}
+			return known.n == t.n, true
+		}
+		if t.rest && known.n >= t.n {
+			return true, true
+		}
+		if !t.rest && t.n < known.n {
+			return false, true
+		}
+	case testHash:
+		if t.kind == testArray || t.kind == testEqual {
+			return false, true
+		}
+	case testKey:
+		switch t.kind {
+		case testHash:
+			return true, true
+		case testArray, testEqual:
+			return false, true
+		}
+	case testEqual:
+		if t.kind == testEqual {
+			return known.value.Equals(t.value), true
+		}
+		return false, true
+	}
+	return false, false
+}
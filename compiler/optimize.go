@@ -0,0 +1,304 @@
+package compiler
+
+import (
+	"Monkey/ast"
+	"Monkey/code"
+	"Monkey/object"
+)
+
+// 优化级别，见SetOptimization
+const (
+	OptimizeNone     = 0
+	OptimizeConstant = 1
+	OptimizeJumps    = 2
+	OptimizeFused    = 3
+)
+
+// SetOptimization 设置优化级别：
+// 0不优化；1折叠常量表达式，删除条件为常量的if的死分支，合并常量池中相同的整数和字符串；
+// 2在1的基础上把跳转到OpJump的跳转直接指向最终的目标；
+// 3在2的基础上用超级指令代替常见的指令序列：加减整数常量，if条件中的比较和跳转。
+// 优化不改变程序的结果
+func (c *Compiler) SetOptimization(level int) {
+	c.optimization = level
+}
+
+// compileFolded 表达式的值在编译时可以确定时，直接生成该值并返回true
+func (c *Compiler) compileFolded(node ast.Expression) bool {
+	if c.optimization < OptimizeConstant {
+		return false
+	}
+	value, ok := foldConstant(node)
+	if !ok {
+		return false
+	}
+	switch value := value.(type) {
+	case *object.Boolean:
+		if value.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+	default:
+		c.emit(code.OpConstant, c.addConstant(value))
+	}
+	return true
+}
+
+// foldConstant 计算只由字面量组成的表达式的值，和虚拟机的运算结果一致。
+// 虚拟机运行时会出错的表达式（如除以0、对布尔值取负）不折叠
+func foldConstant(node ast.Expression) (object.Object, bool) {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		return &object.Integer{Value: node.Value}, true
+	case *ast.Boolean:
+		return &object.Boolean{Value: node.Value}, true
+	case *ast.StringLiteral:
+		return &object.String{Value: node.Value}, true
+	case *ast.PrefixExpression:
+		right, ok := foldConstant(node.Right)
+		if !ok {
+			return nil, false
+		}
+		switch node.Operator {
+		case "-":
+			if right, ok := right.(*object.Integer); ok {
+				return &object.Integer{Value: -right.Value}, true
+			}
+		case "!":
+			return &object.Boolean{Value: !isTruthy(right)}, true
+		}
+	case *ast.InfixExpression:
+		left, ok := foldConstant(node.Left)
+		if !ok {
+			return nil, false
+		}
+		right, ok := foldConstant(node.Right)
+		if !ok {
+			return nil, false
+		}
+		return foldInfix(node.Operator, left, right)
+	}
+	return nil, false
+}
+
+func foldInfix(operator string, left, right object.Object) (object.Object, bool) {
+	l, lok := left.(*object.Integer)
+	r, rok := right.(*object.Integer)
+	if lok && rok {
+		switch operator {
+		case "+":
+			return &object.Integer{Value: l.Value + r.Value}, true
+		case "-":
+			return &object.Integer{Value: l.Value - r.Value}, true
+		case "*":
+			return &object.Integer{Value: l.Value * r.Value}, true
+		case "/":
+			if r.Value == 0 {
+				return nil, false
+			}
+			return &object.Integer{Value: l.Value / r.Value}, true
+		case ">":
+			return &object.Boolean{Value: l.Value > r.Value}, true
+		case "<":
+			return &object.Boolean{Value: l.Value < r.Value}, true
+		}
+	}
+	ls, lok := left.(*object.String)
+	rs, rok := right.(*object.String)
+	if lok && rok && operator == "+" {
+		return &object.String{Value: ls.Value + rs.Value}, true
+	}
+	switch operator {
+	case "==":
+		return &object.Boolean{Value: left.Equals(right)}, true
+	case "!=":
+		return &object.Boolean{Value: !left.Equals(right)}, true
+	}
+	return nil, false
+}
+
+// isTruthy 与虚拟机相同：只有false和null为假
+func isTruthy(obj object.Object) bool {
+	switch obj := obj.(type) {
+	case *object.Boolean:
+		return obj.Value
+	case *object.Null:
+		return false
+	default:
+		return true
+	}
+}
+
+// compileConstantIf 条件为常量时只生成会执行的分支的指令。
+// 另一个分支仍然按源码顺序编译到丢弃的作用域中，使其中定义的变量和不优化时一致
+func (c *Compiler) compileConstantIf(node *ast.IfExpression) (bool, error) {
+	if c.optimization < OptimizeConstant {
+		return false, nil
+	}
+	condition, ok := foldConstant(node.Condition)
+	if !ok {
+		return false, nil
+	}
+
+	taken := isTruthy(condition)
+	if err := c.compileBranch(node.Consequence, taken); err != nil {
+		return true, err
+	}
+	if node.Alternative != nil {
+		return true, c.compileBranch(node.Alternative, !taken)
+	}
+	if !taken {
+		c.emit(code.OpNull)
+	}
+	return true, nil
+}
+
+// compileBranch 编译if的一个分支，taken为false时丢弃生成的指令
+func (c *Compiler) compileBranch(block *ast.BlockStatement, taken bool) error {
+	if !taken {
+		return c.compileDiscarded(block)
+	}
+	start := len(c.currentInstructions())
+	if err := c.Compile(block); err != nil {
+		return err
+	}
+	if len(c.currentInstructions()) > start && c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	}
+	return nil
+}
+
+// compileDiscarded 编译node但丢弃生成的指令
+func (c *Compiler) compileDiscarded(node ast.Node) error {
+	c.scopes = append(c.scopes, CompilationScope{
+		instructions: code.Instructions{},
+		sourceMap:    &code.SourceMap{File: c.file},
+	})
+	c.scopeIndex++
+	defer func() {
+		c.scopes = c.scopes[:len(c.scopes)-1]
+		c.scopeIndex--
+	}()
+	return c.Compile(node)
+}
+
+// constantKey 可以合并的常量：整数和字符串
+type constantKey struct {
+	typ   object.ObjectType
+	value interface{}
+}
+
+// dedupeConstant 常量池中已有相同的常量时返回其索引
+func (c *Compiler) dedupeConstant(constant object.Object) (int, bool) {
+	var key constantKey
+	switch constant := constant.(type) {
+	case *object.Integer:
+		key = constantKey{constant.Type(), constant.Value}
+	case *object.String:
+		key = constantKey{constant.Type(), constant.Value}
+	default:
+		return 0, false
+	}
+
+	if c.constantIndexes == nil {
+		// NewWithState传入的常量池中也可能有相同的常量
+		c.constantIndexes = make(map[constantKey]int)
+		for i, existing := range c.constants {
+			if existing, ok := existing.(*object.Integer); ok {
+				c.constantIndexes[constantKey{existing.Type(), existing.Value}] = i
+			}
+			if existing, ok := existing.(*object.String); ok {
+				c.constantIndexes[constantKey{existing.Type(), existing.Value}] = i
+			}
+		}
+	}
+	if i, ok := c.constantIndexes[key]; ok {
+		return i, true
+	}
+	c.constantIndexes[key] = len(c.constants)
+	return 0, false
+}
+
+// compileFusedInfix 右操作数为整数常量的加减法编译为OpAddConst或OpSubConst
+func (c *Compiler) compileFusedInfix(node *ast.InfixExpression) (bool, error) {
+	if c.optimization < OptimizeFused || (node.Operator != "+" && node.Operator != "-") {
+		return false, nil
+	}
+	right, _ := foldConstant(node.Right)
+	if _, ok := right.(*object.Integer); !ok {
+		return false, nil
+	}
+
+	if err := c.Compile(node.Left); err != nil {
+		return true, err
+	}
+	op := code.OpAddConst
+	if node.Operator == "-" {
+		op = code.OpSubConst
+	}
+	c.emit(op, c.addConstant(right))
+	return true, nil
+}
+
+// fusedJumps 条件中的比较运算符对应的条件不成立时跳转的超级指令
+var fusedJumps = map[string]code.Opcode{
+	"==": code.OpJumpNotEqual,
+	"!=": code.OpJumpEqual,
+	">":  code.OpJumpNotGreater,
+	"<":  code.OpJumpNotGreater, // 和OpGreaterThan一样交换操作数
+}
+
+// compileCondition 编译if的条件和条件不成立时的跳转，返回跳转指令的位置，目标留待回填
+func (c *Compiler) compileCondition(condition ast.Expression) (int, error) {
+	if infix, ok := condition.(*ast.InfixExpression); ok && c.optimization >= OptimizeFused {
+		if op, ok := fusedJumps[infix.Operator]; ok {
+			left, right := infix.Left, infix.Right
+			if infix.Operator == "<" {
+				left, right = right, left
+			}
+			if err := c.Compile(left); err != nil {
+				return 0, err
+			}
+			if err := c.Compile(right); err != nil {
+				return 0, err
+			}
+			return c.emit(op, 9999), nil
+		}
+	}
+
+	if err := c.Compile(condition); err != nil {
+		return 0, err
+	}
+	return c.emit(code.OpJumpNotTruthy, 9999), nil
+}
+
+// isJump 操作数为跳转目标的指令
+func isJump(op code.Opcode) bool {
+	switch op {
+	case code.OpJump, code.OpJumpNotTruthy, code.OpJumpNotEqual, code.OpJumpEqual, code.OpJumpNotGreater:
+		return true
+	}
+	return false
+}
+
+// collapseJumps 把目标是OpJump的跳转直接指向最终的目标，指令长度不变
+func collapseJumps(ins code.Instructions) {
+	for ip := 0; ip < len(ins); {
+		op := code.Opcode(ins[ip])
+		def, err := code.Lookup(ins[ip])
+		if err != nil {
+			return
+		}
+		if isJump(op) {
+			target := int(code.ReadUnit16(ins[ip+1:]))
+			// 跳转链的长度不会超过指令数，防止跳转成环时死循环
+			for steps := 0; steps < len(ins) && target < len(ins) && code.Opcode(ins[target]) == code.OpJump; steps++ {
+				target = int(code.ReadUnit16(ins[target+1:]))
+			}
+			copy(ins[ip:], code.Make(op, target))
+		}
+		_, read := code.ReadOperands(def, ins[ip+1:])
+		ip += 1 + read
+	}
+}
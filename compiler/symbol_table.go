@@ -1,22 +1,41 @@
 package compiler
 
+import (
+	"fmt"
+	"sort"
+)
+
 // SymbolScope 作用域使用SymbolScope别名，SymbolScope本身不重要，主要是有唯一性；
 // 使用String是为了方便调式。
 type SymbolScope string
 
 const (
-	GlobalScope SymbolScope = "GLOBAL" //全局作用域
+	GlobalScope   SymbolScope = "GLOBAL"   //全局作用域
+	LocalScope    SymbolScope = "LOCAL"    //函数内的局部作用域
+	BuiltinScope  SymbolScope = "BUILTIN"  //内置函数
+	FreeScope     SymbolScope = "FREE"     //闭包引用的外层局部变量
+	FunctionScope SymbolScope = "FUNCTION" //函数体内对函数自身的引用
 )
 
 type Symbol struct {
-	Name  string
-	Scope SymbolScope // 作用域
-	Index int         // 索引
+	Name    string
+	Scope   SymbolScope // 作用域
+	Index   int         // 索引
+	Mutable bool        // var定义的变量，可以重新赋值
+}
+
+// boxed var定义的局部变量保存在单元中，闭包捕获的是单元，赋值对外层函数和闭包都可见；
+// 全局变量不会被捕获，直接保存值
+func (s Symbol) boxed() bool {
+	return s.Mutable && s.Scope != GlobalScope
 }
 
 type SymbolTable struct {
+	Outer *SymbolTable // 外层符号表，全局符号表的Outer为nil
+
 	store          map[string]Symbol // string为标识符，可以将标识符和Symbol相关联
 	numDefinitions int
+	FreeSymbols    []Symbol // 当前作用域引用的外层局部变量，按自由变量的索引排列
 }
 
 func NewSymbolTable() *SymbolTable {
@@ -24,18 +43,142 @@ func NewSymbolTable() *SymbolTable {
 	return &SymbolTable{store: s}
 }
 
+// NewEnclosedSymbolTable 创建嵌套在outer中的符号表，用于函数体
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
 // Define 将标识符作为参数
-// 创建定义并返回Symbol
+// 创建定义并返回Symbol；在嵌套的符号表中定义的是局部变量
 func (s *SymbolTable) Define(name string) Symbol {
-	symbol := Symbol{Name: name, Index: s.numDefinitions, Scope: GlobalScope}
+	symbol := Symbol{Name: name, Index: s.numDefinitions}
+	if s.Outer == nil {
+		symbol.Scope = GlobalScope
+	} else {
+		symbol.Scope = LocalScope
+	}
 	s.store[name] = symbol
 	s.numDefinitions++
 	return symbol
 }
 
+// DefineMutable 与Define相同，但定义的变量可以重新赋值，用于var
+func (s *SymbolTable) DefineMutable(name string) Symbol {
+	symbol := s.Define(name)
+	symbol.Mutable = true
+	s.store[name] = symbol
+	return symbol
+}
+
+// DefineBuiltin 以index定义内置函数，不占用变量的索引
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	symbol := Symbol{Name: name, Index: index, Scope: BuiltinScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+// DefineFunctionName 在函数体的符号表中定义函数自身的名字，用于递归调用
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+// defineFree 把外层的局部变量original登记为当前作用域的自由变量
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1, Scope: FreeScope, Mutable: original.Mutable}
+	s.store[original.Name] = symbol
+	return symbol
+}
+
 // Resolve 将一个定义的标识符交给符号表
-// 返回与其相关的Define
+// 返回与其相关的Define；当前作用域找不到时到外层查找，
+// 外层函数的局部变量会被转换为当前作用域的自由变量
 func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 	obj, ok := s.store[name]
+	if !ok && s.Outer != nil {
+		obj, ok = s.Outer.Resolve(name)
+		if !ok {
+			return obj, ok
+		}
+		if obj.Scope == GlobalScope || obj.Scope == BuiltinScope {
+			return obj, ok
+		}
+		return s.defineFree(obj), true
+	}
 	return obj, ok
 }
+
+// ResolveAssign 解析赋值的目标name，name没有定义或不能重新赋值时返回错误：
+// 只有var定义的变量可以重新赋值，let定义的变量、函数参数和内置函数都不可以
+func (s *SymbolTable) ResolveAssign(name string) (Symbol, error) {
+	symbol, ok := s.Resolve(name)
+	if !ok {
+		return symbol, fmt.Errorf("undefined variable: %s", name)
+	}
+	if !symbol.Mutable {
+		return symbol, fmt.Errorf("cannot assign to immutable variable: %s", name)
+	}
+	return symbol, nil
+}
+
+// Copy 复制符号表，之后在副本中定义的变量不影响原符号表；外层符号表共用
+func (s *SymbolTable) Copy() *SymbolTable {
+	store := make(map[string]Symbol, len(s.store))
+	for name, symbol := range s.store {
+		store[name] = symbol
+	}
+	return &SymbolTable{
+		Outer:          s.Outer,
+		store:          store,
+		numDefinitions: s.numDefinitions,
+		FreeSymbols:    append([]Symbol(nil), s.FreeSymbols...),
+	}
+}
+
+// snapshot 记录当前作用域中可见的名字，之后用restore撤销其间的定义，见matchCompiler.inArm
+func (s *SymbolTable) snapshot() map[string]Symbol {
+	saved := make(map[string]Symbol, len(s.store))
+	for name, symbol := range s.store {
+		saved[name] = symbol
+	}
+	return saved
+}
+
+// restore 恢复snapshot时可见的名字。已分配的变量索引不回收；
+// 其间引用的自由变量保留，避免再次引用时重复捕获
+func (s *SymbolTable) restore(saved map[string]Symbol) {
+	for name, symbol := range s.store {
+		if symbol.Scope == FreeScope {
+			continue
+		}
+		if previous, ok := saved[name]; ok {
+			s.store[name] = previous
+		} else {
+			delete(s.store, name)
+		}
+	}
+}
+
+// NumDefinitions 当前作用域中定义的变量个数
+func (s *SymbolTable) NumDefinitions() int {
+	return s.numDefinitions
+}
+
+// Symbols 返回当前作用域中定义的变量，按索引排序；同名变量只保留最后一次定义
+func (s *SymbolTable) Symbols() []Symbol {
+	symbols := make([]Symbol, 0, len(s.store))
+	for _, symbol := range s.store {
+		if symbol.Scope == GlobalScope || symbol.Scope == LocalScope {
+			symbols = append(symbols, symbol)
+		}
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		return symbols[i].Index < symbols[j].Index
+	})
+	return symbols
+}
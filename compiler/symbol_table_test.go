@@ -43,3 +43,188 @@ func TestResolveGlobal(t *testing.T) {
 		}
 	}
 }
+
+func TestResolveLocal(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	global.Define("b")
+
+	local := NewEnclosedSymbolTable(global)
+	local.Define("c")
+	local.Define("d")
+
+	expected := []Symbol{
+		{Name: "a", Scope: GlobalScope, Index: 0},
+		{Name: "b", Scope: GlobalScope, Index: 1},
+		{Name: "c", Scope: LocalScope, Index: 0},
+		{Name: "d", Scope: LocalScope, Index: 1},
+	}
+
+	for _, sym := range expected {
+		result, ok := local.Resolve(sym.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", sym.Name)
+			continue
+		}
+		if result != sym {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", sym.Name, sym, result)
+		}
+	}
+}
+
+func TestDefineResolveBuiltins(t *testing.T) {
+	global := NewSymbolTable()
+	firstLocal := NewEnclosedSymbolTable(global)
+	secondLocal := NewEnclosedSymbolTable(firstLocal)
+
+	expected := []Symbol{
+		{Name: "a", Scope: BuiltinScope, Index: 0},
+		{Name: "c", Scope: BuiltinScope, Index: 1},
+	}
+	for i, v := range expected {
+		global.DefineBuiltin(i, v.Name)
+	}
+
+	for _, table := range []*SymbolTable{global, firstLocal, secondLocal} {
+		for _, sym := range expected {
+			result, ok := table.Resolve(sym.Name)
+			if !ok {
+				t.Errorf("name %s not resolvable", sym.Name)
+				continue
+			}
+			if result != sym {
+				t.Errorf("expected %s to resolve to %+v, got=%+v", sym.Name, sym, result)
+			}
+		}
+	}
+}
+
+func TestResolveFree(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	firstLocal := NewEnclosedSymbolTable(global)
+	firstLocal.Define("c")
+
+	secondLocal := NewEnclosedSymbolTable(firstLocal)
+	secondLocal.Define("e")
+
+	expected := []Symbol{
+		{Name: "a", Scope: GlobalScope, Index: 0},
+		{Name: "c", Scope: FreeScope, Index: 0},
+		{Name: "e", Scope: LocalScope, Index: 0},
+	}
+	for _, sym := range expected {
+		result, ok := secondLocal.Resolve(sym.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", sym.Name)
+			continue
+		}
+		if result != sym {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", sym.Name, sym, result)
+		}
+	}
+
+	expectedFree := []Symbol{{Name: "c", Scope: LocalScope, Index: 0}}
+	if len(secondLocal.FreeSymbols) != len(expectedFree) {
+		t.Fatalf("wrong number of free symbols. got=%d, want=%d", len(secondLocal.FreeSymbols), len(expectedFree))
+	}
+	for i, sym := range expectedFree {
+		if secondLocal.FreeSymbols[i] != sym {
+			t.Errorf("wrong free symbol. got=%+v, want=%+v", secondLocal.FreeSymbols[i], sym)
+		}
+	}
+
+	if _, ok := secondLocal.Resolve("z"); ok {
+		t.Errorf("name z resolved, but was expected not to")
+	}
+}
+
+func TestResolveAssign(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineBuiltin(0, "len")
+	global.Define("a")
+	global.DefineMutable("b")
+
+	local := NewEnclosedSymbolTable(global)
+	local.DefineMutable("c")
+	inner := NewEnclosedSymbolTable(local)
+	inner.Define("d")
+
+	tests := []struct {
+		name     string
+		expected Symbol
+		err      string
+	}{
+		{"b", Symbol{Name: "b", Scope: GlobalScope, Index: 1, Mutable: true}, ""},
+		{"c", Symbol{Name: "c", Scope: FreeScope, Index: 0, Mutable: true}, ""},
+		{"a", Symbol{}, "cannot assign to immutable variable: a"},
+		{"d", Symbol{}, "cannot assign to immutable variable: d"},
+		{"len", Symbol{}, "cannot assign to immutable variable: len"},
+		{"z", Symbol{}, "undefined variable: z"},
+	}
+	for _, tt := range tests {
+		symbol, err := inner.ResolveAssign(tt.name)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: expected error %q, got %v", tt.name, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+			continue
+		}
+		if symbol != tt.expected {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", tt.name, tt.expected, symbol)
+		}
+	}
+
+	// 之后用let定义的同名变量不能赋值
+	global.Define("b")
+	if _, err := global.ResolveAssign("b"); err == nil {
+		t.Errorf("expected b redefined by let to be immutable")
+	}
+}
+
+func TestSymbols(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineBuiltin(0, "len")
+	global.Define("b")
+	global.Define("a")
+	global.Define("b")
+
+	expected := []Symbol{
+		{Name: "a", Scope: GlobalScope, Index: 1},
+		{Name: "b", Scope: GlobalScope, Index: 2},
+	}
+	symbols := global.Symbols()
+	if len(symbols) != len(expected) {
+		t.Fatalf("wrong number of symbols. got=%d, want=%d", len(symbols), len(expected))
+	}
+	for i, sym := range expected {
+		if symbols[i] != sym {
+			t.Errorf("wrong symbol. got=%+v, want=%+v", symbols[i], sym)
+		}
+	}
+}
+
+func TestCopy(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	copied := global.Copy()
+	b := copied.Define("b")
+	if b.Index != 1 {
+		t.Errorf("b has wrong index. want=1, got=%d", b.Index)
+	}
+	if _, ok := copied.Resolve("a"); !ok {
+		t.Errorf("a not resolvable in copy")
+	}
+	if _, ok := global.Resolve("b"); ok {
+		t.Errorf("b defined in copy leaked into original")
+	}
+	if global.NumDefinitions() != 1 {
+		t.Errorf("original has wrong number of definitions. want=1, got=%d", global.NumDefinitions())
+	}
+}
@@ -0,0 +1,70 @@
+package compiler
+
+import (
+	"Monkey/ast"
+	"Monkey/code"
+)
+
+// compileTry 编译try表达式：
+//
+//	start:  try代码块，值留在栈上
+//	end:    OpJump after
+//	target: 保存错误到catch的参数（虚拟机跳转到这里时已把错误压栈）
+//	        catch代码块，值留在栈上
+//	after:
+//
+// 并在当前作用域的异常表中记录[start, end)出错时跳转到target
+func (c *Compiler) compileTry(node *ast.TryExpression) error {
+	start := len(c.currentInstructions())
+	err := c.compileBlockValue(node.Block)
+	if err != nil {
+		return err
+	}
+	end := c.emit(code.OpJump, 9999)
+	handler := code.Handler{Start: start, End: end, Target: len(c.currentInstructions())}
+
+	symbol := c.symbolTable.Define(node.Param.Value)
+	c.storeSymbol(symbol)
+	err = c.compileBlockValue(node.Handler)
+	if err != nil {
+		return err
+	}
+	c.changeOperand(end, len(c.currentInstructions()))
+
+	// 内层的try先编译完，排在外层之前
+	c.scopes[c.scopeIndex].handlers = append(c.scopes[c.scopeIndex].handlers, handler)
+	return nil
+}
+
+// compileBlockValue 编译代码块并把最后一条表达式语句的值留在栈上，没有值时为null
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	start := len(c.currentInstructions())
+	err := c.Compile(block)
+	if err != nil {
+		return err
+	}
+	// 代码块为空时最后一条指令属于代码块之前的语句，不能删除
+	if c.lastInstructionIs(code.OpPop) && c.scopes[c.scopeIndex].lastInstruction.Position >= start {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
+	return nil
+}
+
+// resolveHandlers 按当前作用域的指令计算异常表中每个try开始处的栈深度。
+// 执行不到的try保持深度为0，虚拟机不会用到它
+func (c *Compiler) resolveHandlers() {
+	handlers := c.scopes[c.scopeIndex].handlers
+	if len(handlers) == 0 {
+		return
+	}
+	depths, err := code.StackDepths(c.currentInstructions(), handlers)
+	if err != nil {
+		// 编译器生成的指令不应该出错，交给code.Verify报告
+		return
+	}
+	for i := range handlers {
+		handlers[i].Depth = depths[handlers[i].Start]
+	}
+}
@@ -0,0 +1,355 @@
+package debugger
+
+import (
+	"Monkey/code"
+	"Monkey/compiler"
+	"Monkey/object"
+	"Monkey/vm"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrQuit 用户退出调试时BeforeInstruction返回的错误，Run随之停止
+var ErrQuit = errors.New("debugger: quit")
+
+// mode 恢复执行后在哪里再次暂停
+type mode int
+
+const (
+	modeStep     mode = iota // 在下一条语句暂停，包括被调用的函数中的语句
+	modeNext                 // 在当前帧或调用方的下一条语句暂停，跳过函数调用
+	modeContinue             // 只在断点暂停
+)
+
+// location 源码中的一行，file为绝对路径
+type location struct {
+	file string
+	line int
+}
+
+// Debugger 虚拟机的交互式调试器。作为vm.Hook在语句的第一条指令之前检查是否需要暂停，
+// 暂停后从in读取命令，直到继续执行。语句的位置来自编译器生成的SourceMap
+type Debugger struct {
+	in      *bufio.Scanner
+	out     io.Writer
+	file    string   // 主文件，不带文件名的断点设置在这里
+	globals []string // 全局变量名，按索引
+	maps    []*code.SourceMap
+
+	breakpoints map[location]bool
+	mode        mode
+	depth       int       // 执行next时的调用深度
+	lastFrame   *vm.Frame // 上一次暂停的帧和行，继续执行时不会在同一行再次因断点暂停
+	lastLine    int
+	lastCommand string
+
+	sources map[string][]string
+}
+
+// New 创建调试bytecode的调试器，globals为全局变量名（按索引）。
+// 调试器从第一条语句开始暂停
+func New(in io.Reader, out io.Writer, bytecode *compiler.Bytecode, globals []string) *Debugger {
+	d := &Debugger{
+		in:          bufio.NewScanner(in),
+		out:         out,
+		globals:     globals,
+		breakpoints: make(map[location]bool),
+		mode:        modeStep,
+		sources:     make(map[string][]string),
+	}
+	if bytecode.SourceMap != nil {
+		d.file = bytecode.SourceMap.File
+		d.maps = append(d.maps, bytecode.SourceMap)
+	}
+	for _, constant := range bytecode.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok && fn.SourceMap != nil {
+			d.maps = append(d.maps, fn.SourceMap)
+		}
+	}
+	return d
+}
+
+// Break 在file的第line行设置断点，file为空表示主文件。该行没有语句时返回错误
+func (d *Debugger) Break(file string, line int) error {
+	loc, err := d.location(file, line)
+	if err != nil {
+		return err
+	}
+	for _, m := range d.maps {
+		if sameFile(m.File, loc.file) && m.HasLine(line) {
+			d.breakpoints[loc] = true
+			return nil
+		}
+	}
+	return fmt.Errorf("no statement at %s:%d", filepath.Base(loc.file), line)
+}
+
+// Clear 删除file第line行的断点
+func (d *Debugger) Clear(file string, line int) error {
+	loc, err := d.location(file, line)
+	if err != nil {
+		return err
+	}
+	if !d.breakpoints[loc] {
+		return fmt.Errorf("no breakpoint at %s:%d", filepath.Base(loc.file), line)
+	}
+	delete(d.breakpoints, loc)
+	return nil
+}
+
+func (d *Debugger) location(file string, line int) (location, error) {
+	if file == "" {
+		file = d.file
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return location{}, err
+	}
+	return location{file: abs, line: line}, nil
+}
+
+func sameFile(a, b string) bool {
+	abs, err := filepath.Abs(a)
+	return err == nil && abs == b
+}
+
+// BeforeInstruction 实现vm.Hook
+func (d *Debugger) BeforeInstruction(machine *vm.VM) error {
+	frame := machine.Frame(machine.Depth() - 1)
+	sourceMap := frame.Closure().Fn.SourceMap
+	pos, ok := sourceMap.StatementAt(frame.IP())
+	if !ok || !d.shouldStop(machine, frame, sourceMap.File, pos.Line) {
+		return nil
+	}
+
+	d.lastFrame, d.lastLine = frame, pos.Line
+	d.printSource(sourceMap.File, pos.Line, 0)
+	return d.prompt(machine)
+}
+
+func (d *Debugger) shouldStop(machine *vm.VM, frame *vm.Frame, file string, line int) bool {
+	abs, err := filepath.Abs(file)
+	if err == nil && d.breakpoints[location{file: abs, line: line}] {
+		if frame != d.lastFrame || line != d.lastLine {
+			return true
+		}
+	}
+	switch d.mode {
+	case modeStep:
+		return true
+	case modeNext:
+		return machine.Depth() <= d.depth
+	}
+	return false
+}
+
+// prompt 读取并执行命令，直到恢复执行或退出
+func (d *Debugger) prompt(machine *vm.VM) error {
+	for {
+		fmt.Fprint(d.out, "(debug) ")
+		if !d.in.Scan() {
+			fmt.Fprintln(d.out)
+			return ErrQuit
+		}
+		line := strings.TrimSpace(d.in.Text())
+		if line == "" {
+			// 空行重复上一条命令，方便连续单步
+			line = d.lastCommand
+		}
+		d.lastCommand = line
+
+		name, arg, _ := strings.Cut(line, " ")
+		arg = strings.TrimSpace(arg)
+		switch name {
+		case "":
+		case "step", "s":
+			d.mode = modeStep
+			return nil
+		case "next", "n":
+			d.mode = modeNext
+			d.depth = machine.Depth()
+			return nil
+		case "continue", "c":
+			d.mode = modeContinue
+			return nil
+		case "break", "b":
+			d.breakCommand(arg, d.Break, "breakpoint set at")
+		case "clear":
+			d.breakCommand(arg, d.Clear, "breakpoint cleared at")
+		case "breakpoints":
+			d.listBreakpoints()
+		case "stack":
+			d.printStack(machine)
+		case "locals":
+			d.printLocals(machine)
+		case "globals":
+			d.printGlobals(machine)
+		case "where", "bt":
+			d.printFrames(machine)
+		case "list", "l":
+			frame := machine.Frame(machine.Depth() - 1)
+			d.printSource(frame.Closure().Fn.SourceMap.File, d.lastLine, 3)
+		case "quit", "q":
+			return ErrQuit
+		case "help", "h":
+			fmt.Fprint(d.out, help)
+		default:
+			fmt.Fprintf(d.out, "unknown command %q, type help for a list of commands\n", name)
+		}
+	}
+}
+
+const help = `break [file:]line   set a breakpoint (b)
+clear [file:]line   delete a breakpoint
+breakpoints         list breakpoints
+step                run to the next statement, entering calls (s)
+next                run to the next statement in this function (n)
+continue            run to the next breakpoint (c)
+stack               print the value stack
+locals              print the locals of the current function
+globals             print the global variables
+where               print the call stack (bt)
+list                print the source around the current line (l)
+quit                stop the program (q)
+`
+
+func (d *Debugger) breakCommand(arg string, apply func(file string, line int) error, done string) {
+	file, lineText := "", arg
+	if i := strings.LastIndex(arg, ":"); i >= 0 {
+		file, lineText = arg[:i], arg[i+1:]
+	}
+	line, err := strconv.Atoi(lineText)
+	if err != nil {
+		fmt.Fprintln(d.out, "usage: break [file:]line")
+		return
+	}
+	if err := apply(file, line); err != nil {
+		fmt.Fprintln(d.out, err)
+		return
+	}
+	if file == "" {
+		file = d.file
+	}
+	fmt.Fprintf(d.out, "%s %s:%d\n", done, filepath.Base(file), line)
+}
+
+func (d *Debugger) listBreakpoints() {
+	var locations []location
+	for loc := range d.breakpoints {
+		locations = append(locations, loc)
+	}
+	sort.Slice(locations, func(i, j int) bool {
+		if locations[i].file != locations[j].file {
+			return locations[i].file < locations[j].file
+		}
+		return locations[i].line < locations[j].line
+	})
+	if len(locations) == 0 {
+		fmt.Fprintln(d.out, "no breakpoints")
+	}
+	for _, loc := range locations {
+		fmt.Fprintf(d.out, "%s:%d\n", filepath.Base(loc.file), loc.line)
+	}
+}
+
+func (d *Debugger) printStack(machine *vm.VM) {
+	stack := machine.Stack()
+	if len(stack) == 0 {
+		fmt.Fprintln(d.out, "stack is empty")
+	}
+	for i := len(stack) - 1; i >= 0; i-- {
+		fmt.Fprintf(d.out, "[%d] %s\n", i, inspect(stack[i]))
+	}
+}
+
+func (d *Debugger) printLocals(machine *vm.VM) {
+	if machine.Depth() == 1 {
+		d.printGlobals(machine)
+		return
+	}
+	frame := machine.Frame(machine.Depth() - 1)
+	fn := frame.Closure().Fn
+	var locals, free []string
+	if fn.SourceMap != nil {
+		locals, free = fn.SourceMap.Locals, fn.SourceMap.Free
+	}
+	for i, value := range machine.Locals(frame) {
+		if i < len(locals) && locals[i] != "" {
+			fmt.Fprintf(d.out, "%s = %s\n", locals[i], inspect(value))
+		}
+	}
+	for i, value := range frame.Closure().Free {
+		if i < len(free) {
+			fmt.Fprintf(d.out, "%s = %s (captured)\n", free[i], inspect(value))
+		}
+	}
+}
+
+func (d *Debugger) printGlobals(machine *vm.VM) {
+	globals := machine.Globals()
+	for i, name := range d.globals {
+		if name == "" || i >= len(globals) || globals[i] == nil {
+			continue
+		}
+		fmt.Fprintf(d.out, "%s = %s\n", name, inspect(globals[i]))
+	}
+}
+
+func (d *Debugger) printFrames(machine *vm.VM) {
+	for i := machine.Depth() - 1; i >= 0; i-- {
+		frame := machine.Frame(i)
+		fn := frame.Closure().Fn
+		where := "?"
+		if pos, ok := fn.SourceMap.Lookup(frame.IP()); ok {
+			where = fmt.Sprintf("%s:%d", filepath.Base(fn.SourceMap.File), pos.Line)
+		}
+		fmt.Fprintf(d.out, "#%d %s at %s\n", machine.Depth()-1-i, machine.FunctionName(i), where)
+	}
+}
+
+// printSource 输出file第line行及其前后context行，当前行用=>标记
+func (d *Debugger) printSource(file string, line int, context int) {
+	lines, ok := d.sources[file]
+	if !ok {
+		src, err := os.ReadFile(file)
+		if err == nil {
+			lines = strings.Split(string(src), "\n")
+		}
+		d.sources[file] = lines
+	}
+	if context == 0 {
+		fmt.Fprintf(d.out, "stopped at %s:%d\n", filepath.Base(file), line)
+	}
+	for i := line - context; i <= line+context; i++ {
+		if i < 1 || i > len(lines) {
+			continue
+		}
+		marker := "  "
+		if i == line {
+			marker = "=>"
+		}
+		fmt.Fprintf(d.out, "%s %4d  %s\n", marker, i, lines[i-1])
+	}
+}
+
+func inspect(obj object.Object) string {
+	switch obj := obj.(type) {
+	case nil:
+		return "<unset>"
+	case *object.String:
+		return strconv.Quote(obj.Value)
+	case *object.Closure:
+		if obj.Fn.Name != "" {
+			return fmt.Sprintf("<fn %s/%d>", obj.Fn.Name, obj.Fn.NumParameters)
+		}
+		return fmt.Sprintf("<fn/%d>", obj.Fn.NumParameters)
+	}
+	return obj.Inspect()
+}
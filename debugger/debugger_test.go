@@ -0,0 +1,136 @@
+package debugger
+
+import (
+	"Monkey/compiler"
+	"Monkey/lexer"
+	"Monkey/object"
+	"Monkey/parser"
+	"Monkey/vm"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const program = `let double = fn(x) {
+  let y = x * 2;
+  y
+};
+let a = double(3);
+let b = double(a);
+b;
+`
+
+// debug 在调试器中运行program，commands为依次输入的命令
+func debug(t *testing.T, commands ...string) (string, error) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "main.mk")
+	if err := os.WriteFile(file, []byte(program), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := parser.New(lexer.New(program))
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+	comp := compiler.NewWithState(symbolTable, []object.Object{})
+	comp.SetFile(file)
+	if err := comp.Compile(p.ParseProgram()); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	globals := make([]string, symbolTable.NumDefinitions())
+	for _, symbol := range symbolTable.Symbols() {
+		globals[symbol.Index] = symbol.Name
+	}
+
+	var out strings.Builder
+	in := strings.NewReader(strings.Join(commands, "\n") + "\n")
+	bytecode := comp.Bytecode()
+	machine := vm.New(bytecode)
+	machine.SetHook(New(in, &out, bytecode, globals))
+	err := machine.Run(context.Background())
+	return out.String(), err
+}
+
+// stops 返回每次暂停的位置
+func stops(output string) []string {
+	var result []string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimPrefix(line, "(debug) ")
+		if strings.HasPrefix(line, "stopped at ") {
+			result = append(result, strings.TrimPrefix(line, "stopped at "))
+		}
+	}
+	return result
+}
+
+func TestStepping(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []string
+		expected []string
+	}{
+		{"step into calls", []string{"s", "s", "s", "s", "s"}, []string{"main.mk:1", "main.mk:5", "main.mk:2", "main.mk:3", "main.mk:6", "main.mk:2"}},
+		{"next steps over calls", []string{"n", "n", "n", "n"}, []string{"main.mk:1", "main.mk:5", "main.mk:6", "main.mk:7"}},
+		{"empty line repeats", []string{"n", "", ""}, []string{"main.mk:1", "main.mk:5", "main.mk:6", "main.mk:7"}},
+		{"next returns to caller", []string{"s", "s", "n", "n"}, []string{"main.mk:1", "main.mk:5", "main.mk:2", "main.mk:3", "main.mk:6"}},
+		{"continue to breakpoint", []string{"b 3", "c", "c", "c"}, []string{"main.mk:1", "main.mk:3", "main.mk:3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, _ := debug(t, tt.commands...)
+			got := stops(output)
+			if strings.Join(got, " ") != strings.Join(tt.expected, " ") {
+				t.Errorf("wrong stops.\nwant=%q\ngot =%q\noutput:\n%s", tt.expected, got, output)
+			}
+		})
+	}
+}
+
+func TestInspect(t *testing.T) {
+	output, err := debug(t, "b 3", "c", "locals", "stack", "where", "c", "globals", "q")
+	if err != ErrQuit {
+		t.Fatalf("expected ErrQuit, got %v", err)
+	}
+	for _, want := range []string{
+		"breakpoint set at main.mk:3",
+		"x = 3\ny = 6\n",
+		"[2] 6\n[1] 3\n[0] <fn double/1>\n",
+		"#0 double at main.mk:3\n#1 <main> at main.mk:5\n",
+		"double = <fn double/1>\na = 6\n",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("output missing %q, got:\n%s", want, output)
+		}
+	}
+}
+
+func TestBreakpoints(t *testing.T) {
+	output, err := debug(t, "b 4", "b 2", "b 3", "breakpoints", "clear 2", "clear 2", "breakpoints", "c", "c", "c")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, want := range []string{
+		"no statement at main.mk:4",
+		"main.mk:2\nmain.mk:3\n",
+		"breakpoint cleared at main.mk:2",
+		"no breakpoint at main.mk:2",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("output missing %q, got:\n%s", want, output)
+		}
+	}
+	if got := stops(output); len(got) != 3 {
+		t.Errorf("expected to stop 3 times, got %q", got)
+	}
+}
+
+func TestQuitOnEOF(t *testing.T) {
+	_, err := debug(t)
+	if err != ErrQuit {
+		t.Fatalf("expected ErrQuit, got %v", err)
+	}
+}
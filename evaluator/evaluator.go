@@ -3,6 +3,8 @@ package evaluator
 import (
 	"Monkey/ast"
 	"Monkey/object"
+	"Monkey/token"
+	"context"
 	"fmt"
 )
 
@@ -12,14 +14,43 @@ var (
 	NULL  = &object.Null{}
 )
 
+// DefaultMaxCallDepth limits.MaxCallDepth为零时使用的调用深度上限。
+// 求值器用Go的递归实现函数调用，不限制深度时无限递归会耗尽Go的栈使进程崩溃
+const DefaultMaxCallDepth = 10000
+
+// EvalContext 在ctx和limits的约束下对node求值。
+// ctx结束或超出限制时中止求值并返回错误，超出限制时错误为*object.LimitError
+func EvalContext(ctx context.Context, node ast.Node, env *object.Environment, limits object.Limits) (object.Object, error) {
+	if limits.MaxCallDepth == 0 {
+		limits.MaxCallDepth = DefaultMaxCallDepth
+	}
+	budget := object.NewBudget(ctx, limits)
+	previous := env.Budget()
+	env.SetBudget(budget)
+	defer env.SetBudget(previous)
+
+	result := Eval(node, env)
+	if err := budget.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	budget := env.Budget()
+	if budget != nil {
+		if err := budget.Step(); err != nil {
+			return fatalError(err)
+		}
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
 		return evalProgram(node, env)
 	case *ast.ExpressionStatement:
-		return Eval(node.Expression, env)
+		return at(node.Token, Eval(node.Expression, env))
 	case *ast.IntegerLiteral:
-		return &object.Integer{Value: node.Value}
+		return allocate(budget, &object.Integer{Value: node.Value})
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.PrefixExpression:
@@ -27,7 +58,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(right) {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right)
+		return allocateResult(budget, evalPrefixExpression(node.Operator, right))
 	case *ast.InfixExpression:
 		left := Eval(node.Left, env)
 		if isError(left) {
@@ -37,30 +68,49 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		return allocateResult(budget, evalInfixExpression(node.Operator, left, right))
 	case *ast.BlockStatement:
 		return evalBlockStatement(node, env)
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
+	case *ast.TryExpression:
+		return evalTryExpression(node, env)
+	case *ast.MatchExpression:
+		return evalMatchExpression(node, env)
 	case *ast.ReturnStatement:
 		val := Eval(node.ReturnValue, env)
 		if isError(val) {
-			return val
+			return at(node.Token, val)
 		}
 		return &object.ReturnValue{Value: val}
 	case *ast.LetStatement:
 		val := Eval(node.Value, env)
 		if isError(val) {
-			return val
+			return at(node.Token, val)
+		}
+		if node.Pattern != nil {
+			if err := evalDestructuring(node.Pattern, val, env, node.Mutable()); err != nil {
+				return at(node.Token, err)
+			}
+			return nil
+		}
+		if node.Mutable() {
+			env.SetMutable(node.Name.Value, val)
+		} else {
+			env.Set(node.Name.Value, val)
 		}
-		env.Set(node.Name.Value, val)
+	case *ast.AssignExpression:
+		return evalAssignExpression(node, env)
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Body: body, Env: env}
+		return allocate(budget, &object.Function{Parameters: params, Body: body, Env: env, Name: node.Name})
 	case *ast.CallExpression:
+		if isImport(node, env) {
+			return evalImport(node, env)
+		}
 		function := Eval(node.Function, env)
 		if isError(function) {
 			return function
@@ -69,15 +119,15 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if len(args) == 1 && isError(args[0]) {
 			return args[0]
 		}
-		return applyFunction(function, args)
+		return applyFunction(function, args, env)
 	case *ast.StringLiteral:
-		return &object.String{Value: node.Value}
+		return allocate(budget, &object.String{Value: node.Value})
 	case *ast.ArrayLiteral:
 		elememts := evalExpressions(node.Elements, env)
 		if len(elememts) == 1 && isError(elememts[0]) {
 			return elememts[0]
 		}
-		return &object.Array{Elements: elememts}
+		return allocate(budget, &object.Array{Elements: elememts})
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
 		if isError(left) {
@@ -89,13 +139,34 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 		return evalIndexExpression(left, index)
 	case *ast.HashLiteral:
-		return evalHashLiteralExpression(node, env)
+		return allocateResult(budget, evalHashLiteralExpression(node, env))
 
 	}
 
 	return nil
 }
 
+// allocate 记录新创建的对象obj，超出限制时返回错误
+func allocate(budget *object.Budget, obj object.Object) object.Object {
+	if budget == nil {
+		return obj
+	}
+	if err := budget.Allocate(); err != nil {
+		return fatalError(err)
+	}
+	return obj
+}
+
+// allocateResult 运算结果为新创建的对象时记录分配；布尔值、null和错误不计入
+func allocateResult(budget *object.Budget, obj object.Object) object.Object {
+	switch obj.(type) {
+	case *object.Integer, *object.String, *object.Array, *object.Hash:
+		return allocate(budget, obj)
+	default:
+		return obj
+	}
+}
+
 func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	var result object.Object
 
@@ -116,13 +187,50 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
 		return val
 	}
 
-	if val, ok := builtins[node.Value]; ok {
+	if val := object.GetBuiltinByName(node.Value); val != nil {
 		return val
 	}
 
 	return newError("identifier not found: %s", node.Value)
 }
 
+// evalAssignExpression 给var定义的变量赋值，或修改数组、哈希中的元素，值为赋的值
+func evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		if _, ok := env.Get(target.Value); !ok && object.GetBuiltinByName(target.Value) != nil {
+			return newError("cannot assign to immutable variable: %s", target.Value)
+		}
+		if err := env.Assign(target.Value, val); err != nil {
+			return newError("%s", err)
+		}
+		return val
+	case *ast.IndexExpression:
+		left := Eval(target.Left, env)
+		if isError(left) {
+			return left
+		}
+		index := Eval(target.Index, env)
+		if isError(index) {
+			return index
+		}
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		if err := object.SetIndex(left, index, val); err != nil {
+			return newError("%s", err)
+		}
+		return val
+	default:
+		return newError("cannot assign to %s", node.Target)
+	}
+}
+
 func evalBlockStatement(blockStmt *ast.BlockStatement, env *object.Environment) object.Object {
 	var result object.Object
 
@@ -184,6 +292,10 @@ func evalInfixExpression(operator string, left object.Object, right object.Objec
 		return evalIntegerInfixExpression(operator, left, right)
 	case left.Type() == object.BOOLEAN_OBJ && right.Type() == object.BOOLEAN_OBJ:
 		return evalBooleanInfix(operator, left, right)
+	case operator == "==":
+		return nativeBoolToBooleanObject(left.Equals(right))
+	case operator == "!=":
+		return nativeBoolToBooleanObject(!left.Equals(right))
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfix(operator, left, right)
 	case left.Type() != right.Type():
@@ -199,13 +311,16 @@ func evalIntegerInfixExpression(operator string, left object.Object, right objec
 	rightValue := right.(*object.Integer).Value
 	switch operator {
 	case "+":
-		return &object.Integer{leftValue + rightValue}
+		return &object.Integer{Value: leftValue + rightValue}
 	case "-":
-		return &object.Integer{leftValue - rightValue}
+		return &object.Integer{Value: leftValue - rightValue}
 	case "*":
-		return &object.Integer{leftValue * rightValue}
+		return &object.Integer{Value: leftValue * rightValue}
 	case "/":
-		return &object.Integer{leftValue / rightValue}
+		if rightValue == 0 {
+			return newError("division by zero")
+		}
+		return &object.Integer{Value: leftValue / rightValue}
 	case ">":
 		return nativeBoolToBooleanObject(leftValue > rightValue)
 	case "<":
@@ -224,9 +339,9 @@ func evalBooleanInfix(operator string, left object.Object, right object.Object)
 	rightVal := right.(*object.Boolean).Value
 	switch operator {
 	case "==":
-		return &object.Boolean{leftVal == rightVal}
+		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
-		return &object.Boolean{leftVal != rightVal}
+		return nativeBoolToBooleanObject(leftVal != rightVal)
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
@@ -255,6 +370,34 @@ func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Obje
 	}
 }
 
+// evalTryExpression 执行try代码块，出错时把错误转换为哈希绑定到catch的参数，再执行catch代码块。
+// 致命错误不会被捕获
+func evalTryExpression(te *ast.TryExpression, env *object.Environment) object.Object {
+	result := Eval(te.Block, env)
+	errObj, ok := result.(*object.Error)
+	if !ok {
+		if result == nil {
+			return NULL
+		}
+		return result
+	}
+	budget := env.Budget()
+	if errObj.Fatal || (budget != nil && budget.Err() != nil) {
+		return errObj
+	}
+
+	caught := allocate(budget, errObj.Caught())
+	if isError(caught) {
+		return caught
+	}
+	env.Set(te.Param.Value, caught)
+	result = Eval(te.Handler, env)
+	if result == nil {
+		return NULL
+	}
+	return result
+}
+
 func isTruthy(obj object.Object) bool {
 	switch obj {
 	case NULL:
@@ -290,7 +433,7 @@ func evalHashLiteralExpression(hashLiteral ast.Expression, env *object.Environme
 		if isError(keyObj) {
 			return keyObj
 		}
-		hashKey, ok := keyObj.(object.Hashable)
+		hashed, ok := object.HashKeyOf(keyObj)
 		if !ok {
 			return newError("unusable as hash key: %s", keyObj.Type())
 		}
@@ -298,15 +441,27 @@ func evalHashLiteralExpression(hashLiteral ast.Expression, env *object.Environme
 		if isError(valueObj) {
 			return valueObj
 		}
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{Key: keyObj, Value: valueObj}
+		pairs[hashed] = object.HashPair{Key: object.FreezeKey(keyObj), Value: valueObj}
 	}
 
 	return &object.Hash{Pairs: pairs}
 }
 
 func newError(format string, a ...any) *object.Error {
-	return &object.Error{fmt.Sprintf(format, a...)}
+	return &object.Error{Message: fmt.Sprintf(format, a...)}
+}
+
+// fatalError 不能被catch捕获的错误：超出资源限制、执行被中断或权限不足
+func fatalError(err error) *object.Error {
+	return &object.Error{Message: err.Error(), Fatal: true}
+}
+
+// at 为obj中的错误记录所在语句的位置，tok为语句的第一个词法单元
+func at(tok token.Token, obj object.Object) object.Object {
+	if errObj, ok := obj.(*object.Error); ok {
+		errObj.SetPosition(tok.Line, tok.Column)
+	}
+	return obj
 }
 
 func isError(obj object.Object) bool {
@@ -316,14 +471,33 @@ func isError(obj object.Object) bool {
 	return false
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// applyFunction 以args调用fn，env为调用处的环境
+func applyFunction(fn object.Object, args []object.Object, env *object.Environment) object.Object {
+	budget := env.Budget()
 	switch function := fn.(type) {
 	case *object.Function:
+		// 参数个数与虚拟机一样严格检查，出错时可以被try捕获
+		if len(args) != len(function.Parameters) {
+			return newError("wrong number of arguments: want=%d, got=%d", len(function.Parameters), len(args))
+		}
+		if budget != nil {
+			if err := budget.Enter(); err != nil {
+				return fatalError(err)
+			}
+			defer budget.Leave()
+		}
 		extendEnv := extendFunctionEnv(function, args)
 		evaluated := Eval(function.Body, extendEnv)
 		return unwrapReturnValue(evaluated)
 	case *object.Builtin:
-		return function.Fn(args...)
+		result, err := env.Host().Call(function, args...)
+		if err != nil {
+			return fatalError(err)
+		}
+		if result != nil {
+			return allocateResult(budget, result)
+		}
+		return NULL
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
@@ -352,6 +526,8 @@ func evalIndexExpression(left object.Object, index object.Object) object.Object
 		return evalArrayIndexExpression(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
+	case left.Type() == object.MODULE_OBJ:
+		return evalHashIndexExpression(left.(*object.Module).Exports, index)
 	default:
 		return newError("index operator not supported: %s", left.Type())
 	}
@@ -372,12 +548,11 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 func evalHashIndexExpression(hash, index object.Object) object.Object {
 	hashObj := hash.(*object.Hash)
 
-	key, ok := index.(object.Hashable)
-	if !ok {
+	if _, ok := object.HashKeyOf(index); !ok {
 		return newError("unusable as hash key: %s", index.Type())
 	}
 
-	pair, ok := hashObj.Pairs[key.HashKey()]
+	pair, ok := hashObj.Lookup(index)
 	if !ok {
 		return NULL
 	}
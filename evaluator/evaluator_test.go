@@ -0,0 +1,347 @@
+package evaluator
+
+import (
+	"Monkey/lexer"
+	"Monkey/object"
+	"Monkey/parser"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEvalContextLimits(t *testing.T) {
+	tests := []struct {
+		input    string
+		limits   object.Limits
+		expected string
+	}{
+		{"let f = fn(x) { f(x + 1) }; f(0)", object.Limits{MaxCallDepth: 50}, "call depth"},
+		{"let f = fn(x) { f(x + 1) }; f(0)", object.Limits{MaxInstructions: 1000}, "instructions"},
+		{"let f = fn(x) { f([x]) }; f(0)", object.Limits{MaxAllocations: 100}, "allocations"},
+		{"let f = fn() { f() }; f()", object.Limits{}, "call depth"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			program := parser.New(lexer.New(tt.input)).ParseProgram()
+			_, err := EvalContext(context.Background(), program, object.NewEnvironment(), tt.limits)
+
+			var limitErr *object.LimitError
+			if !errors.As(err, &limitErr) {
+				t.Fatalf("error is not LimitError. got=%T (%v)", err, err)
+			}
+			if limitErr.Limit != tt.expected {
+				t.Fatalf("wrong limit. want=%q, got=%q", tt.expected, limitErr.Limit)
+			}
+		})
+	}
+}
+
+func TestEvalContextWithinLimits(t *testing.T) {
+	program := parser.New(lexer.New("let f = fn(x) { x + 1 }; f(1)")).ParseProgram()
+	env := object.NewEnvironment()
+	limits := object.Limits{MaxInstructions: 100, MaxCallDepth: 2, MaxAllocations: 10}
+
+	result, err := EvalContext(context.Background(), program, env, limits)
+	if err != nil {
+		t.Fatalf("EvalContext error: %s", err)
+	}
+	if integer, ok := result.(*object.Integer); !ok || integer.Value != 2 {
+		t.Fatalf("wrong result. got=%+v", result)
+	}
+	if env.Budget() != nil {
+		t.Fatalf("budget not removed from environment after EvalContext")
+	}
+
+	// 默认的调用深度上限不影响正常深度的递归
+	program = parser.New(lexer.New("let count = fn(n) { if (n == 0) { 0 } else { 1 + count(n - 1) } }; count(5000)")).ParseProgram()
+	result, err = EvalContext(context.Background(), program, object.NewEnvironment(), object.Limits{})
+	if err != nil {
+		t.Fatalf("EvalContext error: %s", err)
+	}
+	if integer, ok := result.(*object.Integer); !ok || integer.Value != 5000 {
+		t.Fatalf("wrong result. got=%+v", result)
+	}
+}
+
+func TestEvalContextCancel(t *testing.T) {
+	input := "let f = fn(x) { if (x == 0) { 0 } else { f(x - 1) + f(x - 1) } }; f(40)"
+	program := parser.New(lexer.New(input)).ParseProgram()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := EvalContext(ctx, program, object.NewEnvironment(), object.Limits{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error, got=%v", err)
+	}
+}
+
+func TestHostCapabilities(t *testing.T) {
+	program := parser.New(lexer.New(`let show = fn(x) { println(x) }; show(1); now()`)).ParseProgram()
+
+	var out strings.Builder
+	env := object.NewEnvironment()
+	env.SetHost(&object.Host{Stdout: &out, Capabilities: []object.Capability{object.CapabilityIO}})
+
+	result := Eval(program, env)
+	errObj, ok := result.(*object.Error)
+	if !ok {
+		t.Fatalf("expected error for now(), got=%T (%+v)", result, result)
+	}
+	expected := `permission denied: now requires the "time" capability`
+	if errObj.Message != expected {
+		t.Fatalf("wrong error message. want=%q, got=%q", expected, errObj.Message)
+	}
+	if out.String() != "1\n" {
+		t.Fatalf("wrong output. got=%q", out.String())
+	}
+}
+
+func TestTryCatch(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"try { 1 } catch (e) { 2 }", "1"},
+		{"try { 1 + true } catch (e) { e[\"message\"] }", "type mismatch: INTEGER + BOOLEAN"},
+		{"try { len(1) } catch (e) { e[\"type\"] }", "RuntimeError"},
+		{`try { throw("boom") } catch (e) { e["message"] + ":" + e["type"] }`, "boom:Error"},
+		{`try { throw(42) } catch (e) { e["value"] }`, "42"},
+		{`try { throw({"type": "ValueError", "message": "bad"}) } catch (e) { e["type"] + ":" + e["message"] }`, "ValueError:bad"},
+		{"1 + try { 2 + throw(3) } catch (e) { 10 }", "11"},
+		{"let f = fn() { let a = 5; try { a + len(1) } catch (e) { a + e[\"line\"] } }; f()", "6"},
+		{`try { try { throw("inner") } catch (e) { throw(e["message"] + "!") } } catch (e) { e["message"] }`, "inner!"},
+		{"try {\n  1;\n  {}[fn() {}]\n} catch (e) { [e[\"line\"], e[\"column\"]] }", "[3,3]"},
+		{`let f = fn() { throw("x") }; let g = fn() { try { f() } catch (e) { 1 } }; g() + g()`, "2"},
+		{"try { } catch (e) { 1 }", "null"},
+		{`try { 1 / 0 } catch (e) { e["message"] }`, "division by zero"},
+		{`let d = fn(a, b) { a / b }; try { d(1, 0) } catch (e) { e["type"] }`, "RuntimeError"},
+		{`let f = fn(a) { a }; try { f() } catch (e) { e["message"] }`, "wrong number of arguments: want=1, got=0"},
+		{`try { fn() { 1 }(1) } catch (e) { e["message"] }`, "wrong number of arguments: want=0, got=1"},
+		{`throw("uncaught")`, "ERROR: uncaught"},
+		{`try { now() } catch (e) { 1 }`, `ERROR: permission denied: now requires the "time" capability`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			env := object.NewEnvironment()
+			env.SetHost(&object.Host{})
+			program := parser.New(lexer.New(tt.input)).ParseProgram()
+			result := Eval(program, env)
+			if result.Inspect() != tt.expected {
+				t.Fatalf("wrong result. want=%q, got=%q", tt.expected, result.Inspect())
+			}
+		})
+	}
+}
+
+func TestMatchExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"match (1) { 0 => 10, 1 => 11, _ => 12 }", "11"},
+		{"match (5) { 0 => 10, n => n * 2 }", "10"},
+		{"match (-1) { -1 => true, _ => false }", "true"},
+		{`match ("b") { "a" => 1, "b" => 2 }`, "2"},
+		{"match (true) { false => 0, true => 1 }", "1"},
+		{"match (7) { 0 => 1 }", "null"},
+		{"match (1) { }", "null"},
+		{"match ([1, 2]) { [a] => a, [a, b] => a + b, _ => 0 }", "3"},
+		{"match ([1, 2, 3]) { [a, b] => 0, [a, ...rest] => rest }", "[2,3]"},
+		{"match ([1]) { [a, ...rest] => rest }", "[]"},
+		{"match ([]) { [] => 1, _ => 2 }", "1"},
+		{"match ([[1, 2], 3]) { [[1, x], y] => x + y }", "5"},
+		{"match ([0, 5]) { [1, x] => x, [0, x] => -x }", "-5"},
+		{`match ({"kind": "circle", "r": 2}) { {"kind": "square", "side": s} => s * s, {"kind": "circle", "r": r} => 3 * r * r }`, "12"},
+		{`match ({"kind": "circle"}) { {"kind": "circle", "r": r} => r, {"kind": k} => k }`, "circle"},
+		{`match ({1: true}) { {1: true} => "yes", _ => "no" }`, "yes"},
+		{`match ([1, 2]) { {"a": a} => a, _ => "not a hash" }`, "not a hash"},
+		{"match (3) { n if n > 5 => 1, n if n > 1 => 2, _ => 3 }", "2"},
+		{"match ([4, 5]) { [a, b] if a > b => a, [a, b] => b }", "5"},
+		{"let x = 1; match (2) { x => x }; x", "1"},
+		{"let x = 5; match (1) { 1 => x, [x] => x }", "5"},
+		{`match (1) { "1" => 1, true => 2, 1 => 3 }`, "3"},
+		{"let f = fn(p) { match (p) { [x, y] => x * y, {\"n\": n} => n, _ => 0 } }; [f([2, 3]), f({\"n\": 4}), f(5)]", "[6,4,0]"},
+		{"match (1) { n if n + true => 1 }", "ERROR: type mismatch: INTEGER + BOOLEAN"},
+		{"match (1 + true) { _ => 1 }", "ERROR: type mismatch: INTEGER + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			program := parser.New(lexer.New(tt.input)).ParseProgram()
+			result := Eval(program, object.NewEnvironment())
+			if result.Inspect() != tt.expected {
+				t.Fatalf("wrong result. want=%q, got=%q", tt.expected, result.Inspect())
+			}
+		})
+	}
+}
+
+func TestDestructuringLet(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let [a, b] = [1, 2]; a + b", "3"},
+		{"let [a, _, c] = [1, 2, 3]; [a, c]", "[1,3]"},
+		{"let [first, ...rest] = [1, 2, 3]; rest", "[2,3]"},
+		{"let [x, ...rest] = [1]; rest", "[]"},
+		{"let [[a, b], c] = [[1, 2], 3]; a + b + c", "6"},
+		{`let {name, age} = {"name": "ann", "age": 30}; name`, "ann"},
+		{`let {"name": n, "tags": [t]} = {"name": "x", "tags": ["y"]}; t`, "y"},
+		{`let {1: one} = {1: "a", 2: "b"}; one`, "a"},
+		{"let [1, x] = [1, 2]; x", "2"},
+		{"let a = 1; let [a, b] = [a + 1, a]; [a, b]", "[2,1]"},
+		{"let f = fn() { [1, 2] }; let g = fn() { let [a, b] = f(); a * 10 + b }; g()", "12"},
+		{"let [a, b] = [1]; a", "ERROR: destructuring mismatch: expected array of 2 elements, got 1"},
+		{"let [a, b, ...r] = [1]; a", "ERROR: destructuring mismatch: expected array of at least 2 elements, got 1"},
+		{"let [a] = 1; a", "ERROR: destructuring mismatch: expected array, got INTEGER"},
+		{`let {name} = [1]; name`, "ERROR: destructuring mismatch: expected hash, got ARRAY"},
+		{`let {name} = {"age": 1}; name`, `ERROR: destructuring mismatch: missing key "name"`},
+		{"let [1, x] = [2, 3]; x", "ERROR: destructuring mismatch: expected 1, got 2"},
+		{"let [a] = [1 + true]; a", "ERROR: type mismatch: INTEGER + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			program := parser.New(lexer.New(tt.input)).ParseProgram()
+			result := Eval(program, object.NewEnvironment())
+			if result.Inspect() != tt.expected {
+				t.Fatalf("wrong result. want=%q, got=%q", tt.expected, result.Inspect())
+			}
+		})
+	}
+}
+
+func TestAssignment(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"var x = 1; x = x + 1; x", "2"},
+		{"var x = 1; x = 5", "5"},
+		{"var a = 0; var b = 0; a = b = 3; [a, b]", "[3,3]"},
+		{"var x = 1; let f = fn() { x = 10 }; f(); x", "10"},
+		{"let counter = fn() { var n = 0; fn() { n = n + 1; n } }; let c = counter(); c(); c(); c()", "3"},
+		{"let make = fn() { var n = 0; [fn() { n = n + 1 }, fn() { n }] }; let fs = make(); fs[0](); fs[0](); fs[1]()", "2"},
+		{"var [a, b] = [1, 2]; a = 10; [a, b]", "[10,2]"},
+		{`var {name} = {"name": "x"}; name = "y"; name`, "y"},
+		{"var x = 1; let x = 2; x", "2"},
+		{"let xs = [1, 2, 3]; xs[1] = 5; xs", "[1,5,3]"},
+		{`let h = {"a": 1}; h["b"] = 2; [h["a"], h["b"]]`, "[1,2]"},
+		{"let xs = [[1], [2]]; xs[1][0] = 3; xs", "[[1],[3]]"},
+		{"let xs = [1]; let ys = xs; ys[0] = 2; xs", "[2]"},
+		{"let xs = freeze([1, [2]]); xs[1][0] = 3; xs", "[1,[3]]"},
+		{`let key = [1]; let hk = {key: "v"}; key[0] = 2; [hk[[1]], hk[key]]`, "[v,null]"},
+		{`let key = [1]; let hk = {}; hk[key] = "v"; key[0] = 2; hk[[1]]`, "v"},
+		{`let inner = {"a": [1]}; let hk = {inner: "v"}; inner["a"][0] = 2; hk[{"a": [1]}]`, "v"},
+		{"let x = 1; x = 2", "ERROR: cannot assign to immutable variable: x"},
+		{"let f = fn(a) { a = 1 }; f(0)", "ERROR: cannot assign to immutable variable: a"},
+		{"len = 1", "ERROR: cannot assign to immutable variable: len"},
+		{"y = 1", "ERROR: identifier not found: y"},
+		{"match (1) { n => n = 2 }", "ERROR: cannot assign to immutable variable: n"},
+		{"let xs = [1]; xs[1] = 2", "ERROR: index 1 out of range for array of length 1"},
+		{"let xs = freeze([1]); xs[0] = 2", "ERROR: cannot modify frozen ARRAY"},
+		{`let h = freeze({"a": 1}); h["a"] = 2`, "ERROR: cannot modify frozen HASH"},
+		{`"abc"[0] = "x"`, "ERROR: index assignment not supported: STRING"},
+		{"freeze(1)", "ERROR: argument to `freeze` must be ARRAY or HASH, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			program := parser.New(lexer.New(tt.input)).ParseProgram()
+			result := Eval(program, object.NewEnvironment())
+			if result.Inspect() != tt.expected {
+				t.Fatalf("wrong result. want=%q, got=%q", tt.expected, result.Inspect())
+			}
+		})
+	}
+}
+
+func TestTryCatchLimits(t *testing.T) {
+	program := parser.New(lexer.New("let f = fn(x) { f(x + 1) }; try { f(0) } catch (e) { 1 }")).ParseProgram()
+	_, err := EvalContext(context.Background(), program, object.NewEnvironment(), object.Limits{MaxCallDepth: 50})
+
+	var limitErr *object.LimitError
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("limit error was caught. got=%T (%v)", err, err)
+	}
+}
+
+func writeModules(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestImport(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"lib/math.mk": `
+let helper = import("util.mk");
+let double = fn(x) { helper["twice"](x) };
+let _hidden = 1;
+let square = fn(x) { x * x };
+`,
+		"lib/util.mk": `println("loading util"); let twice = fn(x) { x + x };`,
+		"a.mk":        `let b = import("b.mk");`,
+		"b.mk":        `let a = import("a.mk");`,
+		"scope.mk":    `let y = x;`,
+	})
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let m = import("lib/math.mk"); m["double"](4) + m["square"](3)`, "17"},
+		{`import("lib/math.mk")["_hidden"]`, "null"},
+		{`import("lib/util.mk") == import("lib/math.mk")["helper"]`, "true"},
+		{`import("lib/math.mk")`, `module("lib/math.mk")`},
+		{`import("a.mk")`, "ERROR: import cycle: "},
+		{`let x = 1; import("scope.mk")`, "ERROR: identifier not found: x"},
+		{`import("missing.mk")`, `ERROR: import "missing.mk": open `},
+		{`let import = fn(x) { x }; import(3)`, "3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			var out strings.Builder
+			env := object.NewEnvironment()
+			env.SetDir(dir)
+			env.SetHost(&object.Host{Stdout: &out, Capabilities: []object.Capability{object.CapabilityIO, object.CapabilityFS}})
+
+			program := parser.New(lexer.New(tt.input)).ParseProgram()
+			result := Eval(program, env)
+			if !strings.HasPrefix(result.Inspect(), tt.expected) {
+				t.Fatalf("wrong result. want prefix %q, got=%q", tt.expected, result.Inspect())
+			}
+			if strings.Count(out.String(), "loading util") > 1 {
+				t.Fatalf("module evaluated more than once. output=%q", out.String())
+			}
+		})
+	}
+
+	// 未授予CapabilityFS时不能读取模块，也不能被catch捕获
+	env := object.NewEnvironment()
+	env.SetDir(dir)
+	env.SetHost(&object.Host{})
+	program := parser.New(lexer.New(`try { import("lib/util.mk") } catch (e) { "caught" }`)).ParseProgram()
+	result := Eval(program, env)
+	expected := `ERROR: permission denied: import requires the "fs" capability`
+	if !strings.HasPrefix(result.Inspect(), expected) {
+		t.Fatalf("wrong result. want prefix %q, got=%q", expected, result.Inspect())
+	}
+}
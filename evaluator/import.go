@@ -0,0 +1,90 @@
+package evaluator
+
+import (
+	"Monkey/ast"
+	"Monkey/lexer"
+	"Monkey/object"
+	"Monkey/parser"
+	"errors"
+	"path/filepath"
+	"strings"
+)
+
+// isImport 判断调用是否为import。import不是内置函数，被同名变量遮蔽时按普通调用处理
+func isImport(node *ast.CallExpression, env *object.Environment) bool {
+	ident, ok := node.Function.(*ast.Identifier)
+	if !ok || ident.Value != "import" {
+		return false
+	}
+	_, defined := env.Get(ident.Value)
+	return !defined
+}
+
+// evalImport 加载并执行模块文件，返回其导出的绑定。
+// 同一次执行中每个文件只执行一次，之后的导入直接返回缓存的模块
+func evalImport(node *ast.CallExpression, env *object.Environment) object.Object {
+	if len(node.Arguments) != 1 {
+		return newError("wrong number of arguments to import. got=%d, want=1", len(node.Arguments))
+	}
+	lit, ok := node.Arguments[0].(*ast.StringLiteral)
+	if !ok {
+		return newError("import path must be a string literal")
+	}
+
+	path, err := object.ResolveImportPath(env.Dir(), lit.Value)
+	if err != nil {
+		return newError("import %q: %s", lit.Value, err)
+	}
+	modules := env.Modules()
+	if module, ok := modules.Get(path); ok {
+		return module
+	}
+
+	if err := modules.Begin(path); err != nil {
+		return newError("%s", err)
+	}
+	module := loadModule(lit.Value, path, env)
+	if m, ok := module.(*object.Module); ok {
+		modules.End(path, m)
+	} else {
+		modules.End(path, nil)
+	}
+	return module
+}
+
+func loadModule(name string, path string, importer *object.Environment) object.Object {
+	src, err := importer.Host().ReadModule(path)
+	if err != nil {
+		var permission *object.PermissionError
+		if errors.As(err, &permission) {
+			return fatalError(err)
+		}
+		return newError("import %q: %s", name, err)
+	}
+
+	p := parser.New(lexer.New(string(src)))
+	program := p.ParseProgram()
+	if errors := p.Errors(); len(errors) != 0 {
+		return newError("import %q: parse error:\n\t%s", name, strings.Join(errors, "\n\t"))
+	}
+	for _, stmt := range program.Statements {
+		if _, ok := stmt.(*ast.ReturnStatement); ok {
+			return newError("import %q: return at module top level", name)
+		}
+	}
+
+	env := object.NewModuleEnvironment(importer, filepath.Dir(path))
+	if result := Eval(program, env); isError(result) {
+		return result
+	}
+
+	pairs := make(map[object.HashKey]object.HashPair)
+	for name, value := range env.Bindings() {
+		if !object.IsExported(name) {
+			continue
+		}
+		key := &object.String{Value: name}
+		pairs[key.HashKey()] = object.HashPair{Key: key, Value: value}
+	}
+	return &object.Module{Path: name, Exports: &object.Hash{Pairs: pairs}}
+}
@@ -0,0 +1,133 @@
+package evaluator
+
+import (
+	"Monkey/ast"
+	"Monkey/object"
+)
+
+// evalMatchExpression 依次尝试每个分支：模式匹配后把绑定的变量设置到分支自己的环境中，
+// 再对guard求值，guard为真时返回分支的值。绑定只在所在分支中可见，没有分支匹配时返回NULL
+func evalMatchExpression(me *ast.MatchExpression, env *object.Environment) object.Object {
+	subject := Eval(me.Subject, env)
+	if isError(subject) {
+		return subject
+	}
+
+	budget := env.Budget()
+	for _, arm := range me.Arms {
+		bindings := make(map[string]object.Object)
+		mismatch, err := matchPattern(arm.Pattern, subject, budget, bindings)
+		if err != nil {
+			return err
+		}
+		if mismatch != "" {
+			continue
+		}
+		armEnv := object.NewEnclosedEnvironment(env)
+		for name, value := range bindings {
+			armEnv.Set(name, value)
+		}
+
+		if arm.Guard != nil {
+			guard := Eval(arm.Guard, armEnv)
+			if isError(guard) {
+				return guard
+			}
+			if !isTruthy(guard) {
+				continue
+			}
+		}
+		return Eval(arm.Body, armEnv)
+	}
+	return NULL
+}
+
+// matchPattern 判断value是否匹配pattern，匹配时把模式中的变量记录到bindings中并返回空字符串，
+// 否则返回不匹配的说明。只有创建...rest的数组超出限制时才返回错误
+func matchPattern(pattern ast.Expression, value object.Object, budget *object.Budget, bindings map[string]object.Object) (string, object.Object) {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		if pattern.Value != ast.Wildcard {
+			bindings[pattern.Value] = value
+		}
+		return "", nil
+	case *ast.IntegerLiteral, *ast.StringLiteral, *ast.Boolean:
+		return object.ValueMismatch(value, patternLiteral(pattern)), nil
+	case *ast.ArrayPattern:
+		n := len(pattern.Elements)
+		if mismatch := object.ArrayMismatch(value, n, pattern.Rest != nil); mismatch != "" {
+			return mismatch, nil
+		}
+		array := value.(*object.Array)
+		for i, element := range pattern.Elements {
+			mismatch, err := matchPattern(element, array.Elements[i], budget, bindings)
+			if err != nil || mismatch != "" {
+				return mismatch, err
+			}
+		}
+		if pattern.Rest != nil {
+			rest := make([]object.Object, len(array.Elements)-n)
+			copy(rest, array.Elements[n:])
+			restArray := allocate(budget, &object.Array{Elements: rest})
+			if isError(restArray) {
+				return "", restArray
+			}
+			return matchPattern(pattern.Rest, restArray, budget, bindings)
+		}
+		return "", nil
+	case *ast.HashPattern:
+		if mismatch := object.HashMismatch(value); mismatch != "" {
+			return mismatch, nil
+		}
+		hash := value.(*object.Hash)
+		for i, key := range pattern.Keys {
+			literal := patternLiteral(key)
+			pair, ok := hash.Lookup(literal)
+			if !ok {
+				return object.KeyMismatch(hash, literal), nil
+			}
+			mismatch, err := matchPattern(pattern.Values[i], pair.Value, budget, bindings)
+			if err != nil || mismatch != "" {
+				return mismatch, err
+			}
+		}
+		return "", nil
+	default:
+		return "", newError("unsupported pattern: %s", pattern)
+	}
+}
+
+// evalDestructuring 把value按pattern解构并绑定到env中，不匹配时返回错误；
+// mutable为true时绑定的名字可以重新赋值
+func evalDestructuring(pattern ast.Expression, value object.Object, env *object.Environment, mutable bool) object.Object {
+	bindings := make(map[string]object.Object)
+	mismatch, err := matchPattern(pattern, value, env.Budget(), bindings)
+	if err != nil {
+		return err
+	}
+	if mismatch != "" {
+		return newError("destructuring mismatch: %s", mismatch)
+	}
+	for name, value := range bindings {
+		if mutable {
+			env.SetMutable(name, value)
+		} else {
+			env.Set(name, value)
+		}
+	}
+	return nil
+}
+
+// patternLiteral 返回模式中字面量对应的对象
+func patternLiteral(pattern ast.Expression) object.Object {
+	switch pattern := pattern.(type) {
+	case *ast.IntegerLiteral:
+		return &object.Integer{Value: pattern.Value}
+	case *ast.StringLiteral:
+		return &object.String{Value: pattern.Value}
+	case *ast.Boolean:
+		return nativeBoolToBooleanObject(pattern.Value)
+	default:
+		return NULL
+	}
+}
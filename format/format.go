@@ -0,0 +1,464 @@
+// Package format 实现Monkey源码的规范格式化，供monkey fmt使用
+package format
+
+import (
+	"Monkey/ast"
+	"Monkey/lexer"
+	"Monkey/parser"
+	"Monkey/token"
+	"fmt"
+	"strings"
+)
+
+const (
+	maxLineWidth = 80     // 超过该宽度的调用、数组和哈希会拆成多行
+	indentUnit   = "    " // 每一级缩进
+)
+
+// 与parser中的优先级保持一致，用于决定是否需要添加括号
+const (
+	_ int = iota
+	lowest
+	assign
+	equals
+	lessGreater
+	sum
+	product
+)
+
+var precedences = map[string]int{
+	"==": equals,
+	"!=": equals,
+	"<":  lessGreater,
+	">":  lessGreater,
+	"+":  sum,
+	"-":  sum,
+	"*":  product,
+	"/":  product,
+}
+
+// Source 格式化src，返回规范化后的源码。
+// 源码存在语法错误时返回错误；格式化结果是幂等的
+func Source(src []byte) ([]byte, error) {
+	l := lexer.New(string(src))
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if errors := p.Errors(); len(errors) != 0 {
+		return nil, fmt.Errorf("parse error:\n\t%s", strings.Join(errors, "\n\t"))
+	}
+
+	pr := &printer{
+		lines:    strings.Split(string(src), "\n"),
+		comments: l.Comments(),
+	}
+	var out strings.Builder
+	pr.statements(&out, program.Statements, 0, -1)
+	return []byte(out.String()), nil
+}
+
+type printer struct {
+	lines    []string      // 源码的每一行，用于保留语句之间的空行
+	comments []token.Token // 按出现顺序排列的注释
+	next     int           // 下一条待输出的注释
+}
+
+// statements 输出一组语句及其间的注释。
+// endLine为所在代码块右大括号的行号，在其之前的注释都属于该代码块；-1表示程序末尾
+func (p *printer) statements(out *strings.Builder, stmts []ast.Statement, indent int, endLine int) {
+	first := true
+	for i, stmt := range stmts {
+		line := statementToken(stmt).Line
+		p.leadingComments(out, line, indent, &first)
+
+		if !first && p.blankLineBefore(line) {
+			out.WriteString("\n")
+		}
+		first = false
+
+		rendered := p.statement(stmt, indent)
+		out.WriteString(strings.Repeat(indentUnit, indent))
+		out.WriteString(rendered)
+		if needsSemicolon(stmt, stmts[i+1:], endLine >= 0) {
+			out.WriteString(";")
+		}
+		limit := endLine
+		if i+1 < len(stmts) {
+			limit = statementToken(stmts[i+1]).Line
+		}
+		if p.trailingComment(line, limit) {
+			out.WriteString(" " + p.comments[p.next].Literal)
+			p.next++
+		}
+		out.WriteString("\n")
+	}
+
+	if endLine < 0 {
+		endLine = int(^uint(0) >> 1)
+	}
+	p.leadingComments(out, endLine, indent, &first)
+}
+
+// leadingComments 输出所有位于line之前的注释，每条注释独占一行
+func (p *printer) leadingComments(out *strings.Builder, line int, indent int, first *bool) {
+	for p.next < len(p.comments) && p.comments[p.next].Line < line {
+		comment := p.comments[p.next]
+		if !*first && p.blankLineBefore(comment.Line) {
+			out.WriteString("\n")
+		}
+		*first = false
+		out.WriteString(strings.Repeat(indentUnit, indent))
+		out.WriteString(comment.Literal)
+		out.WriteString("\n")
+		p.next++
+	}
+}
+
+// trailingComment 判断下一条注释是否是从line开始的语句的行尾注释：注释前面有代码，
+// 且位于limit之前。limit为下一条语句或所在代码块右大括号的行，-1表示没有；
+// 与右大括号同行的注释在大括号之后，属于代码块所在的外层语句
+func (p *printer) trailingComment(line int, limit int) bool {
+	if p.next >= len(p.comments) {
+		return false
+	}
+	comment := p.comments[p.next]
+	if comment.Line < line || (limit >= 0 && comment.Line >= limit) || comment.Line > len(p.lines) {
+		return false
+	}
+	source := p.lines[comment.Line-1]
+	return strings.TrimSpace(source[:min(comment.Column-1, len(source))]) != ""
+}
+
+// blankLineBefore 源码中line的上一行是否为空行
+func (p *printer) blankLineBefore(line int) bool {
+	idx := line - 2
+	if idx < 0 || idx >= len(p.lines) {
+		return false
+	}
+	return strings.TrimSpace(p.lines[idx]) == ""
+}
+
+func statementToken(stmt ast.Statement) token.Token {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		return stmt.Token
+	case *ast.ReturnStatement:
+		return stmt.Token
+	case *ast.ExpressionStatement:
+		return stmt.Token
+	default:
+		return token.Token{}
+	}
+}
+
+// needsSemicolon 判断语句后是否需要分号。
+// 代码块中最后一条表达式语句不需要分号；以大括号结尾的if、try和match表达式后面，
+// 只有下一条语句可能被解析为其中缀延续时才需要分号
+func needsSemicolon(stmt ast.Statement, rest []ast.Statement, inBlock bool) bool {
+	es, ok := stmt.(*ast.ExpressionStatement)
+	if !ok {
+		return true
+	}
+	if len(rest) == 0 && inBlock {
+		return false
+	}
+	switch es.Expression.(type) {
+	case *ast.IfExpression, *ast.TryExpression, *ast.MatchExpression:
+	default:
+		return true
+	}
+	if len(rest) == 0 {
+		return false
+	}
+	switch statementToken(rest[0]).Type {
+	case token.LPAREN, token.LBARACKET, token.PLUS, token.MINUS, token.ASTERISK,
+		token.SLASH, token.LT, token.GT, token.EQ, token.NOT_EQ:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *printer) statement(stmt ast.Statement, indent int) string {
+	col := len(indentUnit) * indent
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		name := ""
+		if stmt.Pattern != nil {
+			name = pattern(stmt.Pattern)
+		} else {
+			name = stmt.Name.Value
+		}
+		if stmt.Type != nil {
+			name += ": " + stmt.Type.String()
+		}
+		prefix := stmt.TokenLiteral() + " " + name + " = "
+		return prefix + p.expression(stmt.Value, indent, col+len(prefix))
+	case *ast.ReturnStatement:
+		return "return " + p.expression(stmt.ReturnValue, indent, col+len("return "))
+	case *ast.ExpressionStatement:
+		return p.expression(stmt.Expression, indent, col)
+	default:
+		return stmt.String()
+	}
+}
+
+// expression 渲染表达式。indent为所在行的缩进层级，col为表达式起始列
+func (p *printer) expression(exp ast.Expression, indent int, col int) string {
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		return exp.Value
+	case *ast.IntegerLiteral:
+		return exp.Token.Literal
+	case *ast.Boolean:
+		return exp.Token.Literal
+	case *ast.StringLiteral:
+		return `"` + exp.Value + `"`
+	case *ast.PrefixExpression:
+		operand := p.operand(exp.Right, indent, col+len(exp.Operator), false)
+		return exp.Operator + operand
+	case *ast.InfixExpression:
+		precedence := precedences[exp.Operator]
+		left := p.wrap(exp.Left, indent, col, childPrecedence(exp.Left) < precedence)
+		op := " " + exp.Operator + " "
+		rightCol := columnAfter(col, left) + len(op)
+		right := p.wrap(exp.Right, indent, rightCol, childPrecedence(exp.Right) <= precedence)
+		return left + op + right
+	case *ast.AssignExpression:
+		// 赋值是右结合的，右侧不需要括号
+		target := p.expression(exp.Target, indent, col)
+		return target + " = " + p.expression(exp.Value, indent, columnAfter(col, target)+3)
+	case *ast.IfExpression:
+		prefix := "if ("
+		condition := p.expression(exp.Condition, indent, col+len(prefix))
+		out := prefix + condition + ") " + p.block(exp.Consequence, indent)
+		if exp.Alternative != nil {
+			out += " else " + p.block(exp.Alternative, indent)
+		}
+		return out
+	case *ast.TryExpression:
+		out := "try " + p.block(exp.Block, indent)
+		return out + " catch (" + exp.Param.Value + ") " + p.block(exp.Handler, indent)
+	case *ast.MatchExpression:
+		return p.match(exp, indent, col)
+	case *ast.FunctionLiteral:
+		var params []string
+		for i, param := range exp.Parameters {
+			if t := exp.ParameterType(i); t != nil {
+				params = append(params, param.Value+": "+t.String())
+			} else {
+				params = append(params, param.Value)
+			}
+		}
+		out := "fn(" + strings.Join(params, ", ") + ")"
+		if exp.ReturnType != nil {
+			out += ": " + exp.ReturnType.String()
+		}
+		return out + " " + p.block(exp.Body, indent)
+	case *ast.CallExpression:
+		function := p.operand(exp.Function, indent, col, true)
+		var items []listItem
+		for _, arg := range exp.Arguments {
+			items = append(items, p.expressionItem(arg))
+		}
+		return function + p.list("(", items, ")", indent, columnAfter(col, function))
+	case *ast.ArrayLiteral:
+		var items []listItem
+		for _, element := range exp.Elements {
+			items = append(items, p.expressionItem(element))
+		}
+		return p.list("[", items, "]", indent, col)
+	case *ast.IndexExpression:
+		left := p.operand(exp.Left, indent, col, true)
+		indexCol := columnAfter(col, left) + 1
+		return left + "[" + p.expression(exp.Index, indent, indexCol) + "]"
+	case *ast.HashLiteral:
+		var items []listItem
+		for _, key := range exp.OrderedKeys() {
+			key, value := key, exp.Pairs[key]
+			items = append(items, listItem{render: func(indent int, col int) string {
+				k := p.expression(key, indent, col)
+				return k + ": " + p.expression(value, indent, columnAfter(col, k)+2)
+			}})
+		}
+		return p.list("{", items, "}", indent, col)
+	case nil:
+		return ""
+	default:
+		return exp.String()
+	}
+}
+
+// operand 渲染前缀表达式的操作数以及调用、索引的左侧，必要时加括号
+func (p *printer) operand(exp ast.Expression, indent int, col int, postfix bool) string {
+	switch exp.(type) {
+	case *ast.InfixExpression, *ast.AssignExpression:
+		return p.wrap(exp, indent, col, true)
+	case *ast.PrefixExpression, *ast.IfExpression, *ast.TryExpression, *ast.MatchExpression:
+		return p.wrap(exp, indent, col, postfix)
+	default:
+		return p.expression(exp, indent, col)
+	}
+}
+
+func (p *printer) wrap(exp ast.Expression, indent int, col int, parens bool) string {
+	if !parens {
+		return p.expression(exp, indent, col)
+	}
+	return "(" + p.expression(exp, indent, col+1) + ")"
+}
+
+// childPrecedence 作为中缀表达式子节点时的优先级：赋值表达式低于所有中缀运算符，其他表达式不需要括号
+func childPrecedence(exp ast.Expression) int {
+	switch exp := exp.(type) {
+	case *ast.InfixExpression:
+		return precedences[exp.Operator]
+	case *ast.AssignExpression:
+		return assign
+	}
+	return product + 1
+}
+
+func (p *printer) block(block *ast.BlockStatement, indent int) string {
+	if len(block.Statements) == 0 && !p.hasCommentBefore(block.Rbrace.Line) {
+		return "{}"
+	}
+	var out strings.Builder
+	out.WriteString("{\n")
+	p.statements(&out, block.Statements, indent+1, block.Rbrace.Line)
+	out.WriteString(strings.Repeat(indentUnit, indent))
+	out.WriteString("}")
+	return out.String()
+}
+
+// match 渲染match表达式，每个分支独占一行并以逗号结尾
+func (p *printer) match(exp *ast.MatchExpression, indent int, col int) string {
+	prefix := "match ("
+	subject := p.expression(exp.Subject, indent, col+len(prefix))
+	if len(exp.Arms) == 0 {
+		return prefix + subject + ") {}"
+	}
+
+	var out strings.Builder
+	out.WriteString(prefix + subject + ") {\n")
+	first := true
+	armIndent := strings.Repeat(indentUnit, indent+1)
+	for _, arm := range exp.Arms {
+		p.leadingComments(&out, arm.Token.Line, indent+1, &first)
+		first = false
+		line := pattern(arm.Pattern)
+		if arm.Guard != nil {
+			line += " if " + p.expression(arm.Guard, indent+1, len(armIndent)+len(line)+len(" if "))
+		}
+		line += " => "
+		line += p.expression(arm.Body, indent+1, columnAfter(len(armIndent), line))
+		out.WriteString(armIndent + line + ",\n")
+	}
+	out.WriteString(strings.Repeat(indentUnit, indent) + "}")
+	return out.String()
+}
+
+// pattern 渲染模式，模式总是保持单行
+func pattern(exp ast.Expression) string {
+	switch exp := exp.(type) {
+	case *ast.ArrayPattern:
+		var elements []string
+		for _, element := range exp.Elements {
+			elements = append(elements, pattern(element))
+		}
+		if exp.Rest != nil {
+			elements = append(elements, "..."+exp.Rest.Value)
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	case *ast.HashPattern:
+		var pairs []string
+		for i, key := range exp.Keys {
+			// 简写{name}的键的词法单元为标识符
+			if str, ok := key.(*ast.StringLiteral); ok && str.Token.Type == token.IDENT {
+				pairs = append(pairs, pattern(exp.Values[i]))
+				continue
+			}
+			pairs = append(pairs, pattern(key)+": "+pattern(exp.Values[i]))
+		}
+		return "{" + strings.Join(pairs, ", ") + "}"
+	case *ast.StringLiteral:
+		return `"` + exp.Value + `"`
+	default:
+		return exp.TokenLiteral()
+	}
+}
+
+func (p *printer) hasCommentBefore(line int) bool {
+	return p.next < len(p.comments) && p.comments[p.next].Line < line
+}
+
+// listItem 列表中的一项，render按给定的缩进和起始列渲染该项
+type listItem struct {
+	render   func(indent int, col int) string
+	function bool // 是否为函数字面量
+}
+
+func (p *printer) expressionItem(exp ast.Expression) listItem {
+	_, function := exp.(*ast.FunctionLiteral)
+	return listItem{
+		render: func(indent int, col int) string {
+			return p.expression(exp, indent, col)
+		},
+		function: function,
+	}
+}
+
+// list 渲染调用参数、数组和哈希。
+// 能放进一行时保持单行（只有作为最后一项的函数字面量可以跨行），否则每项独占一行
+func (p *printer) list(open string, items []listItem, close string, indent int, col int) string {
+	if len(items) == 0 {
+		return open + close
+	}
+
+	// 渲染会消耗注释，先记录位置，以便改为多行时重新渲染
+	next := p.next
+	var parts []string
+	itemCol := col + len(open)
+	multiline := false
+	for i, item := range items {
+		part := item.render(indent, itemCol)
+		parts = append(parts, part)
+		itemCol = columnAfter(itemCol, part) + 2
+		if strings.Contains(part, "\n") && !(item.function && i == len(items)-1) {
+			multiline = true
+		}
+	}
+	inline := open + strings.Join(parts, ", ") + close
+	if !multiline && col+len(firstLine(inline)) <= maxLineWidth {
+		return inline
+	}
+
+	p.next = next
+	var out strings.Builder
+	out.WriteString(open + "\n")
+	itemIndent := strings.Repeat(indentUnit, indent+1)
+	for i, item := range items {
+		out.WriteString(itemIndent)
+		out.WriteString(item.render(indent+1, len(itemIndent)))
+		if i < len(items)-1 {
+			out.WriteString(",")
+		}
+		out.WriteString("\n")
+	}
+	out.WriteString(strings.Repeat(indentUnit, indent) + close)
+	return out.String()
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// columnAfter 返回从col开始输出s之后所在的列
+func columnAfter(col int, s string) int {
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return len(s) - i - 1
+	}
+	return col + len(s)
+}
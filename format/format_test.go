@@ -0,0 +1,172 @@
+package format
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "spacing",
+			input:    "let   x=1+2*3;x",
+			expected: "let x = 1 + 2 * 3;\nx;\n",
+		},
+		{
+			name:     "parentheses",
+			input:    "a-(b-c);(a-b)-c;(a+b)*c;-(a+b);(-a)(b);!(a==b)",
+			expected: "a - (b - c);\na - b - c;\n(a + b) * c;\n-(a + b);\n(-a)(b);\n!(a == b);\n",
+		},
+		{
+			name:  "blocks",
+			input: "let f=fn(x,y){if(x>y){return x;}else{y}};",
+			expected: `let f = fn(x, y) {
+    if (x > y) {
+        return x;
+    } else {
+        y
+    }
+};
+`,
+		},
+		{
+			name:     "empty block",
+			input:    "fn(){}",
+			expected: "fn() {};\n",
+		},
+		{
+			name:  "if followed by expression",
+			input: "if (a) { 1 }; -1; if (b) { 2 } let c = 3;",
+			expected: `if (a) {
+    1
+};
+-1;
+if (b) {
+    2
+}
+let c = 3;
+`,
+		},
+		{
+			name:  "try",
+			input: "let x=1+try{f(1)}catch(e){0};try{g()}catch(err){println(err)}",
+			expected: `let x = 1 + try {
+    f(1)
+} catch (e) {
+    0
+};
+try {
+    g()
+} catch (err) {
+    println(err)
+}
+`,
+		},
+		{
+			name:  "match",
+			input: "let area=match(s){{\"kind\":\"square\",\"side\":n}=>n*n,[a,_,...rest] if a>-1=>a,\n// fallback\n_=>0};match(x){}",
+			expected: `let area = match (s) {
+    {"kind": "square", "side": n} => n * n,
+    [a, _, ...rest] if a > -1 => a,
+    // fallback
+    _ => 0,
+};
+match (x) {}
+`,
+		},
+		{
+			name:     "destructuring let",
+			input:    "let [a,b,...rest]=f();let {name,\"age\":years,1:[x,_]}=p;",
+			expected: "let [a, b, ...rest] = f();\nlet {name, \"age\": years, 1: [x, _]} = p;\n",
+		},
+		{
+			name:     "var and assignment",
+			input:    "var x=1;x=y=2;xs[0]=x+1;(x=3)+1;-(x=1);",
+			expected: "var x = 1;\nx = y = 2;\nxs[0] = x + 1;\n(x = 3) + 1;\n-(x = 1);\n",
+		},
+		{
+			name:     "type annotations",
+			input:    "let x:int=5;var f=fn(a:string,b : [int]):bool{true};let g:fn(int,{string:any}):null=h;",
+			expected: "let x: int = 5;\nvar f = fn(a: string, b: [int]): bool {\n    true\n};\nlet g: fn(int, {string: any}): null = h;\n",
+		},
+		{
+			name:     "trailing comment after multi-line statement",
+			input:    "let add = fn(a, b) { a + b }; // trailing\nlet f = fn() {\n  1 // one\n}; // after f\nf(); // call\nlet h = {\n  \"a\": 1\n}; // h\n",
+			expected: "let add = fn(a, b) {\n    a + b\n}; // trailing\nlet f = fn() {\n    1 // one\n}; // after f\nf(); // call\nlet h = {\"a\": 1}; // h\n",
+		},
+		{
+			name:  "comments and blank lines",
+			input: "// head\nlet a = 1; // one\n\n\n// before b\nlet b = fn() {\n  // inside\n};\n// tail\n",
+			expected: `// head
+let a = 1; // one
+
+// before b
+let b = fn() {
+    // inside
+};
+// tail
+`,
+		},
+		{
+			name:  "long array",
+			input: `let xs = ["aaaaaaaaaa", "bbbbbbbbbb", "cccccccccc", "dddddddddd", "eeeeeeeeee", "ffffffffff"];`,
+			expected: `let xs = [
+    "aaaaaaaaaa",
+    "bbbbbbbbbb",
+    "cccccccccc",
+    "dddddddddd",
+    "eeeeeeeeee",
+    "ffffffffff"
+];
+`,
+		},
+		{
+			name:  "hash keeps source order",
+			input: `{"b": 1, "a": 2}`,
+			expected: `{"b": 1, "a": 2};
+`,
+		},
+		{
+			name:  "trailing function argument",
+			input: "map(xs, fn(x) { x * 2 });",
+			expected: `map(xs, fn(x) {
+    x * 2
+});
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := Source([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("Source returned error: %s", err)
+			}
+			if string(out) != tt.expected {
+				t.Fatalf("wrong output.\nwant=\n%s\ngot=\n%s", tt.expected, out)
+			}
+
+			again, err := Source(out)
+			if err != nil {
+				t.Fatalf("formatting output returned error: %s", err)
+			}
+			if string(again) != string(out) {
+				t.Fatalf("format is not idempotent.\nfirst=\n%s\nsecond=\n%s", out, again)
+			}
+		})
+	}
+}
+
+func TestSourceParseError(t *testing.T) {
+	_, err := Source([]byte("let x = ;"))
+	if err == nil {
+		t.Fatalf("expected parse error")
+	}
+	if !strings.Contains(err.Error(), "1:9") {
+		t.Errorf("error does not contain position: %s", err)
+	}
+}
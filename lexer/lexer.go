@@ -2,6 +2,7 @@ package lexer
 
 import (
 	"Monkey/token"
+	"strings"
 )
 
 type Lexer struct {
@@ -9,15 +10,23 @@ type Lexer struct {
 	readPosition int
 	position     int
 	ch           byte
+	line         int // 当前字符ch所在行
+	column       int // 当前字符ch所在列
+	comments     []token.Token
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	l := &Lexer{input: input, line: 1}
 	l.readChar()
 	return l
 }
 
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+	l.column++
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -27,14 +36,26 @@ func (l *Lexer) readChar() {
 	l.readPosition += 1
 }
 
+// NextToken 返回下一个词法单元，并记录其起始行列
 func (l *Lexer) NextToken() token.Token {
+	l.skipWhitespaceAndComments()
+	line, column := l.line, l.column
+	tok := l.readToken()
+	tok.Line = line
+	tok.Column = column
+	return tok
+}
+
+func (l *Lexer) readToken() token.Token {
 	var tok token.Token
-	l.skipWhitespace()
 	switch l.ch {
 	case '=':
 		if l.peakChar() == '=' {
 			l.readChar()
 			tok = token.Token{Type: token.EQ, Literal: "=="}
+		} else if l.peakChar() == '>' {
+			l.readChar()
+			tok = token.Token{Type: token.ARROW, Literal: "=>"}
 		} else {
 			tok = token.Token{Type: token.ASSIGN, Literal: string(l.ch)}
 		}
@@ -78,6 +99,14 @@ func (l *Lexer) NextToken() token.Token {
 		tok = token.Token{Type: token.RBARACKET, Literal: string(l.ch)}
 	case ':':
 		tok = token.Token{Type: token.COLON, Literal: string(l.ch)}
+	case '.':
+		if strings.HasPrefix(l.input[l.position:], "...") {
+			l.readChar()
+			l.readChar()
+			tok = token.Token{Type: token.ELLIPSIS, Literal: "..."}
+		} else {
+			tok = newToken(token.ILLEGAL, l.ch)
+		}
 	case 0:
 		tok.Literal = ""
 		tok.Type = token.EOF
@@ -106,7 +135,6 @@ func (l *Lexer) NextToken() token.Token {
 			return tok
 		} else {
 			tok = newToken(token.ILLEGAL, l.ch)
-			return tok
 		}
 	}
 
@@ -141,6 +169,28 @@ func isLetter(ch byte) bool {
 	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
 }
 
+// Comments 返回目前为止跳过的所有行注释
+func (l *Lexer) Comments() []token.Token {
+	return l.comments
+}
+
+// skipWhitespaceAndComments 跳过空白和以//开头的行注释，注释会被记录下来
+func (l *Lexer) skipWhitespaceAndComments() {
+	for {
+		l.skipWhitespace()
+		if l.ch != '/' || l.peakChar() != '/' {
+			return
+		}
+		comment := token.Token{Type: token.COMMENT, Line: l.line, Column: l.column}
+		position := l.position
+		for l.ch != '\n' && l.ch != 0 {
+			l.readChar()
+		}
+		comment.Literal = strings.TrimRight(l.input[position:l.position], " \t\r")
+		l.comments = append(l.comments, comment)
+	}
+}
+
 func (l *Lexer) skipWhitespace() {
 	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
 		l.readChar()
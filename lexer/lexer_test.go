@@ -161,3 +161,117 @@ return false;
 	}
 
 }
+
+func TestTokenPosition(t *testing.T) {
+	input := "let x = 5;\n  x + \"ab\""
+
+	tests := []struct {
+		expectType   token.TokenType
+		expectLine   int
+		expectColumn int
+	}{
+		{token.LET, 1, 1},
+		{token.IDENT, 1, 5},
+		{token.ASSIGN, 1, 7},
+		{token.INT, 1, 9},
+		{token.SEMICOLON, 1, 10},
+		{token.IDENT, 2, 3},
+		{token.PLUS, 2, 5},
+		{token.STRING, 2, 7},
+		{token.EOF, 2, 11},
+	}
+
+	l := lexer.New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectType {
+			t.Fatalf("tests[%d]-token wrong.expected=%q, got=%q", i, tt.expectType, tok.Type)
+		}
+		if tok.Line != tt.expectLine || tok.Column != tt.expectColumn {
+			t.Fatalf("tests[%d]-position wrong.expected=%d:%d, got=%d:%d", i, tt.expectLine, tt.expectColumn, tok.Line, tok.Column)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := "// head\nlet x = 5; // five\nx / 2"
+
+	expectedTypes := []token.TokenType{
+		token.LET, token.IDENT, token.ASSIGN, token.INT, token.SEMICOLON,
+		token.IDENT, token.SLASH, token.INT, token.EOF,
+	}
+
+	l := lexer.New(input)
+	for i, expected := range expectedTypes {
+		tok := l.NextToken()
+		if tok.Type != expected {
+			t.Fatalf("tests[%d]-token wrong.expected=%q, got=%q", i, expected, tok.Type)
+		}
+	}
+
+	comments := l.Comments()
+	if len(comments) != 2 {
+		t.Fatalf("wrong number of comments. want=2, got=%d", len(comments))
+	}
+	if comments[0].Literal != "// head" || comments[0].Line != 1 {
+		t.Errorf("comments[0] wrong. got=%+v", comments[0])
+	}
+	if comments[1].Literal != "// five" || comments[1].Line != 2 || comments[1].Column != 12 {
+		t.Errorf("comments[1] wrong. got=%+v", comments[1])
+	}
+}
+
+func TestMatchTokens(t *testing.T) {
+	input := "match (x) { [a, ...r] => a, _ => 0 }"
+
+	expected := []token.Token{
+		{Type: token.MATCH, Literal: "match"},
+		{Type: token.LPAREN, Literal: "("},
+		{Type: token.IDENT, Literal: "x"},
+		{Type: token.RPAREN, Literal: ")"},
+		{Type: token.LBRACE, Literal: "{"},
+		{Type: token.LBARACKET, Literal: "["},
+		{Type: token.IDENT, Literal: "a"},
+		{Type: token.COMMA, Literal: ","},
+		{Type: token.ELLIPSIS, Literal: "..."},
+		{Type: token.IDENT, Literal: "r"},
+		{Type: token.RBARACKET, Literal: "]"},
+		{Type: token.ARROW, Literal: "=>"},
+		{Type: token.IDENT, Literal: "a"},
+		{Type: token.COMMA, Literal: ","},
+		{Type: token.IDENT, Literal: "_"},
+		{Type: token.ARROW, Literal: "=>"},
+		{Type: token.INT, Literal: "0"},
+		{Type: token.RBRACE, Literal: "}"},
+		{Type: token.EOF, Literal: ""},
+	}
+
+	l := lexer.New(input)
+	for i, tt := range expected {
+		tok := l.NextToken()
+		if tok.Type != tt.Type || tok.Literal != tt.Literal {
+			t.Fatalf("tests[%d]-token wrong. expected=%q %q, got=%q %q", i, tt.Type, tt.Literal, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestIllegalCharacters(t *testing.T) {
+	input := "a.b @"
+
+	expected := []token.Token{
+		{Type: token.IDENT, Literal: "a"},
+		{Type: token.ILLEGAL, Literal: "."},
+		{Type: token.IDENT, Literal: "b"},
+		{Type: token.ILLEGAL, Literal: "@"},
+		{Type: token.EOF, Literal: ""},
+	}
+
+	l := lexer.New(input)
+	for i, tt := range expected {
+		tok := l.NextToken()
+		if tok.Type != tt.Type || tok.Literal != tt.Literal {
+			t.Fatalf("tests[%d]-token wrong. expected=%q %q, got=%q %q", i, tt.Type, tt.Literal, tok.Type, tok.Literal)
+		}
+	}
+}
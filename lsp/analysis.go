@@ -0,0 +1,342 @@
+package lsp
+
+import (
+	"Monkey/ast"
+	"Monkey/compiler"
+	"Monkey/lexer"
+	"Monkey/object"
+	"Monkey/parser"
+	"Monkey/types"
+	"Monkey/vet"
+	"fmt"
+	"strings"
+)
+
+// definition 一个let绑定、函数参数、catch的参数或模式中绑定的名字
+type definition struct {
+	ident    *ast.Identifier
+	value    ast.Expression       // let绑定的值，参数为nil
+	keyword  string               // 定义value的关键字，let或var
+	function *ast.FunctionLiteral // 参数所属的函数
+	role     string               // catch的参数和模式中的名字在悬停提示中的说明，其他为空
+	scope    compiler.SymbolScope // 符号表给出的作用域
+}
+
+// reference 源码中出现的一个标识符，包括定义处的名字
+type reference struct {
+	ident   *ast.Identifier
+	def     *definition // 内置函数和未定义的标识符为nil
+	builtin bool
+}
+
+// document 打开的文档及其分析结果
+type document struct {
+	uri         string
+	text        string
+	program     *ast.Program
+	diagnostics []Diagnostic
+	references  []reference
+	globals     []*definition
+}
+
+func analyze(uri, text string) *document {
+	doc := &document{uri: uri, text: text, diagnostics: []Diagnostic{}}
+
+	p := parser.New(lexer.New(text))
+	doc.program = p.ParseProgram()
+	for _, d := range p.Diagnostics() {
+		doc.diagnostics = append(doc.diagnostics, Diagnostic{
+			Range:    tokenRange(d.Line, d.Column, len(d.Found.Literal)),
+			Severity: SeverityError,
+			Source:   "monkey",
+			Message:  d.Message,
+		})
+	}
+	if len(p.Errors()) == 0 {
+		for _, d := range vet.Check(doc.program) {
+			doc.diagnostics = append(doc.diagnostics, Diagnostic{
+				Range:    tokenRange(d.Line, d.Column, 1),
+				Severity: SeverityWarning,
+				Source:   "monkey vet",
+				Message:  d.Message,
+			})
+		}
+		for _, d := range types.Check(doc.program) {
+			doc.diagnostics = append(doc.diagnostics, Diagnostic{
+				Range:    tokenRange(d.Line, d.Column, 1),
+				Severity: SeverityWarning,
+				Source:   "monkey check",
+				Message:  d.Message,
+			})
+		}
+	}
+
+	a := newAnalyzer()
+	a.statements(doc.program.Statements)
+	doc.references = a.references
+	doc.globals = a.globals
+	return doc
+}
+
+// symbolKey 唯一确定一个变量：定义它的符号表以及它在其中的索引
+type symbolKey struct {
+	table *compiler.SymbolTable
+	index int
+}
+
+// analyzer 按照编译器的规则，用compiler.SymbolTable解析每个标识符所引用的定义
+type analyzer struct {
+	global      *compiler.SymbolTable
+	table       *compiler.SymbolTable
+	function    *ast.FunctionLiteral
+	definitions map[symbolKey]*definition
+	references  []reference
+	globals     []*definition
+}
+
+func newAnalyzer() *analyzer {
+	global := compiler.NewSymbolTable()
+	for i, name := range object.BuiltinNames() {
+		global.DefineBuiltin(i, name)
+	}
+	return &analyzer{
+		global:      global,
+		table:       global,
+		definitions: make(map[symbolKey]*definition),
+	}
+}
+
+func (a *analyzer) Visit(node ast.Node) ast.Visitor {
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		if node.Pattern != nil {
+			// 解构时先处理值再定义名字
+			if node.Value != nil {
+				ast.Walk(a, node.Value)
+			}
+			for _, ident := range ast.PatternBindings(node.Pattern) {
+				def := a.define(ident, nil)
+				def.function, def.role = nil, "destructuring binding"
+				if a.table == a.global {
+					a.globals = append(a.globals, def)
+				}
+			}
+			return nil
+		}
+		// 与编译器一致：先处理值再定义名字，值中的同名标识符引用的是之前的定义；
+		// 函数字面量可以递归引用自身，先定义名字
+		_, recursive := node.Value.(*ast.FunctionLiteral)
+		if !recursive && node.Value != nil {
+			ast.Walk(a, node.Value)
+		}
+		if node.Name != nil {
+			def := a.define(node.Name, node.Value)
+			def.keyword = node.TokenLiteral()
+			if a.table == a.global {
+				a.globals = append(a.globals, def)
+			}
+		}
+		if recursive {
+			ast.Walk(a, node.Value)
+		}
+		return nil
+	case *ast.TryExpression:
+		// catch的参数与let一样定义在当前作用域中
+		if node.Block != nil {
+			ast.Walk(a, node.Block)
+		}
+		if node.Param != nil {
+			def := a.define(node.Param, nil)
+			def.function, def.role = nil, "catch parameter"
+		}
+		if node.Handler != nil {
+			ast.Walk(a, node.Handler)
+		}
+		return nil
+	case *ast.MatchExpression:
+		if node.Subject != nil {
+			ast.Walk(a, node.Subject)
+		}
+		// 模式中的名字在当前作用域中定义，只在所在分支的guard和分支体中引用
+		for _, arm := range node.Arms {
+			for _, ident := range ast.PatternBindings(arm.Pattern) {
+				def := a.define(ident, nil)
+				def.function, def.role = nil, "pattern binding"
+			}
+			if arm.Guard != nil {
+				ast.Walk(a, arm.Guard)
+			}
+			if arm.Body != nil {
+				ast.Walk(a, arm.Body)
+			}
+		}
+		return nil
+	case *ast.FunctionLiteral:
+		outer, outerFunction := a.table, a.function
+		a.table = compiler.NewEnclosedSymbolTable(outer)
+		a.function = node
+		for _, param := range node.Parameters {
+			a.define(param, nil)
+		}
+		if node.Body != nil {
+			a.statements(node.Body.Statements)
+		}
+		a.table, a.function = outer, outerFunction
+		return nil
+	case *ast.Identifier:
+		a.resolve(node)
+	}
+	return a
+}
+
+func (a *analyzer) statements(stmts []ast.Statement) {
+	for _, stmt := range stmts {
+		if stmt != nil {
+			ast.Walk(a, stmt)
+		}
+	}
+}
+
+func (a *analyzer) define(ident *ast.Identifier, value ast.Expression) *definition {
+	symbol := a.table.Define(ident.Value)
+	def := &definition{ident: ident, value: value, scope: symbol.Scope}
+	if value == nil {
+		def.function = a.function
+	}
+	a.definitions[symbolKey{a.table, symbol.Index}] = def
+	a.references = append(a.references, reference{ident: ident, def: def})
+	return def
+}
+
+func (a *analyzer) resolve(ident *ast.Identifier) {
+	ref := reference{ident: ident}
+	symbol, ok := a.table.Resolve(ident.Value)
+	if ok {
+		table := a.table
+		// 自由变量指向外层作用域中的原始符号
+		for symbol.Scope == compiler.FreeScope {
+			symbol = table.FreeSymbols[symbol.Index]
+			table = table.Outer
+		}
+		switch symbol.Scope {
+		case compiler.BuiltinScope:
+			ref.builtin = true
+		case compiler.GlobalScope:
+			ref.def = a.definitions[symbolKey{a.global, symbol.Index}]
+		case compiler.LocalScope:
+			ref.def = a.definitions[symbolKey{table, symbol.Index}]
+		}
+	}
+	a.references = append(a.references, ref)
+}
+
+// referenceAt 返回覆盖pos的标识符
+func (d *document) referenceAt(pos Position) (reference, bool) {
+	for _, ref := range d.references {
+		r := identRange(ref.ident)
+		if r.Start.Line == pos.Line && r.Start.Character <= pos.Character && pos.Character < r.End.Character {
+			return ref, true
+		}
+	}
+	return reference{}, false
+}
+
+func (d *document) hover(pos Position) *Hover {
+	ref, ok := d.referenceAt(pos)
+	if !ok {
+		return nil
+	}
+	r := identRange(ref.ident)
+
+	var value string
+	switch {
+	case ref.builtin:
+		value = fmt.Sprintf("```monkey\nbuiltin %s\n```", ref.ident.Value)
+	case ref.def == nil:
+		return nil
+	case ref.def.role != "":
+		value = fmt.Sprintf("```monkey\n%s\n```\n%s", ref.def.ident.Value, ref.def.role)
+	case ref.def.value == nil:
+		value = fmt.Sprintf("```monkey\n%s\n```\nparameter", ref.def.ident.Value)
+		if ref.def.function != nil {
+			value += " of `" + signature(ref.def.function) + "`"
+		}
+	default:
+		value = fmt.Sprintf("```monkey\n%s %s = %s\n```\n%s binding", ref.def.keyword, ref.def.ident.Value,
+			summarize(ref.def.value), strings.ToLower(string(ref.def.scope)))
+	}
+	return &Hover{Contents: MarkupContent{Kind: "markdown", Value: value}, Range: &r}
+}
+
+func (d *document) definition(pos Position) *Location {
+	ref, ok := d.referenceAt(pos)
+	if !ok || ref.def == nil {
+		return nil
+	}
+	return &Location{URI: d.uri, Range: identRange(ref.def.ident)}
+}
+
+// completion 补全内置函数和全局变量
+func (d *document) completion() []CompletionItem {
+	items := []CompletionItem{}
+	for _, name := range object.BuiltinNames() {
+		items = append(items, CompletionItem{Label: name, Kind: CompletionKindFunction, Detail: "builtin"})
+	}
+	seen := make(map[string]bool)
+	for _, def := range d.globals {
+		if seen[def.ident.Value] {
+			continue
+		}
+		seen[def.ident.Value] = true
+		kind := CompletionKindVariable
+		if _, ok := def.value.(*ast.FunctionLiteral); ok {
+			kind = CompletionKindFunction
+		}
+		items = append(items, CompletionItem{Label: def.ident.Value, Kind: kind, Detail: summarize(def.value)})
+	}
+	return items
+}
+
+// summarize 用一行概括表达式，函数只展示签名
+func summarize(exp ast.Expression) string {
+	if exp == nil {
+		return ""
+	}
+	if fn, ok := exp.(*ast.FunctionLiteral); ok {
+		return signature(fn)
+	}
+	s := exp.String()
+	if len(s) > 60 {
+		s = s[:57] + "..."
+	}
+	return s
+}
+
+func signature(fn *ast.FunctionLiteral) string {
+	var params []string
+	for i, param := range fn.Parameters {
+		if t := fn.ParameterType(i); t != nil {
+			params = append(params, param.Value+": "+t.String())
+		} else {
+			params = append(params, param.Value)
+		}
+	}
+	out := "fn(" + strings.Join(params, ", ") + ")"
+	if fn.ReturnType != nil {
+		out += ": " + fn.ReturnType.String()
+	}
+	return out
+}
+
+// LSP的行和列从0开始，词法单元的行列从1开始。Monkey源码只支持ASCII，列数即字符数
+func tokenRange(line, column, length int) Range {
+	if length < 1 {
+		length = 1
+	}
+	start := Position{Line: line - 1, Character: column - 1}
+	return Range{Start: start, End: Position{Line: start.Line, Character: start.Character + length}}
+}
+
+func identRange(ident *ast.Identifier) Range {
+	return tokenRange(ident.Token.Line, ident.Token.Column, len(ident.Value))
+}
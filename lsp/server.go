@@ -0,0 +1,203 @@
+// Package lsp 实现Monkey的语言服务器，通过标准输入输出使用JSON-RPC与编辑器通信
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type Server struct {
+	in  *bufio.Reader
+	out io.Writer
+	mu  sync.Mutex // 保护对out的写入
+
+	documents map[string]*document
+	shutdown  bool
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:        bufio.NewReader(in),
+		out:       out,
+		documents: make(map[string]*document),
+	}
+}
+
+// Serve 循环处理消息，直到收到exit通知或输入结束
+func (s *Server) Serve() error {
+	for {
+		body, err := s.readMessage()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var msg message
+		if err := json.Unmarshal(body, &msg); err != nil {
+			s.replyError(nil, codeParseError, err.Error())
+			continue
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		s.handle(&msg)
+	}
+}
+
+// readMessage 读取一条以Content-Length头部分帧的消息
+func (s *Server) readMessage() ([]byte, error) {
+	header, err := textproto.NewReader(s.in).ReadMIMEHeader()
+	if err != nil {
+		if errors.Is(err, io.EOF) && len(header) == 0 {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length: %q", header.Get("Content-Length"))
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func (s *Server) write(msg *message) {
+	msg.JSONRPC = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (s *Server) reply(id *json.RawMessage, result any) {
+	body, err := json.Marshal(result)
+	if err != nil {
+		s.replyError(id, codeInvalidRequest, err.Error())
+		return
+	}
+	s.write(&message{ID: id, Result: body})
+}
+
+func (s *Server) replyError(id *json.RawMessage, code int, msg string) {
+	s.write(&message{ID: id, Error: &responseError{Code: code, Message: msg}})
+}
+
+func (s *Server) notify(method string, params any) {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return
+	}
+	s.write(&message{Method: method, Params: body})
+}
+
+func (s *Server) handle(msg *message) {
+	isRequest := msg.ID != nil
+	if s.shutdown && isRequest {
+		s.replyError(msg.ID, codeInvalidRequest, "server is shutting down")
+		return
+	}
+
+	switch msg.Method {
+	case "initialize":
+		s.reply(msg.ID, InitializeResult{
+			Capabilities: ServerCapabilities{
+				TextDocumentSync:   1,
+				HoverProvider:      true,
+				DefinitionProvider: true,
+				CompletionProvider: &CompletionOptions{},
+			},
+			ServerInfo: ServerInfo{Name: "monkey"},
+		})
+	case "initialized":
+	case "shutdown":
+		s.shutdown = true
+		s.reply(msg.ID, nil)
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if s.decode(msg, &params) {
+			s.update(params.TextDocument.URI, params.TextDocument.Text)
+		}
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if s.decode(msg, &params) && len(params.ContentChanges) > 0 {
+			// 使用完整文本同步，最后一次变更即为当前内容
+			text := params.ContentChanges[len(params.ContentChanges)-1].Text
+			s.update(params.TextDocument.URI, text)
+		}
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if s.decode(msg, &params) {
+			delete(s.documents, params.TextDocument.URI)
+			s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+				URI: params.TextDocument.URI, Diagnostics: []Diagnostic{},
+			})
+		}
+	case "textDocument/hover":
+		var params TextDocumentPositionParams
+		if s.decode(msg, &params) {
+			var result *Hover
+			if doc, ok := s.documents[params.TextDocument.URI]; ok {
+				result = doc.hover(params.Position)
+			}
+			s.reply(msg.ID, result)
+		}
+	case "textDocument/definition":
+		var params TextDocumentPositionParams
+		if s.decode(msg, &params) {
+			var result *Location
+			if doc, ok := s.documents[params.TextDocument.URI]; ok {
+				result = doc.definition(params.Position)
+			}
+			s.reply(msg.ID, result)
+		}
+	case "textDocument/completion":
+		var params TextDocumentPositionParams
+		if s.decode(msg, &params) {
+			result := []CompletionItem{}
+			if doc, ok := s.documents[params.TextDocument.URI]; ok {
+				result = doc.completion()
+			}
+			s.reply(msg.ID, result)
+		}
+	default:
+		// 未知的通知直接忽略，$/开头的请求同样可以忽略
+		if isRequest && !strings.HasPrefix(msg.Method, "$/") {
+			s.replyError(msg.ID, codeMethodNotFound, "method not found: "+msg.Method)
+		}
+	}
+}
+
+// decode 解析参数，失败时对请求回复错误
+func (s *Server) decode(msg *message, v any) bool {
+	if err := json.Unmarshal(msg.Params, v); err != nil {
+		if msg.ID != nil {
+			s.replyError(msg.ID, codeInvalidParams, err.Error())
+		}
+		return false
+	}
+	return true
+}
+
+// update 重新分析文档并发布诊断信息
+func (s *Server) update(uri, text string) {
+	doc := analyze(uri, text)
+	s.documents[uri] = doc
+	s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+		URI: uri, Diagnostics: doc.diagnostics,
+	})
+}
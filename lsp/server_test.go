@@ -0,0 +1,298 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// client 在同一进程中通过管道与Server通信的测试客户端
+type client struct {
+	t      *testing.T
+	writer io.WriteCloser
+	reader *bufio.Reader
+	nextID int
+	done   chan error
+}
+
+func newClient(t *testing.T) *client {
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+
+	c := &client{t: t, writer: clientOut, reader: bufio.NewReader(clientIn), done: make(chan error, 1)}
+	go func() {
+		err := NewServer(serverIn, serverOut).Serve()
+		serverOut.Close()
+		c.done <- err
+	}()
+	t.Cleanup(func() { clientOut.Close() })
+	return c
+}
+
+func (c *client) send(msg map[string]any) {
+	c.t.Helper()
+	msg["jsonrpc"] = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := io.WriteString(c.writer, "Content-Length: "+strconv.Itoa(len(body))+"\r\n\r\n"+string(body)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *client) receive() message {
+	c.t.Helper()
+	header, err := textproto.NewReader(c.reader).ReadMIMEHeader()
+	if err != nil {
+		c.t.Fatalf("reading header: %s", err)
+	}
+	length, _ := strconv.Atoi(header.Get("Content-Length"))
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, body); err != nil {
+		c.t.Fatalf("reading body: %s", err)
+	}
+	var msg message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		c.t.Fatalf("decoding %s: %s", body, err)
+	}
+	return msg
+}
+
+// request 发送请求并把结果解析到result中
+func (c *client) request(method string, params any, result any) {
+	c.t.Helper()
+	c.nextID++
+	c.send(map[string]any{"id": c.nextID, "method": method, "params": params})
+	msg := c.receive()
+	if msg.Error != nil {
+		c.t.Fatalf("%s failed: %s", method, msg.Error.Message)
+	}
+	if string(*msg.ID) != strconv.Itoa(c.nextID) {
+		c.t.Fatalf("wrong id. want=%d, got=%s", c.nextID, *msg.ID)
+	}
+	if result != nil {
+		if err := json.Unmarshal(msg.Result, result); err != nil {
+			c.t.Fatalf("decoding result %s: %s", msg.Result, err)
+		}
+	}
+}
+
+func (c *client) notify(method string, params any) {
+	c.t.Helper()
+	c.send(map[string]any{"method": method, "params": params})
+}
+
+func (c *client) diagnostics() PublishDiagnosticsParams {
+	c.t.Helper()
+	msg := c.receive()
+	if msg.Method != "textDocument/publishDiagnostics" {
+		c.t.Fatalf("expected publishDiagnostics, got %q", msg.Method)
+	}
+	var params PublishDiagnosticsParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		c.t.Fatal(err)
+	}
+	return params
+}
+
+func (c *client) open(uri, text string) PublishDiagnosticsParams {
+	c.t.Helper()
+	c.notify("textDocument/didOpen", map[string]any{
+		"textDocument": map[string]any{"uri": uri, "languageId": "monkey", "version": 1, "text": text},
+	})
+	return c.diagnostics()
+}
+
+func position(uri string, line, character int) map[string]any {
+	return map[string]any{
+		"textDocument": map[string]any{"uri": uri},
+		"position":     map[string]any{"line": line, "character": character},
+	}
+}
+
+func TestInitializeAndShutdown(t *testing.T) {
+	c := newClient(t)
+
+	var result InitializeResult
+	c.request("initialize", map[string]any{"capabilities": map[string]any{}}, &result)
+	if !result.Capabilities.HoverProvider || !result.Capabilities.DefinitionProvider {
+		t.Errorf("missing capabilities: %+v", result.Capabilities)
+	}
+	c.notify("initialized", map[string]any{})
+
+	c.request("shutdown", nil, nil)
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		t.Fatalf("Serve returned error: %s", err)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	c := newClient(t)
+	c.request("initialize", map[string]any{}, nil)
+
+	params := c.open("file:///a.mk", "let x = 1;\nlet y 2;\nx + y;")
+	if params.URI != "file:///a.mk" {
+		t.Fatalf("wrong uri %q", params.URI)
+	}
+	if len(params.Diagnostics) != 1 {
+		t.Fatalf("wrong number of diagnostics. want=1, got=%+v", params.Diagnostics)
+	}
+	d := params.Diagnostics[0]
+	if d.Severity != SeverityError || d.Range.Start != (Position{Line: 1, Character: 6}) {
+		t.Errorf("wrong diagnostic: %+v", d)
+	}
+
+	c.notify("textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": "file:///a.mk", "version": 2},
+		"contentChanges": []map[string]any{{"text": "let x = 1;\nx;"}},
+	})
+	if params := c.diagnostics(); len(params.Diagnostics) != 0 {
+		t.Errorf("expected no diagnostics, got %+v", params.Diagnostics)
+	}
+
+	c.notify("textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": "file:///a.mk", "version": 3},
+		"contentChanges": []map[string]any{{"text": "let unused = 1;"}},
+	})
+	params = c.diagnostics()
+	if len(params.Diagnostics) != 1 || params.Diagnostics[0].Severity != SeverityWarning {
+		t.Errorf("expected one vet warning, got %+v", params.Diagnostics)
+	}
+
+	c.notify("textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": "file:///a.mk", "version": 4},
+		"contentChanges": []map[string]any{{"text": "let x: int = \"a\";\nx;"}},
+	})
+	params = c.diagnostics()
+	if len(params.Diagnostics) != 1 || params.Diagnostics[0].Source != "monkey check" ||
+		params.Diagnostics[0].Range.Start != (Position{Line: 0, Character: 13}) {
+		t.Errorf("expected one type warning, got %+v", params.Diagnostics)
+	}
+}
+
+const source = `let add = fn(a, b) { a + b };
+let outer = fn(x) {
+  fn(y) { x + y }
+};
+add(len("ab"), outer(1)(2));
+match ([1]) { [n] => n };
+var total = 0; total = add(total, 1);
+let greet = fn(name: string): string { name }; greet("a");`
+
+func TestDefinition(t *testing.T) {
+	c := newClient(t)
+	c.request("initialize", map[string]any{}, nil)
+	c.open("file:///b.mk", source)
+
+	tests := []struct {
+		line, character int
+		expected        *Range
+	}{
+		// 调用处的add指向第一行的定义
+		{4, 1, &Range{Start: Position{0, 4}, End: Position{0, 7}}},
+		// 函数体中的参数a
+		{0, 21, &Range{Start: Position{0, 13}, End: Position{0, 14}}},
+		// 闭包中引用外层函数的参数x
+		{2, 10, &Range{Start: Position{1, 15}, End: Position{1, 16}}},
+		// 内置函数没有定义位置
+		{4, 5, nil},
+		// 分支体中的n指向模式中的名字
+		{5, 21, &Range{Start: Position{5, 15}, End: Position{5, 16}}},
+	}
+	for _, tt := range tests {
+		var location *Location
+		c.request("textDocument/definition", position("file:///b.mk", tt.line, tt.character), &location)
+		if tt.expected == nil {
+			if location != nil {
+				t.Errorf("%d:%d expected no definition, got %+v", tt.line, tt.character, location)
+			}
+			continue
+		}
+		if location == nil || location.Range != *tt.expected || location.URI != "file:///b.mk" {
+			t.Errorf("%d:%d wrong definition. want=%+v, got=%+v", tt.line, tt.character, tt.expected, location)
+		}
+	}
+
+	// 重新绑定同名变量时，值中的名字指向之前的定义
+	c.open("file:///c.mk", "let x = 1;\nlet x = x + 1;\nx;")
+	shadowed := []struct {
+		line, character int
+		expected        Range
+	}{
+		{1, 8, Range{Start: Position{0, 4}, End: Position{0, 5}}},
+		{2, 0, Range{Start: Position{1, 4}, End: Position{1, 5}}},
+	}
+	for _, tt := range shadowed {
+		var location *Location
+		c.request("textDocument/definition", position("file:///c.mk", tt.line, tt.character), &location)
+		if location == nil || location.Range != tt.expected {
+			t.Errorf("%d:%d wrong definition. want=%+v, got=%+v", tt.line, tt.character, tt.expected, location)
+		}
+	}
+}
+
+func TestHover(t *testing.T) {
+	c := newClient(t)
+	c.request("initialize", map[string]any{}, nil)
+	c.open("file:///b.mk", source)
+
+	tests := []struct {
+		line, character int
+		contains        string
+	}{
+		{4, 0, "let add = fn(a, b)"},
+		{4, 0, "global binding"},
+		{2, 14, "parameter of `fn(y)`"},
+		{4, 4, "builtin len"},
+		{5, 21, "pattern binding"},
+		{6, 15, "var total = 0"},
+		{7, 48, "let greet = fn(name: string): string"},
+	}
+	for _, tt := range tests {
+		var hover *Hover
+		c.request("textDocument/hover", position("file:///b.mk", tt.line, tt.character), &hover)
+		if hover == nil || !strings.Contains(hover.Contents.Value, tt.contains) {
+			t.Errorf("%d:%d hover does not contain %q: %+v", tt.line, tt.character, tt.contains, hover)
+		}
+	}
+
+	var hover *Hover
+	c.request("textDocument/hover", position("file:///b.mk", 0, 3), &hover)
+	if hover != nil {
+		t.Errorf("expected no hover on keyword, got %+v", hover)
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	c := newClient(t)
+	c.request("initialize", map[string]any{}, nil)
+	c.open("file:///b.mk", source)
+
+	var items []CompletionItem
+	c.request("textDocument/completion", position("file:///b.mk", 4, 0), &items)
+
+	labels := make(map[string]bool)
+	for _, item := range items {
+		labels[item.Label] = true
+	}
+	for _, expected := range []string{"len", "first", "last", "rest", "push", "println", "add", "outer"} {
+		if !labels[expected] {
+			t.Errorf("completion missing %q", expected)
+		}
+	}
+}
+
+func TestUnknownMethod(t *testing.T) {
+	c := newClient(t)
+	c.send(map[string]any{"id": 1, "method": "textDocument/unknown", "params": map[string]any{}})
+	msg := c.receive()
+	if msg.Error == nil || msg.Error.Code != codeMethodNotFound {
+		t.Errorf("expected method not found error, got %+v", msg)
+	}
+}
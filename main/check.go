@@ -0,0 +1,51 @@
+package main
+
+import (
+	"Monkey/lexer"
+	"Monkey/parser"
+	"Monkey/types"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// runCheck 实现monkey check file ...，检查类型注解并推断未注解代码的类型
+// 发现类型错误时退出码为1，文件无法读取或解析时为2
+func runCheck(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: monkey check file ...")
+		return 2
+	}
+
+	status := 0
+	for _, filename := range flags.Args() {
+		src, err := os.ReadFile(filename)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 2
+			continue
+		}
+
+		p := parser.New(lexer.New(string(src)))
+		program := p.ParseProgram()
+		if errors := p.Errors(); len(errors) != 0 {
+			for _, msg := range errors {
+				fmt.Fprintf(os.Stderr, "%s:%s\n", filename, msg)
+			}
+			status = 2
+			continue
+		}
+
+		for _, d := range types.Check(program) {
+			fmt.Printf("%s:%s\n", filename, d)
+			if status == 0 {
+				status = 1
+			}
+		}
+	}
+	return status
+}
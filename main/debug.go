@@ -0,0 +1,45 @@
+package main
+
+import (
+	"Monkey/compiler"
+	"Monkey/debugger"
+	"Monkey/vm"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// runDebug 实现monkey debug file，在调试器中运行file。
+// 程序出错时退出码为1，文件无法读取、解析或编译时为2
+func runDebug(args []string) int {
+	flags := flag.NewFlagSet("debug", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: monkey debug file")
+		return 2
+	}
+
+	filename := flags.Arg(0)
+	bytecode, globals, err := compileFile(filename, compiler.OptimizeNone)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	machine := vm.New(bytecode)
+	machine.SetHook(debugger.New(os.Stdin, os.Stdout, bytecode, globals))
+	err = machine.Run(context.Background())
+	if errors.Is(err, debugger.ErrQuit) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", filename, err)
+		return 1
+	}
+	fmt.Println("program exited")
+	return 0
+}
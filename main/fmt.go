@@ -0,0 +1,70 @@
+package main
+
+import (
+	"Monkey/format"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// runFmt 实现monkey fmt [-l] [-w] [file ...]
+// 没有指定文件时从标准输入读取并输出到标准输出
+func runFmt(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	list := flags.Bool("l", false, "list files whose formatting differs from monkey fmt's; exit with status 1 if any")
+	write := flags.Bool("w", false, "write result to (source) file instead of stdout")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if flags.NArg() == 0 {
+		src, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		out, err := format.Source(src)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "<stdin>: %s\n", err)
+			return 2
+		}
+		os.Stdout.Write(out)
+		return 0
+	}
+
+	status := 0
+	for _, filename := range flags.Args() {
+		src, err := os.ReadFile(filename)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 2
+			continue
+		}
+		out, err := format.Source(src)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", filename, err)
+			status = 2
+			continue
+		}
+
+		changed := !bytes.Equal(src, out)
+		if *list && changed {
+			fmt.Println(filename)
+			if status == 0 {
+				status = 1
+			}
+		}
+		if *write && changed {
+			if err := os.WriteFile(filename, out, 0644); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				status = 2
+			}
+		}
+		if !*list && !*write {
+			os.Stdout.Write(out)
+		}
+	}
+	return status
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"Monkey/lsp"
+	"fmt"
+	"os"
+)
+
+// runLSP 实现monkey lsp，通过标准输入输出提供语言服务
+func runLSP(args []string) int {
+	if err := lsp.NewServer(os.Stdin, os.Stdout).Serve(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
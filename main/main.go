@@ -2,17 +2,46 @@ package main
 
 import (
 	"Monkey/repl"
+	"flag"
 	"fmt"
 	"os"
 	user2 "os/user"
 )
 
+// commands 子命令，参数为子命令之后的命令行参数，返回进程退出码
+var commands = map[string]func(args []string) int{
+	"fmt":   runFmt,
+	"vet":   runVet,
+	"check": runCheck,
+	"lsp":   runLSP,
+	"debug": runDebug,
+	"run":   runRun,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
+
+	engine := flag.String("engine", "vm", "engine used by the REPL: vm or eval")
+	flag.Parse()
+	replEngine := repl.EngineVM
+	switch *engine {
+	case "vm":
+	case "eval":
+		replEngine = repl.EngineEval
+	default:
+		fmt.Fprintf(os.Stderr, "unknown engine %q, want vm or eval\n", *engine)
+		os.Exit(2)
+	}
+
 	user, err := user2.Current()
 	if err != nil {
 		panic(err)
 	}
 	fmt.Printf("Hello %s! This is the Monkey programming language!\n", user.Username)
 	fmt.Printf("Feel free to type in commands\n")
-	repl.Start(os.Stdin, os.Stdout)
+	repl.StartEngine(os.Stdin, os.Stdout, replEngine)
 }
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"Monkey/compiler"
+	"Monkey/lexer"
+	"Monkey/object"
+	"Monkey/parser"
+	"Monkey/profile"
+	"Monkey/vm"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// runRun 实现monkey run [-O level] [-trace] [-profile file] file，用虚拟机运行file。
+// -O为编译器的优化级别，见compiler.SetOptimization；
+// -trace把执行的每条指令输出到标准错误；-profile把热点报告输出到标准错误，
+// 并把pprof格式的结果写到指定的文件。
+// 程序出错时退出码为1，文件无法读取、解析或编译时为2
+func runRun(args []string) int {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	level := flags.Int("O", compiler.OptimizeNone, "optimization `level`: 0 none, 1 fold constants, 2 also collapse jump chains, 3 also use superinstructions")
+	trace := flags.Bool("trace", false, "print every executed instruction to stderr")
+	profileFile := flags.String("profile", "", "write a pprof profile to `file` and print hot spots to stderr")
+	interval := flags.Duration("profile-interval", time.Millisecond, "sampling interval of -profile, 0 to only count instructions")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: monkey run [-O level] [-trace] [-profile file] file")
+		return 2
+	}
+
+	filename := flags.Arg(0)
+	bytecode, _, err := compileFile(filename, *level)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	machine := vm.New(bytecode)
+	var hooks []vm.Hook
+	if *trace {
+		hooks = append(hooks, &vm.Tracer{Out: os.Stderr})
+	}
+	var profiler *profile.Profiler
+	if *profileFile != "" {
+		profiler = profile.New(*interval)
+		hooks = append(hooks, profiler)
+	}
+	if len(hooks) > 0 {
+		machine.SetHook(vm.Hooks(hooks...))
+	}
+
+	status := 0
+	if profiler != nil {
+		profiler.Start()
+	}
+	if err := machine.Run(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", filename, err)
+		status = 1
+	}
+	if profiler != nil {
+		profiler.Stop()
+		if err := writeProfile(profiler, *profileFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	return status
+}
+
+func writeProfile(profiler *profile.Profiler, filename string) error {
+	if err := profiler.WriteReport(os.Stderr, 10); err != nil {
+		return err
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := profiler.WritePprof(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// compileFile 以优化级别level编译filename，返回字节码和全局变量名（按索引）。
+// 字节码中记录了文件的绝对路径，import相对于文件所在的目录
+func compileFile(filename string, level int) (*compiler.Bytecode, []string, error) {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := parser.New(lexer.New(string(src)))
+	program := p.ParseProgram()
+	if errors := p.Errors(); len(errors) != 0 {
+		return nil, nil, fmt.Errorf("%s:%s", filename, strings.Join(errors, "\n"+filename+":"))
+	}
+
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+	comp := compiler.NewWithState(symbolTable, []object.Object{})
+	comp.SetFile(path)
+	comp.SetOptimization(level)
+	comp.SetDir(filepath.Dir(path))
+	if err := comp.Compile(program); err != nil {
+		return nil, nil, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	bytecode := comp.Bytecode()
+	if err := vm.Verify(bytecode); err != nil {
+		return nil, nil, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	globals := make([]string, symbolTable.NumDefinitions())
+	for _, symbol := range symbolTable.Symbols() {
+		// 缓存模块的隐藏变量不是合法的标识符，不列出
+		if !strings.Contains(symbol.Name, " ") {
+			globals[symbol.Index] = symbol.Name
+		}
+	}
+	return bytecode, globals, nil
+}
@@ -0,0 +1,296 @@
+package monkey
+
+import (
+	"Monkey/object"
+	"Monkey/vm"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// ToObject 把Go值转换为Monkey对象：
+//
+//   - nil转换为null，bool转换为布尔值，各种整数类型转换为整数，string转换为字符串
+//   - 切片和数组转换为数组，map转换为哈希
+//   - 函数转换为内置函数，调用时参数按FromObject转换为形参类型；
+//     最后一个返回值为error且不为nil时，调用结果为错误对象
+//   - object.Object原样返回
+//
+// 其它类型（如浮点数、结构体）返回错误
+func ToObject(value any) (object.Object, error) {
+	switch value := value.(type) {
+	case nil:
+		return vm.Null, nil
+	case object.Object:
+		return value, nil
+	case bool:
+		if value {
+			return vm.True, nil
+		}
+		return vm.False, nil
+	case string:
+		return &object.String{Value: value}, nil
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &object.Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("integer %d overflows INTEGER", v.Uint())
+		}
+		return &object.Integer{Value: int64(v.Uint())}, nil
+	case reflect.Bool:
+		return ToObject(v.Bool())
+	case reflect.String:
+		return ToObject(v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return vm.Null, nil
+		}
+		elements := make([]object.Object, v.Len())
+		for i := range elements {
+			element, err := ToObject(v.Index(i).Interface())
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %w", i, err)
+			}
+			elements[i] = element
+		}
+		return &object.Array{Elements: elements}, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return vm.Null, nil
+		}
+		pairs := make(map[object.HashKey]object.HashPair, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := ToObject(iter.Key().Interface())
+			if err != nil {
+				return nil, fmt.Errorf("key %v: %w", iter.Key(), err)
+			}
+			hashKey, ok := object.HashKeyOf(key)
+			if !ok {
+				return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+			}
+			value, err := ToObject(iter.Value().Interface())
+			if err != nil {
+				return nil, fmt.Errorf("value for key %v: %w", iter.Key(), err)
+			}
+			pairs[hashKey] = object.HashPair{Key: key, Value: value}
+		}
+		return &object.Hash{Pairs: pairs}, nil
+	case reflect.Func:
+		if v.IsNil() {
+			return vm.Null, nil
+		}
+		return wrapFunc(v)
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return vm.Null, nil
+		}
+		return ToObject(v.Elem().Interface())
+	default:
+		return nil, fmt.Errorf("unsupported Go type %T", value)
+	}
+}
+
+// FromObject 把Monkey对象转换为Go值：
+// 整数转换为int64，布尔值转换为bool，字符串转换为string，null转换为nil，
+// 数组转换为[]any，哈希转换为map[any]any，错误对象转换为error。
+// 哈希中不能作为Go map键的键（数组和哈希）以其Inspect结果作为键。
+// 函数和其它对象原样返回
+func FromObject(obj object.Object) any {
+	switch obj := obj.(type) {
+	case nil, *object.Null:
+		return nil
+	case *object.Integer:
+		return obj.Value
+	case *object.Boolean:
+		return obj.Value
+	case *object.String:
+		return obj.Value
+	case *object.Array:
+		elements := make([]any, len(obj.Elements))
+		for i, element := range obj.Elements {
+			elements[i] = FromObject(element)
+		}
+		return elements
+	case *object.Hash:
+		m := make(map[any]any, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			var key any
+			switch pair.Key.(type) {
+			case *object.Array, *object.Hash:
+				key = pair.Key.Inspect()
+			default:
+				key = FromObject(pair.Key)
+			}
+			m[key] = FromObject(pair.Value)
+		}
+		return m
+	case *object.Error:
+		return fmt.Errorf("%s", obj.Message)
+	default:
+		return obj
+	}
+}
+
+// wrapFunc 把Go函数包装为内置函数
+func wrapFunc(fn reflect.Value) (object.Object, error) {
+	t := fn.Type()
+	numOut := t.NumOut()
+	returnsError := numOut > 0 && t.Out(numOut-1) == errorType
+	if returnsError {
+		numOut--
+	}
+	if numOut > 1 {
+		return nil, fmt.Errorf("unsupported Go func %s: too many results", t)
+	}
+
+	builtin := func(_ *object.Host, args ...object.Object) object.Object {
+		in, err := funcArgs(t, args)
+		if err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+
+		out := fn.Call(in)
+		if returnsError {
+			if err, _ := out[len(out)-1].Interface().(error); err != nil {
+				return &object.Error{Message: err.Error()}
+			}
+		}
+		if numOut == 0 {
+			return nil
+		}
+
+		ret, err := ToObject(out[0].Interface())
+		if err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+		return ret
+	}
+	return &object.Builtin{Fn: builtin}, nil
+}
+
+// funcArgs 检查实参个数，并把实参转换为Go函数的形参类型
+func funcArgs(t reflect.Type, args []object.Object) ([]reflect.Value, error) {
+	numIn := t.NumIn()
+	if t.IsVariadic() {
+		if len(args) < numIn-1 {
+			return nil, fmt.Errorf("wrong number of arguments. got=%d, want at least %d", len(args), numIn-1)
+		}
+	} else if len(args) != numIn {
+		return nil, fmt.Errorf("wrong number of arguments. got=%d, want=%d", len(args), numIn)
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var paramType reflect.Type
+		if t.IsVariadic() && i >= numIn-1 {
+			paramType = t.In(numIn - 1).Elem()
+		} else {
+			paramType = t.In(i)
+		}
+
+		v, err := convertTo(FromObject(arg), paramType)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i, err)
+		}
+		in[i] = v
+	}
+	return in, nil
+}
+
+// convertTo 把FromObject得到的Go值转换为类型t
+func convertTo(value any, t reflect.Type) (reflect.Value, error) {
+	if value == nil {
+		switch t.Kind() {
+		case reflect.Interface, reflect.Pointer, reflect.Slice, reflect.Map, reflect.Func:
+			return reflect.Zero(t), nil
+		default:
+			return reflect.Value{}, fmt.Errorf("cannot use null as %s", t)
+		}
+	}
+
+	v := reflect.ValueOf(value)
+	if v.Type().AssignableTo(t) {
+		return v, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Kind() != reflect.Int64 {
+			break
+		}
+		// 负数转换为uint64等与int64同宽的类型后再转回来不变，需要单独检查
+		if t.Kind() >= reflect.Uint && t.Kind() <= reflect.Uintptr && v.Int() < 0 {
+			return reflect.Value{}, fmt.Errorf("integer %d overflows %s", v.Int(), t)
+		}
+		converted := v.Convert(t)
+		if converted.Convert(v.Type()).Int() != v.Int() {
+			return reflect.Value{}, fmt.Errorf("integer %d overflows %s", v.Int(), t)
+		}
+		return converted, nil
+	case reflect.String, reflect.Bool:
+		if v.Kind() == t.Kind() {
+			return v.Convert(t), nil
+		}
+	case reflect.Slice:
+		elements, ok := value.([]any)
+		if !ok {
+			break
+		}
+		s := reflect.MakeSlice(t, len(elements), len(elements))
+		for i, element := range elements {
+			ev, err := convertTo(element, t.Elem())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("element %d: %w", i, err)
+			}
+			s.Index(i).Set(ev)
+		}
+		return s, nil
+	case reflect.Map:
+		pairs, ok := value.(map[any]any)
+		if !ok {
+			break
+		}
+		m := reflect.MakeMapWithSize(t, len(pairs))
+		for k, val := range pairs {
+			kv, err := convertTo(k, t.Key())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("key %v: %w", k, err)
+			}
+			vv, err := convertTo(val, t.Elem())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("value for key %v: %w", k, err)
+			}
+			m.SetMapIndex(kv, vv)
+		}
+		return m, nil
+	}
+	return reflect.Value{}, fmt.Errorf("cannot use %s as %s", typeName(value), t)
+}
+
+// typeName 用Monkey的类型名描述FromObject得到的值
+func typeName(value any) string {
+	switch value := value.(type) {
+	case int64:
+		return object.INTEGER_OBJ
+	case bool:
+		return object.BOOLEAN_OBJ
+	case string:
+		return object.STRING_OBJ
+	case []any:
+		return object.ARRAY_OBJ
+	case map[any]any:
+		return object.HASH_OBJ
+	case object.Object:
+		return string(value.Type())
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
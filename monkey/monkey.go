@@ -0,0 +1,209 @@
+// Package monkey 提供在Go程序中嵌入Monkey解释器的接口。
+//
+// Interpreter负责串联词法分析、解析、编译和虚拟机执行，并在多次调用之间保留全局变量：
+//
+//	interp := monkey.New()
+//	interp.Set("limit", 10)
+//	interp.Eval(`let double = fn(x) { x * 2 }`)
+//	result, err := interp.Call("double", 21) // int64(42)
+//
+// 新建的Interpreter不授予内置函数任何能力，见SetHost。
+// Go值与Monkey对象之间的转换规则见ToObject和FromObject
+package monkey
+
+import (
+	"Monkey/ast"
+	"Monkey/compiler"
+	"Monkey/lexer"
+	"Monkey/object"
+	"Monkey/parser"
+	"Monkey/vm"
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Interpreter 保存编译状态和全局变量，多次Eval之间共享。
+// Interpreter不能被多个goroutine同时使用
+type Interpreter struct {
+	symbolTable *compiler.SymbolTable
+	constants   []object.Object
+	globals     []object.Object
+	limits      object.Limits
+	host        *object.Host
+}
+
+func New() *Interpreter {
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+
+	return &Interpreter{
+		symbolTable: symbolTable,
+		constants:   []object.Object{},
+		globals:     []object.Object{},
+		host:        &object.Host{},
+	}
+}
+
+// SetHost 设置脚本的宿主。新建的Interpreter不授予任何能力并丢弃输出，
+// 需要输出或访问文件等资源时由宿主显式授权
+func (i *Interpreter) SetHost(host *object.Host) {
+	i.host = host
+}
+
+// SetLimits 设置之后每次Eval和Call的资源上限，超出时返回*object.LimitError；
+// 栈超出MaxStackSize时返回stack overflow错误
+func (i *Interpreter) SetLimits(limits object.Limits) {
+	i.limits = limits
+}
+
+// Eval 执行src。程序以表达式语句结尾时返回其值（已转换为Go值），否则返回nil。
+// 语法错误、编译错误、运行时错误以及求值得到的错误对象都作为error返回
+func (i *Interpreter) Eval(src string) (any, error) {
+	return i.EvalContext(context.Background(), src)
+}
+
+// EvalContext 与Eval相同，ctx结束时中止执行
+func (i *Interpreter) EvalContext(ctx context.Context, src string) (value any, err error) {
+	defer recoverPanic(&err)
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if errors := p.Errors(); len(errors) != 0 {
+		return nil, fmt.Errorf("parse error:\n\t%s", strings.Join(errors, "\n\t"))
+	}
+
+	comp := compiler.NewWithState(i.symbolTable, i.constants)
+	comp.SetHost(i.host)
+	err = comp.Compile(program)
+	if err != nil {
+		return nil, fmt.Errorf("compile error: %w", err)
+	}
+	bytecode := comp.Bytecode()
+	i.constants = bytecode.Constants
+
+	i.globals = vm.GrowGlobals(i.globals, bytecode.NumGlobals)
+	machine := vm.NewWithGlobalsStore(bytecode, i.globals)
+	defer machine.Release()
+	machine.SetHost(i.host)
+	machine.SetLimits(i.limits)
+	err = machine.Run(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("runtime error: %w", err)
+	}
+
+	if !endsWithExpression(program) {
+		return nil, nil
+	}
+	return result(machine.LastPoppedStackElem())
+}
+
+// Set 把value转换为Monkey对象后绑定到全局变量name，已存在的同名变量会被覆盖
+func (i *Interpreter) Set(name string, value any) error {
+	obj, err := ToObject(value)
+	if err != nil {
+		return fmt.Errorf("set %s: %w", name, err)
+	}
+
+	symbol, ok := i.symbolTable.Resolve(name)
+	if !ok || symbol.Scope != compiler.GlobalScope {
+		symbol = i.symbolTable.Define(name)
+	}
+	i.globals = vm.GrowGlobals(i.globals, i.symbolTable.NumDefinitions())
+	i.globals[symbol.Index] = obj
+	return nil
+}
+
+// Get 返回全局变量name的值（已转换为Go值）
+func (i *Interpreter) Get(name string) (any, error) {
+	obj, err := i.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return FromObject(obj), nil
+}
+
+// Call 以args调用名为fnName的全局函数或内置函数，参数按ToObject转换，结果按FromObject转换
+func (i *Interpreter) Call(fnName string, args ...any) (any, error) {
+	return i.CallContext(context.Background(), fnName, args...)
+}
+
+// CallContext 与Call相同，ctx结束时中止执行
+func (i *Interpreter) CallContext(ctx context.Context, fnName string, args ...any) (value any, err error) {
+	defer recoverPanic(&err)
+	fn, err := i.lookup(fnName)
+	if err != nil {
+		return nil, err
+	}
+	switch fn.(type) {
+	case *object.Closure, *object.Builtin:
+	default:
+		return nil, fmt.Errorf("%s is not a function: %s", fnName, fn.Type())
+	}
+
+	objects := make([]object.Object, len(args))
+	for n, arg := range args {
+		obj, err := ToObject(arg)
+		if err != nil {
+			return nil, fmt.Errorf("call %s: argument %d: %w", fnName, n, err)
+		}
+		objects[n] = obj
+	}
+
+	machine, err := vm.NewCall(i.constants, i.globals, fn, objects...)
+	if err != nil {
+		return nil, fmt.Errorf("call %s: %w", fnName, err)
+	}
+	defer machine.Release()
+	machine.SetHost(i.host)
+	machine.SetLimits(i.limits)
+	err = machine.Run(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("runtime error: %w", err)
+	}
+	return result(machine.StackTop())
+}
+
+// recoverPanic 把执行中的panic转换为错误，避免脚本或Go函数中的缺陷使宿主程序崩溃
+func recoverPanic(err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("internal error: %v", r)
+	}
+}
+
+// lookup 按名字查找全局变量或内置函数
+func (i *Interpreter) lookup(name string) (object.Object, error) {
+	symbol, ok := i.symbolTable.Resolve(name)
+	if !ok {
+		return nil, fmt.Errorf("undefined variable: %s", name)
+	}
+	switch symbol.Scope {
+	case compiler.GlobalScope:
+		// 编译失败的程序中定义的变量没有分配存储
+		if symbol.Index < len(i.globals) && i.globals[symbol.Index] != nil {
+			return i.globals[symbol.Index], nil
+		}
+		return vm.Null, nil
+	case compiler.BuiltinScope:
+		return object.Builtins[symbol.Index], nil
+	default:
+		return nil, fmt.Errorf("undefined variable: %s", name)
+	}
+}
+
+// result 把执行结果转换为Go值，错误对象转换为error
+func result(obj object.Object) (any, error) {
+	if errObj, ok := obj.(*object.Error); ok {
+		return nil, fmt.Errorf("runtime error: %s", errObj.Message)
+	}
+	return FromObject(obj), nil
+}
+
+func endsWithExpression(program *ast.Program) bool {
+	if len(program.Statements) == 0 {
+		return false
+	}
+	_, ok := program.Statements[len(program.Statements)-1].(*ast.ExpressionStatement)
+	return ok
+}
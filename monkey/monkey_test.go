@@ -0,0 +1,338 @@
+package monkey
+
+import (
+	"Monkey/object"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"1 + 2", int64(3)},
+		{`"monkey"`, "monkey"},
+		{"1 > 2", false},
+		{"let a = 1;", nil},
+		{"if (false) { 1 }", nil},
+		{"[1, [2]]", []any{int64(1), []any{int64(2)}}},
+		{`{"a": 1, true: "t"}`, map[any]any{"a": int64(1), true: "t"}},
+		{"{[1]: 2}", map[any]any{"[1]": int64(2)}},
+		{"let f = fn(x) { x * 2 }; f(21)", int64(42)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := New().Eval(tt.input)
+			if err != nil {
+				t.Fatalf("Eval error: %s", err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Fatalf("wrong result. want=%#v, got=%#v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestEvalKeepsState(t *testing.T) {
+	interp := New()
+	if _, err := interp.Eval("let x = 40;"); err != nil {
+		t.Fatalf("Eval error: %s", err)
+	}
+	if _, err := interp.Eval("let add = fn(a) { a + x };"); err != nil {
+		t.Fatalf("Eval error: %s", err)
+	}
+	got, err := interp.Eval("add(2)")
+	if err != nil {
+		t.Fatalf("Eval error: %s", err)
+	}
+	if got != int64(42) {
+		t.Fatalf("wrong result. want=42, got=%#v", got)
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let = 1", "parse error"},
+		{"y", "compile error: undefined variable: y"},
+		{"1 + true", "runtime error: unsupport types for binary operation: INTEGER BOOLEAN"},
+		{"len(1)", "runtime error: argument to `len` not supported, got INTEGER"},
+		{"1 / 0", "runtime error: division by zero"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := New().Eval(tt.input)
+			if err == nil || !strings.HasPrefix(err.Error(), tt.expected) {
+				t.Fatalf("wrong error. want prefix %q, got=%v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestSetGet(t *testing.T) {
+	interp := New()
+	values := map[string]any{
+		"n":     7,
+		"s":     "str",
+		"b":     true,
+		"null":  nil,
+		"list":  []int{1, 2},
+		"table": map[string]int{"a": 1},
+	}
+	expected := map[string]any{
+		"n":     int64(7),
+		"s":     "str",
+		"b":     true,
+		"null":  nil,
+		"list":  []any{int64(1), int64(2)},
+		"table": map[any]any{"a": int64(1)},
+	}
+
+	for name, value := range values {
+		if err := interp.Set(name, value); err != nil {
+			t.Fatalf("Set(%s) error: %s", name, err)
+		}
+	}
+	for name, want := range expected {
+		got, err := interp.Get(name)
+		if err != nil {
+			t.Fatalf("Get(%s) error: %s", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("Get(%s): want=%#v, got=%#v", name, want, got)
+		}
+	}
+
+	got, err := interp.Eval(`n * list[1] + table["a"]`)
+	if err != nil {
+		t.Fatalf("Eval error: %s", err)
+	}
+	if got != int64(15) {
+		t.Fatalf("wrong result. want=15, got=%#v", got)
+	}
+
+	if err := interp.Set("n", 8); err != nil {
+		t.Fatalf("Set error: %s", err)
+	}
+	if got, _ := interp.Eval("n"); got != int64(8) {
+		t.Fatalf("Set did not overwrite n: got=%#v", got)
+	}
+
+	if _, err := interp.Get("missing"); err == nil {
+		t.Fatalf("expected error for undefined variable")
+	}
+	if err := interp.Set("f", 1.5); err == nil {
+		t.Fatalf("expected error for unsupported type")
+	}
+}
+
+func TestCall(t *testing.T) {
+	interp := New()
+	_, err := interp.Eval(`
+let add = fn(a, b) { a + b };
+let newAdder = fn(a) { fn(b) { a + b } };
+let fib = fn(n) { if (n < 2) { return n } fib(n - 1) + fib(n - 2) };
+let nothing = fn() { };
+`)
+	if err != nil {
+		t.Fatalf("Eval error: %s", err)
+	}
+
+	tests := []struct {
+		fn       string
+		args     []any
+		expected any
+	}{
+		{"add", []any{1, 2}, int64(3)},
+		{"fib", []any{10}, int64(55)},
+		{"nothing", nil, nil},
+		{"len", []any{[]string{"a", "b"}}, int64(2)},
+	}
+
+	for _, tt := range tests {
+		got, err := interp.Call(tt.fn, tt.args...)
+		if err != nil {
+			t.Fatalf("Call(%s) error: %s", tt.fn, err)
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Fatalf("Call(%s): want=%#v, got=%#v", tt.fn, tt.expected, got)
+		}
+	}
+
+	adder, err := interp.Call("newAdder", 10)
+	if err != nil {
+		t.Fatalf("Call error: %s", err)
+	}
+	if err := interp.Set("addTen", adder); err != nil {
+		t.Fatalf("Set error: %s", err)
+	}
+	if got, err := interp.Call("addTen", 5); err != nil || got != int64(15) {
+		t.Fatalf("Call(addTen): want=15, got=%#v (%v)", got, err)
+	}
+
+	if _, err := interp.Call("add", 1); err == nil {
+		t.Fatalf("expected error for wrong number of arguments")
+	}
+	if _, err := interp.Call("fib", 1.5); err == nil {
+		t.Fatalf("expected error for unsupported argument")
+	}
+	if err := interp.Set("x", 1); err != nil {
+		t.Fatalf("Set error: %s", err)
+	}
+	if _, err := interp.Call("x"); err == nil {
+		t.Fatalf("expected error when calling a non-function")
+	}
+}
+
+func TestGoFunctions(t *testing.T) {
+	interp := New()
+	funcs := map[string]any{
+		"sum": func(xs []int) int {
+			total := 0
+			for _, x := range xs {
+				total += x
+			}
+			return total
+		},
+		"join": func(sep string, parts ...string) string {
+			return strings.Join(parts, sep)
+		},
+		"keys": func(m map[string]int) int { return len(m) },
+		"fail": func() (int, error) { return 0, errors.New("boom") },
+		"log":  func(any) {},
+		"byte": func(b uint8) uint8 { return b },
+		"size": func(n uint64) uint64 { return n },
+		"oops": func() int { panic("oops") },
+	}
+	for name, fn := range funcs {
+		if err := interp.Set(name, fn); err != nil {
+			t.Fatalf("Set(%s) error: %s", name, err)
+		}
+	}
+
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"sum([1, 2, 3])", int64(6)},
+		{`join("-", "a", "b")`, "a-b"},
+		{`keys({"a": 1, "b": 2})`, int64(2)},
+		{"log(1)", nil},
+	}
+	for _, tt := range tests {
+		got, err := interp.Eval(tt.input)
+		if err != nil {
+			t.Fatalf("Eval(%s) error: %s", tt.input, err)
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Fatalf("Eval(%s): want=%#v, got=%#v", tt.input, tt.expected, got)
+		}
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{"fail()", "runtime error: boom"},
+		{`sum(["a"])`, "runtime error: argument 0: element 0: cannot use STRING as int"},
+		{"sum()", "runtime error: wrong number of arguments. got=0, want=1"},
+		{"byte(256)", "runtime error: argument 0: integer 256 overflows uint8"},
+		{"size(-1)", "runtime error: argument 0: integer -1 overflows uint64"},
+		{"oops()", "internal error: oops"},
+	}
+	for _, tt := range errorTests {
+		_, err := interp.Eval(tt.input)
+		if err == nil || err.Error() != tt.expected {
+			t.Fatalf("Eval(%s): want error %q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+
+	if got, err := interp.Call("sum", []int{4, 5}); err != nil || got != int64(9) {
+		t.Fatalf("Call(sum): want=9, got=%#v (%v)", got, err)
+	}
+	if _, err := interp.Call("oops"); err == nil || err.Error() != "internal error: oops" {
+		t.Fatalf("Call(oops): want internal error, got=%v", err)
+	}
+	if got, err := interp.Eval("sum([1])"); err != nil || got != int64(1) {
+		t.Fatalf("Eval after panic: want=1, got=%#v (%v)", got, err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	interp := New()
+	interp.SetLimits(object.Limits{MaxCallDepth: 100})
+	if _, err := interp.Eval("let loop = fn(x) { loop(x + 1) };"); err != nil {
+		t.Fatalf("Eval error: %s", err)
+	}
+
+	var limitErr *object.LimitError
+	if _, err := interp.Eval("loop(0)"); !errors.As(err, &limitErr) {
+		t.Fatalf("Eval: expected LimitError, got=%v", err)
+	}
+	if _, err := interp.Call("loop", 0); !errors.As(err, &limitErr) {
+		t.Fatalf("Call: expected LimitError, got=%v", err)
+	}
+
+	// 不限制调用深度时，递归受栈的大小限制
+	interp.SetLimits(object.Limits{MaxStackSize: 1000})
+	if _, err := interp.Call("loop", 0); err == nil || err.Error() != "runtime error: stack overflow" {
+		t.Fatalf("Call: expected stack overflow, got=%v", err)
+	}
+
+	interp.SetLimits(object.Limits{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := interp.CallContext(ctx, "loop", 0); !errors.Is(err, context.Canceled) {
+		t.Fatalf("CallContext: expected context.Canceled, got=%v", err)
+	}
+}
+
+func TestHost(t *testing.T) {
+	interp := New()
+	_, err := interp.Eval(`println("hidden")`)
+	var permErr *object.PermissionError
+	if !errors.As(err, &permErr) {
+		t.Fatalf("expected PermissionError, got=%v", err)
+	}
+	if permErr.Builtin != "println" || permErr.Capability != object.CapabilityIO {
+		t.Fatalf("wrong PermissionError: %+v", permErr)
+	}
+
+	var out strings.Builder
+	interp.SetHost(&object.Host{Stdout: &out, Capabilities: []object.Capability{object.CapabilityIO}})
+	if _, err := interp.Eval(`println("hello", 1)`); err != nil {
+		t.Fatalf("Eval error: %s", err)
+	}
+	if out.String() != "hello\n1\n" {
+		t.Fatalf("wrong output. got=%q", out.String())
+	}
+
+	if _, err := interp.Call("getenv", "HOME"); !errors.As(err, &permErr) {
+		t.Fatalf("expected PermissionError for getenv, got=%v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "lib.mk")
+	if err := os.WriteFile(path, []byte("let answer = 42;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := fmt.Sprintf("import(%q)[\"answer\"]", path)
+	if _, err := New().Eval(src); !errors.As(err, &permErr) || permErr.Capability != object.CapabilityFS {
+		t.Fatalf("expected PermissionError for import, got=%v", err)
+	}
+	interp = New()
+	interp.SetHost(&object.Host{Capabilities: []object.Capability{object.CapabilityFS}})
+	if got, err := interp.Eval(src); err != nil || got != int64(42) {
+		t.Fatalf("Eval(import): want=42, got=%#v (%v)", got, err)
+	}
+}
@@ -0,0 +1,235 @@
+package object
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// Builtins 内置函数，求值器和虚拟机共用；虚拟机通过下标访问，只能在末尾追加。
+// 内置函数返回nil表示null
+var Builtins = []*Builtin{
+	{
+		Name: "len",
+		Fn: func(_ *Host, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			switch ret := args[0].(type) {
+			case *String:
+				return &Integer{Value: int64(len(ret.Value))}
+			case *Array:
+				return &Integer{Value: int64(len(ret.Elements))}
+			default:
+				return newError("argument to `len` not supported, got %s", ret.Type())
+			}
+		},
+	},
+	{
+		Name: "first",
+		Fn: func(_ *Host, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got =%d, want =1", len(args))
+			}
+			if args[0].Type() != ARRAY_OBJ {
+				return newError("argument to `first` must be Array, got %s", args[0].Type())
+			}
+
+			arr := args[0].(*Array)
+			if len(arr.Elements) > 0 {
+				return arr.Elements[0]
+			}
+			return nil
+		},
+	},
+	{
+		Name: "last",
+		Fn: func(_ *Host, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got =%d, want =1", len(args))
+			}
+			if args[0].Type() != ARRAY_OBJ {
+				return newError("argument to `last` must be Array, got %s", args[0].Type())
+			}
+
+			arr := args[0].(*Array)
+			if len(arr.Elements) > 0 {
+				return arr.Elements[len(arr.Elements)-1]
+			}
+			return nil
+		},
+	},
+	{
+		Name: "rest",
+		Fn: func(_ *Host, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got =%d, want =1", len(args))
+			}
+			if args[0].Type() != ARRAY_OBJ {
+				return newError("argument to `rest` must be Array, got %s", args[0].Type())
+			}
+			arr := args[0].(*Array)
+
+			length := len(arr.Elements)
+			if length > 0 {
+				newElement := make([]Object, length-1)
+				copy(newElement, arr.Elements[1:length])
+				return &Array{Elements: newElement}
+			}
+			return nil
+		},
+	},
+	{
+		Name: "push",
+		Fn: func(_ *Host, args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got =%d, want =2", len(args))
+			}
+			if args[0].Type() != ARRAY_OBJ {
+				return newError("argument to `push` must be Array, got %s", args[0].Type())
+			}
+			arr := args[0].(*Array)
+
+			length := len(arr.Elements)
+			newElement := make([]Object, length, length+1)
+			copy(newElement, arr.Elements)
+			newElement = append(newElement, args[1])
+			return &Array{Elements: newElement}
+		},
+	},
+	{
+		Name:       "println",
+		Capability: CapabilityIO,
+		Fn: func(host *Host, args ...Object) Object {
+			out := host.Output()
+			for _, arg := range args {
+				fmt.Fprintln(out, arg.Inspect())
+			}
+			return nil
+		},
+	},
+	{
+		Name:       "readFile",
+		Capability: CapabilityFS,
+		Fn: func(_ *Host, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			path, ok := args[0].(*String)
+			if !ok {
+				return newError("argument to `readFile` must be STRING, got %s", args[0].Type())
+			}
+
+			content, err := os.ReadFile(path.Value)
+			if err != nil {
+				return newError("readFile: %s", err)
+			}
+			return &String{Value: string(content)}
+		},
+	},
+	{
+		Name:       "writeFile",
+		Capability: CapabilityFS,
+		Fn: func(_ *Host, args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			path, ok := args[0].(*String)
+			if !ok {
+				return newError("first argument to `writeFile` must be STRING, got %s", args[0].Type())
+			}
+			content, ok := args[1].(*String)
+			if !ok {
+				return newError("second argument to `writeFile` must be STRING, got %s", args[1].Type())
+			}
+
+			err := os.WriteFile(path.Value, []byte(content.Value), 0644)
+			if err != nil {
+				return newError("writeFile: %s", err)
+			}
+			return nil
+		},
+	},
+	{
+		Name:       "now",
+		Capability: CapabilityTime,
+		Fn: func(_ *Host, args ...Object) Object {
+			if len(args) != 0 {
+				return newError("wrong number of arguments. got=%d, want=0", len(args))
+			}
+			// 以毫秒为单位的Unix时间
+			return &Integer{Value: time.Now().UnixMilli()}
+		},
+	},
+	{
+		Name:       "getenv",
+		Capability: CapabilityEnv,
+		Fn: func(_ *Host, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			name, ok := args[0].(*String)
+			if !ok {
+				return newError("argument to `getenv` must be STRING, got %s", args[0].Type())
+			}
+
+			value, ok := os.LookupEnv(name.Value)
+			if !ok {
+				return nil
+			}
+			return &String{Value: value}
+		},
+	},
+	{
+		Name: "throw",
+		Fn: func(_ *Host, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			return Throw(args[0])
+		},
+	},
+	{
+		Name: "freeze",
+		Fn: func(_ *Host, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			// 只冻结数组或哈希本身，其中的元素不受影响
+			switch arg := args[0].(type) {
+			case *Array:
+				arg.Frozen = true
+			case *Hash:
+				arg.Frozen = true
+			default:
+				return newError("argument to `freeze` must be ARRAY or HASH, got %s", arg.Type())
+			}
+			return args[0]
+		},
+	},
+}
+
+// GetBuiltinByName 按名字查找内置函数
+func GetBuiltinByName(name string) *Builtin {
+	for _, builtin := range Builtins {
+		if builtin.Name == name {
+			return builtin
+		}
+	}
+	return nil
+}
+
+// BuiltinNames 返回所有内置函数的名字，按字母顺序排列
+func BuiltinNames() []string {
+	names := make([]string, 0, len(Builtins))
+	for _, builtin := range Builtins {
+		names = append(names, builtin.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newError(format string, a ...any) *Error {
+	return &Error{Message: fmt.Sprintf(format, a...)}
+}
@@ -1,13 +1,27 @@
 package object
 
+import "fmt"
+
 func NewEnvironment() *Environment {
 	s := make(map[string]Object)
 	return &Environment{store: s, outer: nil}
 }
 
 type Environment struct {
-	store map[string]Object
-	outer *Environment
+	store   map[string]Object
+	mutable map[string]bool // var定义的名字，只有这些名字可以重新赋值
+	outer   *Environment
+
+	// 以下字段只保存在最外层环境中
+	runtime *runtime
+	dir     string // 导入路径相对的目录
+}
+
+// runtime 一次执行的状态，由执行中所有模块的最外层环境共享
+type runtime struct {
+	budget  *Budget
+	host    *Host
+	modules *ModuleCache
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
@@ -18,13 +32,111 @@ func (e *Environment) Get(name string) (Object, bool) {
 	return obj, ok
 }
 
+// Set 在当前作用域定义不可重新赋值的名字，覆盖之前的同名定义
 func (e *Environment) Set(name string, obj Object) Object {
 	e.store[name] = obj
+	delete(e.mutable, name)
+	return obj
+}
+
+// SetMutable 在当前作用域定义可以重新赋值的名字，用于var
+func (e *Environment) SetMutable(name string, obj Object) Object {
+	e.store[name] = obj
+	if e.mutable == nil {
+		e.mutable = make(map[string]bool)
+	}
+	e.mutable[name] = true
 	return obj
 }
 
+// Assign 修改定义name的作用域中的值；name没有定义或不是var定义的时返回错误
+func (e *Environment) Assign(name string, obj Object) error {
+	for env := e; env != nil; env = env.outer {
+		if _, ok := env.store[name]; !ok {
+			continue
+		}
+		if !env.mutable[name] {
+			return fmt.Errorf("cannot assign to immutable variable: %s", name)
+		}
+		env.store[name] = obj
+		return nil
+	}
+	return fmt.Errorf("identifier not found: %s", name)
+}
+
+// Bindings 返回当前作用域中定义的绑定，不包括外层作用域
+func (e *Environment) Bindings() map[string]Object {
+	return e.store
+}
+
 func NewEnclosedEnvironment(outer *Environment) *Environment {
 	env := NewEnvironment()
 	env.outer = outer
 	return env
 }
+
+// NewModuleEnvironment 创建模块的最外层环境，与导入方共享宿主、资源记录和模块缓存
+func NewModuleEnvironment(importer *Environment, dir string) *Environment {
+	env := NewEnvironment()
+	env.runtime = importer.root().state()
+	env.dir = dir
+	return env
+}
+
+func (e *Environment) root() *Environment {
+	for e.outer != nil {
+		e = e.outer
+	}
+	return e
+}
+
+func (e *Environment) state() *runtime {
+	if e.runtime == nil {
+		e.runtime = &runtime{}
+	}
+	return e.runtime
+}
+
+// Budget 返回当前执行的资源记录，没有限制时返回nil。
+// 闭包的环境可能比当前执行更早创建，因此总是从最外层环境读取
+func (e *Environment) Budget() *Budget {
+	return e.root().state().budget
+}
+
+// SetBudget 设置最外层环境的资源记录
+func (e *Environment) SetBudget(b *Budget) {
+	e.root().state().budget = b
+}
+
+// Host 返回执行所在的宿主，未设置时为DefaultHost
+func (e *Environment) Host() *Host {
+	host := e.root().state().host
+	if host == nil {
+		return DefaultHost
+	}
+	return host
+}
+
+// SetHost 设置最外层环境的宿主，限制脚本可以调用的内置函数
+func (e *Environment) SetHost(h *Host) {
+	e.root().state().host = h
+}
+
+// Modules 返回已加载模块的缓存
+func (e *Environment) Modules() *ModuleCache {
+	state := e.root().state()
+	if state.modules == nil {
+		state.modules = NewModuleCache()
+	}
+	return state.modules
+}
+
+// Dir 返回导入路径相对的目录，为空表示当前工作目录
+func (e *Environment) Dir() string {
+	return e.root().dir
+}
+
+// SetDir 设置导入路径相对的目录，通常为正在执行的文件所在的目录
+func (e *Environment) SetDir(dir string) {
+	e.root().dir = dir
+}
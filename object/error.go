@@ -0,0 +1,61 @@
+package object
+
+// 错误在catch中的类型
+const (
+	RuntimeErrorType = "RuntimeError" // 求值器或虚拟机报告的错误
+	ThrownErrorType  = "Error"        // throw抛出的值，哈希中没有指定type时使用
+)
+
+// Throw 返回throw(value)抛出的错误。value为字符串时作为错误信息；
+// 为哈希时使用其中的"message"，否则使用value.Inspect()
+func Throw(value Object) *Error {
+	message := value
+	if hash, ok := value.(*Hash); ok {
+		if pair, ok := hash.Lookup(&String{Value: "message"}); ok {
+			message = pair.Value
+		}
+	}
+	return &Error{Message: message.Inspect(), Value: value}
+}
+
+// SetPosition 记录出错语句的位置，已经记录过时保留最内层的位置
+func (e *Error) SetPosition(line, column int) {
+	if e.Line == 0 {
+		e.Line, e.Column = line, column
+	}
+}
+
+// Caught 返回catch得到的值：包含message、type、line和column的哈希。
+// throw抛出哈希时在其副本上补全缺少的键；抛出其他值时，该值保存在value中
+func (e *Error) Caught() *Hash {
+	pairs := make(map[HashKey]HashPair)
+	if hash, ok := e.Value.(*Hash); ok {
+		for key, pair := range hash.Pairs {
+			pairs[key] = pair
+		}
+	} else if e.Value != nil {
+		setPair(pairs, "value", e.Value)
+	}
+
+	errorType := RuntimeErrorType
+	if e.Value != nil {
+		errorType = ThrownErrorType
+	}
+	setDefault(pairs, "message", &String{Value: e.Message})
+	setDefault(pairs, "type", &String{Value: errorType})
+	setDefault(pairs, "line", &Integer{Value: int64(e.Line)})
+	setDefault(pairs, "column", &Integer{Value: int64(e.Column)})
+	return &Hash{Pairs: pairs}
+}
+
+func setPair(pairs map[HashKey]HashPair, name string, value Object) {
+	key := &String{Value: name}
+	pairs[key.HashKey()] = HashPair{Key: key, Value: value}
+}
+
+func setDefault(pairs map[HashKey]HashPair, name string, value Object) {
+	key := &String{Value: name}
+	if _, ok := pairs[key.HashKey()]; !ok {
+		setPair(pairs, name, value)
+	}
+}
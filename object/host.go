@@ -0,0 +1,78 @@
+package object
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Capability 一组需要宿主授权才能调用的内置函数
+type Capability string
+
+const (
+	CapabilityIO   Capability = "io"   // 输出，如println
+	CapabilityFS   Capability = "fs"   // 读写文件
+	CapabilityTime Capability = "time" // 读取当前时间
+	CapabilityEnv  Capability = "env"  // 读取环境变量
+)
+
+// Host 宿主提供给脚本的运行环境：授予的能力和输出的目标。
+// 脚本调用未授权的内置函数时得到*PermissionError
+type Host struct {
+	Stdout       io.Writer // 输出的目标，为nil时丢弃输出
+	Capabilities []Capability
+}
+
+// DefaultHost 命令行和REPL使用的宿主，授予所有能力并输出到标准输出。
+// 未设置宿主的执行都使用它
+var DefaultHost = &Host{
+	Stdout:       os.Stdout,
+	Capabilities: []Capability{CapabilityIO, CapabilityFS, CapabilityTime, CapabilityEnv},
+}
+
+// Allows 判断宿主是否授予了能力c，空能力总是允许
+func (h *Host) Allows(c Capability) bool {
+	if c == "" {
+		return true
+	}
+	for _, granted := range h.Capabilities {
+		if granted == c {
+			return true
+		}
+	}
+	return false
+}
+
+// Output 返回输出的目标
+func (h *Host) Output() io.Writer {
+	if h.Stdout == nil {
+		return io.Discard
+	}
+	return h.Stdout
+}
+
+// Call 在宿主中调用内置函数，未授权时返回*PermissionError
+func (h *Host) Call(b *Builtin, args ...Object) (Object, error) {
+	if !h.Allows(b.Capability) {
+		return nil, &PermissionError{Builtin: b.Name, Capability: b.Capability}
+	}
+	return b.Fn(h, args...), nil
+}
+
+// ReadModule 读取import的模块文件。加载模块需要读取文件，与文件操作的内置函数一样需要CapabilityFS
+func (h *Host) ReadModule(path string) ([]byte, error) {
+	if !h.Allows(CapabilityFS) {
+		return nil, &PermissionError{Builtin: "import", Capability: CapabilityFS}
+	}
+	return os.ReadFile(path)
+}
+
+// PermissionError 调用了宿主未授权的内置函数
+type PermissionError struct {
+	Builtin    string
+	Capability Capability
+}
+
+func (e *PermissionError) Error() string {
+	return fmt.Sprintf("permission denied: %s requires the %q capability", e.Builtin, e.Capability)
+}
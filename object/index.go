@@ -0,0 +1,34 @@
+package object
+
+import "fmt"
+
+// SetIndex 执行下标赋值left[index] = value，求值器和虚拟机共用。
+// 数组的下标必须在范围内，哈希的键不存在时添加，数组和哈希键见FreezeKey；冻结的数组和哈希不能修改
+func SetIndex(left, index, value Object) error {
+	switch left := left.(type) {
+	case *Array:
+		if left.Frozen {
+			return fmt.Errorf("cannot modify frozen %s", left.Type())
+		}
+		i, ok := index.(*Integer)
+		if !ok {
+			return fmt.Errorf("array index must be %s, got %s", INTEGER_OBJ, index.Type())
+		}
+		if i.Value < 0 || i.Value >= int64(len(left.Elements)) {
+			return fmt.Errorf("index %d out of range for array of length %d", i.Value, len(left.Elements))
+		}
+		left.Elements[i.Value] = value
+	case *Hash:
+		if left.Frozen {
+			return fmt.Errorf("cannot modify frozen %s", left.Type())
+		}
+		key, ok := HashKeyOf(index)
+		if !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+		left.Pairs[key] = HashPair{Key: FreezeKey(index), Value: value}
+	default:
+		return fmt.Errorf("index assignment not supported: %s", left.Type())
+	}
+	return nil
+}
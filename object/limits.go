@@ -0,0 +1,102 @@
+package object
+
+import (
+	"context"
+	"fmt"
+)
+
+// Limits 一次执行可以消耗的资源上限，字段为零表示不限制
+type Limits struct {
+	MaxInstructions int64 // 执行的指令数；求值器中为求值的语法树节点数
+	MaxCallDepth    int   // 函数调用的最大嵌套深度；求值器中为零时使用evaluator.DefaultMaxCallDepth
+	MaxAllocations  int64 // 创建的对象数，包括整数、字符串、数组、哈希和函数等
+	MaxStackSize    int   // 虚拟机栈最多能扩大到的大小，为零时使用vm.DefaultMaxStackSize；求值器不使用
+}
+
+// LimitError 执行超出Limits中的某项上限
+type LimitError struct {
+	Limit string // 超出的上限："instructions"、"call depth"或"allocations"
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("execution limit exceeded: %s (max %d)", e.Limit, e.Max)
+}
+
+// checkInterval 每执行这么多步检查一次context，避免每步都访问channel
+const checkInterval = 1024
+
+// Budget 记录一次执行已消耗的资源。
+// 超出上限或context结束后，Budget会记住该错误，之后的每次检查都返回它
+type Budget struct {
+	ctx    context.Context
+	done   <-chan struct{}
+	limits Limits
+
+	instructions int64
+	depth        int
+	allocations  int64
+	err          error
+}
+
+func NewBudget(ctx context.Context, limits Limits) *Budget {
+	return &Budget{ctx: ctx, done: ctx.Done(), limits: limits}
+}
+
+// Step 记录执行了一步
+func (b *Budget) Step() error {
+	if b.err != nil {
+		return b.err
+	}
+	b.instructions++
+	if b.limits.MaxInstructions > 0 && b.instructions > b.limits.MaxInstructions {
+		return b.fail(&LimitError{Limit: "instructions", Max: b.limits.MaxInstructions})
+	}
+	if b.done != nil && b.instructions%checkInterval == 0 {
+		select {
+		case <-b.done:
+			return b.fail(fmt.Errorf("execution interrupted: %w", b.ctx.Err()))
+		default:
+		}
+	}
+	return nil
+}
+
+// Enter 记录进入一层函数调用，返回时需调用Leave
+func (b *Budget) Enter() error {
+	if b.err != nil {
+		return b.err
+	}
+	b.depth++
+	if b.limits.MaxCallDepth > 0 && b.depth > b.limits.MaxCallDepth {
+		return b.fail(&LimitError{Limit: "call depth", Max: int64(b.limits.MaxCallDepth)})
+	}
+	return nil
+}
+
+// Leave 记录从一层函数调用返回
+func (b *Budget) Leave() {
+	b.depth--
+}
+
+// Allocate 记录创建了一个对象
+func (b *Budget) Allocate() error {
+	if b.err != nil {
+		return b.err
+	}
+	b.allocations++
+	if b.limits.MaxAllocations > 0 && b.allocations > b.limits.MaxAllocations {
+		return b.fail(&LimitError{Limit: "allocations", Max: b.limits.MaxAllocations})
+	}
+	return nil
+}
+
+// Err 返回导致执行中止的错误，未中止时返回nil
+func (b *Budget) Err() error {
+	return b.err
+}
+
+func (b *Budget) fail(err error) error {
+	b.err = err
+	return err
+}
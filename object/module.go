@@ -0,0 +1,84 @@
+package object
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Module import返回的模块，Exports为模块顶层导出的绑定，可以像哈希一样用名字索引
+type Module struct {
+	Path    string // import中书写的路径
+	Exports *Hash
+}
+
+func (m *Module) Type() ObjectType {
+	return MODULE_OBJ
+}
+
+func (m *Module) Inspect() string {
+	return fmt.Sprintf("module(%q)", m.Path)
+}
+
+func (m *Module) Equals(other Object) bool {
+	o, ok := other.(*Module)
+	return ok && m == o
+}
+
+// IsExported 模块顶层的let绑定中，以下划线开头的名字不导出
+func IsExported(name string) bool {
+	return !strings.HasPrefix(name, "_")
+}
+
+// ResolveImportPath 将import中书写的路径解析为绝对路径，相对路径相对于导入方所在的目录dir
+func ResolveImportPath(dir string, path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	return filepath.Abs(path)
+}
+
+// ImportCycleError 模块之间存在循环导入，Cycle为构成循环的文件，首尾相同
+type ImportCycleError struct {
+	Cycle []string
+}
+
+func (e *ImportCycleError) Error() string {
+	return "import cycle: " + strings.Join(e.Cycle, " -> ")
+}
+
+// ModuleCache 按绝对路径缓存已加载的模块，并记录正在加载的模块以检测循环导入
+type ModuleCache struct {
+	modules map[string]*Module
+	loading []string
+}
+
+func NewModuleCache() *ModuleCache {
+	return &ModuleCache{modules: make(map[string]*Module)}
+}
+
+func (c *ModuleCache) Get(path string) (*Module, bool) {
+	m, ok := c.modules[path]
+	return m, ok
+}
+
+// Begin 开始加载path，path正在加载时返回*ImportCycleError。
+// 加载结束后必须调用End
+func (c *ModuleCache) Begin(path string) error {
+	for i, loading := range c.loading {
+		if loading == path {
+			cycle := append(append([]string{}, c.loading[i:]...), path)
+			return &ImportCycleError{Cycle: cycle}
+		}
+	}
+	c.loading = append(c.loading, path)
+	return nil
+}
+
+// End 结束加载path，m不为nil时缓存该模块
+func (c *ModuleCache) End(path string, m *Module) {
+	c.loading = c.loading[:len(c.loading)-1]
+	if m != nil {
+		c.modules[path] = m
+	}
+}
@@ -2,8 +2,11 @@ package object
 
 import (
 	"Monkey/ast"
+	"Monkey/code"
 	"bytes"
+	"encoding/binary"
 	"fmt"
+	"hash"
 	"hash/fnv"
 	"strings"
 )
@@ -21,13 +24,20 @@ const (
 	BUILTIN_OBJ      = "BUILTIN"
 	ARRAY_OBJ        = "ARRAY"
 	HASH_OBJ         = "HASH"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
+	CLOSURE_OBJ           = "CLOSURE"
+	MODULE_OBJ            = "MODULE"
 )
 
-type BuiltinFunction func(args ...Object) Object
+// BuiltinFunction 内置函数的实现，host为调用方所在的宿主环境
+type BuiltinFunction func(host *Host, args ...Object) Object
 
 type Object interface {
 	Type() ObjectType
 	Inspect() string
+	// Equals 结构相等：同类型且值相等；函数等引用类型按指针比较
+	Equals(other Object) bool
 }
 
 type Hashable interface {
@@ -46,6 +56,11 @@ func (i *Integer) Type() ObjectType {
 	return INTEGER_OBJ
 }
 
+func (i *Integer) Equals(other Object) bool {
+	o, ok := other.(*Integer)
+	return ok && i.Value == o.Value
+}
+
 type Boolean struct {
 	Value bool
 }
@@ -58,6 +73,11 @@ func (b *Boolean) Type() ObjectType {
 	return BOOLEAN_OBJ
 }
 
+func (b *Boolean) Equals(other Object) bool {
+	o, ok := other.(*Boolean)
+	return ok && b.Value == o.Value
+}
+
 type Null struct {
 }
 
@@ -69,6 +89,11 @@ func (n *Null) Inspect() string {
 	return "null"
 }
 
+func (n *Null) Equals(other Object) bool {
+	_, ok := other.(*Null)
+	return ok
+}
+
 type ReturnValue struct {
 	Value Object
 }
@@ -81,8 +106,17 @@ func (rv *ReturnValue) Inspect() string {
 	return rv.Value.Inspect()
 }
 
+func (rv *ReturnValue) Equals(other Object) bool {
+	o, ok := other.(*ReturnValue)
+	return ok && rv.Value.Equals(o.Value)
+}
+
 type Error struct {
 	Message string
+	Value   Object // throw抛出的值，运行时错误为nil
+	Line    int    // 出错语句在源码中的位置，未知时为0
+	Column  int
+	Fatal   bool // 不能被catch捕获的错误，如超出资源限制和权限不足
 }
 
 func (e *Error) Type() ObjectType {
@@ -93,10 +127,16 @@ func (e *Error) Inspect() string {
 	return "ERROR: " + e.Message
 }
 
+func (e *Error) Equals(other Object) bool {
+	o, ok := other.(*Error)
+	return ok && e.Message == o.Message
+}
+
 type Function struct {
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
+	Name       string // 绑定函数的变量名，匿名函数为空
 }
 
 func (f *Function) Type() ObjectType {
@@ -120,6 +160,53 @@ func (f *Function) Inspect() string {
 	return out.String()
 }
 
+func (f *Function) Equals(other Object) bool {
+	o, ok := other.(*Function)
+	return ok && f == o
+}
+
+// CompiledFunction 编译后的函数，保存在常量池中
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	NumLocals     int // 局部变量个数（包括参数）
+	NumParameters int
+	Name          string          // 绑定函数的变量名，匿名函数为空
+	SourceMap     *code.SourceMap // 调试信息，可以为nil
+	Handlers      []code.Handler  // 异常表，见code.Handler
+}
+
+func (cf *CompiledFunction) Type() ObjectType {
+	return COMPILED_FUNCTION_OBJ
+}
+
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+func (cf *CompiledFunction) Equals(other Object) bool {
+	o, ok := other.(*CompiledFunction)
+	return ok && cf == o
+}
+
+// Closure 运行时的函数值，Free为捕获的自由变量
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+}
+
+func (c *Closure) Type() ObjectType {
+	return CLOSURE_OBJ
+}
+
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
+
+func (c *Closure) Equals(other Object) bool {
+	o, ok := other.(*Closure)
+	return ok && c == o
+}
+
 type String struct {
 	Value string
 }
@@ -132,8 +219,15 @@ func (s *String) Inspect() string {
 	return s.Value
 }
 
+func (s *String) Equals(other Object) bool {
+	o, ok := other.(*String)
+	return ok && s.Value == o.Value
+}
+
 type Builtin struct {
-	Fn BuiltinFunction
+	Name       string
+	Fn         BuiltinFunction
+	Capability Capability // 调用需要的能力，为空表示总是可以调用
 }
 
 func (b *Builtin) Type() ObjectType {
@@ -144,8 +238,14 @@ func (b *Builtin) Inspect() string {
 	return "builtin function"
 }
 
+func (b *Builtin) Equals(other Object) bool {
+	o, ok := other.(*Builtin)
+	return ok && b == o
+}
+
 type Array struct {
 	Elements []Object
+	Frozen   bool // 冻结后不能用下标赋值修改，见SetIndex
 }
 
 func (a *Array) Type() ObjectType {
@@ -166,13 +266,27 @@ func (a *Array) Inspect() string {
 	return out.String()
 }
 
+func (a *Array) Equals(other Object) bool {
+	o, ok := other.(*Array)
+	if !ok || len(a.Elements) != len(o.Elements) {
+		return false
+	}
+	for i, element := range a.Elements {
+		if !element.Equals(o.Elements[i]) {
+			return false
+		}
+	}
+	return true
+}
+
 type HashPair struct {
 	Key   Object
 	Value Object
 }
 
 type Hash struct {
-	Pairs map[HashKey]HashPair
+	Pairs  map[HashKey]HashPair
+	Frozen bool // 冻结后不能用下标赋值修改，见SetIndex
 }
 
 func (h *Hash) Type() ObjectType {
@@ -192,6 +306,33 @@ func (h *Hash) Inspect() string {
 	return out.String()
 }
 
+func (h *Hash) Equals(other Object) bool {
+	o, ok := other.(*Hash)
+	if !ok || len(h.Pairs) != len(o.Pairs) {
+		return false
+	}
+	for key, pair := range h.Pairs {
+		otherPair, ok := o.Pairs[key]
+		if !ok || !pair.Key.Equals(otherPair.Key) || !pair.Value.Equals(otherPair.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Lookup 按key查找键值对，命中HashKey后再用Equals确认，避免哈希碰撞时返回错误的值
+func (h *Hash) Lookup(key Object) (HashPair, bool) {
+	hashKey, ok := HashKeyOf(key)
+	if !ok {
+		return HashPair{}, false
+	}
+	pair, ok := h.Pairs[hashKey]
+	if !ok || !pair.Key.Equals(key) {
+		return HashPair{}, false
+	}
+	return pair, true
+}
+
 type HashKey struct {
 	Type  ObjectType
 	Value uint64
@@ -218,3 +359,70 @@ func (s *String) HashKey() HashKey {
 
 	return HashKey{Type: s.Type(), Value: h.Sum64()}
 }
+
+func (n *Null) HashKey() HashKey {
+	return HashKey{Type: n.Type(), Value: 0}
+}
+
+// HashKeyOf 计算obj作为哈希键的HashKey
+// 数组和哈希的所有元素都可哈希时，按元素组合出HashKey；否则返回false
+func HashKeyOf(obj Object) (HashKey, bool) {
+	switch obj := obj.(type) {
+	case Hashable:
+		return obj.HashKey(), true
+	case *Array:
+		h := fnv.New64a()
+		for _, element := range obj.Elements {
+			key, ok := HashKeyOf(element)
+			if !ok {
+				return HashKey{}, false
+			}
+			writeHashKey(h, key)
+		}
+		return HashKey{Type: obj.Type(), Value: h.Sum64()}, true
+	case *Hash:
+		// 哈希的键无序，使用异或组合各键值对，保证与遍历顺序无关
+		var value uint64
+		for key, pair := range obj.Pairs {
+			valueKey, ok := HashKeyOf(pair.Value)
+			if !ok {
+				return HashKey{}, false
+			}
+			h := fnv.New64a()
+			writeHashKey(h, key)
+			writeHashKey(h, valueKey)
+			value ^= h.Sum64()
+		}
+		return HashKey{Type: obj.Type(), Value: value}, true
+	default:
+		return HashKey{}, false
+	}
+}
+
+// FreezeKey 返回保存到哈希中的键。数组和哈希键的HashKey由内容决定，
+// 因此保存它们冻结的深拷贝，之后修改原对象不会使已保存的键失效
+func FreezeKey(key Object) Object {
+	switch key := key.(type) {
+	case *Array:
+		elements := make([]Object, len(key.Elements))
+		for i, element := range key.Elements {
+			elements[i] = FreezeKey(element)
+		}
+		return &Array{Elements: elements, Frozen: true}
+	case *Hash:
+		pairs := make(map[HashKey]HashPair, len(key.Pairs))
+		for hashKey, pair := range key.Pairs {
+			pairs[hashKey] = HashPair{Key: FreezeKey(pair.Key), Value: FreezeKey(pair.Value)}
+		}
+		return &Hash{Pairs: pairs, Frozen: true}
+	default:
+		return key
+	}
+}
+
+func writeHashKey(h hash.Hash64, key HashKey) {
+	var buf [8]byte
+	h.Write([]byte(key.Type))
+	binary.BigEndian.PutUint64(buf[:], key.Value)
+	h.Write(buf[:])
+}
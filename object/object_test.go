@@ -0,0 +1,164 @@
+package object
+
+import "testing"
+
+func TestEquals(t *testing.T) {
+	tests := []struct {
+		name     string
+		left     Object
+		right    Object
+		expected bool
+	}{
+		{"integer", &Integer{Value: 1}, &Integer{Value: 1}, true},
+		{"integer differs", &Integer{Value: 1}, &Integer{Value: 2}, false},
+		{"string", &String{Value: "a"}, &String{Value: "a"}, true},
+		{"null", &Null{}, &Null{}, true},
+		{"type mismatch", &Integer{Value: 1}, &String{Value: "1"}, false},
+		{"array", &Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "a"}}},
+			&Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "a"}}}, true},
+		{"array length", &Array{Elements: []Object{&Integer{Value: 1}}}, &Array{}, false},
+		{"hash", newHash(&String{Value: "a"}, &Integer{Value: 1}),
+			newHash(&String{Value: "a"}, &Integer{Value: 1}), true},
+		{"hash value", newHash(&String{Value: "a"}, &Integer{Value: 1}),
+			newHash(&String{Value: "a"}, &Integer{Value: 2}), false},
+		{"function identity", &Function{}, &Function{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.left.Equals(tt.right); got != tt.expected {
+				t.Errorf("Equals want %t, got %t", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestHashKeyOf(t *testing.T) {
+	array1 := &Array{Elements: []Object{&Integer{Value: 1}, &Null{}}}
+	array2 := &Array{Elements: []Object{&Integer{Value: 1}, &Null{}}}
+	array3 := &Array{Elements: []Object{&Null{}, &Integer{Value: 1}}}
+
+	key1, ok := HashKeyOf(array1)
+	if !ok {
+		t.Fatalf("array should be hashable")
+	}
+	key2, _ := HashKeyOf(array2)
+	key3, _ := HashKeyOf(array3)
+	if key1 != key2 {
+		t.Errorf("arrays with same content have different hash keys")
+	}
+	if key1 == key3 {
+		t.Errorf("arrays with different order have same hash keys")
+	}
+
+	if _, ok := HashKeyOf(&Array{Elements: []Object{&Function{}}}); ok {
+		t.Errorf("array containing function should not be hashable")
+	}
+}
+
+func TestHashLookup(t *testing.T) {
+	key := &Array{Elements: []Object{&String{Value: "a"}}}
+	hash := newHash(key, &Integer{Value: 1})
+
+	pair, ok := hash.Lookup(&Array{Elements: []Object{&String{Value: "a"}}})
+	if !ok {
+		t.Fatalf("key not found")
+	}
+	if !pair.Value.Equals(&Integer{Value: 1}) {
+		t.Errorf("wrong value, got %s", pair.Value.Inspect())
+	}
+
+	if _, ok := hash.Lookup(&Null{}); ok {
+		t.Errorf("null key should not be found")
+	}
+}
+
+func TestEnvironmentAssign(t *testing.T) {
+	outer := NewEnvironment()
+	outer.SetMutable("x", &Integer{Value: 1})
+	outer.Set("y", &Integer{Value: 2})
+	env := NewEnclosedEnvironment(outer)
+
+	if err := env.Assign("x", &Integer{Value: 3}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if x, _ := outer.Get("x"); !x.Equals(&Integer{Value: 3}) {
+		t.Errorf("assignment not visible in defining scope, got %s", x.Inspect())
+	}
+
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"y", "cannot assign to immutable variable: y"},
+		{"z", "identifier not found: z"},
+	}
+	for _, tt := range tests {
+		err := env.Assign(tt.name, &Integer{Value: 0})
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%s: expected error %q, got %v", tt.name, tt.expected, err)
+		}
+	}
+
+	// 用let重新定义后不能再赋值
+	outer.Set("x", &Integer{Value: 4})
+	if err := env.Assign("x", &Integer{Value: 5}); err == nil {
+		t.Errorf("expected x redefined by let to be immutable")
+	}
+}
+
+func TestSetIndex(t *testing.T) {
+	array := &Array{Elements: []Object{&Integer{Value: 1}}}
+	if err := SetIndex(array, &Integer{Value: 0}, &Integer{Value: 2}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !array.Elements[0].Equals(&Integer{Value: 2}) {
+		t.Errorf("element not set, got %s", array.Inspect())
+	}
+
+	hash := &Hash{Pairs: map[HashKey]HashPair{}}
+	if err := SetIndex(hash, &String{Value: "a"}, &Integer{Value: 1}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if pair, ok := hash.Lookup(&String{Value: "a"}); !ok || !pair.Value.Equals(&Integer{Value: 1}) {
+		t.Errorf("key not set, got %s", hash.Inspect())
+	}
+
+	// 数组键保存为冻结的副本，之后修改原数组不影响查找
+	key := &Array{Elements: []Object{&Integer{Value: 1}}}
+	if err := SetIndex(hash, key, &Integer{Value: 2}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	key.Elements[0] = &Integer{Value: 3}
+	pair, ok := hash.Lookup(&Array{Elements: []Object{&Integer{Value: 1}}})
+	if !ok || !pair.Value.Equals(&Integer{Value: 2}) {
+		t.Fatalf("array key not found after mutating the original, got %s", hash.Inspect())
+	}
+	if stored := pair.Key.(*Array); stored == key || !stored.Frozen {
+		t.Errorf("array key not stored as a frozen copy")
+	}
+
+	tests := []struct {
+		left     Object
+		index    Object
+		expected string
+	}{
+		{array, &Integer{Value: 1}, "index 1 out of range for array of length 1"},
+		{array, &String{Value: "a"}, "array index must be INTEGER, got STRING"},
+		{&Array{Frozen: true}, &Integer{Value: 0}, "cannot modify frozen ARRAY"},
+		{&Hash{Frozen: true}, &String{Value: "a"}, "cannot modify frozen HASH"},
+		{hash, &Builtin{}, "unusable as hash key: BUILTIN"},
+		{&String{Value: "a"}, &Integer{Value: 0}, "index assignment not supported: STRING"},
+	}
+	for _, tt := range tests {
+		err := SetIndex(tt.left, tt.index, &Null{})
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("expected error %q, got %v", tt.expected, err)
+		}
+	}
+}
+
+func newHash(key, value Object) *Hash {
+	hashKey, _ := HashKeyOf(key)
+	return &Hash{Pairs: map[HashKey]HashPair{hashKey: {Key: key, Value: value}}}
+}
@@ -0,0 +1,63 @@
+package object
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// 模式匹配和解构赋值对值的检查，求值器和虚拟机共用。
+// 值符合要求时返回空字符串，否则返回不匹配的说明
+
+// ArrayMismatch 检查value是否为长度为n的数组，rest为true时长度不少于n
+func ArrayMismatch(value Object, n int, rest bool) string {
+	array, ok := value.(*Array)
+	switch {
+	case !ok:
+		return fmt.Sprintf("expected array, got %s", value.Type())
+	case rest && len(array.Elements) < n:
+		return fmt.Sprintf("expected array of at least %d elements, got %d", n, len(array.Elements))
+	case !rest && len(array.Elements) != n:
+		return fmt.Sprintf("expected array of %d elements, got %d", n, len(array.Elements))
+	}
+	return ""
+}
+
+// HashMismatch 检查value是否为哈希
+func HashMismatch(value Object) string {
+	if _, ok := value.(*Hash); !ok {
+		return fmt.Sprintf("expected hash, got %s", value.Type())
+	}
+	return ""
+}
+
+// KeyMismatch 检查value是否为包含键key的哈希
+func KeyMismatch(value Object, key Object) string {
+	hash, ok := value.(*Hash)
+	if !ok {
+		return HashMismatch(value)
+	}
+	if _, ok := hash.Lookup(key); !ok {
+		return fmt.Sprintf("missing key %s", describeValue(key))
+	}
+	return ""
+}
+
+// ValueMismatch 检查value是否等于字面量literal
+func ValueMismatch(value Object, literal Object) string {
+	if !literal.Equals(value) {
+		return fmt.Sprintf("expected %s, got %s", describeValue(literal), describeValue(value))
+	}
+	return ""
+}
+
+// describeValue 不匹配的说明中展示的值：字符串加引号，数组、哈希等只展示类型
+func describeValue(value Object) string {
+	switch value := value.(type) {
+	case *String:
+		return strconv.Quote(value.Value)
+	case *Integer, *Boolean, *Null:
+		return value.Inspect()
+	default:
+		return string(value.Type())
+	}
+}
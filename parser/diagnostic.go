@@ -0,0 +1,69 @@
+package parser
+
+import (
+	"Monkey/token"
+	"fmt"
+)
+
+// Severity 诊断信息的严重程度
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+}
+
+// Diagnostic 解析过程中产生的一条诊断信息
+type Diagnostic struct {
+	Severity Severity
+	Line     int
+	Column   int
+	Expected string      // 期望的词法单元或语法成分，可能为空
+	Found    token.Token // 实际遇到的词法单元
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%d:%d: %s: %s", d.Line, d.Column, d.Severity, d.Message)
+}
+
+// describeTokenType 用于错误信息中展示期望的词法单元类型
+func describeTokenType(t token.TokenType) string {
+	switch t {
+	case token.IDENT:
+		return "identifier"
+	case token.INT:
+		return "integer"
+	case token.STRING:
+		return "string"
+	case token.EOF:
+		return "end of input"
+	case token.CATCH:
+		return `"catch"`
+	default:
+		return fmt.Sprintf("%q", string(t))
+	}
+}
+
+// describeToken 用于错误信息中展示词法单元
+func describeToken(tok token.Token) string {
+	switch tok.Type {
+	case token.EOF:
+		return "end of input"
+	case token.IDENT, token.INT, token.STRING:
+		return fmt.Sprintf("%s %q", describeTokenType(tok.Type), tok.Literal)
+	default:
+		return fmt.Sprintf("%q", tok.Literal)
+	}
+}
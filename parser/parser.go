@@ -12,7 +12,12 @@ type Parser struct {
 	l         *lexer.Lexer
 	curToken  token.Token
 	peekToken token.Token
-	errors    []string
+
+	diagnostics []Diagnostic
+	// panicking 为true表示当前语句已经报告过错误，
+	// 在同步到下一个语句边界之前不再报告新的错误，避免级联错误
+	panicking  bool
+	blockDepth int
 
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
@@ -27,6 +32,7 @@ type (
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN      // =
 	EQUALS      //==
 	LESSGREATER //> or <
 	SUM         // +
@@ -38,6 +44,7 @@ const (
 
 // 优先级表
 var precedences = map[token.TokenType]int{
+	token.ASSIGN:    ASSIGN,
 	token.EQ:        EQUALS,
 	token.NOT_EQ:    EQUALS,
 	token.LT:        LESSGREATER,
@@ -52,8 +59,8 @@ var precedences = map[token.TokenType]int{
 
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
-		l:      l,
-		errors: []string{},
+		l:           l,
+		diagnostics: []Diagnostic{},
 	}
 	// 注册前缀函数
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
@@ -70,6 +77,8 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.ASTERISK, p.parsePrefixExpression)
 	p.registerPrefix(token.SLASH, p.parsePrefixExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
+	p.registerPrefix(token.TRY, p.parseTryExpression)
+	p.registerPrefix(token.MATCH, p.parseMatchExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionExpression)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
 	p.registerPrefix(token.LBARACKET, p.parseArrayLiteral)
@@ -86,6 +95,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.GT, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBARACKET, p.parseIndexExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
 	//读取两个词法单元以设置curToken和peekToken
 	p.nextToken()
 	p.nextToken()
@@ -93,8 +103,20 @@ func New(l *lexer.Lexer) *Parser {
 	return p
 }
 
+// Errors 返回所有错误的文本形式
 func (p *Parser) Errors() []string {
-	return p.errors
+	errors := []string{}
+	for _, d := range p.diagnostics {
+		if d.Severity == SeverityError {
+			errors = append(errors, d.String())
+		}
+	}
+	return errors
+}
+
+// Diagnostics 返回结构化的诊断信息
+func (p *Parser) Diagnostics() []Diagnostic {
+	return p.diagnostics
 }
 
 func (p *Parser) nextToken() {
@@ -107,7 +129,7 @@ func (p *Parser) ParseProgram() *ast.Program {
 	program.Statements = []ast.Statement{}
 
 	for p.curToken.Type != token.EOF {
-		stmt := p.ParseStatement()
+		stmt := p.parseStatementWithRecovery()
 		if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
 		}
@@ -116,10 +138,57 @@ func (p *Parser) ParseProgram() *ast.Program {
 	return program
 }
 
+// parseStatementWithRecovery 解析一条语句，出错时同步到下一个语句边界，
+// 返回的语句可能只解析了一部分
+func (p *Parser) parseStatementWithRecovery() ast.Statement {
+	stmt := p.ParseStatement()
+	if p.panicking {
+		p.synchronize()
+		p.panicking = false
+	}
+	return stmt
+}
+
+// synchronize 跳过词法单元直到语句边界：
+// 当前词法单元为分号，或下一个词法单元开始新语句、结束所在代码块。
+// 跳过的过程中会匹配大括号，不会停在错误语句内部的代码块中
+func (p *Parser) synchronize() {
+	depth := 0
+	for !p.curTokenIs(token.EOF) {
+		switch p.curToken.Type {
+		case token.LBRACE:
+			depth++
+		case token.RBRACE:
+			if depth > 0 {
+				depth--
+			}
+		case token.SEMICOLON:
+			if depth == 0 {
+				return
+			}
+		}
+		if depth == 0 {
+			switch p.peekToken.Type {
+			case token.LET, token.VAR, token.RETURN, token.EOF:
+				return
+			case token.RBRACE:
+				if p.blockDepth > 0 {
+					return
+				}
+			}
+		}
+		p.nextToken()
+	}
+}
+
 func (p *Parser) ParseStatement() ast.Statement {
 	switch p.curToken.Type {
-	case token.LET:
-		return p.ParseLetStatement()
+	case token.LET, token.VAR:
+		// 避免返回包含nil指针的非nil接口
+		if stmt := p.ParseLetStatement(); stmt != nil {
+			return stmt
+		}
+		return nil
 	case token.RETURN:
 		return p.ParseReturnStatement()
 	default:
@@ -127,13 +196,27 @@ func (p *Parser) ParseStatement() ast.Statement {
 	}
 }
 
+// ParseLetStatement 解析let name = value，以及解构赋值let [a, ...rest] = value和let {name, age} = value；
+// var语句的形式相同
 func (p *Parser) ParseLetStatement() *ast.LetStatement {
 	stmt := &ast.LetStatement{Token: p.curToken}
-	if !p.expectPeek(token.IDENT) {
-		return nil
-	}
+	if p.peekTokenIs(token.LBARACKET) || p.peekTokenIs(token.LBRACE) {
+		p.nextToken()
+		stmt.Pattern = p.parsePattern()
+		if stmt.Pattern == nil || !p.checkBindings(stmt.Pattern) {
+			return nil
+		}
+	} else {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 
-	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		var ok bool
+		if stmt.Type, ok = p.parseTypeAnnotation(); !ok {
+			return nil
+		}
+	}
 
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
@@ -141,8 +224,12 @@ func (p *Parser) ParseLetStatement() *ast.LetStatement {
 
 	p.nextToken()
 	stmt.Value = p.parseExpression(LOWEST)
+	// var定义的变量可能被重新赋值，函数体中的名字需要按变量解析，不能当作函数自身
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok && stmt.Name != nil && !stmt.Mutable() {
+		fl.Name = stmt.Name.Value
+	}
 
-	for !p.curTokenIs(token.SEMICOLON) {
+	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 	return stmt
@@ -153,7 +240,7 @@ func (p *Parser) ParseReturnStatement() *ast.ReturnStatement {
 
 	p.nextToken()
 	stmt.ReturnValue = p.parseExpression(LOWEST)
-	for !p.curTokenIs(token.SEMICOLON) {
+	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 	return stmt
@@ -172,7 +259,7 @@ func (p *Parser) ParseExpressionStatement() *ast.ExpressionStatement {
 func (p *Parser) parseExpression(precedence int) ast.Expression {
 	prefix := p.prefixParseFns[p.curToken.Type]
 	if prefix == nil {
-		p.noPrefixParseFnError(p.curToken.Type)
+		p.noPrefixParseFnError()
 		return nil
 	}
 	leftExp := prefix()
@@ -196,8 +283,7 @@ func (p *Parser) parseIdentifier() ast.Expression {
 func (p *Parser) parseIntegerLiteral() ast.Expression {
 	num, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %v as interger", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.errorAt(p.curToken, "", fmt.Sprintf("could not parse %v as integer", p.curToken.Literal))
 		return nil
 	}
 	return &ast.IntegerLiteral{Token: p.curToken, Value: num}
@@ -222,8 +308,24 @@ func (p *Parser) expectPeek(t token.TokenType) bool {
 }
 
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("peekToken want to be [%v], but got [%v] ", t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	msg := fmt.Sprintf("expected %s, found %s", describeTokenType(t), describeToken(p.peekToken))
+	p.errorAt(p.peekToken, string(t), msg)
+}
+
+// errorAt 在tok的位置记录一条错误；同一语句中只记录第一条
+func (p *Parser) errorAt(tok token.Token, expected string, msg string) {
+	if p.panicking {
+		return
+	}
+	p.panicking = true
+	p.diagnostics = append(p.diagnostics, Diagnostic{
+		Severity: SeverityError,
+		Line:     tok.Line,
+		Column:   tok.Column,
+		Expected: expected,
+		Found:    tok,
+		Message:  msg,
+	})
 }
 
 func (p *Parser) registerPrefix(tokeType token.TokenType, fn prefixParseFn) {
@@ -234,9 +336,9 @@ func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) {
 	p.infixParseFns[tokenType] = fn
 }
 
-func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+func (p *Parser) noPrefixParseFnError() {
+	msg := fmt.Sprintf("expected expression, found %s", describeToken(p.curToken))
+	p.errorAt(p.curToken, "expression", msg)
 }
 
 func (p *Parser) parsePrefixExpression() ast.Expression {
@@ -293,6 +395,7 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	expression := &ast.IfExpression{Token: p.curToken}
 
 	if !p.peekTokenIs(token.LPAREN) {
+		p.peekError(token.LPAREN)
 		return nil
 	}
 
@@ -316,19 +419,54 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return expression
 }
 
+func (p *Parser) parseTryExpression() ast.Expression {
+	expression := &ast.TryExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	expression.Block = p.parseBlockStatement()
+
+	if !p.expectPeek(token.CATCH) {
+		return nil
+	}
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	expression.Param = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	expression.Handler = p.parseBlockStatement()
+	return expression
+}
+
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curToken}
 	block.Statements = []ast.Statement{}
 
+	p.blockDepth++
+	defer func() { p.blockDepth-- }()
+
 	p.nextToken()
 
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
-		statement := p.ParseStatement()
+		statement := p.parseStatementWithRecovery()
 		if statement != nil {
 			block.Statements = append(block.Statements, statement)
 		}
 		p.nextToken()
 	}
+	block.Rbrace = p.curToken
+	if p.curTokenIs(token.EOF) {
+		p.errorAt(p.curToken, token.RBRACE, fmt.Sprintf("expected %s, found %s", describeTokenType(token.RBRACE), describeToken(p.curToken)))
+	}
 	return block
 }
 
@@ -339,8 +477,12 @@ func (p *Parser) parseFunctionExpression() ast.Expression {
 		return nil
 	}
 
-	fn.Parameters = p.parseFunctionParameters()
+	fn.Parameters, fn.ParameterTypes = p.parseFunctionParameters()
 
+	var ok bool
+	if fn.ReturnType, ok = p.parseTypeAnnotation(); !ok {
+		return nil
+	}
 	if !p.expectPeek(token.LBRACE) {
 		return nil
 	}
@@ -348,22 +490,38 @@ func (p *Parser) parseFunctionExpression() ast.Expression {
 	return fn
 }
 
-func (p *Parser) parseFunctionParameters() []*ast.Identifier {
-	var idents []*ast.Identifier
+// parseFunctionParameters 解析参数列表及参数的类型注解，所有参数都没有注解时types为nil
+func (p *Parser) parseFunctionParameters() (idents []*ast.Identifier, types []ast.Type) {
 	if p.peekTokenIs(token.RPAREN) {
-		return idents
+		p.nextToken()
+		return idents, nil
 	}
-	p.nextToken()
-	for !p.curTokenIs(token.RPAREN) && !p.curTokenIs(token.EOF) {
-		if p.curTokenIs(token.COMMA) {
-			p.nextToken()
+
+	annotated := false
+	for {
+		if !p.expectPeek(token.IDENT) {
+			return nil, nil
+		}
+		idents = append(idents, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+		t, ok := p.parseTypeAnnotation()
+		if !ok {
+			return nil, nil
+		}
+		types = append(types, t)
+		annotated = annotated || t != nil
+		if !p.peekTokenIs(token.COMMA) {
+			break
 		}
-		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-		idents = append(idents, ident)
 		p.nextToken()
 	}
 
-	return idents
+	if !p.expectPeek(token.RPAREN) {
+		return nil, nil
+	}
+	if !annotated {
+		types = nil
+	}
+	return idents, types
 }
 
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
@@ -417,6 +575,24 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	return exp
 }
 
+// parseAssignExpression 解析target = value，赋值是右结合的：a = b = 1即a = (b = 1)
+func (p *Parser) parseAssignExpression(target ast.Expression) ast.Expression {
+	exp := &ast.AssignExpression{Token: p.curToken, Target: target}
+	switch target.(type) {
+	case nil:
+		// 左侧解析出错，错误已经报告
+		return nil
+	case *ast.Identifier, *ast.IndexExpression:
+	default:
+		p.errorAt(p.curToken, "", fmt.Sprintf("cannot assign to %s", target.String()))
+		return nil
+	}
+
+	p.nextToken()
+	exp.Value = p.parseExpression(ASSIGN - 1)
+	return exp
+}
+
 func (p *Parser) parseHashLiteral() ast.Expression {
 	hash := &ast.HashLiteral{Token: p.curToken}
 	hash.Pairs = make(map[ast.Expression]ast.Expression)
@@ -430,6 +606,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		p.nextToken()
 		value := p.parseExpression(LOWEST)
 		hash.Pairs[key] = value
+		hash.Keys = append(hash.Keys, key)
 
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
 			return nil
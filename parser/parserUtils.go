@@ -5,7 +5,7 @@ import (
 )
 
 func CheckErrors(t *testing.T, p *Parser) {
-	errors := p.errors
+	errors := p.Errors()
 	if len(errors) == 0 {
 		return
 	}
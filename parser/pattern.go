@@ -0,0 +1,200 @@
+package parser
+
+import (
+	"Monkey/ast"
+	"Monkey/token"
+	"fmt"
+	"strconv"
+)
+
+// parseMatchExpression 解析match (subject) { pattern [if guard] => body, ... }，
+// 最后一个分支后的逗号可以省略
+func (p *Parser) parseMatchExpression() ast.Expression {
+	expression := &ast.MatchExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	expression.Subject = p.parseExpression(LOWEST)
+	if expression.Subject == nil {
+		return nil
+	}
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		arm := p.parseMatchArm()
+		if arm == nil {
+			return nil
+		}
+		expression.Arms = append(expression.Arms, arm)
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+	p.nextToken()
+	return expression
+}
+
+func (p *Parser) parseMatchArm() *ast.MatchArm {
+	arm := &ast.MatchArm{Token: p.curToken}
+
+	arm.Pattern = p.parsePattern()
+	if arm.Pattern == nil || !p.checkBindings(arm.Pattern) {
+		return nil
+	}
+
+	if p.peekTokenIs(token.IF) {
+		p.nextToken()
+		p.nextToken()
+		arm.Guard = p.parseExpression(LOWEST)
+		if arm.Guard == nil {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.ARROW) {
+		return nil
+	}
+	p.nextToken()
+	arm.Body = p.parseExpression(LOWEST)
+	if arm.Body == nil {
+		return nil
+	}
+	return arm
+}
+
+// parsePattern 解析一个模式：标识符、字面量、数组模式或哈希模式
+func (p *Parser) parsePattern() ast.Expression {
+	switch p.curToken.Type {
+	case token.IDENT:
+		return p.parseIdentifier()
+	case token.INT, token.MINUS, token.STRING, token.TRUE, token.FALSE:
+		return p.parseLiteralPattern()
+	case token.LBARACKET:
+		return p.parseArrayPattern()
+	case token.LBRACE:
+		return p.parseHashPattern()
+	default:
+		p.errorAt(p.curToken, "pattern", fmt.Sprintf("expected pattern, found %s", describeToken(p.curToken)))
+		return nil
+	}
+}
+
+// parseLiteralPattern 解析整数、字符串或布尔字面量。负整数解析为值为负的IntegerLiteral
+func (p *Parser) parseLiteralPattern() ast.Expression {
+	switch p.curToken.Type {
+	case token.MINUS:
+		minus := p.curToken
+		if !p.expectPeek(token.INT) {
+			return nil
+		}
+		literal := "-" + p.curToken.Literal
+		num, err := strconv.ParseInt(literal, 0, 64)
+		if err != nil {
+			p.errorAt(minus, "", fmt.Sprintf("could not parse %v as integer", literal))
+			return nil
+		}
+		tok := token.Token{Type: token.INT, Literal: literal, Line: minus.Line, Column: minus.Column}
+		return &ast.IntegerLiteral{Token: tok, Value: num}
+	case token.INT:
+		return p.parseIntegerLiteral()
+	case token.STRING:
+		return p.parseStringLiteral()
+	case token.TRUE, token.FALSE:
+		return p.parseBoolean()
+	default:
+		msg := fmt.Sprintf("expected string, integer or boolean, found %s", describeToken(p.curToken))
+		p.errorAt(p.curToken, "literal", msg)
+		return nil
+	}
+}
+
+// parseArrayPattern 解析[p1, p2, ...rest]，...rest只能是最后一个元素
+func (p *Parser) parseArrayPattern() ast.Expression {
+	pattern := &ast.ArrayPattern{Token: p.curToken}
+
+	for !p.peekTokenIs(token.RBARACKET) {
+		p.nextToken()
+		if p.curTokenIs(token.ELLIPSIS) {
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			pattern.Rest = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			break
+		}
+
+		element := p.parsePattern()
+		if element == nil {
+			return nil
+		}
+		pattern.Elements = append(pattern.Elements, element)
+		if !p.peekTokenIs(token.RBARACKET) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBARACKET) {
+		return nil
+	}
+	return pattern
+}
+
+// parseHashPattern 解析{key: pattern, ...}，键为字面量。
+// {name}是{"name": name}的简写
+func (p *Parser) parseHashPattern() ast.Expression {
+	pattern := &ast.HashPattern{Token: p.curToken}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		if p.curTokenIs(token.IDENT) && !p.peekTokenIs(token.COLON) {
+			// 键的词法单元仍为IDENT，据此区分简写
+			pattern.Keys = append(pattern.Keys, &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal})
+			pattern.Values = append(pattern.Values, p.parseIdentifier())
+			if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+				return nil
+			}
+			continue
+		}
+
+		key := p.parseLiteralPattern()
+		if key == nil {
+			return nil
+		}
+		if !p.expectPeek(token.COLON) {
+			return nil
+		}
+		p.nextToken()
+		value := p.parsePattern()
+		if value == nil {
+			return nil
+		}
+		pattern.Keys = append(pattern.Keys, key)
+		pattern.Values = append(pattern.Values, value)
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	p.nextToken()
+	return pattern
+}
+
+// checkBindings 报告模式中重复绑定的名字
+func (p *Parser) checkBindings(pattern ast.Expression) bool {
+	seen := make(map[string]bool)
+	for _, name := range ast.PatternBindings(pattern) {
+		if seen[name.Value] {
+			p.errorAt(name.Token, "", fmt.Sprintf("duplicate binding %q in pattern", name.Value))
+			return false
+		}
+		seen[name.Value] = true
+	}
+	return true
+}
@@ -4,6 +4,7 @@ import (
 	"Monkey/ast"
 	"Monkey/lexer"
 	"Monkey/parser"
+	"Monkey/token"
 	"fmt"
 	"github.com/stretchr/testify/require"
 	"testing"
@@ -327,6 +328,9 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 		{"true", "true"},
 		{"false", "false"},
 		{"3>5==false", "((3 > 5) == false)"},
+		{"a = b = c + 1", "(a = (b = (c + 1)))"},
+		{"xs[i] = a == b", "((xs[i]) = (a == b))"},
+		{"f(x = 1)", "f((x = 1))"},
 		{"3<5==true", "((3 < 5) == true)"},
 		{"1+(2+3)+4", "((1 + (2 + 3)) + 4)"},
 		{"(5+5)*2", "((5 + 5) * 2)"},
@@ -369,6 +373,64 @@ func TestIfExpression(t *testing.T) {
 	}
 }
 
+func TestTryExpression(t *testing.T) {
+	input := `try { get(x) } catch (e) { e["message"] }`
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	parser.CheckErrors(t, p)
+
+	require.Len(t, program.Statements, 1)
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	exp, ok := stmt.Expression.(*ast.TryExpression)
+	require.True(t, ok, "expression is not *ast.TryExpression. got=%T", stmt.Expression)
+	require.Len(t, exp.Block.Statements, 1)
+	require.Equal(t, "get(x)", exp.Block.String())
+	parser.TestIdentifier(t, exp.Param, "e")
+	require.Len(t, exp.Handler.Statements, 1)
+	require.Equal(t, "(e[message])", exp.Handler.String())
+}
+
+func TestMatchExpression(t *testing.T) {
+	input := `match (x) {
+		[a, _, ...rest] if a > 0 => rest,
+		{"kind": "point", "at": [x, y]} => x,
+		-1 => "minus one",
+		true => 1,
+		_ => null,
+	}`
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	parser.CheckErrors(t, p)
+
+	require.Len(t, program.Statements, 1)
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	exp, ok := stmt.Expression.(*ast.MatchExpression)
+	require.True(t, ok, "expression is not *ast.MatchExpression. got=%T", stmt.Expression)
+	parser.TestIdentifier(t, exp.Subject, "x")
+	require.Len(t, exp.Arms, 5)
+
+	array, ok := exp.Arms[0].Pattern.(*ast.ArrayPattern)
+	require.True(t, ok, "pattern is not *ast.ArrayPattern. got=%T", exp.Arms[0].Pattern)
+	require.Len(t, array.Elements, 2)
+	parser.TestIdentifier(t, array.Rest, "rest")
+	parser.TestInfixExpression(t, exp.Arms[0].Guard, "a", ">", 0)
+	parser.TestIdentifier(t, exp.Arms[0].Body, "rest")
+
+	hash, ok := exp.Arms[1].Pattern.(*ast.HashPattern)
+	require.True(t, ok, "pattern is not *ast.HashPattern. got=%T", exp.Arms[1].Pattern)
+	require.Equal(t, `{kind:point,at:[x,y]}`, hash.String())
+	require.Nil(t, exp.Arms[1].Guard)
+
+	parser.TestLiteralExpression(t, exp.Arms[2].Pattern, -1)
+	parser.TestLiteralExpression(t, exp.Arms[3].Pattern, true)
+	parser.TestIdentifier(t, exp.Arms[4].Pattern, "_")
+
+	bindings := ast.PatternBindings(exp.Arms[0].Pattern)
+	require.Len(t, bindings, 2)
+	require.Equal(t, "a", bindings[0].Value)
+	require.Equal(t, "rest", bindings[1].Value)
+}
+
 func TestFunctionLiteralParsing(t *testing.T) {
 	tests := []struct {
 		input  string
@@ -453,6 +515,103 @@ func TestLetStatements(t *testing.T) {
 	}
 }
 
+func TestDestructuringLetStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		bindings []string
+	}{
+		{"let [a, b, ...rest] = arr;", "let [a,b,...rest]=arr;", []string{"a", "b", "rest"}},
+		{"let {name, age} = person;", "let {name:name,age:age}=person;", []string{"name", "age"}},
+		{`let {"pos": [x, _], 1: y} = p;`, "let {pos:[x,_],1:y}=p;", []string{"x", "y"}},
+		{"let [] = f();", "let []=f();", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := parser.New(lexer.New(tt.input))
+			program := p.ParseProgram()
+			parser.CheckErrors(t, p)
+
+			require.Len(t, program.Statements, 1)
+			stmt, ok := program.Statements[0].(*ast.LetStatement)
+			require.True(t, ok, "statement is not *ast.LetStatement. got=%T", program.Statements[0])
+			require.Nil(t, stmt.Name)
+			require.Equal(t, tt.expected, stmt.String())
+
+			var names []string
+			for _, ident := range ast.PatternBindings(stmt.Pattern) {
+				names = append(names, ident.Value)
+			}
+			require.Equal(t, tt.bindings, names)
+		})
+	}
+}
+
+func TestVarStatements(t *testing.T) {
+	input := "var x = 1; let f = fn() { 1 }; var g = fn() { 2 }; var [a, b] = xs;"
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	parser.CheckErrors(t, p)
+
+	require.Len(t, program.Statements, 4)
+	mutable := []bool{true, false, true, true}
+	for i, stmt := range program.Statements {
+		let, ok := stmt.(*ast.LetStatement)
+		require.True(t, ok, "statement %d is not *ast.LetStatement. got=%T", i, stmt)
+		require.Equal(t, mutable[i], let.Mutable(), "statement %d", i)
+	}
+	require.Equal(t, "var x=1;", program.Statements[0].String())
+	// var定义的函数可能被重新赋值，不记录函数名
+	require.Equal(t, "f", program.Statements[1].(*ast.LetStatement).Value.(*ast.FunctionLiteral).Name)
+	require.Equal(t, "", program.Statements[2].(*ast.LetStatement).Value.(*ast.FunctionLiteral).Name)
+}
+
+func TestTypeAnnotations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x: int = 5;", "let x: int=5;"},
+		{"var names: [string] = [];", "var names: [string]=[];"},
+		{"let ages: {string: int} = {};", "let ages: {string: int}={};"},
+		{"let f = fn(a: string, b: [int]): bool { true };", "let f=fn(a: string,b: [int]): bool{ true };"},
+		{"let g = fn(h: fn(int, any): int, x) { h(x, x) };", "let g=fn(h: fn(int, any): int,x){ h(x,x) };"},
+		{"let k: fn(): fn() = fn() { fn() { 1 } };", "let k: fn(): fn()=fn(){ fn(){ 1 } };"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := parser.New(lexer.New(tt.input))
+			program := p.ParseProgram()
+			parser.CheckErrors(t, p)
+
+			require.Len(t, program.Statements, 1)
+			require.Equal(t, tt.expected, program.String())
+		})
+	}
+}
+
+func TestParameterTypes(t *testing.T) {
+	input := "fn(a, b: int) { a }; fn(a, b) { a };"
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	parser.CheckErrors(t, p)
+
+	annotated := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	require.Len(t, annotated.ParameterTypes, 2)
+	require.Nil(t, annotated.ParameterType(0))
+	require.Equal(t, "int", annotated.ParameterType(1).String())
+	require.Nil(t, annotated.ReturnType)
+
+	// 没有注解时不分配ParameterTypes
+	plain := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	require.Nil(t, plain.ParameterTypes)
+	require.Nil(t, plain.ParameterType(1))
+}
+
 // parser/parser_test.go
 
 func TestStringLiteralExpression(t *testing.T) {
@@ -604,3 +763,69 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 		testFunc(value)
 	}
 }
+
+func TestErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedErrors []string
+	}{
+		{"add(1, 2;\nlet x = 5;", []string{`1:9: error: expected ")", found ";"`}},
+		{"let = 5; let y 3; let z = 1;", []string{
+			`1:5: error: expected identifier, found "="`,
+			`1:16: error: expected "=", found integer "3"`,
+		}},
+		{"let f = fn(x, { x };\nf(1)", []string{`1:15: error: expected identifier, found "{"`}},
+		{"fn() {\n  let = 1;\n  let b = ;\n  3\n}", []string{
+			`2:7: error: expected identifier, found "="`,
+			`3:11: error: expected expression, found ";"`,
+		}},
+		{"if (x { 1 } else { 2 }; y", []string{`1:7: error: expected ")", found "{"`}},
+		{"let x = (1 + 2", []string{`1:15: error: expected ")", found end of input`}},
+		{"fn(x) { x", []string{`1:10: error: expected "}", found end of input`}},
+		{"let x = 1 let y = 2", []string{}},
+		{"try { 1 } (e) { 2 }", []string{`1:11: error: expected "catch", found "("`}},
+		{"try { 1 } catch e { 2 }", []string{`1:17: error: expected "(", found identifier "e"`}},
+		{"match (x) { x + 1 => 2 }", []string{`1:15: error: expected "=>", found "+"`}},
+		{"match (x) { [a, a] => a }", []string{`1:17: error: duplicate binding "a" in pattern`}},
+		{"match (x) { [...a, b] => a }", []string{`1:18: error: expected "]", found ","`}},
+		{"match (x) { {k: v} => v }", []string{`1:14: error: expected string, integer or boolean, found identifier "k"`}},
+		{"match (x) { fn => 1 }", []string{`1:13: error: expected pattern, found "fn"`}},
+		{"let [a, b] 1; let y = 2;", []string{`1:12: error: expected "=", found integer "1"`}},
+		{"let {a, a} = p;", []string{`1:9: error: duplicate binding "a" in pattern`}},
+		{"let [a + 1] = p;", []string{`1:8: error: expected ",", found "+"`}},
+		{"1 = 2; let y = 1;", []string{`1:3: error: cannot assign to 1`}},
+		{"a + b = c;", []string{`1:7: error: cannot assign to (a + b)`}},
+		{"let x: = 1; let y = 2;", []string{`1:8: error: expected type, found "="`}},
+		{"let f = fn(a: 1) { a };", []string{`1:15: error: expected type, found integer "1"`}},
+		{"let f = fn(a): [int { a };", []string{`1:21: error: expected "]", found "{"`}},
+		{"let h: {string} = {};", []string{`1:15: error: expected ":", found "}"`}},
+		{"var = 1; var y 2;", []string{
+			`1:5: error: expected identifier, found "="`,
+			`1:16: error: expected "=", found integer "2"`,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := parser.New(lexer.New(tt.input))
+			p.ParseProgram()
+			require.Equal(t, tt.expectedErrors, p.Errors())
+		})
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	p := parser.New(lexer.New("let x = 1;\nlet y 2;"))
+	p.ParseProgram()
+
+	diagnostics := p.Diagnostics()
+	require.Len(t, diagnostics, 1)
+
+	d := diagnostics[0]
+	require.Equal(t, parser.SeverityError, d.Severity)
+	require.Equal(t, 2, d.Line)
+	require.Equal(t, 7, d.Column)
+	require.Equal(t, "=", d.Expected)
+	require.Equal(t, token.INT, string(d.Found.Type))
+	require.Equal(t, "2", d.Found.Literal)
+}
@@ -0,0 +1,95 @@
+package parser
+
+import (
+	"Monkey/ast"
+	"Monkey/token"
+	"fmt"
+)
+
+// parseType 解析类型注解，当前词法单元为类型的第一个词法单元：
+// 具名类型int，数组类型[int]，哈希类型{string: int}，函数类型fn(int, string): bool
+func (p *Parser) parseType() ast.Type {
+	switch p.curToken.Type {
+	case token.IDENT:
+		return &ast.NamedType{Token: p.curToken, Name: p.curToken.Literal}
+	case token.LBARACKET:
+		t := &ast.ArrayType{Token: p.curToken}
+		p.nextToken()
+		if t.Element = p.parseType(); t.Element == nil {
+			return nil
+		}
+		if !p.expectPeek(token.RBARACKET) {
+			return nil
+		}
+		return t
+	case token.LBRACE:
+		t := &ast.HashType{Token: p.curToken}
+		p.nextToken()
+		if t.Key = p.parseType(); t.Key == nil {
+			return nil
+		}
+		if !p.expectPeek(token.COLON) {
+			return nil
+		}
+		p.nextToken()
+		if t.Value = p.parseType(); t.Value == nil {
+			return nil
+		}
+		if !p.expectPeek(token.RBRACE) {
+			return nil
+		}
+		return t
+	case token.FUNCTION:
+		return p.parseFunctionType()
+	default:
+		p.errorAt(p.curToken, "type", fmt.Sprintf("expected type, found %s", describeToken(p.curToken)))
+		return nil
+	}
+}
+
+func (p *Parser) parseFunctionType() ast.Type {
+	t := &ast.FunctionType{Token: p.curToken}
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	if p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+	} else {
+		for {
+			p.nextToken()
+			param := p.parseType()
+			if param == nil {
+				return nil
+			}
+			t.Parameters = append(t.Parameters, param)
+			if !p.peekTokenIs(token.COMMA) {
+				break
+			}
+			p.nextToken()
+		}
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+	}
+
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		p.nextToken()
+		if t.Return = p.parseType(); t.Return == nil {
+			return nil
+		}
+	}
+	return t
+}
+
+// parseTypeAnnotation 下一个词法单元为冒号时解析其后的类型注解。
+// 没有注解时返回nil和true，注解有错误时返回false
+func (p *Parser) parseTypeAnnotation() (ast.Type, bool) {
+	if !p.peekTokenIs(token.COLON) {
+		return nil, true
+	}
+	p.nextToken()
+	p.nextToken()
+	t := p.parseType()
+	return t, t != nil
+}
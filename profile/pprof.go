@@ -0,0 +1,210 @@
+package profile
+
+import (
+	"compress/gzip"
+	"io"
+	"path/filepath"
+	"sort"
+)
+
+// profile.proto中用到的字段编号，见github.com/google/pprof/proto/profile.proto
+const (
+	profileSampleType        = 1
+	profileSample            = 2
+	profileLocation          = 4
+	profileFunction          = 5
+	profileStringTable       = 6
+	profileTimeNanos         = 9
+	profileDurationNanos     = 10
+	profilePeriodType        = 11
+	profilePeriod            = 12
+	profileDefaultSampleType = 14
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	locationID   = 1
+	locationLine = 4
+
+	lineFunctionID = 1
+	lineLine       = 2
+
+	functionID         = 1
+	functionName       = 2
+	functionSystemName = 3
+	functionFilename   = 4
+	functionStartLine  = 5
+)
+
+// WritePprof 以gzip压缩的pprof格式输出调用树，可以用go tool pprof打开。
+// 每个样本有三个值：执行的指令数、采样次数和样本代表的时间
+func (p *Profiler) WritePprof(w io.Writer) error {
+	table := &stringTable{index: map[string]int64{"": 0}, table: []string{""}}
+	var b protobuf
+
+	for _, t := range [][2]string{{"instructions", "count"}, {"samples", "count"}, {"cpu", "nanoseconds"}} {
+		b.message(profileSampleType, func(b *protobuf) {
+			b.int64(valueTypeType, table.get(t[0]))
+			b.int64(valueTypeUnit, table.get(t[1]))
+		})
+	}
+
+	p.walk(func(n *node) {
+		if n.instructions == 0 && n.samples == 0 {
+			return
+		}
+		var ids []uint64
+		for a := n; a != p.root; a = a.parent {
+			ids = append(ids, a.loc.id)
+		}
+		values := []int64{n.instructions, n.samples, n.time.Nanoseconds()}
+		b.message(profileSample, func(b *protobuf) {
+			b.packedUint64(sampleLocationID, ids)
+			b.packedInt64(sampleValue, values)
+		})
+	})
+
+	locations := make([]*location, 0, len(p.locations))
+	for _, loc := range p.locations {
+		locations = append(locations, loc)
+	}
+	sort.Slice(locations, func(i, j int) bool { return locations[i].id < locations[j].id })
+	for _, loc := range locations {
+		b.message(profileLocation, func(b *protobuf) {
+			b.uint64(locationID, loc.id)
+			b.message(locationLine, func(b *protobuf) {
+				b.uint64(lineFunctionID, loc.fn.id)
+				b.int64(lineLine, int64(loc.line))
+			})
+		})
+	}
+
+	functions := make([]*function, 0, len(p.functions))
+	for _, f := range p.functions {
+		functions = append(functions, f)
+	}
+	sort.Slice(functions, func(i, j int) bool { return functions[i].id < functions[j].id })
+	for _, f := range functions {
+		b.message(profileFunction, func(b *protobuf) {
+			b.uint64(functionID, f.id)
+			b.int64(functionName, table.get(f.pprofName()))
+			b.int64(functionSystemName, table.get(f.pprofName()))
+			b.int64(functionFilename, table.get(f.file))
+			b.int64(functionStartLine, int64(f.line))
+		})
+	}
+
+	b.int64(profileTimeNanos, p.started.UnixNano())
+	b.int64(profileDurationNanos, p.duration.Nanoseconds())
+	b.message(profilePeriodType, func(b *protobuf) {
+		b.int64(valueTypeType, table.get("cpu"))
+		b.int64(valueTypeUnit, table.get("nanoseconds"))
+	})
+	b.int64(profilePeriod, p.interval.Nanoseconds())
+	defaultType := "instructions"
+	if p.samples > 0 {
+		defaultType = "cpu"
+	}
+	b.int64(profileDefaultSampleType, table.get(defaultType))
+
+	// 字符串表要等其它字段都编码完才完整，放在最后
+	for _, s := range table.table {
+		b.string(profileStringTable, s)
+	}
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(b.buf); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// pprofName 返回函数在pprof中的名字。pprof会去掉名字中尖括号括起的部分，
+// 因此最外层的程序用文件名代替<main>
+func (f *function) pprofName() string {
+	if !f.main {
+		return f.name
+	}
+	if f.file == "" {
+		return "main"
+	}
+	return filepath.Base(f.file)
+}
+
+// stringTable pprof的字符串表，第0项必须为空字符串
+type stringTable struct {
+	index map[string]int64
+	table []string
+}
+
+func (t *stringTable) get(s string) int64 {
+	if i, ok := t.index[s]; ok {
+		return i
+	}
+	i := int64(len(t.table))
+	t.index[s] = i
+	t.table = append(t.table, s)
+	return i
+}
+
+// protobuf 最小的protobuf编码器，只支持pprof用到的varint和length-delimited字段
+type protobuf struct {
+	buf []byte
+}
+
+func (b *protobuf) varint(x uint64) {
+	for x >= 0x80 {
+		b.buf = append(b.buf, byte(x)|0x80)
+		x >>= 7
+	}
+	b.buf = append(b.buf, byte(x))
+}
+
+func (b *protobuf) tag(field int, wireType int) {
+	b.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (b *protobuf) uint64(field int, x uint64) {
+	b.tag(field, 0)
+	b.varint(x)
+}
+
+func (b *protobuf) int64(field int, x int64) {
+	b.tag(field, 0)
+	b.varint(uint64(x))
+}
+
+func (b *protobuf) bytes(field int, data []byte) {
+	b.tag(field, 2)
+	b.varint(uint64(len(data)))
+	b.buf = append(b.buf, data...)
+}
+
+func (b *protobuf) string(field int, s string) {
+	b.bytes(field, []byte(s))
+}
+
+func (b *protobuf) packedUint64(field int, xs []uint64) {
+	var packed protobuf
+	for _, x := range xs {
+		packed.varint(x)
+	}
+	b.bytes(field, packed.buf)
+}
+
+func (b *protobuf) packedInt64(field int, xs []int64) {
+	var packed protobuf
+	for _, x := range xs {
+		packed.varint(uint64(x))
+	}
+	b.bytes(field, packed.buf)
+}
+
+func (b *protobuf) message(field int, encode func(b *protobuf)) {
+	var m protobuf
+	encode(&m)
+	b.bytes(field, m.buf)
+}
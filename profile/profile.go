@@ -0,0 +1,310 @@
+package profile
+
+import (
+	"Monkey/code"
+	"Monkey/object"
+	"Monkey/vm"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// clockInterval 每执行这么多条指令读取一次时钟，判断是否需要采样
+const clockInterval = 64
+
+// Profiler 虚拟机的指令级性能分析器，作为vm.Hook使用。
+// 它统计每个函数和操作码执行的指令数；设置了采样间隔时，
+// 每隔一段时间记录一次当前的调用栈，把这段时间归到栈顶的Monkey函数而不是虚拟机的实现上
+type Profiler struct {
+	interval   time.Duration
+	started    time.Time
+	lastSample time.Time
+	duration   time.Duration
+
+	root      *node
+	callers   *node // 当前帧的调用方在调用树中的节点
+	depth     int   // callers对应的调用深度
+	locations map[locationKey]*location
+	functions map[*object.CompiledFunction]*function
+
+	opcodes      [256]int64 // 每个操作码执行的次数
+	opcodeTime   [256]time.Duration
+	instructions int64
+	samples      int64
+}
+
+// node 调用树的节点，从根到节点的路径是一个调用栈
+type node struct {
+	loc          *location
+	parent       *node
+	children     map[*location]*node
+	instructions int64 // 栈顶为该节点时执行的指令数
+	samples      int64
+	time         time.Duration // 样本代表的时间
+}
+
+// function 被执行的Monkey函数
+type function struct {
+	id   uint64
+	name string
+	file string
+	line int // 函数第一条语句所在行
+	main bool
+}
+
+type locationKey struct {
+	fn   *object.CompiledFunction
+	line int
+}
+
+// location 函数中的一行
+type location struct {
+	id   uint64
+	fn   *function
+	line int
+}
+
+// New 创建分析器，interval为采样间隔，为0时只计数不采样
+func New(interval time.Duration) *Profiler {
+	return &Profiler{
+		interval:  interval,
+		root:      &node{},
+		locations: make(map[locationKey]*location),
+		functions: make(map[*object.CompiledFunction]*function),
+	}
+}
+
+// Start 开始计时，在Run之前调用
+func (p *Profiler) Start() {
+	p.started = time.Now()
+	p.lastSample = p.started
+	p.callers = nil
+}
+
+// Stop 停止计时，在Run之后调用
+func (p *Profiler) Stop() {
+	p.duration = time.Since(p.started)
+}
+
+// BeforeInstruction 实现vm.Hook
+func (p *Profiler) BeforeInstruction(machine *vm.VM) error {
+	// 调用和返回都会改变调用深度，深度不变时调用方的栈不变，不必重新查找
+	depth := machine.Depth()
+	if depth != p.depth || p.callers == nil {
+		p.callers = p.root
+		for i := 0; i < depth-1; i++ {
+			p.callers = p.callers.child(p.location(machine, i))
+		}
+		p.depth = depth
+	}
+
+	frame := machine.Frame(depth - 1)
+	leaf := p.callers.child(p.location(machine, depth-1))
+	op := frame.Closure().Fn.Instructions[frame.IP()]
+
+	leaf.instructions++
+	p.opcodes[op]++
+	p.instructions++
+	if p.interval > 0 && p.instructions%clockInterval == 0 {
+		// 距上次采样的时间都算在当前的栈上，读时钟的间隔远小于采样间隔时误差可以忽略
+		now := time.Now()
+		if elapsed := now.Sub(p.lastSample); elapsed >= p.interval {
+			p.lastSample = now
+			leaf.samples++
+			leaf.time += elapsed
+			p.opcodeTime[op] += elapsed
+			p.samples++
+		}
+	}
+	return nil
+}
+
+func (n *node) child(loc *location) *node {
+	if c, ok := n.children[loc]; ok {
+		return c
+	}
+	if n.children == nil {
+		n.children = make(map[*location]*node)
+	}
+	c := &node{loc: loc, parent: n}
+	n.children[loc] = c
+	return c
+}
+
+// location 返回第i层调用帧当前所在的行
+func (p *Profiler) location(machine *vm.VM, i int) *location {
+	frame := machine.Frame(i)
+	fn := frame.Closure().Fn
+	line := 0
+	if pos, ok := fn.SourceMap.Lookup(frame.IP()); ok {
+		line = pos.Line
+	}
+
+	key := locationKey{fn: fn, line: line}
+	if loc, ok := p.locations[key]; ok {
+		return loc
+	}
+	f, ok := p.functions[fn]
+	if !ok {
+		f = &function{id: uint64(len(p.functions) + 1), name: machine.FunctionName(i), main: i == 0}
+		if fn.SourceMap != nil {
+			f.file = fn.SourceMap.File
+			if len(fn.SourceMap.Positions) > 0 {
+				f.line = fn.SourceMap.Positions[0].Line
+			}
+		}
+		p.functions[fn] = f
+	}
+	loc := &location{id: uint64(len(p.locations) + 1), fn: f, line: line}
+	p.locations[key] = loc
+	return loc
+}
+
+// walk 以先序遍历调用树中除根以外的节点
+func (p *Profiler) walk(visit func(n *node)) {
+	var walk func(n *node)
+	walk = func(n *node) {
+		if n != p.root {
+			visit(n)
+		}
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	walk(p.root)
+}
+
+// stat 函数或操作码的统计：flat为自身，cum包括被调用的函数
+type stat struct {
+	name              string
+	flat, cum         int64
+	flatTime, cumTime time.Duration
+}
+
+// functionStats 按自身执行的指令数从多到少返回每个函数的统计
+func (p *Profiler) functionStats() []*stat {
+	stats := make(map[*function]*stat)
+	get := func(f *function) *stat {
+		s, ok := stats[f]
+		if !ok {
+			s = &stat{name: f.name}
+			stats[f] = s
+		}
+		return s
+	}
+
+	p.walk(func(n *node) {
+		s := get(n.loc.fn)
+		s.flat += n.instructions
+		s.flatTime += n.time
+		// 递归时同一个函数在栈上出现多次，cum只计一次
+		seen := make(map[*function]bool)
+		for a := n; a != p.root; a = a.parent {
+			if seen[a.loc.fn] {
+				continue
+			}
+			seen[a.loc.fn] = true
+			s := get(a.loc.fn)
+			s.cum += n.instructions
+			s.cumTime += n.time
+		}
+	})
+
+	result := make([]*stat, 0, len(stats))
+	for _, s := range stats {
+		result = append(result, s)
+	}
+	sortStats(result)
+	return result
+}
+
+// opcodeStats 按执行次数从多到少返回每个操作码的统计
+func (p *Profiler) opcodeStats() []*stat {
+	var result []*stat
+	for op, count := range p.opcodes {
+		if count == 0 {
+			continue
+		}
+		result = append(result, &stat{name: code.Opcode(op).String(), flat: count, flatTime: p.opcodeTime[op]})
+	}
+	sortStats(result)
+	return result
+}
+
+func sortStats(stats []*stat) {
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].flat != stats[j].flat {
+			return stats[i].flat > stats[j].flat
+		}
+		return stats[i].name < stats[j].name
+	})
+}
+
+// WriteReport 输出最热的n个函数和操作码，n<=0时输出全部
+func (p *Profiler) WriteReport(w io.Writer, n int) error {
+	sampled := p.samples > 0
+	pw := &printer{w: w}
+	pw.printf("%d instructions in %s", p.instructions, p.duration.Round(time.Microsecond))
+	if sampled {
+		pw.printf(", %d samples every %s", p.samples, p.interval)
+	}
+	pw.printf("\n\nhot functions:\n")
+	pw.printf("%12s %6s %12s %6s", "flat", "flat%", "cum", "cum%")
+	if sampled {
+		pw.printf(" %10s %10s", "time", "cum time")
+	}
+	pw.printf("  function\n")
+	for i, s := range p.functionStats() {
+		if n > 0 && i >= n {
+			break
+		}
+		pw.printf("%12d %6s %12d %6s", s.flat, p.percent(s.flat), s.cum, p.percent(s.cum))
+		if sampled {
+			pw.printf(" %10s %10s", roundTime(s.flatTime), roundTime(s.cumTime))
+		}
+		pw.printf("  %s\n", s.name)
+	}
+
+	pw.printf("\nhot opcodes:\n")
+	pw.printf("%12s %6s", "count", "count%")
+	if sampled {
+		pw.printf(" %10s", "time")
+	}
+	pw.printf("  opcode\n")
+	for i, s := range p.opcodeStats() {
+		if n > 0 && i >= n {
+			break
+		}
+		pw.printf("%12d %6s", s.flat, p.percent(s.flat))
+		if sampled {
+			pw.printf(" %10s", roundTime(s.flatTime))
+		}
+		pw.printf("  %s\n", s.name)
+	}
+	return pw.err
+}
+
+func (p *Profiler) percent(count int64) string {
+	if p.instructions == 0 {
+		return "0.0%"
+	}
+	return fmt.Sprintf("%.1f%%", float64(count)*100/float64(p.instructions))
+}
+
+func roundTime(d time.Duration) string {
+	return d.Round(time.Microsecond).String()
+}
+
+// printer 记录第一次写入的错误，之后的写入都被忽略
+type printer struct {
+	w   io.Writer
+	err error
+}
+
+func (p *printer) printf(format string, a ...interface{}) {
+	if p.err != nil {
+		return
+	}
+	_, p.err = fmt.Fprintf(p.w, format, a...)
+}
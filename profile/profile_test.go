@@ -0,0 +1,131 @@
+package profile
+
+import (
+	"Monkey/code"
+	"Monkey/compiler"
+	"Monkey/lexer"
+	"Monkey/parser"
+	"Monkey/vm"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+const program = `let fib = fn(n) {
+  if (n < 2) { return n; }
+  fib(n - 1) + fib(n - 2)
+};
+let run = fn() { fib(10) };
+run();`
+
+func profileProgram(t *testing.T, interval time.Duration) *Profiler {
+	t.Helper()
+	comp := compiler.New()
+	comp.SetFile("fib.mk")
+	if err := comp.Compile(parser.New(lexer.New(program)).ParseProgram()); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	p := New(interval)
+	machine := vm.New(comp.Bytecode())
+	machine.SetHook(p)
+	p.Start()
+	if err := machine.Run(context.Background()); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	p.Stop()
+	return p
+}
+
+func TestCounting(t *testing.T) {
+	p := profileProgram(t, 0)
+	if p.samples != 0 {
+		t.Errorf("expected no samples without an interval, got %d", p.samples)
+	}
+
+	stats := make(map[string]*stat)
+	var total int64
+	for _, s := range p.functionStats() {
+		stats[s.name] = s
+		total += s.flat
+	}
+	if total != p.instructions {
+		t.Errorf("flat counts add up to %d, want %d", total, p.instructions)
+	}
+	if len(stats) != 3 {
+		t.Fatalf("expected 3 functions, got %d", len(stats))
+	}
+	// 递归时cum不重复计算
+	if fib := stats["fib"]; fib.cum != fib.flat || fib.flat == 0 {
+		t.Errorf("wrong fib stats: flat=%d cum=%d", fib.flat, fib.cum)
+	}
+	if run := stats["run"]; run.cum != run.flat+stats["fib"].flat {
+		t.Errorf("wrong run cum: %d", run.cum)
+	}
+	if main := stats["<main>"]; main.cum != p.instructions {
+		t.Errorf("wrong <main> cum: %d, want %d", main.cum, p.instructions)
+	}
+	// fib(10)共调用fib 177次，再加上对run的调用
+	if calls := p.opcodes[code.OpCall]; calls != 177+1 {
+		t.Errorf("wrong OpCall count: %d", calls)
+	}
+
+	var report strings.Builder
+	if err := p.WriteReport(&report, 3); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"hot functions:", "  fib\n", "hot opcodes:", "  OpGetLocal\n"} {
+		if !strings.Contains(report.String(), want) {
+			t.Errorf("report missing %q:\n%s", want, report.String())
+		}
+	}
+}
+
+func TestSampling(t *testing.T) {
+	p := profileProgram(t, time.Nanosecond)
+	if p.samples == 0 {
+		t.Fatal("expected samples")
+	}
+	var sampled time.Duration
+	for _, s := range p.functionStats() {
+		sampled += s.flatTime
+	}
+	if sampled <= 0 || sampled > p.duration {
+		t.Errorf("sampled time %s out of range (duration %s)", sampled, p.duration)
+	}
+}
+
+func TestWritePprof(t *testing.T) {
+	p := profileProgram(t, time.Nanosecond)
+	var buf bytes.Buffer
+	if err := p.WritePprof(&buf); err != nil {
+		t.Fatal(err)
+	}
+	r, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("output is not gzip: %s", err)
+	}
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"instructions", "cpu", "nanoseconds", "fib", "run", "fib.mk"} {
+		if !bytes.Contains(raw, []byte(want)) {
+			t.Errorf("string table missing %q", want)
+		}
+	}
+}
+
+func TestProtobufVarint(t *testing.T) {
+	var b protobuf
+	b.uint64(1, 300)
+	b.string(2, "hi")
+	want := []byte{0x08, 0xac, 0x02, 0x12, 0x02, 'h', 'i'}
+	if !bytes.Equal(b.buf, want) {
+		t.Errorf("wrong encoding. want=%x, got=%x", want, b.buf)
+	}
+}
@@ -0,0 +1,188 @@
+package repl
+
+import (
+	"Monkey/ast"
+	"Monkey/compiler"
+	"Monkey/lexer"
+	"Monkey/object"
+	"Monkey/parser"
+	"Monkey/token"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// replCommand 以冒号开头的元命令，arg为命令名之后的内容
+type replCommand struct {
+	usage string
+	help  string
+	run   func(s *session, arg string)
+}
+
+var commands map[string]replCommand
+
+func init() {
+	// 在init中赋值，避免:help引用commands造成初始化循环
+	commands = map[string]replCommand{
+		"tokens":   {":tokens <src>", "print the tokens of src", (*session).tokens},
+		"ast":      {":ast <src>", "print the parse tree of src", (*session).ast},
+		"bytecode": {":bytecode <src>", "print the disassembled bytecode of src", (*session).bytecode},
+		"env":      {":env", "list global variables and their values", (*session).envCommand},
+		"load":     {":load <file>", "run a file in the current session", (*session).load},
+		"reset":    {":reset", "clear all global variables", (*session).resetCommand},
+		"help":     {":help", "list the commands", (*session).help},
+	}
+}
+
+// command 执行元命令line，line以冒号开头
+func (s *session) command(line string) {
+	name, arg, _ := strings.Cut(strings.TrimPrefix(line, ":"), " ")
+	arg = strings.TrimSpace(arg)
+
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(s.out, "unknown command :%s, type :help for a list of commands\n", name)
+		return
+	}
+	cmd.run(s, arg)
+}
+
+func (s *session) help(string) {
+	for _, name := range []string{"tokens", "ast", "bytecode", "env", "load", "reset", "help"} {
+		cmd := commands[name]
+		fmt.Fprintf(s.out, "%-16s %s\n", cmd.usage, cmd.help)
+	}
+}
+
+func (s *session) tokens(src string) {
+	l := lexer.New(src)
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		fmt.Fprintf(s.out, "%d:%d\t%-10s %q\n", tok.Line, tok.Column, tok.Type, tok.Literal)
+	}
+}
+
+func (s *session) ast(src string) {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		s.printParserErrors(p.Errors())
+		return
+	}
+
+	depth := 0
+	ast.Inspect(program, func(node ast.Node) bool {
+		if node == nil {
+			depth--
+			return false
+		}
+		fmt.Fprintf(s.out, "%s%s\n", strings.Repeat("  ", depth), describeNode(node))
+		depth++
+		return true
+	})
+}
+
+// describeNode 语法树中一个节点的类型和关键内容
+func describeNode(node ast.Node) string {
+	name := reflect.TypeOf(node).Elem().Name()
+	switch node := node.(type) {
+	case *ast.Identifier:
+		return name + " " + node.Value
+	case *ast.IntegerLiteral, *ast.Boolean:
+		return name + " " + node.TokenLiteral()
+	case *ast.StringLiteral:
+		return fmt.Sprintf("%s %q", name, node.Value)
+	case *ast.PrefixExpression:
+		return name + " " + node.Operator
+	case *ast.InfixExpression:
+		return name + " " + node.Operator
+	case *ast.FunctionLiteral:
+		if node.Name != "" {
+			return name + " " + node.Name
+		}
+	}
+	return name
+}
+
+// bytecode 独立编译src并输出反汇编结果，不影响当前会话
+func (s *session) bytecode(src string) {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		s.printParserErrors(p.Errors())
+		return
+	}
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		s.printError("Woops!Compilation fail:\n%s", err)
+		return
+	}
+	bytecode := comp.Bytecode()
+	fmt.Fprint(s.out, bytecode.Instructions.String())
+
+	for i, constant := range bytecode.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+		name := fn.Name
+		if name == "" {
+			name = "<anonymous>"
+		}
+		fmt.Fprintf(s.out, "\nconstant %d: fn %s (params=%d, locals=%d)\n", i, name, fn.NumParameters, fn.NumLocals)
+		fmt.Fprint(s.out, fn.Instructions.String())
+	}
+}
+
+func (s *session) envCommand(string) {
+	if s.engine == EngineEval {
+		bindings := s.env.Bindings()
+		names := make([]string, 0, len(bindings))
+		for name := range bindings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(s.out, "%s = %s\n", name, formatValue(bindings[name], s.color))
+		}
+		return
+	}
+
+	for _, symbol := range s.symbolTable.Symbols() {
+		// 缓存模块的隐藏变量不是合法的标识符，不列出
+		if strings.Contains(symbol.Name, " ") {
+			continue
+		}
+		value := "<unset>"
+		if symbol.Index < len(s.globals) && s.globals[symbol.Index] != nil {
+			value = formatValue(s.globals[symbol.Index], s.color)
+		}
+		fmt.Fprintf(s.out, "%s = %s\n", symbol.Name, value)
+	}
+}
+
+func (s *session) load(path string) {
+	if path == "" {
+		fmt.Fprintln(s.out, "usage: :load <file>")
+		return
+	}
+	src, err := os.ReadFile(path)
+	if err != nil {
+		s.printError("Woops! %s", err)
+		return
+	}
+	file, err := filepath.Abs(path)
+	if err != nil {
+		s.printError("Woops! %s", err)
+		return
+	}
+	s.run(string(src), file)
+}
+
+func (s *session) resetCommand(string) {
+	s.reset()
+	fmt.Fprintln(s.out, "state cleared")
+}
@@ -0,0 +1,145 @@
+package repl
+
+import (
+	"Monkey/object"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxLineWidth 数组和哈希的单行形式超过该宽度时，每个元素单独占一行
+const maxLineWidth = 80
+
+const (
+	colorReset  = "\x1b[0m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorCyan   = "\x1b[36m"
+	colorGray   = "\x1b[90m"
+)
+
+// colorEnabled 输出到终端且没有设置NO_COLOR时才使用颜色
+func colorEnabled(out io.Writer) bool {
+	f, ok := out.(*os.File)
+	if !ok || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	return isTerminal(f)
+}
+
+// valuePrinter 按类型格式化REPL的结果：字符串加引号，哈希的键排序，
+// 过长的数组和哈希分行缩进。求值器和虚拟机的结果格式相同
+type valuePrinter struct {
+	color bool
+}
+
+func formatValue(obj object.Object, color bool) string {
+	p := &valuePrinter{color: color}
+	return p.format(obj, "")
+}
+
+func (p *valuePrinter) paint(color, s string) string {
+	if !p.color {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// format 格式化obj，indent为obj所在行的缩进
+func (p *valuePrinter) format(obj object.Object, indent string) string {
+	plain := &valuePrinter{}
+	if len(indent)+len(plain.inline(obj)) <= maxLineWidth {
+		return p.inline(obj)
+	}
+
+	inner := indent + "  "
+	var out strings.Builder
+	switch obj := obj.(type) {
+	case *object.Array:
+		out.WriteString("[\n")
+		for _, element := range obj.Elements {
+			out.WriteString(inner + p.format(element, inner) + ",\n")
+		}
+		out.WriteString(indent + "]")
+	case *object.Hash:
+		out.WriteString("{\n")
+		for _, pair := range sortedPairs(obj) {
+			out.WriteString(inner + p.inline(pair.Key) + ": " + p.format(pair.Value, inner) + ",\n")
+		}
+		out.WriteString(indent + "}")
+	default:
+		return p.inline(obj)
+	}
+	return out.String()
+}
+
+// inline 把obj格式化为一行
+func (p *valuePrinter) inline(obj object.Object) string {
+	switch obj := obj.(type) {
+	case nil:
+		return p.paint(colorGray, "null")
+	case *object.Null:
+		return p.paint(colorGray, "null")
+	case *object.Integer:
+		return p.paint(colorYellow, strconv.FormatInt(obj.Value, 10))
+	case *object.Boolean:
+		return p.paint(colorYellow, strconv.FormatBool(obj.Value))
+	case *object.String:
+		return p.paint(colorGreen, strconv.Quote(obj.Value))
+	case *object.Array:
+		elements := make([]string, len(obj.Elements))
+		for i, element := range obj.Elements {
+			elements[i] = p.inline(element)
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	case *object.Hash:
+		var pairs []string
+		for _, pair := range sortedPairs(obj) {
+			pairs = append(pairs, p.inline(pair.Key)+": "+p.inline(pair.Value))
+		}
+		return "{" + strings.Join(pairs, ", ") + "}"
+	case *object.Function:
+		return p.paint(colorCyan, describeFunction(obj.Name, len(obj.Parameters)))
+	case *object.Closure:
+		return p.paint(colorCyan, describeFunction(obj.Fn.Name, obj.Fn.NumParameters))
+	case *object.CompiledFunction:
+		return p.paint(colorCyan, describeFunction(obj.Name, obj.NumParameters))
+	case *object.Builtin:
+		return p.paint(colorCyan, fmt.Sprintf("<builtin %s>", obj.Name))
+	case *object.Error:
+		return p.paint(colorRed, obj.Inspect())
+	default:
+		return obj.Inspect()
+	}
+}
+
+func describeFunction(name string, arity int) string {
+	if name == "" {
+		return fmt.Sprintf("<fn/%d>", arity)
+	}
+	return fmt.Sprintf("<fn %s/%d>", name, arity)
+}
+
+// sortedPairs 返回按键排序的键值对：整数按大小，其余按类型和格式化后的文本
+func sortedPairs(h *object.Hash) []object.HashPair {
+	pairs := make([]object.HashPair, 0, len(h.Pairs))
+	for _, pair := range h.Pairs {
+		pairs = append(pairs, pair)
+	}
+	plain := &valuePrinter{}
+	sort.Slice(pairs, func(i, j int) bool {
+		a, b := pairs[i].Key, pairs[j].Key
+		if a.Type() != b.Type() {
+			return a.Type() < b.Type()
+		}
+		if a, ok := a.(*object.Integer); ok {
+			return a.Value < b.(*object.Integer).Value
+		}
+		return plain.inline(a) < plain.inline(b)
+	})
+	return pairs
+}
@@ -0,0 +1,135 @@
+package repl
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	historyFile = ".monkey_history" // 位于用户主目录
+	maxHistory  = 1000              // 保留的历史记录条数
+)
+
+// History 输入历史，每段完整的输入一条，追加保存到文件中。
+// 多行的输入在文件中除最后一行外都以反斜杠结尾；文件超过maxHistory条时用保留的历史记录重写
+type History struct {
+	entries []string
+	path    string // 为空时不保存
+	records int    // 文件中的记录条数
+}
+
+// defaultHistoryPath 返回~/.monkey_history，无法确定主目录时返回空
+func defaultHistoryPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, historyFile)
+}
+
+// LoadHistory 读取path中的历史记录；文件不存在时返回空的历史记录
+func LoadHistory(path string) (*History, error) {
+	h := &History{path: path}
+	if path == "" {
+		return h, nil
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return h, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	var continued []string // 以反斜杠结尾的行，属于同一条多行的输入
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasSuffix(line, "\\") {
+			continued = append(continued, strings.TrimSuffix(line, "\\"))
+			continue
+		}
+		if entry := strings.Join(append(continued, line), "\n"); entry != "" {
+			h.entries = append(h.entries, entry)
+		}
+		continued = nil
+	}
+	h.records = len(h.entries)
+	if len(h.entries) > maxHistory {
+		h.entries = h.entries[len(h.entries)-maxHistory:]
+	}
+	return h, scanner.Err()
+}
+
+// Add 记录一段输入并追加到文件中，空行和与上一条相同的输入不记录
+func (h *History) Add(line string) error {
+	if line == "" || (len(h.entries) > 0 && h.entries[len(h.entries)-1] == line) {
+		return nil
+	}
+	h.entries = append(h.entries, line)
+	if len(h.entries) > maxHistory {
+		h.entries = h.entries[1:]
+	}
+	if h.path == "" {
+		return nil
+	}
+	if h.records >= maxHistory {
+		return h.rewrite()
+	}
+
+	f, err := os.OpenFile(h.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(encodeEntry(line)); err != nil {
+		return err
+	}
+	h.records++
+	return nil
+}
+
+// rewrite 用保留的历史记录重写文件。先写入同目录下的临时文件再替换，中途出错时原文件不受影响
+func (h *History) rewrite() error {
+	f, err := os.CreateTemp(filepath.Dir(h.path), historyFile+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	w := bufio.NewWriter(f)
+	for _, entry := range h.entries {
+		w.WriteString(encodeEntry(entry))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), h.path); err != nil {
+		return err
+	}
+	h.records = len(h.entries)
+	return nil
+}
+
+// encodeEntry 返回一条记录在文件中的内容，多行的输入除最后一行外以反斜杠结尾
+func encodeEntry(entry string) string {
+	return strings.ReplaceAll(entry, "\n", "\\\n") + "\n"
+}
+
+// Len 历史记录的条数
+func (h *History) Len() int {
+	return len(h.entries)
+}
+
+// At 返回第i条历史记录，0为最早的一条
+func (h *History) At(i int) string {
+	return h.entries[i]
+}
@@ -0,0 +1,103 @@
+package repl
+
+import (
+	"Monkey/lexer"
+	"Monkey/token"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// errInterrupted 用户按下Ctrl-C，放弃当前输入
+var errInterrupted = errors.New("interrupted")
+
+// lineReader 按行读取输入
+type lineReader interface {
+	// ReadLine 显示prompt并读取一行，不包括换行符；输入结束时返回io.EOF
+	ReadLine(prompt string) (string, error)
+	// AddHistory 记录一段完整的输入
+	AddHistory(entry string)
+	Close() error
+}
+
+// newLineReader 输入为终端时使用支持行编辑和历史记录的读取器，否则逐行读取
+func newLineReader(in io.Reader, out io.Writer) lineReader {
+	if f, ok := in.(*os.File); ok && isTerminal(f) {
+		history, err := LoadHistory(defaultHistoryPath())
+		if err != nil {
+			fmt.Fprintf(out, "could not load history: %s\n", err)
+		}
+		if r, err := newTerminalReader(f, out, history); err == nil {
+			return r
+		}
+	}
+	return &scannerReader{scanner: bufio.NewScanner(in), out: out}
+}
+
+// scannerReader 非终端输入的读取器，用于管道和测试
+type scannerReader struct {
+	scanner *bufio.Scanner
+	out     io.Writer
+}
+
+func (r *scannerReader) ReadLine(prompt string) (string, error) {
+	io.WriteString(r.out, prompt)
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return r.scanner.Text(), nil
+}
+
+func (r *scannerReader) AddHistory(string) {}
+
+func (r *scannerReader) Close() error {
+	return nil
+}
+
+// readInput 读取一段完整的输入：括号未闭合时以续行提示符继续读取。
+// 读取到的输入作为一条历史记录
+func readInput(r lineReader) (string, error) {
+	var lines []string
+	prompt := PROMPT
+	for {
+		line, err := r.ReadLine(prompt)
+		if err != nil {
+			if err == io.EOF && len(lines) > 0 {
+				// 输入在括号闭合前结束，交给解析器报告错误
+				input := strings.Join(lines, "\n")
+				r.AddHistory(input)
+				return input, nil
+			}
+			return "", err
+		}
+		lines = append(lines, line)
+
+		input := strings.Join(lines, "\n")
+		if !needsMoreInput(input) {
+			r.AddHistory(input)
+			return input, nil
+		}
+		prompt = CONTINUATION_PROMPT
+	}
+}
+
+// needsMoreInput 输入中的圆括号、方括号或大括号尚未闭合时返回true
+func needsMoreInput(input string) bool {
+	depth := 0
+	l := lexer.New(input)
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		switch tok.Type {
+		case token.LPAREN, token.LBRACE, token.LBARACKET:
+			depth++
+		case token.RPAREN, token.RBRACE, token.RBARACKET:
+			depth--
+		}
+	}
+	return depth > 0
+}
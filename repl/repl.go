@@ -1,18 +1,27 @@
 package repl
 
 import (
+	"Monkey/ast"
 	"Monkey/compiler"
+	"Monkey/evaluator"
 	"Monkey/lexer"
 	"Monkey/object"
 	"Monkey/parser"
 	"Monkey/vm"
-	"bufio"
+	"context"
 	"fmt"
 	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
 )
 
 const PROMPT = ">>"
 
+// CONTINUATION_PROMPT 括号未闭合时，继续输入的提示符
+const CONTINUATION_PROMPT = ".."
+
 // repl/repl.go
 
 const MONKEY_FACE = `            __,__
@@ -28,56 +37,228 @@ const MONKEY_FACE = `            __,__
            '-----'
 `
 
+// Engine 执行REPL输入的引擎
+type Engine int
+
+const (
+	EngineVM   Engine = iota // 编译为字节码后由虚拟机执行
+	EngineEval               // 由求值器直接遍历语法树执行
+)
+
+// Start 启动使用虚拟机的REPL，见StartEngine
 func Start(in io.Reader, out io.Writer) {
+	StartEngine(in, out, EngineVM)
+}
+
+// StartEngine 启动使用engine执行输入的REPL。括号未闭合时继续读取下一行；
+// in为终端时支持行编辑，历史记录保存在~/.monkey_history。
+// 以冒号开头的输入为元命令，见commands
+func StartEngine(in io.Reader, out io.Writer, engine Engine) {
 	io.WriteString(out, MONKEY_FACE)
-	scanner := bufio.NewScanner(in)
-	constants := []object.Object{}
-	globals := make([]object.Object, vm.GlobalsSize)
-	symbolTable := compiler.NewSymbolTable()
-	//env := object.NewEnvironment()
+	reader := newLineReader(in, out)
+	defer reader.Close()
+
+	s := newSession(out, engine)
 	for {
-		fmt.Fprintf(out, PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
+		line, err := readInput(reader)
+		if err == errInterrupted {
+			continue
+		}
+		if err != nil {
 			return
 		}
-		line := scanner.Text()
-		l := lexer.New(line)
-		p := parser.New(l)
 
-		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
-			continue
-		}
+		s.interruptible(func() {
+			if strings.HasPrefix(strings.TrimSpace(line), ":") {
+				s.command(strings.TrimSpace(line))
+				return
+			}
+			s.run(line, "")
+		})
+	}
+}
 
-		comp := compiler.NewWithState(symbolTable, constants)
-		err := comp.Compile(program)
-		if err != nil {
-			fmt.Fprintf(out, "Woops!Compilation fail:\n%s\n", err)
-			continue
+// session REPL会话的状态，在多次输入之间保留全局变量
+type session struct {
+	out    io.Writer
+	engine Engine
+	color  bool
+	host   *object.Host
+	ctx    context.Context // 执行输入的上下文，见interruptible
+
+	// 虚拟机的状态
+	constants   []object.Object
+	globals     []object.Object
+	symbolTable *compiler.SymbolTable
+
+	// 求值器的状态
+	env *object.Environment
+}
+
+func newSession(out io.Writer, engine Engine) *session {
+	s := &session{
+		out:    out,
+		engine: engine,
+		color:  colorEnabled(out),
+		host:   &object.Host{Stdout: out, Capabilities: object.DefaultHost.Capabilities},
+		ctx:    context.Background(),
+	}
+	s.reset()
+	return s
+}
+
+// interruptible 执行f，期间的Ctrl-C（SIGINT）中断正在执行的脚本而不结束REPL
+func (s *session) interruptible(f func()) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	s.ctx = ctx
+	defer func() { s.ctx = context.Background() }()
+	f()
+}
+
+// reset 清空所有全局变量
+func (s *session) reset() {
+	s.constants = []object.Object{}
+	s.globals = []object.Object{}
+	s.symbolTable = compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		s.symbolTable.DefineBuiltin(i, v.Name)
+	}
+
+	s.env = object.NewEnvironment()
+	s.env.SetHost(s.host)
+}
+
+// run 执行src并显示结果，file为src所在文件的绝对路径，交互输入时为空。
+// 只有最后一条语句是表达式时才显示结果，let等语句不产生值
+func (s *session) run(src string, file string) {
+	l := lexer.New(src)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		s.printParserErrors(p.Errors())
+		return
+	}
+
+	var result object.Object
+	var ok bool
+	if s.engine == EngineEval {
+		result, ok = s.eval(program, file)
+	} else {
+		result, ok = s.execute(program, file)
+	}
+	if !ok {
+		return
+	}
+
+	if !producesValue(program) {
+		return
+	}
+	if result, ok := result.(*object.Error); ok {
+		s.printError("ERROR: %s", result.Message)
+		return
+	}
+	fmt.Fprintln(s.out, formatValue(result, s.color))
+}
+
+// eval 用求值器执行program，出错时输出错误并返回false
+func (s *session) eval(program *ast.Program, file string) (object.Object, bool) {
+	s.env.SetDir(importDir(file))
+	if file != "" {
+		// 执行的文件也在加载中，它出现在循环导入中时才能被检测到
+		modules := s.env.Modules()
+		if err := modules.Begin(file); err != nil {
+			s.printError("ERROR: %s", err)
+			return nil, false
 		}
-		code := comp.Bytecode()
-		machine := vm.NewWithGlobalsStore(code, globals)
-		err = machine.Run()
-		if err != nil {
-			fmt.Fprintf(out, "Woops!Executing bytecode failed:\n%s\n", err)
-			continue
+		defer modules.End(file, nil)
+	}
+	result, err := evaluator.EvalContext(s.ctx, program, s.env, object.Limits{})
+	if err != nil {
+		s.printError("ERROR: %s", err)
+		return nil, false
+	}
+	// 求值器遇到错误即停止，不论最后一条语句是什么都要显示错误
+	if result, ok := result.(*object.Error); ok {
+		s.printError("ERROR: %s", result.Message)
+		return nil, false
+	}
+	return result, true
+}
+
+// importDir 返回import中相对路径所相对的目录：文件所在的目录，交互输入时为当前工作目录
+func importDir(file string) string {
+	if file == "" {
+		return ""
+	}
+	return filepath.Dir(file)
+}
+
+// execute 编译program并用虚拟机执行，出错时输出错误并返回false。
+// 在符号表的副本上编译，编译失败时其中定义的变量不会留在会话中
+func (s *session) execute(program *ast.Program, file string) (object.Object, bool) {
+	symbolTable := s.symbolTable.Copy()
+	comp := compiler.NewWithState(symbolTable, s.constants)
+	comp.SetDir(importDir(file))
+	if file != "" {
+		comp.SetFile(file)
+	}
+	comp.SetHost(s.host)
+	err := comp.Compile(program)
+	if err != nil {
+		s.printError("Woops!Compilation fail:\n%s", err)
+		return nil, false
+	}
+	code := comp.Bytecode()
+	if err := vm.Verify(code); err != nil {
+		s.printError("Woops!Compilation fail:\n%s", err)
+		return nil, false
+	}
+	s.symbolTable, s.constants = symbolTable, code.Constants
+
+	s.globals = vm.GrowGlobals(s.globals, code.NumGlobals)
+	machine := vm.NewWithGlobalsStore(code, s.globals)
+	defer machine.Release()
+	machine.SetHost(s.host)
+	err = machine.Run(s.ctx)
+	if err != nil {
+		// 出错后没有执行到的let定义的变量没有值，设为null以免之后的输入读到nil。
+		// 缓存模块的隐藏变量为nil表示模块尚未加载，保持不变
+		for _, symbol := range s.symbolTable.Symbols() {
+			if s.globals[symbol.Index] == nil && !strings.Contains(symbol.Name, " ") {
+				s.globals[symbol.Index] = vm.Null
+			}
 		}
+		s.printError("ERROR: %s", err)
+		return nil, false
+	}
+	return machine.LastPoppedStackElem(), true
+}
 
-		stackTop := machine.LastPoppedStackElem()
-		io.WriteString(out, stackTop.Inspect())
-		io.WriteString(out, "\n")
-		//evaluated := evaluator.Eval(program, env)
-		//if evaluated != nil {
-		//	io.WriteString(out, evaluated.Inspect())
-		//	io.WriteString(out, "\n")
-		//}
-		//for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
-		//	fmt.Fprintf(out, "%+v\n", tok)
-		//}
+// producesValue 判断program执行后是否有值可以显示：最后一条语句是表达式。
+// 虚拟机执行let等语句后，LastPoppedStackElem是之前残留在栈上的值，不能显示
+func producesValue(program *ast.Program) bool {
+	if len(program.Statements) == 0 {
+		return false
+	}
+	_, ok := program.Statements[len(program.Statements)-1].(*ast.ExpressionStatement)
+	return ok
+}
 
+// printError 输出错误信息，终端中显示为红色
+func (s *session) printError(format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	if s.color {
+		msg = colorRed + msg + colorReset
 	}
+	fmt.Fprintln(s.out, msg)
+}
+
+func (s *session) printParserErrors(errors []string) {
+	var out strings.Builder
+	printParserErrors(&out, errors)
+	s.printError("%s", strings.TrimSuffix(out.String(), "\n"))
 }
 
 func printParserErrors(out io.Writer, errors []string) {
@@ -0,0 +1,363 @@
+package repl
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestNeedsMoreInput(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"let a = 1;", false},
+		{"let f = fn(x) {", true},
+		{"let f = fn(x) {\n x", true},
+		{"let f = fn(x) {\n x\n}", false},
+		{"[1, 2,", true},
+		{"add(1,", true},
+		{`"{"`, false},
+		{"// {", false},
+		{"}", false},
+	}
+
+	for _, tt := range tests {
+		if got := needsMoreInput(tt.input); got != tt.expected {
+			t.Errorf("needsMoreInput(%q) = %t, want %t", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestMultiLineInput(t *testing.T) {
+	in := strings.NewReader("let add = fn(a, b) {\n  a + b\n};\nadd(\n1,\n2)\n")
+	var out strings.Builder
+	Start(in, &out)
+
+	output := strings.TrimPrefix(out.String(), MONKEY_FACE)
+	if strings.Count(output, CONTINUATION_PROMPT) != 4 {
+		t.Fatalf("expected 4 continuation prompts, got output %q", output)
+	}
+	if !strings.Contains(output, "3\n") {
+		t.Fatalf("multi-line call not evaluated, got output %q", output)
+	}
+	if strings.Contains(output, "parser errors") {
+		t.Fatalf("unexpected parser errors, got output %q", output)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), historyFile)
+
+	h, err := LoadHistory(path)
+	if err != nil {
+		t.Fatalf("LoadHistory error: %s", err)
+	}
+	for _, line := range []string{"let a = 1;", "", "a", "a", "a + 1"} {
+		if err := h.Add(line); err != nil {
+			t.Fatalf("Add error: %s", err)
+		}
+	}
+
+	h, err = LoadHistory(path)
+	if err != nil {
+		t.Fatalf("LoadHistory error: %s", err)
+	}
+	expected := []string{"let a = 1;", "a", "a + 1"}
+	if h.Len() != len(expected) {
+		t.Fatalf("wrong number of entries. want=%d, got=%d", len(expected), h.Len())
+	}
+	for i, want := range expected {
+		if h.At(i) != want {
+			t.Errorf("entry %d wrong. want=%q, got=%q", i, want, h.At(i))
+		}
+	}
+}
+
+func TestHistoryTruncatesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), historyFile)
+
+	h, err := LoadHistory(path)
+	if err != nil {
+		t.Fatalf("LoadHistory error: %s", err)
+	}
+	total := maxHistory + 10
+	for i := 0; i < total; i++ {
+		if err := h.Add(strconv.Itoa(i)); err != nil {
+			t.Fatalf("Add error: %s", err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != maxHistory {
+		t.Fatalf("wrong number of lines in file. want=%d, got=%d", maxHistory, len(lines))
+	}
+	if lines[0] != strconv.Itoa(total-maxHistory) || lines[len(lines)-1] != strconv.Itoa(total-1) {
+		t.Fatalf("file does not keep the last entries. first=%q, last=%q", lines[0], lines[len(lines)-1])
+	}
+}
+
+func TestTerminalLineEditing(t *testing.T) {
+	history := &History{entries: []string{"first", "second"}}
+	keys := strings.Join([]string{
+		"ab\x1b[Dc\r",           // 左移后插入：acb
+		"\x1b[A\x1b[A\x1b[A\r",  // 向上三次：first
+		"\x1b[A\x1b[B\x1b[B\r",  // 向上再向下回到正在编辑的空行
+		"xyz\x01\x1b[3~\x05!\r", // Ctrl-A后删除第一个字符，Ctrl-E后追加
+		"oops\x7f\x7f\r",        // 退格
+		"gone\x03",              // Ctrl-C
+		"\x04",                  // 空行上的Ctrl-D
+	}, "")
+	// 每次读取一行时进入原始模式，返回前恢复
+	var entered, restored int
+	r := &terminalReader{
+		in:  bufio.NewReader(strings.NewReader(keys)),
+		out: io.Discard,
+		raw: func() (func() error, error) {
+			entered++
+			return func() error { restored++; return nil }, nil
+		},
+		history: history,
+	}
+
+	expected := []string{"acb", "first", "", "yz!", "oo"}
+	for _, want := range expected {
+		line, err := r.ReadLine(PROMPT)
+		if err != nil {
+			t.Fatalf("ReadLine error: %s", err)
+		}
+		if line != want {
+			t.Fatalf("wrong line. want=%q, got=%q", want, line)
+		}
+	}
+	if _, err := r.ReadLine(PROMPT); err != errInterrupted {
+		t.Fatalf("expected errInterrupted, got=%v", err)
+	}
+	if _, err := r.ReadLine(PROMPT); err != io.EOF {
+		t.Fatalf("expected io.EOF, got=%v", err)
+	}
+	if entered != len(expected)+2 || restored != entered {
+		t.Fatalf("terminal not restored after each line. entered=%d, restored=%d", entered, restored)
+	}
+}
+
+func TestHistoryMultiLineInput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), historyFile)
+	history, err := LoadHistory(path)
+	if err != nil {
+		t.Fatalf("LoadHistory error: %s", err)
+	}
+	r := &terminalReader{
+		in:      bufio.NewReader(strings.NewReader("let f = fn() {\r1\r}\r1 + 1\r")),
+		out:     io.Discard,
+		raw:     func() (func() error, error) { return func() error { return nil }, nil },
+		history: history,
+	}
+
+	expected := []string{"let f = fn() {\n1\n}", "1 + 1"}
+	for _, want := range expected {
+		input, err := readInput(r)
+		if err != nil {
+			t.Fatalf("readInput error: %s", err)
+		}
+		if input != want {
+			t.Fatalf("wrong input. want=%q, got=%q", want, input)
+		}
+	}
+
+	// 多行的输入作为一条记录，重新读取文件后不变
+	history, err = LoadHistory(path)
+	if err != nil {
+		t.Fatalf("LoadHistory error: %s", err)
+	}
+	if history.Len() != len(expected) {
+		t.Fatalf("wrong number of entries. want=%d, got=%d", len(expected), history.Len())
+	}
+	for i, want := range expected {
+		if history.At(i) != want {
+			t.Errorf("entry %d wrong. want=%q, got=%q", i, want, history.At(i))
+		}
+	}
+}
+
+func TestCommands(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "lib.mk")
+	if err := os.WriteFile(file, []byte("let double = fn(x) { x * 2 };\nlet y = double(4);\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input    string
+		contains []string
+		excludes []string
+	}{
+		{":tokens let a = 1;", []string{"1:1\tLET        \"let\"", "1:9\tINT        \"1\""}, nil},
+		{":ast 1 + 2", []string{"Program", "InfixExpression +", "IntegerLiteral 1"}, nil},
+		{":bytecode let f = fn(x) { x };", []string{"OpClosure", "constant 0: fn f (params=1, locals=1)"}, nil},
+		{"let a = 5;\n:env", []string{"a = 5"}, []string{"import "}},
+		{":load " + file + "\ny", []string{">>8\n"}, nil},
+		{"let a = 5;\n:reset\na", []string{"state cleared", "undefined variable: a"}, nil},
+		{":nope", []string{"unknown command :nope"}, nil},
+		{":help", []string{":load <file>", ":reset"}, nil},
+	}
+
+	for _, tt := range tests {
+		var out strings.Builder
+		Start(strings.NewReader(tt.input+"\n"), &out)
+		output := strings.TrimPrefix(out.String(), MONKEY_FACE)
+		for _, want := range tt.contains {
+			if !strings.Contains(output, want) {
+				t.Errorf("input %q: output missing %q, got %q", tt.input, want, output)
+			}
+		}
+		for _, unwanted := range tt.excludes {
+			if strings.Contains(output, unwanted) {
+				t.Errorf("input %q: output contains %q, got %q", tt.input, unwanted, output)
+			}
+		}
+	}
+}
+
+func TestLoadImportCycle(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.mk": `println("running a"); let b = import("b.mk");`,
+		"b.mk": `let a = import("a.mk");`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, b := filepath.Join(dir, "a.mk"), filepath.Join(dir, "b.mk")
+	cycle := "import cycle: " + a + " -> " + b + " -> " + a
+
+	for _, engine := range []Engine{EngineVM, EngineEval} {
+		var out strings.Builder
+		newSession(&out, engine).load(a)
+		if !strings.Contains(out.String(), cycle) {
+			t.Errorf("engine %d: output missing %q, got %q", engine, cycle, out.String())
+		}
+		if strings.Count(out.String(), "running a") > 1 {
+			t.Errorf("engine %d: entry file evaluated more than once. output=%q", engine, out.String())
+		}
+	}
+}
+
+func TestInputAfterError(t *testing.T) {
+	tests := []struct {
+		inputs   []string
+		expected string
+	}{
+		{[]string{"let a = 1; let b = nope;", "b"}, "undefined variable: b"},
+		{[]string{"let a = 1; let b = nope;", "let a = 2; a + 1"}, "3\n"},
+		{[]string{"let c = len(1);", "c"}, "null\n"},
+		{[]string{"let c = len(1);", "c + 1"}, "ERROR: unsupport types for binary operation: NULL INTEGER\n"},
+	}
+
+	for _, tt := range tests {
+		var out strings.Builder
+		s := newSession(&out, EngineVM)
+		for _, input := range tt.inputs {
+			out.Reset()
+			s.run(input, "")
+		}
+		if !strings.Contains(out.String(), tt.expected) {
+			t.Errorf("inputs %q: output missing %q, got %q", tt.inputs, tt.expected, out.String())
+		}
+	}
+}
+
+func TestMatchBindingsScopedToArm(t *testing.T) {
+	// 其他分支绑定的名字不可见，两种引擎都报告未定义
+	input := "match ([1, 2]) { [x, 3] => 1, _ => x }"
+	expected := map[Engine]string{
+		EngineVM:   "Woops!Compilation fail:\nundefined variable: x\n",
+		EngineEval: "ERROR: identifier not found: x\n",
+	}
+	for engine, want := range expected {
+		var out strings.Builder
+		newSession(&out, engine).run(input, "")
+		if out.String() != want {
+			t.Errorf("engine %d: wrong output.\nwant=%q\ngot =%q", engine, want, out.String())
+		}
+	}
+}
+
+func TestResultDisplay(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 5;", ""},
+		{"let x = 5; x", "5\n"},
+		{"let x = 5; x; let y = 6;", ""},
+		{`"hi"`, "\"hi\"\n"},
+		{`let s = "mon"; s + "key"`, "\"monkey\"\n"},
+		{`[1, "a", true, [2]]`, "[1, \"a\", true, [2]]\n"},
+		{`{"b": 2, "a": 1, 10: 3, 2: 4}`, "{2: 4, 10: 3, \"a\": 1, \"b\": 2}\n"},
+		{"let add = fn(a, b) { a + b }; add", "<fn add/2>\n"},
+		{"fn(x) { x }", "<fn/1>\n"},
+		{"len", "<builtin len>\n"},
+		{"if (false) { 1 }", "null\n"},
+		{"fn() {}()", "null\n"},
+		{`len(1)`, "ERROR: argument to `len` not supported, got INTEGER\n"},
+		{"let x = 5; match ([1, 2]) { [x, 3] => 1, _ => x }", "5\n"},
+		{"let x = 5; match ([1, 2]) { [x, 2] => x }; x", "5\n"},
+		{"match ([1, 2]) { [x, 2] if (x > 5) => x, [y, 2] => y }", "1\n"},
+		{"let f = match (3) { n => fn() { n } }; f()", "3\n"},
+		{`try { import("missing.mk") } catch (e) { e["type"] }`, "\"RuntimeError\"\n"},
+		{`let f = fn() { import("missing.mk") }; try { f() } catch (e) { 1 }`, "1\n"},
+		{`[1111111111, 2222222222, 3333333333, 4444444444, 5555555555, 6666666666, 7777777777]`,
+			"[\n  1111111111,\n  2222222222,\n  3333333333,\n  4444444444,\n  5555555555,\n  6666666666,\n  7777777777,\n]\n"},
+	}
+
+	for _, engine := range []Engine{EngineVM, EngineEval} {
+		for _, tt := range tests {
+			var out strings.Builder
+			s := newSession(&out, engine)
+			s.run(tt.input, "")
+			if out.String() != tt.expected {
+				t.Errorf("engine %d, input %q: wrong output.\nwant=%q\ngot =%q", engine, tt.input, tt.expected, out.String())
+			}
+		}
+	}
+}
+
+func TestColorizedErrors(t *testing.T) {
+	for _, engine := range []Engine{EngineVM, EngineEval} {
+		var out strings.Builder
+		s := newSession(&out, engine)
+		s.color = true
+		s.run("-true", "")
+		if !strings.HasPrefix(out.String(), colorRed+"ERROR: ") || !strings.HasSuffix(out.String(), colorReset+"\n") {
+			t.Errorf("engine %d: error not colorized, got %q", engine, out.String())
+		}
+
+		out.Reset()
+		s.run("1", "")
+		if out.String() != colorYellow+"1"+colorReset+"\n" {
+			t.Errorf("engine %d: value not colorized, got %q", engine, out.String())
+		}
+	}
+}
+
+func TestPrintlnWritesToSession(t *testing.T) {
+	for _, engine := range []Engine{EngineVM, EngineEval} {
+		var out strings.Builder
+		s := newSession(&out, engine)
+		s.run(`println("hello");`, "")
+		if !strings.HasPrefix(out.String(), "hello\n") {
+			t.Errorf("engine %d: println output missing, got %q", engine, out.String())
+		}
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package repl
+
+import (
+	"errors"
+	"os"
+)
+
+// 其它平台不支持行编辑，逐行读取输入
+
+func isTerminal(f *os.File) bool {
+	return false
+}
+
+func makeRaw(f *os.File) (func() error, error) {
+	return nil, errors.New("raw mode not supported")
+}
@@ -0,0 +1,232 @@
+package repl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// 行编辑中使用的控制字符
+const (
+	keyCtrlA     = 1
+	keyCtrlB     = 2
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyCtrlF     = 6
+	keyBackspace = 8
+	keyCtrlK     = 11
+	keyEnter     = 13
+	keyCtrlN     = 14
+	keyCtrlP     = 16
+	keyCtrlU     = 21
+	keyEscape    = 27
+	keyDelete    = 127
+)
+
+// terminalReader 终端的读取器，读取一行时切换到原始模式自行处理行编辑：
+// 左右方向键、Home/End、Ctrl-A/E移动光标，上下方向键浏览历史记录，
+// Ctrl-C放弃当前输入，空行上的Ctrl-D结束输入。
+// 读取完成后恢复终端原来的模式，执行输入时Ctrl-C可以中断脚本
+type terminalReader struct {
+	in      *bufio.Reader
+	out     io.Writer
+	raw     func() (restore func() error, err error) // 切换到原始模式，返回恢复原模式的函数
+	history *History
+}
+
+func newTerminalReader(f *os.File, out io.Writer, history *History) (*terminalReader, error) {
+	// 先确认终端支持原始模式，不支持时由调用方改为逐行读取
+	restore, err := makeRaw(f)
+	if err != nil {
+		return nil, err
+	}
+	if err := restore(); err != nil {
+		return nil, err
+	}
+	raw := func() (func() error, error) { return makeRaw(f) }
+	return &terminalReader{in: bufio.NewReader(f), out: out, raw: raw, history: history}, nil
+}
+
+func (r *terminalReader) Close() error {
+	return nil
+}
+
+// AddHistory 记录一段完整的输入，多行的输入作为一条记录
+func (r *terminalReader) AddHistory(entry string) {
+	if err := r.history.Add(entry); err != nil {
+		fmt.Fprintf(r.out, "could not save history: %s\n", err)
+	}
+}
+
+// lineEditor 正在编辑的一行
+type lineEditor struct {
+	prompt string
+	buf    []rune
+	pos    int // 光标在buf中的位置
+}
+
+func (r *terminalReader) ReadLine(prompt string) (string, error) {
+	restore, err := r.raw()
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+
+	e := &lineEditor{prompt: prompt}
+	historyIndex := r.history.Len() // 等于Len时表示正在编辑新的一行
+	var pending string              // 浏览历史记录前正在编辑的内容
+	r.render(e)
+
+	for {
+		ch, _, err := r.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch ch {
+		case keyEnter, '\n':
+			io.WriteString(r.out, "\r\n")
+			return string(e.buf), nil
+		case keyCtrlC:
+			io.WriteString(r.out, "^C\r\n")
+			return "", errInterrupted
+		case keyCtrlD:
+			if len(e.buf) == 0 {
+				io.WriteString(r.out, "\r\n")
+				return "", io.EOF
+			}
+			e.deleteForward()
+		case keyBackspace, keyDelete:
+			e.deleteBackward()
+		case keyCtrlA:
+			e.pos = 0
+		case keyCtrlE:
+			e.pos = len(e.buf)
+		case keyCtrlB:
+			e.moveLeft()
+		case keyCtrlF:
+			e.moveRight()
+		case keyCtrlK:
+			e.buf = e.buf[:e.pos]
+		case keyCtrlU:
+			e.buf = e.buf[e.pos:]
+			e.pos = 0
+		case keyCtrlP, keyCtrlN:
+			historyIndex, pending = r.browse(e, historyIndex, pending, ch == keyCtrlP)
+		case keyEscape:
+			switch r.readEscape() {
+			case 'A':
+				historyIndex, pending = r.browse(e, historyIndex, pending, true)
+			case 'B':
+				historyIndex, pending = r.browse(e, historyIndex, pending, false)
+			case 'C':
+				e.moveRight()
+			case 'D':
+				e.moveLeft()
+			case 'H':
+				e.pos = 0
+			case 'F':
+				e.pos = len(e.buf)
+			case '3':
+				e.deleteForward()
+			}
+		default:
+			if ch >= ' ' {
+				e.insert(ch)
+			}
+		}
+		r.render(e)
+	}
+}
+
+// readEscape 读取方向键等转义序列，返回其中标识按键的字符，无法识别时返回0
+func (r *terminalReader) readEscape() rune {
+	ch, _, err := r.in.ReadRune()
+	if err != nil || (ch != '[' && ch != 'O') {
+		return 0
+	}
+	ch, _, err = r.in.ReadRune()
+	if err != nil {
+		return 0
+	}
+	if ch >= '0' && ch <= '9' {
+		// 形如ESC [ 3 ~ 的序列
+		for {
+			next, _, err := r.in.ReadRune()
+			if err != nil || next == '~' {
+				break
+			}
+		}
+	}
+	return ch
+}
+
+// browse 在历史记录中向前或向后移动一条，返回新的位置和暂存的编辑内容
+func (r *terminalReader) browse(e *lineEditor, index int, pending string, older bool) (int, string) {
+	if index == r.history.Len() {
+		pending = string(e.buf)
+	}
+	if older && index > 0 {
+		index--
+	} else if !older && index < r.history.Len() {
+		index++
+	} else {
+		return index, pending
+	}
+
+	if index == r.history.Len() {
+		e.buf = []rune(pending)
+	} else {
+		e.buf = []rune(r.history.At(index))
+	}
+	e.pos = len(e.buf)
+	return index, pending
+}
+
+// render 重新绘制当前行并把光标放到正确的位置
+func (r *terminalReader) render(e *lineEditor) {
+	var out strings.Builder
+	// 从历史记录中取出的多行输入显示在一行中，换行显示为↵
+	out.WriteString("\r" + e.prompt + strings.ReplaceAll(string(e.buf), "\n", "↵") + "\x1b[K")
+	if back := len(e.buf) - e.pos; back > 0 {
+		fmt.Fprintf(&out, "\x1b[%dD", back)
+	}
+	io.WriteString(r.out, out.String())
+}
+
+func (e *lineEditor) insert(ch rune) {
+	e.buf = append(e.buf, 0)
+	copy(e.buf[e.pos+1:], e.buf[e.pos:])
+	e.buf[e.pos] = ch
+	e.pos++
+}
+
+func (e *lineEditor) deleteBackward() {
+	if e.pos == 0 {
+		return
+	}
+	e.buf = append(e.buf[:e.pos-1], e.buf[e.pos:]...)
+	e.pos--
+}
+
+func (e *lineEditor) deleteForward() {
+	if e.pos == len(e.buf) {
+		return
+	}
+	e.buf = append(e.buf[:e.pos], e.buf[e.pos+1:]...)
+}
+
+func (e *lineEditor) moveLeft() {
+	if e.pos > 0 {
+		e.pos--
+	}
+}
+
+func (e *lineEditor) moveRight() {
+	if e.pos < len(e.buf) {
+		e.pos++
+	}
+}
@@ -5,6 +5,8 @@ type TokenType string
 type Token struct {
 	Type    TokenType
 	Literal string
+	Line    int // 词法单元起始位置所在行，从1开始
+	Column  int // 词法单元起始位置所在列，从1开始
 }
 
 // token/token.go
@@ -12,6 +14,7 @@ type Token struct {
 const (
 	ILLEGAL = "ILLEGAL"
 	EOF     = "EOF"
+	COMMENT = "COMMENT" // 行注释，不会出现在词法单元流中
 
 	// 标识符+字面量
 	IDENT = "IDENT" // add, foobar, x, y, ...
@@ -28,6 +31,8 @@ const (
 	NOT_EQ   = "!="
 	LT       = "<"
 	GT       = ">"
+	ARROW    = "=>"
+	ELLIPSIS = "..."
 	// 分隔符
 	COMMA     = ","
 	SEMICOLON = ";"
@@ -40,12 +45,16 @@ const (
 	// 关键字
 	FUNCTION = "FUNCTION"
 	LET      = "LET"
+	VAR      = "VAR"
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
 	TRUE     = "TRUE"
 	FALSE    = "FALSE"
 	STRING   = "STRING"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	MATCH    = "MATCH"
 	//array
 	LBARACKET = "["
 	RBARACKET = "]"
@@ -54,8 +63,12 @@ const (
 )
 
 var keywords = map[string]TokenType{
-	"fn":  FUNCTION,
-	"let": LET,
+	"fn":    FUNCTION,
+	"let":   LET,
+	"var":   VAR,
+	"try":   TRY,
+	"catch": CATCH,
+	"match": MATCH,
 }
 
 func LoopupIdent(s string) TokenType {
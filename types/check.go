@@ -0,0 +1,621 @@
+// Package types 对Monkey程序做静态类型检查，在运行之前发现类型不匹配。
+// 类型注解是可选的：未注解的参数由函数体中的用法推断，不支持泛型，
+// 检查完函数后仍无法确定的类型视为any，与任何类型兼容
+package types
+
+import (
+	"Monkey/ast"
+	"Monkey/token"
+	"fmt"
+	"sort"
+)
+
+// Diagnostic 一条检查结果
+type Diagnostic struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%d:%d: %s", d.Line, d.Column, d.Message)
+}
+
+// Check 检查program，返回按位置排序的诊断信息。未定义的标识符视为any，由vet报告
+func Check(program *ast.Program) []Diagnostic {
+	c := &checker{}
+	c.pushScope()
+	c.statements(program.Statements)
+
+	sort.SliceStable(c.diagnostics, func(i, j int) bool {
+		a, b := c.diagnostics[i], c.diagnostics[j]
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return c.diagnostics
+}
+
+// function 正在检查的函数
+type function struct {
+	result  Type // 返回值的类型注解，没有注解时为nil
+	returns Type // 未注解时return语句的值的类型
+}
+
+type checker struct {
+	unifier
+	// scopes 函数作用域，与vet一样只有函数会引入新的作用域
+	scopes      []map[string]Type
+	function    *function // 最外层为nil
+	level       int       // 函数的嵌套层数
+	diagnostics []Diagnostic
+}
+
+func (c *checker) report(tok token.Token, format string, args ...any) {
+	c.diagnostics = append(c.diagnostics, Diagnostic{
+		Line:    tok.Line,
+		Column:  tok.Column,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (c *checker) pushScope() {
+	c.scopes = append(c.scopes, make(map[string]Type))
+}
+
+func (c *checker) popScope() {
+	c.scopes = c.scopes[:len(c.scopes)-1]
+}
+
+func (c *checker) define(name string, t Type) {
+	c.scopes[len(c.scopes)-1][name] = t
+}
+
+func (c *checker) resolve(name string) (Type, bool) {
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		if t, ok := c.scopes[i][name]; ok {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+func (c *checker) fresh() *Var {
+	return &Var{level: c.level}
+}
+
+// statements 检查语句序列并返回其值的类型，以return结束时返回nil
+func (c *checker) statements(stmts []ast.Statement) Type {
+	var value Type = Null
+	for _, stmt := range stmts {
+		var t Type
+		switch stmt := stmt.(type) {
+		case *ast.LetStatement:
+			c.letStatement(stmt)
+			t = Null
+		case *ast.ReturnStatement:
+			c.returnStatement(stmt)
+		case *ast.ExpressionStatement:
+			t = c.expression(stmt.Expression, nil)
+		}
+		// return之后的语句不会执行，仍然检查其中的错误
+		if value != nil {
+			value = t
+		}
+	}
+	return value
+}
+
+func (c *checker) letStatement(node *ast.LetStatement) {
+	if node.Pattern != nil {
+		c.bindPattern(node.Pattern, c.typeOf(node.Value, nil))
+		return
+	}
+	if node.Name == nil {
+		return
+	}
+
+	var declared Type
+	if node.Type != nil {
+		declared = c.annotation(node.Type)
+	}
+	var t Type
+	if fn, ok := node.Value.(*ast.FunctionLiteral); ok && !node.Mutable() {
+		// let绑定的函数可以递归引用自身
+		t = c.functionLiteral(fn, node.Name.Value, declared)
+	} else {
+		t = c.typeOf(node.Value, declared)
+	}
+	if declared != nil {
+		if !c.unify(declared, t) {
+			c.report(position(node.Value), "cannot use %s as %s in declaration of %s", t, declared, node.Name.Value)
+		}
+		t = declared
+	}
+	c.define(node.Name.Value, t)
+}
+
+func (c *checker) returnStatement(node *ast.ReturnStatement) {
+	if c.function == nil || c.function.result == nil {
+		t := c.typeOf(node.ReturnValue, nil)
+		if c.function != nil {
+			c.function.returns = c.join(c.function.returns, t)
+		}
+		return
+	}
+	c.expect(node.ReturnValue, c.function.result, "return statement")
+}
+
+// expect 检查exp的类型可以用作want，context说明exp所在的位置
+func (c *checker) expect(exp ast.Expression, want Type, context string, args ...any) Type {
+	got := c.typeOf(exp, want)
+	if !c.unify(want, got) {
+		c.report(position(exp), "cannot use %s as %s in %s", got, want, fmt.Sprintf(context, args...))
+	}
+	return got
+}
+
+// typeOf 返回表达式的类型，不会产生值的表达式视为any
+func (c *checker) typeOf(exp ast.Expression, expected Type) Type {
+	if t := c.expression(exp, expected); t != nil {
+		return t
+	}
+	return Any
+}
+
+// expression 返回表达式的类型，以return结束的代码块返回nil。
+// expected为上下文期望的类型，用于推断函数字面量的参数，可以为nil
+func (c *checker) expression(exp ast.Expression, expected Type) Type {
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		return c.identifier(exp)
+	case *ast.IntegerLiteral:
+		return Int
+	case *ast.StringLiteral:
+		return String
+	case *ast.Boolean:
+		return Bool
+	case *ast.PrefixExpression:
+		return c.prefixExpression(exp)
+	case *ast.InfixExpression:
+		return c.infixExpression(exp)
+	case *ast.IfExpression:
+		c.typeOf(exp.Condition, nil)
+		consequence := c.expression(exp.Consequence, nil)
+		var alternative Type = Null
+		if exp.Alternative != nil {
+			alternative = c.expression(exp.Alternative, nil)
+		}
+		if consequence == nil && alternative == nil {
+			return nil
+		}
+		return c.join(consequence, alternative)
+	case *ast.BlockStatement:
+		return c.statements(exp.Statements)
+	case *ast.FunctionLiteral:
+		return c.functionLiteral(exp, "", expected)
+	case *ast.CallExpression:
+		return c.callExpression(exp)
+	case *ast.ArrayLiteral:
+		var element Type
+		for _, e := range exp.Elements {
+			element = c.join(element, c.typeOf(e, nil))
+		}
+		if element == nil {
+			element = c.fresh()
+		}
+		return &Array{Element: element}
+	case *ast.HashLiteral:
+		var key, value Type
+		for _, k := range exp.Keys {
+			key = c.join(key, c.typeOf(k, nil))
+			value = c.join(value, c.typeOf(exp.Pairs[k], nil))
+		}
+		if key == nil {
+			key, value = c.fresh(), c.fresh()
+		}
+		return &Hash{Key: key, Value: value}
+	case *ast.IndexExpression:
+		return c.indexExpression(exp)
+	case *ast.AssignExpression:
+		return c.assignExpression(exp)
+	case *ast.TryExpression:
+		block := c.expression(exp.Block, nil)
+		// 抛出的可以是任何值
+		if exp.Param != nil {
+			c.define(exp.Param.Value, Any)
+		}
+		handler := c.expression(exp.Handler, nil)
+		if block == nil && handler == nil {
+			return nil
+		}
+		return c.join(block, handler)
+	case *ast.MatchExpression:
+		return c.matchExpression(exp)
+	}
+	return Any
+}
+
+func (c *checker) identifier(node *ast.Identifier) Type {
+	if t, ok := c.resolve(node.Value); ok {
+		return t
+	}
+	if f := c.builtin(node.Value); f != nil {
+		return f
+	}
+	return Any
+}
+
+func (c *checker) prefixExpression(node *ast.PrefixExpression) Type {
+	t := c.typeOf(node.Right, nil)
+	if node.Operator == "-" {
+		if !c.unify(t, Int) {
+			c.report(node.Token, "invalid operation: operator - not defined on %s", t)
+		}
+		return Int
+	}
+	return Bool
+}
+
+func (c *checker) infixExpression(node *ast.InfixExpression) Type {
+	left, right := c.typeOf(node.Left, nil), c.typeOf(node.Right, nil)
+	switch node.Operator {
+	case "==", "!=":
+		// 任意两个值都可以比较，不同类型的值不相等，与两种引擎一致
+		return Bool
+	case "<", ">":
+		c.operands(node, left, right, Int)
+		return Bool
+	case "+":
+		return c.operands(node, left, right, Int, String)
+	default:
+		return c.operands(node, left, right, Int)
+	}
+}
+
+// operands 检查二元运算的两个操作数类型相同且为allowed之一，返回操作数的类型
+func (c *checker) operands(node *ast.InfixExpression, left, right Type, allowed ...Type) Type {
+	if !c.unify(left, right) {
+		c.report(node.Token, "invalid operation: mismatched types %s and %s for %s", left, right, node.Operator)
+		return Any
+	}
+	t := prune(left)
+	if t == Any {
+		t = prune(right)
+	}
+	if v, ok := t.(*Var); ok {
+		// 只有一种可能的类型时可以确定，+的两个操作数只能确定相同
+		if len(allowed) == 1 {
+			c.unify(v, allowed[0])
+			return allowed[0]
+		}
+		return v
+	}
+	if t == Any {
+		return Any
+	}
+	for _, a := range allowed {
+		if t == a {
+			return t
+		}
+	}
+	c.report(node.Token, "invalid operation: operator %s not defined on %s", node.Operator, t)
+	return Any
+}
+
+// functionLiteral 检查函数字面量。name不为空时在函数体之前定义，用于递归调用；
+// expected为期望的函数类型，用于推断未注解的参数和返回值
+func (c *checker) functionLiteral(node *ast.FunctionLiteral, name string, expected Type) Type {
+	c.level++
+	fn := &Function{Params: make([]Type, len(node.Parameters))}
+	for i := range node.Parameters {
+		if t := node.ParameterType(i); t != nil {
+			fn.Params[i] = c.annotation(t)
+		} else {
+			fn.Params[i] = c.fresh()
+		}
+	}
+	var declared Type
+	if node.ReturnType != nil {
+		declared = c.annotation(node.ReturnType)
+		fn.Result = declared
+	} else {
+		fn.Result = c.fresh()
+	}
+	if want, ok := prune(expected).(*Function); ok && len(want.Params) == len(fn.Params) {
+		// 不匹配时由调用方报告，这里只用于推断
+		for i := range fn.Params {
+			c.unify(fn.Params[i], want.Params[i])
+		}
+		c.unify(fn.Result, want.Result)
+	}
+	if name != "" {
+		c.define(name, fn)
+	}
+
+	outer := c.function
+	c.function = &function{result: declared}
+	c.pushScope()
+	for i, param := range node.Parameters {
+		c.define(param.Value, fn.Params[i])
+	}
+	var body Type = Null
+	if node.Body != nil {
+		body = c.statements(node.Body.Statements)
+	}
+	c.popScope()
+
+	if declared != nil {
+		if body != nil && !c.unify(declared, body) {
+			c.report(lastPosition(node), "cannot use %s as %s in return value", body, declared)
+		}
+	} else if result := c.join(c.function.returns, body); result != nil {
+		c.unify(fn.Result, result)
+	}
+	c.function = outer
+	c.level--
+	c.settle(fn, c.level+1)
+	return fn
+}
+
+func (c *checker) callExpression(node *ast.CallExpression) Type {
+	name := node.Function.String()
+	if ident, ok := node.Function.(*ast.Identifier); ok {
+		if _, defined := c.resolve(ident.Value); !defined {
+			switch ident.Value {
+			case "println", "import":
+				// 参数个数不固定，或者返回的模块无法静态确定
+				for _, arg := range node.Arguments {
+					c.typeOf(arg, nil)
+				}
+				if ident.Value == "println" {
+					return Null
+				}
+				return Any
+			case "len":
+				if len(node.Arguments) == 1 {
+					if t := c.typeOf(node.Arguments[0], nil); !hasLength(t) {
+						c.report(position(node.Arguments[0]), "cannot use %s as string or array in argument 1 to len", t)
+					}
+					return Int
+				}
+			}
+		}
+	}
+
+	callee := c.typeOf(node.Function, nil)
+	switch f := prune(callee).(type) {
+	case *Function:
+		if len(f.Params) != len(node.Arguments) {
+			c.report(position(node.Function), "wrong number of arguments in call to %s: want %d, got %d",
+				name, len(f.Params), len(node.Arguments))
+			for _, arg := range node.Arguments {
+				c.typeOf(arg, nil)
+			}
+			return f.Result
+		}
+		for i, arg := range node.Arguments {
+			c.expect(arg, f.Params[i], "argument %d to %s", i+1, name)
+		}
+		return f.Result
+	case *Var:
+		// 未注解的参数被调用，推断为函数
+		inferred := &Function{Result: c.fresh()}
+		for _, arg := range node.Arguments {
+			inferred.Params = append(inferred.Params, c.typeOf(arg, nil))
+		}
+		c.unify(f, inferred)
+		return inferred.Result
+	default:
+		for _, arg := range node.Arguments {
+			c.typeOf(arg, nil)
+		}
+		if f != Any {
+			c.report(position(node.Function), "cannot call non-function %s", f)
+		}
+		return Any
+	}
+}
+
+func (c *checker) indexExpression(node *ast.IndexExpression) Type {
+	left := c.typeOf(node.Left, nil)
+	index := c.typeOf(node.Index, nil)
+	switch l := prune(left).(type) {
+	case *Array:
+		if !c.unify(Int, index) {
+			c.report(position(node.Index), "cannot use %s as int in array index", index)
+		}
+		return l.Element
+	case *Hash:
+		if !c.unify(l.Key, index) {
+			c.report(position(node.Index), "cannot use %s as %s in hash key", index, l.Key)
+		}
+		return l.Value
+	case *Var:
+		return Any
+	default:
+		if l != Any {
+			c.report(node.Token, "cannot index %s", l)
+		}
+		return Any
+	}
+}
+
+func (c *checker) assignExpression(node *ast.AssignExpression) Type {
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		if t, ok := c.resolve(target.Value); ok {
+			c.expect(node.Value, t, "assignment to %s", target.Value)
+			return t
+		}
+	case *ast.IndexExpression:
+		element := c.indexExpression(target)
+		c.expect(node.Value, element, "index assignment")
+		return element
+	}
+	return c.typeOf(node.Value, nil)
+}
+
+// matchExpression 模式中的名字按匹配的值的类型定义，没有分支匹配时值为null
+func (c *checker) matchExpression(node *ast.MatchExpression) Type {
+	subject := c.typeOf(node.Subject, nil)
+	var result Type
+	for _, arm := range node.Arms {
+		c.bindPattern(arm.Pattern, subject)
+		if arm.Guard != nil {
+			c.typeOf(arm.Guard, nil)
+		}
+		result = c.join(result, c.expression(arm.Body, nil))
+	}
+	return c.join(result, Null)
+}
+
+// bindPattern 按值的类型t定义模式中的名字，类型不是数组或哈希时其中的名字为any
+func (c *checker) bindPattern(pattern ast.Expression, t Type) {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		if pattern.Value != ast.Wildcard {
+			c.define(pattern.Value, t)
+		}
+	case *ast.ArrayPattern:
+		var element Type = Any
+		if a, ok := prune(t).(*Array); ok {
+			element = a.Element
+		}
+		for _, e := range pattern.Elements {
+			c.bindPattern(e, element)
+		}
+		if pattern.Rest != nil {
+			c.bindPattern(pattern.Rest, &Array{Element: element})
+		}
+	case *ast.HashPattern:
+		var value Type = Any
+		if h, ok := prune(t).(*Hash); ok {
+			value = h.Value
+		}
+		for _, v := range pattern.Values {
+			c.bindPattern(v, value)
+		}
+	}
+}
+
+// annotation 把类型注解转换为类型，未知的类型名报告后视为any
+func (c *checker) annotation(t ast.Type) Type {
+	switch t := t.(type) {
+	case *ast.NamedType:
+		if basic, ok := basics[t.Name]; ok {
+			return basic
+		}
+		c.report(t.Token, "unknown type %s", t.Name)
+	case *ast.ArrayType:
+		return &Array{Element: c.annotation(t.Element)}
+	case *ast.HashType:
+		return &Hash{Key: c.annotation(t.Key), Value: c.annotation(t.Value)}
+	case *ast.FunctionType:
+		fn := &Function{Result: Any}
+		for _, param := range t.Parameters {
+			fn.Params = append(fn.Params, c.annotation(param))
+		}
+		// 没有注解返回值的函数类型可以返回任何值
+		if t.Return != nil {
+			fn.Result = c.annotation(t.Return)
+		}
+		return fn
+	}
+	return Any
+}
+
+// builtin 返回内置函数的类型，每次使用都创建新的类型变量。
+// 参数个数不固定的内置函数以及不是内置函数的名字返回nil
+func (c *checker) builtin(name string) Type {
+	switch name {
+	case "len":
+		return &Function{Params: []Type{Any}, Result: Int}
+	case "first", "last":
+		t := c.fresh()
+		return &Function{Params: []Type{&Array{Element: t}}, Result: t}
+	case "rest":
+		t := &Array{Element: c.fresh()}
+		return &Function{Params: []Type{t}, Result: t}
+	case "push":
+		t := c.fresh()
+		return &Function{Params: []Type{&Array{Element: t}, t}, Result: &Array{Element: t}}
+	case "readFile", "getenv":
+		return &Function{Params: []Type{String}, Result: String}
+	case "writeFile":
+		return &Function{Params: []Type{String, String}, Result: Null}
+	case "now":
+		return &Function{Result: Int}
+	case "throw":
+		return &Function{Params: []Type{Any}, Result: Any}
+	case "freeze":
+		t := c.fresh()
+		return &Function{Params: []Type{t}, Result: t}
+	}
+	return nil
+}
+
+// hasLength 判断t是否可能是len支持的字符串或数组
+func hasLength(t Type) bool {
+	switch t := prune(t).(type) {
+	case *Array, *Var:
+		return true
+	default:
+		return t == String || t == Any
+	}
+}
+
+// position 返回表达式最左侧的词法单元
+func position(exp ast.Expression) token.Token {
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		return exp.Token
+	case *ast.IntegerLiteral:
+		return exp.Token
+	case *ast.StringLiteral:
+		return exp.Token
+	case *ast.Boolean:
+		return exp.Token
+	case *ast.PrefixExpression:
+		return exp.Token
+	case *ast.InfixExpression:
+		return position(exp.Left)
+	case *ast.IfExpression:
+		return exp.Token
+	case *ast.BlockStatement:
+		return exp.Token
+	case *ast.FunctionLiteral:
+		return exp.Token
+	case *ast.CallExpression:
+		return position(exp.Function)
+	case *ast.ArrayLiteral:
+		return exp.Token
+	case *ast.HashLiteral:
+		return exp.Token
+	case *ast.IndexExpression:
+		return position(exp.Left)
+	case *ast.AssignExpression:
+		return position(exp.Target)
+	case *ast.TryExpression:
+		return exp.Token
+	case *ast.MatchExpression:
+		return exp.Token
+	default:
+		return token.Token{}
+	}
+}
+
+// lastPosition 返回函数体中最后一条语句的位置，函数体为空时返回函数的位置
+func lastPosition(node *ast.FunctionLiteral) token.Token {
+	if node.Body == nil || len(node.Body.Statements) == 0 {
+		return node.Token
+	}
+	switch stmt := node.Body.Statements[len(node.Body.Statements)-1].(type) {
+	case *ast.ExpressionStatement:
+		return position(stmt.Expression)
+	case *ast.LetStatement:
+		return stmt.Token
+	}
+	return node.Token
+}
@@ -0,0 +1,190 @@
+package types
+
+import (
+	"Monkey/lexer"
+	"Monkey/parser"
+	"testing"
+)
+
+func check(t *testing.T, input string) []string {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	parser.CheckErrors(t, p)
+
+	var results []string
+	for _, d := range Check(program) {
+		results = append(results, d.String())
+	}
+	return results
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{
+			name:     "let annotation",
+			input:    `let x: int = "five"; let y: [string] = ["a"]; let z: {string: int} = {"a": 1};`,
+			expected: []string{"1:14: cannot use string as int in declaration of x"},
+		},
+		{
+			name:     "unknown type",
+			input:    "let x: integer = 1; let f = fn(a: [foo]) { a };",
+			expected: []string{"1:8: unknown type integer", "1:36: unknown type foo"},
+		},
+		{
+			name:  "parameter and return annotations",
+			input: `let greet = fn(name: string): string { "hi " + name }; greet(3); let bad = fn(n: int): string { n };`,
+			expected: []string{
+				"1:62: cannot use int as string in argument 1 to greet",
+				"1:97: cannot use int as string in return value",
+			},
+		},
+		{
+			name:     "return statement",
+			input:    `let f = fn(n: int): int { if (n > 0) { return "p"; } n };`,
+			expected: []string{"1:47: cannot use string as int in return statement"},
+		},
+		{
+			name:     "return type checks every path",
+			input:    "let f = fn(n: int): int { if (n > 0) { return 1; } }; let g = fn(n: int): int { if (n > 0) { return 1; } else { return 2; } };",
+			expected: []string{"1:27: cannot use null as int in return value"},
+		},
+		{
+			name:  "operators",
+			input: `1 + "a"; "a" - "b"; -"a"; !1; true < false; "a" + "b"; 1 == "a"; 1 != 2;`,
+			expected: []string{
+				"1:3: invalid operation: mismatched types int and string for +",
+				"1:14: invalid operation: operator - not defined on string",
+				"1:21: invalid operation: operator - not defined on string",
+				"1:36: invalid operation: operator < not defined on bool",
+			},
+		},
+		{
+			name:     "parameters inferred from body",
+			input:    `let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; fact("x"); fact(3) + 1;`,
+			expected: []string{"1:70: cannot use string as int in argument 1 to fact"},
+		},
+		{
+			name:     "unconstrained parameters accept anything",
+			input:    `let id = fn(x) { x }; id(1); id("s"); let add = fn(a, b) { a + b }; add(1, 2); add("a", "b");`,
+			expected: nil,
+		},
+		{
+			name:     "inferred result",
+			input:    `let f = fn(a) { a + 1 }; let s: string = f(1);`,
+			expected: []string{"1:42: cannot use int as string in declaration of s"},
+		},
+		{
+			name:  "arity",
+			input: "let add = fn(a, b) { a + b }; add(1); len(1, 2);",
+			expected: []string{
+				"1:31: wrong number of arguments in call to add: want 2, got 1",
+				"1:39: wrong number of arguments in call to len: want 1, got 2",
+			},
+		},
+		{
+			name:     "call non-function",
+			input:    `let x = 1; x(2); "f"();`,
+			expected: []string{"1:12: cannot call non-function int", `1:18: cannot call non-function string`},
+		},
+		{
+			name:  "builtins",
+			input: `len(5); len("a") + len([1]); push([1], "a"); first([1]) + 1; first(["a"]) + 1; println(1, "a"); now() + 1;`,
+			expected: []string{
+				"1:5: cannot use int as string or array in argument 1 to len",
+				"1:40: cannot use string as int in argument 2 to push",
+				"1:75: invalid operation: mismatched types string and int for +",
+			},
+		},
+		{
+			name:     "builtin shadowed by let",
+			input:    "let len = fn(a, b) { a }; len(1, 2);",
+			expected: nil,
+		},
+		{
+			name:     "empty literals take their type from use",
+			input:    `let xs = push([], 1); xs[0] + 1; xs["a"]; let h = {}; let g: {string: int} = h;`,
+			expected: []string{"1:37: cannot use string as int in array index"},
+		},
+		{
+			name:     "heterogeneous literals are any",
+			input:    `let person = {"name": "Alice", "age": 30}; person["age"] + 1; [1, "a"][0] + 1; person[1];`,
+			expected: []string{"1:87: cannot use int as string in hash key"},
+		},
+		{
+			name:     "index non-indexable",
+			input:    `"abc"[0]; 5[1];`,
+			expected: []string{"1:6: cannot index string", "1:12: cannot index int"},
+		},
+		{
+			name:  "assignment",
+			input: `var count = 0; count = count + 1; count = "a"; var xs: [int] = []; xs[0] = "s"; xs = [1];`,
+			expected: []string{
+				"1:43: cannot use string as int in assignment to count",
+				"1:76: cannot use string as int in index assignment",
+			},
+		},
+		{
+			name:  "function arguments",
+			input: `let apply = fn(f: fn(int): int, x: int) { f(x) }; apply(fn(n) { n + "a" }, 1); apply(fn(a, b) { a }, 1); apply(fn(n) { n * 2 }, 2);`,
+			expected: []string{
+				"1:67: invalid operation: mismatched types int and string for +",
+				"1:86: cannot use fn(any, any): any as fn(int): int in argument 1 to apply",
+			},
+		},
+		{
+			name:  "function annotation on let",
+			input: `let inc: fn(int): int = fn(x) { x + 1 }; inc("a"); let bad: fn(string): int = fn(s) { len(s) }; bad(1);`,
+			expected: []string{
+				"1:46: cannot use string as int in argument 1 to inc",
+				"1:101: cannot use int as string in argument 1 to bad",
+			},
+		},
+		{
+			name:     "higher-order inference",
+			input:    `let apply = fn(f, v) { f(v) }; apply(fn(n) { n + 1 }, 2); apply(1, 2);`,
+			expected: []string{"1:65: cannot use int as fn(any): any in argument 1 to apply"},
+		},
+		{
+			name:     "closures",
+			input:    `let counter = fn() { var c = 0; fn() { c = c + 1; c } }; let next = counter(); next() + "a";`,
+			expected: []string{"1:87: invalid operation: mismatched types int and string for +"},
+		},
+		{
+			name:  "patterns",
+			input: `let [a, ...rest] = [1, 2]; a + "x"; len(rest); match ({"k": "v"}) { {"k": v} => v + 1, _ => 0 };`,
+			expected: []string{
+				"1:30: invalid operation: mismatched types int and string for +",
+				"1:83: invalid operation: mismatched types string and int for +",
+			},
+		},
+		{
+			name:     "try and catch",
+			input:    `let r = try { throw("x") } catch (e) { e["message"] }; r + 1; let t: int = try { "a" } catch (e) { "b" };`,
+			expected: []string{"1:76: cannot use string as int in declaration of t"},
+		},
+		{
+			name:     "undefined identifiers are any",
+			input:    "missing + 1; import(\"m.mk\")[\"f\"](1);",
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := check(t, tt.input)
+			if len(results) != len(tt.expected) {
+				t.Fatalf("wrong diagnostics.\nwant=%q\ngot =%q", tt.expected, results)
+			}
+			for i := range tt.expected {
+				if results[i] != tt.expected[i] {
+					t.Errorf("diagnostic %d wrong.\nwant=%q\ngot =%q", i, tt.expected[i], results[i])
+				}
+			}
+		})
+	}
+}
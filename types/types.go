@@ -0,0 +1,224 @@
+package types
+
+import "strings"
+
+// Type 静态类型。类型变量Var绑定后与绑定的类型相同，比较前需要先用prune展开
+type Type interface {
+	String() string
+}
+
+// Basic 基本类型
+type Basic struct {
+	name string
+}
+
+func (b *Basic) String() string { return b.name }
+
+// 基本类型。Any与任何类型兼容，未注解且无法推断的值为Any
+var (
+	Int    = &Basic{"int"}
+	String = &Basic{"string"}
+	Bool   = &Basic{"bool"}
+	Null   = &Basic{"null"}
+	Any    = &Basic{"any"}
+)
+
+// basics 类型注解中可以使用的具名类型
+var basics = map[string]Type{
+	"int":    Int,
+	"string": String,
+	"bool":   Bool,
+	"null":   Null,
+	"any":    Any,
+}
+
+// Array 元素类型为Element的数组
+type Array struct {
+	Element Type
+}
+
+func (a *Array) String() string { return "[" + a.Element.String() + "]" }
+
+// Hash 键类型为Key、值类型为Value的哈希
+type Hash struct {
+	Key   Type
+	Value Type
+}
+
+func (h *Hash) String() string { return "{" + h.Key.String() + ": " + h.Value.String() + "}" }
+
+// Function 函数类型
+type Function struct {
+	Params []Type
+	Result Type
+}
+
+func (f *Function) String() string {
+	var params []string
+	for _, param := range f.Params {
+		params = append(params, param.String())
+	}
+	return "fn(" + strings.Join(params, ", ") + "): " + f.Result.String()
+}
+
+// Var 类型变量，用于未注解的参数和空字面量的元素，由使用方式推断。
+// level为创建时所在函数的嵌套层数，函数检查完后仍未绑定的类型变量成为Any
+type Var struct {
+	bound Type
+	level int
+}
+
+// String 未绑定的类型变量可以是任何类型，显示为any
+func (v *Var) String() string {
+	if v.bound != nil {
+		return v.bound.String()
+	}
+	return Any.String()
+}
+
+// prune 展开已绑定的类型变量
+func prune(t Type) Type {
+	for {
+		v, ok := t.(*Var)
+		if !ok || v.bound == nil {
+			return t
+		}
+		t = v.bound
+	}
+}
+
+// unifier 合一类型，记录绑定过的类型变量以便失败时撤销
+type unifier struct {
+	trail []*Var
+}
+
+func (u *unifier) bind(v *Var, t Type) {
+	v.bound = t
+	u.trail = append(u.trail, v)
+}
+
+// undo 撤销mark之后的绑定
+func (u *unifier) undo(mark int) {
+	for _, v := range u.trail[mark:] {
+		v.bound = nil
+	}
+	u.trail = u.trail[:mark]
+}
+
+// unify 使a与b成为相同的类型，失败时撤销过程中的绑定并返回false
+func (u *unifier) unify(a, b Type) bool {
+	mark := len(u.trail)
+	if !u.unifyAll(a, b) {
+		u.undo(mark)
+		return false
+	}
+	return true
+}
+
+func (u *unifier) unifyAll(a, b Type) bool {
+	a, b = prune(a), prune(b)
+	if a == b || a == Any || b == Any {
+		return true
+	}
+	if va, ok := a.(*Var); ok {
+		// 两个类型变量合一时保留外层函数的那个
+		if vb, ok := b.(*Var); ok && vb.level > va.level {
+			u.bind(vb, va)
+			return true
+		}
+		if occurs(va, b) {
+			return false
+		}
+		u.bind(va, b)
+		return true
+	}
+	if _, ok := b.(*Var); ok {
+		return u.unifyAll(b, a)
+	}
+
+	switch a := a.(type) {
+	case *Array:
+		b, ok := b.(*Array)
+		return ok && u.unifyAll(a.Element, b.Element)
+	case *Hash:
+		b, ok := b.(*Hash)
+		return ok && u.unifyAll(a.Key, b.Key) && u.unifyAll(a.Value, b.Value)
+	case *Function:
+		b, ok := b.(*Function)
+		if !ok || len(a.Params) != len(b.Params) {
+			return false
+		}
+		for i := range a.Params {
+			if !u.unifyAll(a.Params[i], b.Params[i]) {
+				return false
+			}
+		}
+		return u.unifyAll(a.Result, b.Result)
+	}
+	return false
+}
+
+// occurs 判断v是否出现在t中，同时把t中更内层的类型变量提升到v的层数，
+// 使它们随v所在的函数一起确定
+func occurs(v *Var, t Type) bool {
+	switch t := prune(t).(type) {
+	case *Var:
+		if t == v {
+			return true
+		}
+		if t.level > v.level {
+			t.level = v.level
+		}
+	case *Array:
+		return occurs(v, t.Element)
+	case *Hash:
+		return occurs(v, t.Key) || occurs(v, t.Value)
+	case *Function:
+		for _, param := range t.Params {
+			if occurs(v, param) {
+				return true
+			}
+		}
+		return occurs(v, t.Result)
+	}
+	return false
+}
+
+// join 分支、数组元素等多个可能的值的类型：相同时为该类型，null可以出现在任何类型的位置，
+// 无法合一时为Any。nil表示没有值，例如以return结束的代码块
+func (u *unifier) join(a, b Type) Type {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	case prune(a) == Null:
+		return b
+	case prune(b) == Null:
+		return a
+	case u.unify(a, b):
+		return a
+	default:
+		return Any
+	}
+}
+
+// settle 把t中层数不小于level且仍未绑定的类型变量绑定为Any，用于检查完的函数
+func (u *unifier) settle(t Type, level int) {
+	switch t := prune(t).(type) {
+	case *Var:
+		if t.level >= level {
+			u.bind(t, Any)
+		}
+	case *Array:
+		u.settle(t.Element, level)
+	case *Hash:
+		u.settle(t.Key, level)
+		u.settle(t.Value, level)
+	case *Function:
+		for _, param := range t.Params {
+			u.settle(param, level)
+		}
+		u.settle(t.Result, level)
+	}
+}
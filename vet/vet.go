@@ -0,0 +1,324 @@
+// Package vet 对Monkey程序做静态检查，在运行之前发现常见错误
+package vet
+
+import (
+	"Monkey/ast"
+	"Monkey/object"
+	"Monkey/token"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// 检查项的名字
+const (
+	CheckUnused      = "unused"
+	CheckShadow      = "shadow"
+	CheckArity       = "arity"
+	CheckUnreachable = "unreachable"
+	CheckUndefined   = "undefined"
+	CheckAssign      = "assign"
+)
+
+// Diagnostic 一条检查结果
+type Diagnostic struct {
+	Line    int
+	Column  int
+	Check   string
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%d:%d: %s (%s)", d.Line, d.Column, d.Message, d.Check)
+}
+
+// Check 检查program，返回按位置排序的诊断信息
+func Check(program *ast.Program) []Diagnostic {
+	c := &checker{}
+	c.pushScope()
+	c.statements(program.Statements)
+	c.resolvePending()
+	c.popScope()
+
+	sort.SliceStable(c.diagnostics, func(i, j int) bool {
+		a, b := c.diagnostics[i], c.diagnostics[j]
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return c.diagnostics
+}
+
+// binding 一次let、var或参数绑定
+type binding struct {
+	name    *ast.Identifier
+	param   bool
+	used    bool
+	mutable bool // var定义，可以重新赋值
+	params  int  // 绑定的值为函数字面量时的参数个数，否则为-1
+}
+
+// scope 函数作用域。Monkey中只有函数会引入新的作用域，if的代码块不会
+type scope struct {
+	names    map[string]*binding
+	bindings []*binding
+}
+
+type checker struct {
+	scopes      []*scope
+	diagnostics []Diagnostic
+	pending     []pendingIdentifier
+}
+
+// pendingIdentifier 函数体中暂时无法解析的标识符。
+// 函数在调用时才查找变量，因此可以引用之后才定义的全局变量，需要在检查完整个程序后再解析
+type pendingIdentifier struct {
+	ident  *ast.Identifier
+	scopes []*scope
+}
+
+func (c *checker) report(tok token.Token, check string, format string, args ...any) {
+	c.diagnostics = append(c.diagnostics, Diagnostic{
+		Line:    tok.Line,
+		Column:  tok.Column,
+		Check:   check,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (c *checker) pushScope() {
+	c.scopes = append(c.scopes, &scope{names: make(map[string]*binding)})
+}
+
+// popScope 退出作用域，并报告其中未使用的let绑定
+func (c *checker) popScope() {
+	s := c.scopes[len(c.scopes)-1]
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	for _, b := range s.bindings {
+		if !b.used && !b.param && !strings.HasPrefix(b.name.Value, "_") {
+			c.report(b.name.Token, CheckUnused, "%s declared and not used", b.name.Value)
+		}
+	}
+}
+
+func (c *checker) define(name *ast.Identifier, param bool, value ast.Expression) *binding {
+	current := c.scopes[len(c.scopes)-1]
+	_, redeclared := current.names[name.Value]
+	for i := len(c.scopes) - 2; i >= 0 && !redeclared; i-- {
+		if outer, ok := c.scopes[i].names[name.Value]; ok {
+			c.report(name.Token, CheckShadow, "declaration of %s shadows declaration at %d:%d",
+				name.Value, outer.name.Token.Line, outer.name.Token.Column)
+			break
+		}
+	}
+
+	b := &binding{name: name, param: param, params: -1}
+	if fn, ok := value.(*ast.FunctionLiteral); ok {
+		b.params = len(fn.Parameters)
+	}
+	current.names[name.Value] = b
+	current.bindings = append(current.bindings, b)
+	return b
+}
+
+func (c *checker) resolve(name string) (*binding, bool) {
+	return resolveIn(c.scopes, name)
+}
+
+func resolveIn(scopes []*scope, name string) (*binding, bool) {
+	for i := len(scopes) - 1; i >= 0; i-- {
+		if b, ok := scopes[i].names[name]; ok {
+			return b, true
+		}
+	}
+	return nil, false
+}
+
+func (c *checker) resolvePending() {
+	for _, p := range c.pending {
+		if b, ok := resolveIn(p.scopes, p.ident.Value); ok {
+			b.used = true
+			continue
+		}
+		c.report(p.ident.Token, CheckUndefined, "undefined: %s", p.ident.Value)
+	}
+	c.pending = nil
+}
+
+// Visit 实现ast.Visitor。声明相关的节点需要控制遍历顺序，在这里手动遍历
+func (c *checker) Visit(node ast.Node) ast.Visitor {
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		c.letStatement(node)
+		return nil
+	case *ast.FunctionLiteral:
+		c.functionLiteral(node)
+		return nil
+	case *ast.TryExpression:
+		c.tryExpression(node)
+		return nil
+	case *ast.MatchExpression:
+		c.matchExpression(node)
+		return nil
+	case *ast.AssignExpression:
+		c.assignExpression(node)
+		return nil
+	case *ast.BlockStatement:
+		c.statements(node.Statements)
+		return nil
+	case *ast.Identifier:
+		c.identifier(node)
+	case *ast.CallExpression:
+		c.callExpression(node)
+	}
+	return c
+}
+
+func (c *checker) walk(node ast.Node) {
+	if node != nil {
+		ast.Walk(c, node)
+	}
+}
+
+func (c *checker) statements(stmts []ast.Statement) {
+	for i, stmt := range stmts {
+		c.walk(stmt)
+		if _, ok := stmt.(*ast.ReturnStatement); ok && i+1 < len(stmts) {
+			c.report(statementToken(stmts[i+1]), CheckUnreachable, "unreachable code")
+			for _, rest := range stmts[i+1:] {
+				c.walk(rest)
+			}
+			return
+		}
+	}
+}
+
+func (c *checker) letStatement(node *ast.LetStatement) {
+	if node.Pattern != nil {
+		c.walk(node.Value)
+		for _, name := range ast.PatternBindings(node.Pattern) {
+			c.define(name, false, nil).mutable = node.Mutable()
+		}
+		return
+	}
+	if node.Name == nil {
+		return
+	}
+	// var定义的变量可能被赋值为其他函数，不检查调用的参数个数
+	value := node.Value
+	if node.Mutable() {
+		value = nil
+	}
+	// let的值先于名字求值，但函数字面量可以递归引用自身
+	if _, ok := node.Value.(*ast.FunctionLiteral); ok {
+		c.define(node.Name, false, value).mutable = node.Mutable()
+		c.walk(node.Value)
+		return
+	}
+	c.walk(node.Value)
+	c.define(node.Name, false, value).mutable = node.Mutable()
+}
+
+func (c *checker) functionLiteral(node *ast.FunctionLiteral) {
+	c.pushScope()
+	for _, param := range node.Parameters {
+		c.define(param, true, nil)
+	}
+	if node.Body != nil {
+		c.statements(node.Body.Statements)
+	}
+	c.popScope()
+}
+
+// tryExpression catch的参数定义在当前作用域中，与参数一样不要求被使用
+func (c *checker) tryExpression(node *ast.TryExpression) {
+	c.walk(node.Block)
+	if node.Param != nil {
+		c.define(node.Param, true, nil)
+	}
+	c.walk(node.Handler)
+}
+
+// matchExpression 模式中的名字与let一样定义在当前作用域中，未使用时报告，不需要时应写作_
+func (c *checker) matchExpression(node *ast.MatchExpression) {
+	c.walk(node.Subject)
+	for _, arm := range node.Arms {
+		for _, name := range ast.PatternBindings(arm.Pattern) {
+			c.define(name, false, nil)
+		}
+		c.walk(arm.Guard)
+		c.walk(arm.Body)
+	}
+}
+
+// assignExpression 只赋值不算使用变量；给let定义的变量、参数或内置函数赋值时报告
+func (c *checker) assignExpression(node *ast.AssignExpression) {
+	c.walk(node.Value)
+	target, ok := node.Target.(*ast.Identifier)
+	if !ok {
+		c.walk(node.Target)
+		return
+	}
+	if b, ok := c.resolve(target.Value); ok {
+		if !b.mutable {
+			c.report(target.Token, CheckAssign, "cannot assign to immutable variable %s", target.Value)
+		}
+		return
+	}
+	if object.GetBuiltinByName(target.Value) != nil {
+		c.report(target.Token, CheckAssign, "cannot assign to immutable variable %s", target.Value)
+		return
+	}
+	c.identifier(target)
+}
+
+func (c *checker) identifier(node *ast.Identifier) {
+	if b, ok := c.resolve(node.Value); ok {
+		b.used = true
+		return
+	}
+	// import由编译器和求值器特殊处理，不是内置函数
+	if object.GetBuiltinByName(node.Value) != nil || node.Value == "import" {
+		return
+	}
+	if len(c.scopes) > 1 {
+		scopes := make([]*scope, len(c.scopes))
+		copy(scopes, c.scopes)
+		c.pending = append(c.pending, pendingIdentifier{ident: node, scopes: scopes})
+		return
+	}
+	c.report(node.Token, CheckUndefined, "undefined: %s", node.Value)
+}
+
+func (c *checker) callExpression(node *ast.CallExpression) {
+	params := -1
+	tok := node.Token
+	switch fn := node.Function.(type) {
+	case *ast.Identifier:
+		if b, ok := c.resolve(fn.Value); ok {
+			params = b.params
+		}
+		tok = fn.Token
+	case *ast.FunctionLiteral:
+		params = len(fn.Parameters)
+		tok = fn.Token
+	}
+	if params >= 0 && params != len(node.Arguments) {
+		c.report(tok, CheckArity, "wrong number of arguments in call to %s: want %d, got %d",
+			node.Function.String(), params, len(node.Arguments))
+	}
+}
+
+func statementToken(stmt ast.Statement) token.Token {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		return stmt.Token
+	case *ast.ReturnStatement:
+		return stmt.Token
+	case *ast.ExpressionStatement:
+		return stmt.Token
+	default:
+		return token.Token{}
+	}
+}
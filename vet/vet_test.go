@@ -0,0 +1,128 @@
+package vet
+
+import (
+	"Monkey/lexer"
+	"Monkey/parser"
+	"testing"
+)
+
+func check(t *testing.T, input string) []string {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	parser.CheckErrors(t, p)
+
+	var results []string
+	for _, d := range Check(program) {
+		results = append(results, d.String())
+	}
+	return results
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{
+			name:     "unused",
+			input:    "let a = 1; let b = 2; let _c = 3; b;",
+			expected: []string{"1:5: a declared and not used (unused)"},
+		},
+		{
+			name:     "unused parameter is fine",
+			input:    "let f = fn(a, b) { a }; f(1, 2);",
+			expected: nil,
+		},
+		{
+			name:     "shadow",
+			input:    "let x = 1; let f = fn(x) { let x = 2; x }; f(x);",
+			expected: []string{"1:23: declaration of x shadows declaration at 1:5 (shadow)"},
+		},
+		{
+			name:  "arity",
+			input: "let add = fn(a, b) { a + b }; add(1); add(1, 2); fn(x) { x }(1, 2);",
+			expected: []string{
+				"1:31: wrong number of arguments in call to add: want 2, got 1 (arity)",
+				"1:50: wrong number of arguments in call to fn(x){ x }: want 1, got 2 (arity)",
+			},
+		},
+		{
+			name:     "arity after rebinding",
+			input:    "let f = fn(a) { a }; let f = 1; f(1, 2);",
+			expected: []string{"1:5: f declared and not used (unused)"},
+		},
+		{
+			name:     "unreachable",
+			input:    "let f = fn() { return 1; 2; 3 }; f();",
+			expected: []string{"1:26: unreachable code (unreachable)"},
+		},
+		{
+			name:     "undefined",
+			input:    "let a = b; len(a); c;",
+			expected: []string{"1:9: undefined: b (undefined)", "1:20: undefined: c (undefined)"},
+		},
+		{
+			name:     "recursion and forward references",
+			input:    "let f = fn(n) { if (n > 0) { g(n - 1) } else { 0 } }; let g = fn(n) { f(n) }; f(3);",
+			expected: nil,
+		},
+		{
+			name:     "import is predeclared",
+			input:    `let m = import("lib.mk"); m;`,
+			expected: nil,
+		},
+		{
+			name:     "match bindings",
+			input:    "match ([1, 2]) { [a, b] => b, [x, _] if x > 0 => 0, n => n };",
+			expected: []string{"1:19: a declared and not used (unused)"},
+		},
+		{
+			name:     "destructuring let",
+			input:    "let [a, b, ...rest] = [1, 2]; let {name} = {\"name\": b}; rest; name;",
+			expected: []string{"1:6: a declared and not used (unused)"},
+		},
+		{
+			name:  "assignment",
+			input: "let a = 1; var b = 2; var c = 3; a = b; c = 4; len = 1; fn(p) { p = 1 }; let f = fn() { f = 2 }; f();",
+			expected: []string{
+				"1:5: a declared and not used (unused)",
+				"1:27: c declared and not used (unused)",
+				"1:34: cannot assign to immutable variable a (assign)",
+				"1:48: cannot assign to immutable variable len (assign)",
+				"1:65: cannot assign to immutable variable p (assign)",
+				"1:89: cannot assign to immutable variable f (assign)",
+			},
+		},
+		{
+			name:     "var functions are not checked for arity",
+			input:    "var f = fn(a) { a }; f = fn(a, b) { a + b }; f(1, 2);",
+			expected: nil,
+		},
+		{
+			name:     "catch parameter",
+			input:    "try { 1 } catch (e) { 2 }; try { 1 } catch (err) { err };",
+			expected: nil,
+		},
+		{
+			name:     "let value cannot refer to itself",
+			input:    "let a = a + 1; a;",
+			expected: []string{"1:9: undefined: a (undefined)"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := check(t, tt.input)
+			if len(results) != len(tt.expected) {
+				t.Fatalf("wrong diagnostics.\nwant=%q\ngot =%q", tt.expected, results)
+			}
+			for i := range tt.expected {
+				if results[i] != tt.expected[i] {
+					t.Errorf("diagnostic %d wrong.\nwant=%q\ngot =%q", i, tt.expected[i], results[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package vm
+
+import (
+	"Monkey/compiler"
+	"context"
+	"fmt"
+	"testing"
+)
+
+// 基准测试覆盖数值计算、函数调用、闭包和集合操作，每个程序分别在不优化和最高优化级别下运行。
+// 比较两个版本的结果：
+//
+//	go test ./vm -run '^$' -bench . -count 10 > old.txt
+//	go test ./vm -run '^$' -bench . -count 10 > new.txt
+//	benchstat old.txt new.txt
+var benchmarks = []struct {
+	name  string
+	input string
+}{
+	{"Fib", `
+let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+fib(20);`},
+	{"Arithmetic", `
+let sum = fn(n, acc) { if (n == 0) { acc } else { sum(n - 1, acc + n * 2 - n / 3) } };
+sum(500, 0);`},
+	{"Closures", `
+let adder = fn(x) { fn(y) { x + y } };
+let apply = fn(n, acc) { if (n > 0) { apply(n - 1, adder(n)(acc)) } else { acc } };
+apply(500, 0);`},
+	{"Arrays", `
+let build = fn(n, arr) { if (n == 0) { arr } else { build(n - 1, push(arr, n)) } };
+let total = fn(arr, i, acc) { if (i == len(arr)) { acc } else { total(arr, i + 1, acc + arr[i]) } };
+total(build(300, []), 0, 0);`},
+	{"Hashes", `
+let h = {"a": 1, "b": 2, "c": 3, 1: 4, true: 5};
+let lookup = fn(n, acc) { if (n == 0) { acc } else { lookup(n - 1, acc + h["a"] + h[1] + h[true]) } };
+lookup(500, 0);`},
+}
+
+func BenchmarkRun(b *testing.B) {
+	for _, bm := range benchmarks {
+		for _, level := range []int{compiler.OptimizeNone, compiler.OptimizeFused} {
+			comp := compiler.New()
+			comp.SetOptimization(level)
+			if err := comp.Compile(parse(bm.input)); err != nil {
+				b.Fatalf("%s: compiler error: %s", bm.name, err)
+			}
+			bytecode := comp.Bytecode()
+
+			b.Run(fmt.Sprintf("%s/O%d", bm.name, level), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					machine := New(bytecode)
+					if err := machine.Run(context.Background()); err != nil {
+						b.Fatal(err)
+					}
+					machine.Release()
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkShortEval 只执行一行表达式的虚拟机，衡量创建虚拟机本身的开销
+func BenchmarkShortEval(b *testing.B) {
+	comp := compiler.New()
+	if err := comp.Compile(parse("let x = 1; x + 2")); err != nil {
+		b.Fatalf("compiler error: %s", err)
+	}
+	bytecode := comp.Bytecode()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		machine := New(bytecode)
+		if err := machine.Run(context.Background()); err != nil {
+			b.Fatal(err)
+		}
+		machine.Release()
+	}
+}
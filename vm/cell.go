@@ -0,0 +1,23 @@
+package vm
+
+import "Monkey/object"
+
+// cell 保存var定义的局部变量的值。闭包捕获单元本身，
+// 外层函数和闭包通过同一个单元读写变量，见OpMakeCell
+type cell struct {
+	value object.Object
+}
+
+func (c *cell) Type() object.ObjectType {
+	return "CELL"
+}
+
+// Inspect 返回其中的值的形式，调试器显示局部变量时与普通变量一致
+func (c *cell) Inspect() string {
+	return c.value.Inspect()
+}
+
+func (c *cell) Equals(other object.Object) bool {
+	o, ok := other.(*cell)
+	return ok && c == o
+}
@@ -0,0 +1,31 @@
+package vm
+
+import (
+	"Monkey/code"
+	"Monkey/object"
+)
+
+// Frame 调用帧，保存一次函数调用的执行状态
+type Frame struct {
+	cl          *object.Closure
+	ip          int // 当前帧的指令指针
+	basePointer int // 调用前的栈指针，局部变量从这里开始存放
+}
+
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{cl: cl, ip: -1, basePointer: basePointer}
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
+
+// Closure 返回帧正在执行的闭包
+func (f *Frame) Closure() *object.Closure {
+	return f.cl
+}
+
+// IP 返回帧的指令指针：当前帧中为即将执行的指令的偏移，调用方帧中指向正在执行的OpCall
+func (f *Frame) IP() int {
+	return f.ip
+}
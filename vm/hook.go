@@ -0,0 +1,89 @@
+package vm
+
+import (
+	"Monkey/object"
+	"fmt"
+	"io"
+)
+
+// Hook 在虚拟机执行每条指令之前调用，供调试器等工具观察执行状态。
+// 返回错误时Run停止并返回该错误。没有设置Hook时Run只多一次nil判断
+type Hook interface {
+	BeforeInstruction(vm *VM) error
+}
+
+// SetHook 设置之后Run使用的Hook，nil表示不观察
+func (vm *VM) SetHook(hook Hook) {
+	vm.hook = hook
+}
+
+// Depth 返回调用栈的深度，最外层的程序为1
+func (vm *VM) Depth() int {
+	return vm.framesIndex
+}
+
+// Frame 返回第i层调用帧，0为最外层的程序，Depth()-1为当前帧
+func (vm *VM) Frame(i int) *Frame {
+	return vm.frames[i]
+}
+
+// Stack 返回栈上的所有值，最后一个为栈顶
+func (vm *VM) Stack() []object.Object {
+	return vm.stack[:vm.sp]
+}
+
+// Globals 返回全局变量
+func (vm *VM) Globals() []object.Object {
+	return vm.globals
+}
+
+// Locals 返回帧f的局部变量（包括参数），最外层的程序没有局部变量
+func (vm *VM) Locals(f *Frame) []object.Object {
+	if f == vm.frames[0] {
+		return nil
+	}
+	end := f.basePointer + f.cl.Fn.NumLocals
+	if end > vm.sp {
+		end = vm.sp
+	}
+	return vm.stack[f.basePointer:end]
+}
+
+// FunctionName 返回第i层调用帧执行的函数名，最外层的程序为<main>，匿名函数为<anonymous>
+func (vm *VM) FunctionName(i int) string {
+	if i == 0 {
+		return "<main>"
+	}
+	if name := vm.frames[i].cl.Fn.Name; name != "" {
+		return name
+	}
+	return "<anonymous>"
+}
+
+// Hooks 把多个Hook组合为一个，按顺序调用，遇到错误即返回
+func Hooks(hooks ...Hook) Hook {
+	return multiHook(hooks)
+}
+
+type multiHook []Hook
+
+func (hooks multiHook) BeforeInstruction(vm *VM) error {
+	for _, hook := range hooks {
+		if err := hook.BeforeInstruction(vm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Tracer 把执行的每条指令写到Out，每行包括调用深度、栈指针、函数名、偏移和指令
+type Tracer struct {
+	Out io.Writer
+}
+
+func (t *Tracer) BeforeInstruction(vm *VM) error {
+	frame := vm.currentFrame()
+	_, err := fmt.Fprintf(t.Out, "depth=%d sp=%d %s %04d %s\n",
+		vm.framesIndex, vm.sp, vm.FunctionName(vm.framesIndex-1), frame.ip, frame.Instructions().Format(frame.ip))
+	return err
+}
@@ -0,0 +1,101 @@
+package vm
+
+import (
+	"Monkey/code"
+	"Monkey/compiler"
+	"Monkey/object"
+	"fmt"
+)
+
+// Verify 在执行之前校验bytecode中的主程序和每个函数的指令，见code.Verify。
+// 从磁盘等不可信的来源加载的字节码必须先通过校验再交给New
+func Verify(bytecode *compiler.Bytecode) error {
+	kinds := make([]code.ConstantKind, len(bytecode.Constants))
+	for i, constant := range bytecode.Constants {
+		switch constant.(type) {
+		case *object.Integer:
+			kinds[i] = code.ConstantInteger
+		case *object.String:
+			kinds[i] = code.ConstantString
+		case *object.CompiledFunction:
+			kinds[i] = code.ConstantFunction
+		}
+	}
+	bounds := code.Bounds{
+		Constants: kinds,
+		Globals:   bytecode.NumGlobals,
+		Builtins:  len(object.Builtins),
+	}
+
+	main := bounds
+	main.Main = true
+	main.Handlers = bytecode.Handlers
+	if err := code.Verify(bytecode.Instructions, main); err != nil {
+		return fmt.Errorf("invalid bytecode in main program: %w", err)
+	}
+
+	free, err := freeCounts(bytecode)
+	if err != nil {
+		return err
+	}
+	for i, constant := range bytecode.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+		if fn.NumParameters > fn.NumLocals {
+			return fmt.Errorf("invalid bytecode in %s: %d parameters but only %d locals", describe(i, fn), fn.NumParameters, fn.NumLocals)
+		}
+		fnBounds := bounds
+		fnBounds.Locals = fn.NumLocals
+		fnBounds.Free = free[i]
+		fnBounds.Handlers = fn.Handlers
+		if err := code.Verify(fn.Instructions, fnBounds); err != nil {
+			return fmt.Errorf("invalid bytecode in %s: %w", describe(i, fn), err)
+		}
+	}
+	return nil
+}
+
+// freeCounts 根据OpClosure的操作数得到每个函数的自由变量个数，同一个函数的闭包必须捕获相同个数的变量
+func freeCounts(bytecode *compiler.Bytecode) (map[int]int, error) {
+	free := make(map[int]int)
+	scan := func(ins code.Instructions) error {
+		for ip := 0; ip < len(ins); {
+			def, err := code.Lookup(ins[ip])
+			if err != nil || ip+1+def.Width() > len(ins) {
+				// 由code.Verify报告
+				return nil
+			}
+			operands, read := code.ReadOperands(def, ins[ip+1:])
+			if code.Opcode(ins[ip]) == code.OpClosure {
+				fnIndex, numFree := operands[0], operands[1]
+				if n, ok := free[fnIndex]; ok && n != numFree {
+					return fmt.Errorf("invalid bytecode: closures of constant %d capture both %d and %d variables", fnIndex, n, numFree)
+				}
+				free[fnIndex] = numFree
+			}
+			ip += 1 + read
+		}
+		return nil
+	}
+
+	if err := scan(bytecode.Instructions); err != nil {
+		return nil, err
+	}
+	for _, constant := range bytecode.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			if err := scan(fn.Instructions); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return free, nil
+}
+
+func describe(index int, fn *object.CompiledFunction) string {
+	if fn.Name != "" {
+		return fmt.Sprintf("function %s (constant %d)", fn.Name, index)
+	}
+	return fmt.Sprintf("function (constant %d)", index)
+}
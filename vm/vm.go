@@ -4,50 +4,313 @@ import (
 	"Monkey/code"
 	"Monkey/compiler"
 	"Monkey/object"
+	"context"
+	"errors"
 	"fmt"
+	"sync"
 )
 
-const StackSize = 2048
+// GlobalsSize 全局变量个数的上限，由OpGetGlobal和OpSetGlobal操作数的宽度决定
 const GlobalsSize = 65536
 
+// DefaultMaxStackSize 栈默认最多能扩大到的大小，见SetMaxStackSize
+const DefaultMaxStackSize = 1 << 18
+
+const (
+	initialStackSize = 64
+	initialFrames    = 16
+	maxPooledStack   = 4096 // 栈超过这个大小的虚拟机放回池中时不保留栈，避免长期占用内存
+	maxPooledFrames  = 1024
+)
+
 type VM struct {
-	constants    []object.Object
-	instructions code.Instructions
+	constants []object.Object
+
+	stack    []object.Object // 按需扩大，最大为maxStack
+	sp       int             // 指向栈顶下一个位置的指针
+	maxStack int
+	globals  []object.Object
+
+	frames      []*Frame // 按需扩大，调用深度受栈的大小和Limits.MaxCallDepth限制
+	framesIndex int      // 指向当前帧的下一个位置
 
-	stack   []object.Object
-	sp      int // 指向栈顶下一个位置的指针
-	globals []object.Object
+	limits object.Limits
+	budget *object.Budget // 当前Run的资源记录
+	host   *object.Host
+	hook   Hook
 }
 
+// 运算结果在此范围内的整数复用预先创建的对象，不再分配。虚拟机中的整数是不可变的，可以共享
+const (
+	minCachedInteger = -128
+	maxCachedInteger = 1023
+)
+
+var cachedIntegers = func() []*object.Integer {
+	integers := make([]*object.Integer, maxCachedInteger-minCachedInteger+1)
+	for i := range integers {
+		integers[i] = &object.Integer{Value: int64(i + minCachedInteger)}
+	}
+	return integers
+}()
+
 var True = &object.Boolean{Value: true}
 var False = &object.Boolean{Value: false}
 var Null = &object.Null{}
 
+// pool 缓存用完的虚拟机，复用其栈和帧的内存，见Release
+var pool = sync.Pool{
+	New: func() any {
+		return &VM{
+			stack:  make([]object.Object, initialStackSize),
+			frames: make([]*Frame, initialFrames),
+		}
+	},
+}
+
+// New 创建执行bytecode的虚拟机，全局变量的个数为bytecode.NumGlobals。
+// 虚拟机取自缓存池，用完后可以调用Release放回
 func New(bytecode *compiler.Bytecode) *VM {
-	return &VM{
-		instructions: bytecode.Instructions,
-		constants:    bytecode.Constants,
-		stack:        make([]object.Object, StackSize),
-		sp:           0,
-		globals:      make([]object.Object, GlobalsSize),
-	}
+	return NewWithGlobalsStore(bytecode, make([]object.Object, bytecode.NumGlobals))
 }
 
+// NewWithGlobalsStore 创建使用s作为全局变量存储的虚拟机，多次执行之间可以共享全局变量。
+// s的长度应不少于bytecode.NumGlobals，可以先用GrowGlobals扩大；
+// 长度不足时虚拟机使用扩大后的副本，执行中的赋值只能通过Globals取得
 func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
-	vm := New(bytecode)
-	vm.globals = s
+	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions, SourceMap: bytecode.SourceMap, Handlers: bytecode.Handlers}
+	mainClosure := &object.Closure{Fn: mainFn}
+
+	vm := pool.Get().(*VM)
+	vm.constants = bytecode.Constants
+	vm.sp = 0
+	vm.maxStack = DefaultMaxStackSize
+	vm.globals = GrowGlobals(s, bytecode.NumGlobals)
+	vm.frames[0] = NewFrame(mainClosure, 0)
+	vm.framesIndex = 1
+	vm.limits = object.Limits{}
+	vm.budget = nil
+	vm.host = object.DefaultHost
+	vm.hook = nil
 	return vm
+}
+
+// Release 把虚拟机放回缓存池，之后不能再使用vm和从Stack等方法取得的切片。
+// 执行的结果需要在Release之前取出
+func (vm *VM) Release() {
+	if len(vm.stack) > maxPooledStack {
+		vm.stack = make([]object.Object, initialStackSize)
+	} else {
+		// 不再引用执行中的对象，使其可以被回收
+		clear(vm.stack)
+	}
+	if len(vm.frames) > maxPooledFrames {
+		vm.frames = make([]*Frame, initialFrames)
+	} else {
+		clear(vm.frames)
+	}
+	vm.constants, vm.globals = nil, nil
+	vm.budget, vm.host, vm.hook = nil, nil, nil
+	pool.Put(vm)
+}
+
+// GrowGlobals 返回至少有n个位置的全局变量存储，保留globals中已有的值
+func GrowGlobals(globals []object.Object, n int) []object.Object {
+	if n <= len(globals) {
+		return globals
+	}
+	if n <= cap(globals) {
+		return globals[:n]
+	}
+	grown := make([]object.Object, n, max(n, 2*cap(globals)))
+	copy(grown, globals)
+	return grown
+}
+
+// NewCall 创建以args调用函数fn的虚拟机，与宿主共享常量池和全局变量。
+// fn可以是闭包或内置函数；Run结束后StackTop为函数的结果，供嵌入方从Go代码中调用Monkey函数
+func NewCall(constants []object.Object, globals []object.Object, fn object.Object, args ...object.Object) (*VM, error) {
+	bytecode := &compiler.Bytecode{
+		Instructions: code.Make(code.OpCall, len(args)),
+		Constants:    constants,
+	}
+	vm := NewWithGlobalsStore(bytecode, globals)
+
+	// 按OpCall期望的布局把函数和参数压栈
+	err := vm.push(fn)
+	if err != nil {
+		return nil, err
+	}
+	for _, arg := range args {
+		err := vm.push(arg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return vm, nil
+}
+
+// SetHost 设置执行所在的宿主，限制脚本可以调用的内置函数
+func (vm *VM) SetHost(host *object.Host) {
+	vm.host = host
+}
+
+// SetLimits 设置之后每次Run的资源上限，limits.MaxStackSize不为零时同时设置栈的最大大小
+func (vm *VM) SetLimits(limits object.Limits) {
+	vm.limits = limits
+	if limits.MaxStackSize > 0 {
+		vm.maxStack = limits.MaxStackSize
+	}
+}
 
+// SetMaxStackSize 设置栈最多能扩大到的大小，超过时Run返回stack overflow错误。默认为DefaultMaxStackSize
+func (vm *VM) SetMaxStackSize(n int) {
+	vm.maxStack = n
 }
 
-func (vm *VM) Run() error {
-	for ip := 0; ip < len(vm.instructions); ip++ {
-		op := code.Opcode(vm.instructions[ip])
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) pushFrame(f *Frame) error {
+	err := vm.budget.Enter()
+	if err != nil {
+		return err
+	}
+	if vm.framesIndex == len(vm.frames) {
+		vm.frames = append(vm.frames, f)
+	} else {
+		vm.frames[vm.framesIndex] = f
+	}
+	vm.framesIndex++
+	return nil
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.budget.Leave()
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
+// Run 执行字节码，直到程序结束、出错、ctx结束或超出SetLimits设置的上限。
+// 超出上限时返回*object.LimitError。try中的错误被捕获后从catch代码块继续执行
+func (vm *VM) Run(ctx context.Context) error {
+	vm.budget = object.NewBudget(ctx, vm.limits)
+	for {
+		err := vm.run()
+		if err == nil {
+			return nil
+		}
+		if err = vm.catch(err); err != nil {
+			var fatal *fatalError
+			if errors.As(err, &fatal) {
+				return fatal.err
+			}
+			return err
+		}
+	}
+}
+
+// fatalError 不能被catch捕获的错误，如栈溢出和钩子中止执行，Run返回其中的err。
+// 超出资源限制和权限不足的错误也不能被捕获，但不需要包装
+type fatalError struct {
+	err error
+}
+
+func (e *fatalError) Error() string {
+	return e.err.Error()
+}
+
+func (e *fatalError) Unwrap() error {
+	return e.err
+}
+
+// thrownError 内置函数返回的错误对象，包括throw抛出的值
+type thrownError struct {
+	obj *object.Error
+}
+
+func (e *thrownError) Error() string {
+	return e.obj.Message
+}
+
+// catch 从内向外查找覆盖出错位置的try：找到时弹出其上的帧，把栈恢复到try开始时的深度，
+// 压入错误转换成的哈希并跳转到catch代码块，返回nil；否则返回Run应该返回的错误
+func (vm *VM) catch(err error) error {
+	var fatal *fatalError
+	var permission *object.PermissionError
+	if vm.budget.Err() != nil || errors.As(err, &fatal) || errors.As(err, &permission) {
+		return err
+	}
+
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+		handler, ok := code.FindHandler(frame.cl.Fn.Handlers, frame.ip)
+		if !ok {
+			continue
+		}
+
+		errObj := vm.errorObject(err)
+		if err := vm.budget.Allocate(); err != nil {
+			return err
+		}
+		for vm.framesIndex > i+1 {
+			vm.popFrame()
+		}
+		vm.sp = frame.basePointer + frame.cl.Fn.NumLocals + handler.Depth
+		frame.ip = handler.Target - 1
+		// 栈只会比出错时浅，不需要扩大
+		vm.stack[vm.sp] = errObj.Caught()
+		vm.sp++
+		return nil
+	}
+	return err
+}
+
+// errorObject 把执行中的错误转换为错误对象，并记录当前帧中出错语句的位置
+func (vm *VM) errorObject(err error) *object.Error {
+	var errObj *object.Error
+	var thrown *thrownError
+	if errors.As(err, &thrown) {
+		errObj = thrown.obj
+	} else {
+		errObj = &object.Error{Message: err.Error()}
+	}
+	frame := vm.currentFrame()
+	if pos, ok := frame.cl.Fn.SourceMap.Lookup(frame.ip); ok {
+		errObj.SetPosition(pos.Line, pos.Column)
+	}
+	return errObj
+}
+
+// run 执行指令直到程序结束或出错
+func (vm *VM) run() error {
+	var ip int
+	var ins code.Instructions
+	var op code.Opcode
+
+	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		err := vm.budget.Step()
+		if err != nil {
+			return err
+		}
+		// 调用和返回会切换当前帧，每条指令开始时重新获取
+		frame := vm.currentFrame()
+		frame.ip++
+
+		ip = frame.ip
+		ins = frame.Instructions()
+		op = code.Opcode(ins[ip])
+		if vm.hook != nil {
+			if err := vm.hook.BeforeInstruction(vm); err != nil {
+				return &fatalError{err: err}
+			}
+		}
 		// 直接取op并转化为操作码，而不是使用lookup，因为这会很慢
 		switch op {
 		case code.OpConstant:
-			constIndex := code.ReadUnit16(vm.instructions[ip+1:]) //ReadUnit16期望读取两个字节，因此不用特地使用[ip+1:ip+3]
-			ip += 2
+			constIndex := code.ReadUnit16(ins[ip+1:]) //ReadUnit16期望读取两个字节，因此不用特地使用[ip+1:ip+3]
+			frame.ip += 2
 			err := vm.push(vm.constants[constIndex])
 			if err != nil {
 				return err
@@ -57,6 +320,13 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpAddConst, code.OpSubConst:
+			constIndex := code.ReadUnit16(ins[ip+1:])
+			frame.ip += 2
+			err := vm.executeConstOperation(op, vm.constants[constIndex])
+			if err != nil {
+				return err
+			}
 		case code.OpTrue:
 			err := vm.push(True)
 			if err != nil {
@@ -65,7 +335,7 @@ func (vm *VM) Run() error {
 		case code.OpFalse:
 			err := vm.push(False)
 			if err != nil {
-				return nil
+				return err
 			}
 		case code.OpBang:
 			err := vm.executeBangOperator()
@@ -78,14 +348,24 @@ func (vm *VM) Run() error {
 				return err
 			}
 		case code.OpJump:
-			pos := int(code.ReadUnit16(vm.instructions[ip+1:]))
-			ip = pos - 1 // pos减一是因为循环的时候还会加一
+			pos := int(code.ReadUnit16(ins[ip+1:]))
+			frame.ip = pos - 1 // pos减一是因为循环的时候还会加一
 		case code.OpJumpNotTruthy:
-			pos := int(code.ReadUnit16(vm.instructions[ip+1:]))
-			ip += 2
+			pos := int(code.ReadUnit16(ins[ip+1:]))
+			frame.ip += 2
 			condition := vm.pop()
 			if !isTruthy(condition) {
-				ip = pos - 1
+				frame.ip = pos - 1
+			}
+		case code.OpJumpNotEqual, code.OpJumpEqual, code.OpJumpNotGreater:
+			pos := int(code.ReadUnit16(ins[ip+1:]))
+			frame.ip += 2
+			jump, err := vm.executeCompareJump(op)
+			if err != nil {
+				return err
+			}
+			if jump {
+				frame.ip = pos - 1
 			}
 		case code.OpNull:
 			err := vm.push(Null)
@@ -93,26 +373,360 @@ func (vm *VM) Run() error {
 				return err
 			}
 		case code.OpSetGlobal:
-			globalIndex := code.ReadUnit16(vm.instructions[ip+1:])
-			ip += 2
+			globalIndex := code.ReadUnit16(ins[ip+1:])
+			frame.ip += 2
 			vm.globals[globalIndex] = vm.pop()
 		case code.OpGetGlobal:
-			globalIndex := code.ReadUnit16(vm.instructions[ip+1:])
-			ip += 2
+			globalIndex := code.ReadUnit16(ins[ip+1:])
+			frame.ip += 2
 			err := vm.push(vm.globals[globalIndex])
 			if err != nil {
 				return err
 			}
+		case code.OpArray:
+			numElements := int(code.ReadUnit16(ins[ip+1:]))
+			frame.ip += 2
+
+			err := vm.budget.Allocate()
+			if err != nil {
+				return err
+			}
+			array := vm.buildArray(vm.sp-numElements, vm.sp)
+			vm.sp = vm.sp - numElements
+			err = vm.push(array)
+			if err != nil {
+				return err
+			}
+		case code.OpHash:
+			numElements := int(code.ReadUnit16(ins[ip+1:]))
+			frame.ip += 2
+
+			err := vm.budget.Allocate()
+			if err != nil {
+				return err
+			}
+			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
+			if err != nil {
+				return err
+			}
+			vm.sp = vm.sp - numElements
+			err = vm.push(hash)
+			if err != nil {
+				return err
+			}
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+			err := vm.executeIndexExpression(left, index)
+			if err != nil {
+				return err
+			}
+		case code.OpIsArray:
+			n := int(code.ReadUnit16(ins[ip+1:]))
+			rest := code.ReadUint8(ins[ip+3:]) == 1
+			frame.ip += 3
+
+			array, ok := vm.pop().(*object.Array)
+			matched := ok && (len(array.Elements) == n || rest && len(array.Elements) > n)
+			err := vm.push(nativeBoolToBooleanObject(matched))
+			if err != nil {
+				return err
+			}
+		case code.OpIsHash:
+			_, ok := vm.pop().(*object.Hash)
+			err := vm.push(nativeBoolToBooleanObject(ok))
+			if err != nil {
+				return err
+			}
+		case code.OpHasKey:
+			key := vm.pop()
+			hash, ok := vm.pop().(*object.Hash)
+			if ok {
+				_, ok = hash.Lookup(key)
+			}
+			err := vm.push(nativeBoolToBooleanObject(ok))
+			if err != nil {
+				return err
+			}
+		case code.OpSliceFrom:
+			start := int(code.ReadUnit16(ins[ip+1:]))
+			frame.ip += 2
+
+			err := vm.budget.Allocate()
+			if err != nil {
+				return err
+			}
+			obj := vm.pop()
+			array, ok := obj.(*object.Array)
+			if !ok || len(array.Elements) < start {
+				return fmt.Errorf("cannot take elements from %d of %s", start, obj.Inspect())
+			}
+			elements := make([]object.Object, len(array.Elements)-start)
+			copy(elements, array.Elements[start:])
+			err = vm.push(&object.Array{Elements: elements})
+			if err != nil {
+				return err
+			}
+		case code.OpCheckArray:
+			n := int(code.ReadUnit16(ins[ip+1:]))
+			rest := code.ReadUint8(ins[ip+3:]) == 1
+			frame.ip += 3
+
+			if mismatch := object.ArrayMismatch(vm.pop(), n, rest); mismatch != "" {
+				return destructuringError(mismatch)
+			}
+		case code.OpCheckHash:
+			if mismatch := object.HashMismatch(vm.pop()); mismatch != "" {
+				return destructuringError(mismatch)
+			}
+		case code.OpCheckKey:
+			key := vm.pop()
+			if mismatch := object.KeyMismatch(vm.pop(), key); mismatch != "" {
+				return destructuringError(mismatch)
+			}
+		case code.OpCheckEqual:
+			literal := vm.pop()
+			if mismatch := object.ValueMismatch(vm.pop(), literal); mismatch != "" {
+				return destructuringError(mismatch)
+			}
+		case code.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+			err := object.SetIndex(left, index, value)
+			if err != nil {
+				return err
+			}
+			err = vm.push(value)
+			if err != nil {
+				return err
+			}
+		case code.OpMakeCell:
+			err := vm.budget.Allocate()
+			if err != nil {
+				return err
+			}
+			err = vm.push(&cell{value: vm.pop()})
+			if err != nil {
+				return err
+			}
+		case code.OpLoadCell:
+			c, ok := vm.pop().(*cell)
+			if !ok {
+				return fmt.Errorf("OpLoadCell: not a cell")
+			}
+			err := vm.push(c.value)
+			if err != nil {
+				return err
+			}
+		case code.OpStoreCell:
+			c, ok := vm.pop().(*cell)
+			if !ok {
+				return fmt.Errorf("OpStoreCell: not a cell")
+			}
+			c.value = vm.pop()
 		case code.OpPop:
 			vm.pop()
+		case code.OpCall:
+			numArgs := int(code.ReadUnit16(ins[ip+1:]))
+			frame.ip += 2
+
+			err := vm.executeCall(numArgs)
+			if err != nil {
+				return err
+			}
+		case code.OpReturnValue:
+			returnValue := vm.pop()
+
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1 // 同时弹出被调用的函数
+
+			err := vm.push(returnValue)
+			if err != nil {
+				return err
+			}
+		case code.OpReturn:
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+
+			err := vm.push(Null)
+			if err != nil {
+				return err
+			}
+		case code.OpSetLocal:
+			localIndex := int(code.ReadUnit16(ins[ip+1:]))
+			frame.ip += 2
+
+			vm.stack[frame.basePointer+localIndex] = vm.pop()
+		case code.OpGetLocal:
+			localIndex := int(code.ReadUnit16(ins[ip+1:]))
+			frame.ip += 2
+
+			err := vm.push(vm.stack[frame.basePointer+localIndex])
+			if err != nil {
+				return err
+			}
+		case code.OpGetBuiltin:
+			builtinIndex := int(code.ReadUint8(ins[ip+1:]))
+			frame.ip += 1
+
+			err := vm.push(object.Builtins[builtinIndex])
+			if err != nil {
+				return err
+			}
+		case code.OpClosure:
+			constIndex := int(code.ReadUnit16(ins[ip+1:]))
+			numFree := int(code.ReadUnit16(ins[ip+3:]))
+			frame.ip += 4
+
+			err := vm.pushClosure(constIndex, numFree)
+			if err != nil {
+				return err
+			}
+		case code.OpGetFree:
+			freeIndex := int(code.ReadUnit16(ins[ip+1:]))
+			frame.ip += 2
+
+			currentClosure := frame.cl
+			err := vm.push(currentClosure.Free[freeIndex])
+			if err != nil {
+				return err
+			}
+		case code.OpCurrentClosure:
+			err := vm.push(frame.cl)
+			if err != nil {
+				return err
+			}
+		case code.OpImport:
+			fnIndex := int(code.ReadUnit16(ins[ip+1:]))
+			slot := int(code.ReadUnit16(ins[ip+3:]))
+			frame.ip += 4
+
+			if module := vm.globals[slot]; module != nil {
+				err := vm.push(module)
+				if err != nil {
+					return err
+				}
+				break
+			}
+			err := vm.importModule(fnIndex)
+			if err != nil {
+				return err
+			}
+		case code.OpModule:
+			pathIndex := int(code.ReadUnit16(ins[ip+1:]))
+			frame.ip += 2
+
+			exports := vm.pop().(*object.Hash)
+			path := vm.constants[pathIndex].(*object.String)
+			err := vm.push(&object.Module{Path: path.Value, Exports: exports})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// executeCall 调用栈上位于numArgs个参数之下的函数
+func (vm *VM) executeCall(numArgs int) error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+	case *object.Builtin:
+		return vm.callBuiltin(callee, numArgs)
+	default:
+		return fmt.Errorf("calling non-function and non-built-in")
+	}
+}
+
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
+	if numArgs != cl.Fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, numArgs)
+	}
+
+	frame := NewFrame(cl, vm.sp-numArgs)
+	err := vm.pushFrame(frame)
+	if err != nil {
+		return err
+	}
+
+	// 参数已经在栈上，作为前几个局部变量；为其余局部变量预留空间
+	sp := frame.basePointer + cl.Fn.NumLocals
+	if sp > len(vm.stack) {
+		if err := vm.growStack(sp); err != nil {
+			return err
 		}
 	}
+	vm.sp = sp
 	return nil
 }
 
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
+	args := vm.stack[vm.sp-numArgs : vm.sp]
+
+	result, err := vm.host.Call(builtin, args...)
+	if err != nil {
+		return err
+	}
+	if errObj, ok := result.(*object.Error); ok {
+		return &thrownError{obj: errObj}
+	}
+	vm.sp = vm.sp - numArgs - 1
+
+	if result != nil {
+		err := vm.budget.Allocate()
+		if err != nil {
+			return err
+		}
+		return vm.push(result)
+	}
+	return vm.push(Null)
+}
+
+// importModule 调用模块函数执行模块，模块函数会把结果缓存到全局变量中
+func (vm *VM) importModule(fnIndex int) error {
+	fn, ok := vm.constants[fnIndex].(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a module: %+v", vm.constants[fnIndex])
+	}
+
+	cl := &object.Closure{Fn: fn}
+	err := vm.push(cl)
+	if err != nil {
+		return err
+	}
+	return vm.callClosure(cl, 0)
+}
+
+// pushClosure 用栈顶的numFree个自由变量和常量池中的函数构造闭包
+func (vm *VM) pushClosure(constIndex int, numFree int) error {
+	constant := vm.constants[constIndex]
+	function, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a function: %+v", constant)
+	}
+
+	free := make([]object.Object, numFree)
+	for i := 0; i < numFree; i++ {
+		free[i] = vm.stack[vm.sp-numFree+i]
+	}
+	vm.sp = vm.sp - numFree
+
+	err := vm.budget.Allocate()
+	if err != nil {
+		return err
+	}
+	closure := &object.Closure{Fn: function, Free: free}
+	return vm.push(closure)
+}
+
 func (vm *VM) push(o object.Object) error {
 	if vm.sp >= len(vm.stack) {
-		return fmt.Errorf("stack overflow")
+		if err := vm.growStack(vm.sp + 1); err != nil {
+			return err
+		}
 	}
 
 	vm.stack[vm.sp] = o
@@ -120,6 +734,18 @@ func (vm *VM) push(o object.Object) error {
 	return nil
 }
 
+// growStack 把栈扩大到至少n个位置，一般扩大为原来的两倍，超过上限时返回错误
+func (vm *VM) growStack(n int) error {
+	if n > vm.maxStack {
+		return &fatalError{err: fmt.Errorf("stack overflow")}
+	}
+	size := min(max(n, 2*len(vm.stack)), vm.maxStack)
+	stack := make([]object.Object, size)
+	copy(stack, vm.stack)
+	vm.stack = stack
+	return nil
+}
+
 func (vm *VM) pop() object.Object {
 	o := vm.stack[vm.sp-1]
 	vm.sp--
@@ -145,8 +771,12 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	switch {
 	case leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ:
 		return vm.executeBinaryIntegerOperation(op, left, right)
-	case leftType == object.BOOLEAN_OBJ && rightType == object.BOOLEAN_OBJ:
-		return vm.executeBinaryBooleanOperation(op, left, right)
+	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ && op == code.OpAdd:
+		return vm.executeStringConcatenation(left, right)
+	case op == code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(left.Equals(right)))
+	case op == code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(!left.Equals(right)))
 	default:
 		return fmt.Errorf("unsupport types for binary operation: %s %s", leftType, rightType)
 	}
@@ -164,6 +794,9 @@ func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left object.Object,
 	case code.OpSub:
 		result = leftValue - rightValue
 	case code.OpDiv:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
 		result = leftValue / rightValue
 	case code.OpMul:
 		result = leftValue * rightValue
@@ -189,7 +822,89 @@ func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left object.Object,
 	default:
 		return fmt.Errorf("unkonwn integer operator:%d", op)
 	}
-	return vm.push(&object.Integer{result})
+	integer, err := vm.newInteger(result)
+	if err != nil {
+		return err
+	}
+	return vm.push(integer)
+}
+
+// executeStringConcatenation 拼接两个字符串，与求值器的字符串+一致
+func (vm *VM) executeStringConcatenation(left object.Object, right object.Object) error {
+	err := vm.budget.Allocate()
+	if err != nil {
+		return err
+	}
+	return vm.push(&object.String{Value: left.(*object.String).Value + right.(*object.String).Value})
+}
+
+// newInteger 返回值为value的整数，小整数取自缓存，不计入分配次数
+func (vm *VM) newInteger(value int64) (object.Object, error) {
+	if value >= minCachedInteger && value <= maxCachedInteger {
+		return cachedIntegers[value-minCachedInteger], nil
+	}
+	err := vm.budget.Allocate()
+	if err != nil {
+		return nil, err
+	}
+	return &object.Integer{Value: value}, nil
+}
+
+// executeConstOperation 执行OpAddConst和OpSubConst，right为整数常量。
+// 栈顶不是整数时按OpConstant加OpAdd或OpSub执行，出错时的信息与之相同
+func (vm *VM) executeConstOperation(op code.Opcode, right object.Object) error {
+	if left, ok := vm.stack[vm.sp-1].(*object.Integer); ok {
+		value := right.(*object.Integer).Value
+		if op == code.OpSubConst {
+			value = -value
+		}
+		integer, err := vm.newInteger(left.Value + value)
+		if err != nil {
+			return err
+		}
+		vm.stack[vm.sp-1] = integer
+		return nil
+	}
+
+	err := vm.push(right)
+	if err != nil {
+		return err
+	}
+	if op == code.OpSubConst {
+		return vm.executeBinaryOperation(code.OpSub)
+	}
+	return vm.executeBinaryOperation(code.OpAdd)
+}
+
+// executeCompareJump 比较并弹出栈顶的两个值，返回OpJumpNotEqual、OpJumpEqual或OpJumpNotGreater是否跳转
+func (vm *VM) executeCompareJump(op code.Opcode) (bool, error) {
+	left, lok := vm.stack[vm.sp-2].(*object.Integer)
+	right, rok := vm.stack[vm.sp-1].(*object.Integer)
+	if lok && rok {
+		vm.sp -= 2
+		switch op {
+		case code.OpJumpNotEqual:
+			return left.Value != right.Value, nil
+		case code.OpJumpEqual:
+			return left.Value == right.Value, nil
+		default:
+			return left.Value <= right.Value, nil
+		}
+	}
+
+	// 其他类型分比较和OpJumpNotTruthy两步执行
+	compare := code.OpGreaterThan
+	switch op {
+	case code.OpJumpNotEqual:
+		compare = code.OpEqual
+	case code.OpJumpEqual:
+		compare = code.OpNotEqual
+	}
+	err := vm.executeBinaryOperation(compare)
+	if err != nil {
+		return false, err
+	}
+	return !isTruthy(vm.pop()), nil
 }
 
 func (vm *VM) executeBangOperator() error {
@@ -213,27 +928,86 @@ func (vm *VM) executeMinusOperator() error {
 		return fmt.Errorf("unsupported type for negation:%s", operand.Type())
 	}
 	value := operand.(*object.Integer).Value
-	return vm.push(&object.Integer{-value})
+	integer, err := vm.newInteger(-value)
+	if err != nil {
+		return err
+	}
+	return vm.push(integer)
 }
-func (vm *VM) executeBinaryBooleanOperation(op code.Opcode, left object.Object, right object.Object) error {
-	leftValue := left.(*object.Boolean).Value
-	rightValue := right.(*object.Boolean).Value
 
-	var result bool
+// buildArray 用栈上[startIndex, endIndex)的元素构造数组
+func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
+	elements := make([]object.Object, endIndex-startIndex)
+	for i := startIndex; i < endIndex; i++ {
+		elements[i-startIndex] = vm.stack[i]
+	}
+	return &object.Array{Elements: elements}
+}
 
-	switch op {
-	case code.OpEqual:
-		result = leftValue == rightValue
-	case code.OpNotEqual:
-		result = leftValue != rightValue
+// buildHash 用栈上[startIndex, endIndex)的元素构造哈希，元素按键、值交替排列
+func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
+	hashedPairs := make(map[object.HashKey]object.HashPair)
+	for i := startIndex; i < endIndex; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+
+		hashKey, ok := object.HashKeyOf(key)
+		if !ok {
+			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+		}
+		hashedPairs[hashKey] = object.HashPair{Key: object.FreezeKey(key), Value: value}
+	}
+	return &object.Hash{Pairs: hashedPairs}, nil
+}
+
+func (vm *VM) executeIndexExpression(left, index object.Object) error {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.executeArrayIndex(left, index)
+	case left.Type() == object.HASH_OBJ:
+		return vm.executeHashIndex(left, index)
+	case left.Type() == object.MODULE_OBJ:
+		return vm.executeHashIndex(left.(*object.Module).Exports, index)
 	default:
-		return fmt.Errorf("unkonwn integer operator:%d", op)
+		return fmt.Errorf("index operator not supported: %s", left.Type())
 	}
-	if result {
-		return vm.push(True)
-	} else {
-		return vm.push(False)
+}
+
+func (vm *VM) executeArrayIndex(array, index object.Object) error {
+	arrayObject := array.(*object.Array)
+	i := index.(*object.Integer).Value
+	maxId := int64(len(arrayObject.Elements) - 1)
+
+	if i < 0 || i > maxId {
+		return vm.push(Null)
+	}
+	return vm.push(arrayObject.Elements[i])
+}
+
+func (vm *VM) executeHashIndex(hash, index object.Object) error {
+	hashObject := hash.(*object.Hash)
+
+	if _, ok := object.HashKeyOf(index); !ok {
+		return fmt.Errorf("unusable as hash key: %s", index.Type())
+	}
+
+	pair, ok := hashObject.Lookup(index)
+	if !ok {
+		return vm.push(Null)
 	}
+	return vm.push(pair.Value)
+}
+
+// destructuringError 解构赋值的值与模式不匹配，信息与求值器相同
+func destructuringError(mismatch string) error {
+	return fmt.Errorf("destructuring mismatch: %s", mismatch)
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return True
+	}
+	return False
 }
 
 func isTruthy(obj object.Object) bool {
@@ -246,4 +1020,5 @@ func isTruthy(obj object.Object) bool {
 		return true
 	}
 }
-//a
\ No newline at end of file
+
+//a
@@ -2,12 +2,20 @@ package vm
 
 import (
 	"Monkey/ast"
+	"Monkey/code"
 	"Monkey/compiler"
 	"Monkey/lexer"
 	"Monkey/object"
 	"Monkey/parser"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 type vmTestCase struct {
@@ -26,9 +34,13 @@ func runVmTests(t *testing.T, tt vmTestCase) {
 	if err != nil {
 		t.Fatalf("compiler fail.%s", err)
 	}
+	// 编译器生成的字节码都应该通过校验
+	if err := Verify(comp.Bytecode()); err != nil {
+		t.Fatalf("verify fail.%s", err)
+	}
 
 	vm := New(comp.Bytecode())
-	err = vm.Run()
+	err = vm.Run(context.Background())
 	if err != nil {
 		t.Fatalf("vm error:%s", err)
 	}
@@ -51,11 +63,68 @@ func testExpectedObject(t *testing.T, expected any, actual object.Object) {
 		if err != nil {
 			t.Fatalf("testBooleanObject failed:%s", err)
 		}
+	case string:
+		err := testStringObject(expected, actual)
+		if err != nil {
+			t.Fatalf("testStringObject failed:%s", err)
+		}
+	case []int:
+		array, ok := actual.(*object.Array)
+		if !ok {
+			t.Fatalf("object not Array: %T (%+v)", actual, actual)
+		}
+		if len(array.Elements) != len(expected) {
+			t.Fatalf("wrong num of elements. want=%d, got=%d", len(expected), len(array.Elements))
+		}
+		for i, expectedElem := range expected {
+			err := testIntegerObject(int64(expectedElem), array.Elements[i])
+			if err != nil {
+				t.Fatalf("testIntegerObject failed:%s", err)
+			}
+		}
+	case map[object.HashKey]int64:
+		hash, ok := actual.(*object.Hash)
+		if !ok {
+			t.Fatalf("object is not Hash. got=%T (%+v)", actual, actual)
+		}
+		if len(hash.Pairs) != len(expected) {
+			t.Fatalf("hash has wrong number of Pairs. want=%d, got=%d", len(expected), len(hash.Pairs))
+		}
+		for expectedKey, expectedValue := range expected {
+			pair, ok := hash.Pairs[expectedKey]
+			if !ok {
+				t.Fatalf("no pair for given key in Pairs")
+			}
+			err := testIntegerObject(expectedValue, pair.Value)
+			if err != nil {
+				t.Fatalf("testIntegerObject failed:%s", err)
+			}
+		}
 	case *object.Null:
 		if actual != Null {
 			t.Errorf("object is not Null :%T(%+v)", actual, actual)
 		}
+	case *object.Error:
+		errObj, ok := actual.(*object.Error)
+		if !ok {
+			t.Fatalf("object is not Error: %T (%+v)", actual, actual)
+		}
+		if errObj.Message != expected.Message {
+			t.Fatalf("wrong error message. want=%q, got=%q", expected.Message, errObj.Message)
+		}
+	}
+}
+
+func testStringObject(expected string, actual object.Object) error {
+	result, ok := actual.(*object.String)
+	if !ok {
+		return fmt.Errorf("object is not String. got=%T (%+v)", actual, actual)
 	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%q, want=%q", result.Value, expected)
+	}
+	return nil
 }
 
 func testIntegerObject(expected int64, actual object.Object) error {
@@ -194,3 +263,872 @@ func TestGlobalLetStatements(t *testing.T) {
 	}
 }
 
+func TestStringExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{`"monkey"`, "monkey"},
+		{`"mon" + "key"`, "monkey"},
+		{`"mon" + "key" + "banana"`, "monkeybanana"},
+		{`let s = "a"; s + s + "b"`, "aab"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			runVmTests(t, tt)
+		})
+	}
+}
+
+func TestArrayLiterals(t *testing.T) {
+	tests := []vmTestCase{
+		{"[]", []int{}},
+		{"[1, 2, 3]", []int{1, 2, 3}},
+		{"[1 + 2, 3 * 4, 5 + 6]", []int{3, 12, 11}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			runVmTests(t, tt)
+		})
+	}
+}
+
+func TestHashLiterals(t *testing.T) {
+	tests := []vmTestCase{
+		{"{}", map[object.HashKey]int64{}},
+		{"{1: 2, 2: 3}", map[object.HashKey]int64{
+			(&object.Integer{Value: 1}).HashKey(): 2,
+			(&object.Integer{Value: 2}).HashKey(): 3,
+		}},
+		{"{1 + 1: 2 * 2, 3 + 3: 4 * 4}", map[object.HashKey]int64{
+			(&object.Integer{Value: 2}).HashKey(): 4,
+			(&object.Integer{Value: 6}).HashKey(): 16,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			runVmTests(t, tt)
+		})
+	}
+}
+
+func TestIndexExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"[1, 2, 3][1]", 2},
+		{"[[1, 1, 1]][0][0]", 1},
+		{"[][0]", Null},
+		{"[1, 2, 3][99]", Null},
+		{"[1][-1]", Null},
+		{"{1: 1, 2: 2}[1]", 1},
+		{"{1: 1}[0]", Null},
+		{"{}[0]", Null},
+		{`{"a": 1}["a"]`, 1},
+		{"{[1, 2]: 3}[[1, 2]]", 3},
+		{`{{"a": 1}: 2}[{"a": 1}]`, 2},
+		{"{true: 5}[true]", 5},
+		{"{[1]: 1}[[2]]", Null},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			runVmTests(t, tt)
+		})
+	}
+}
+
+func TestStructuralEquality(t *testing.T) {
+	tests := []vmTestCase{
+		{`"a" == "a"`, true},
+		{`"a" == "b"`, false},
+		{`"a" != "b"`, true},
+		{"[1, 2] == [1, 2]", true},
+		{"[1, 2] == [2, 1]", false},
+		{"[1, 2] != [1, 2, 3]", true},
+		{"[[1], [2]] == [[1], [2]]", true},
+		{`{"a": 1, "b": 2} == {"b": 2, "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{"if (false) { 1 } == if (false) { 2 }", true},
+		{`1 == "1"`, false},
+		{"1 != true", true},
+		{"[1] == 1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			runVmTests(t, tt)
+		})
+	}
+}
+
+func TestCallingFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let f = fn() { 5 + 10 }; f()", 15},
+		{"let f = fn() { return 1; 2 }; f()", 1},
+		{"let f = fn() { }; f()", Null},
+		{"let sum = fn(a, b) { let c = a + b; c }; sum(1, 2) + sum(3, 4)", 10},
+		{"let g = 10; let f = fn(a) { let b = 5; a + b + g }; f(1)", 16},
+		{"let one = fn() { 1 }; let two = fn() { one() + one() }; two()", 2},
+		{"fn(a) { a * 2 }(4)", 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			runVmTests(t, tt)
+		})
+	}
+}
+
+func TestCallingFunctionsWithWrongArguments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn() { 1 }(1)", "wrong number of arguments: want=0, got=1"},
+		{"fn(a, b) { a }(1)", "wrong number of arguments: want=2, got=1"},
+		{"1(1)", "calling non-function and non-built-in"},
+		{"len(1)", "argument to `len` not supported, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			comp := compiler.New()
+			if err := comp.Compile(parse(tt.input)); err != nil {
+				t.Fatalf("compiler fail.%s", err)
+			}
+			err := New(comp.Bytecode()).Run(context.Background())
+			if err == nil || err.Error() != tt.expected {
+				t.Fatalf("wrong error. want=%q, got=%v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestClosures(t *testing.T) {
+	tests := []vmTestCase{
+		{"let newAdder = fn(a) { fn(b) { a + b } }; let addTwo = newAdder(2); addTwo(3)", 5},
+		{"let f = fn(a) { let b = a * 2; fn(c) { fn(d) { a + b + c + d } } }; f(1)(2)(3)", 8},
+		{"let countDown = fn(x) { if (x == 0) { return 0 } countDown(x - 1) }; countDown(5)", 0},
+		{"let wrapper = fn() { let inner = fn(x) { if (x == 0) { 0 } else { inner(x - 1) } }; inner(3) }; wrapper()", 0},
+		{"let fib = fn(n) { if (n < 2) { return n } fib(n - 1) + fib(n - 2) }; fib(15)", 610},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			runVmTests(t, tt)
+		})
+	}
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{`len("four")`, 4},
+		{"len([1, 2, 3])", 3},
+		{"first([1, 2])", 1},
+		{"first([])", Null},
+		{"last([1, 2])", 2},
+		{"rest([1, 2, 3])", []int{2, 3}},
+		{"push([1], 2)", []int{1, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			runVmTests(t, tt)
+		})
+	}
+}
+
+func TestTryCatch(t *testing.T) {
+	tests := []vmTestCase{
+		{"try { 1 } catch (e) { 2 }", 1},
+		{"try { 1 + true } catch (e) { e[\"message\"] }", "unsupport types for binary operation: INTEGER BOOLEAN"},
+		{"try { len(1) } catch (e) { e[\"type\"] }", "RuntimeError"},
+		{`try { throw("boom") } catch (e) { [e["message"], e["type"]] == ["boom", "Error"] }`, true},
+		{`try { throw(42) } catch (e) { e["value"] }`, 42},
+		{`try { throw({"type": "ValueError", "message": "bad"}) } catch (e) { e["type"] }`, "ValueError"},
+		{"1 + try { 2 + throw(3) } catch (e) { 10 }", 11},
+		{"let f = fn() { let a = 5; try { a + len(1) } catch (e) { a + e[\"line\"] } }; f()", 6},
+		{`try { try { throw("inner") } catch (e) { throw(len(e["message"])) } } catch (e) { e["value"] }`, 5},
+		{"try {\n  1;\n  {}[fn() {}]\n} catch (e) { [e[\"line\"], e[\"column\"]] }", []int{3, 3}},
+		{`let f = fn() { throw("x") }; let g = fn() { try { f() } catch (e) { 1 } }; g() + g()`, 2},
+		{"let f = fn(n) { if (n == 0) { throw(0) } f(n - 1) }; try { f(100) } catch (e) { e[\"value\"] + 1 }", 1},
+		{"try { } catch (e) { 1 }", Null},
+		{`try { 1 / 0 } catch (e) { e["message"] }`, "division by zero"},
+		{`let d = fn(a, b) { a / b }; try { d(1, 0) } catch (e) { e["type"] }`, "RuntimeError"},
+		{`let f = fn(a) { a }; try { f() } catch (e) { e["message"] }`, "wrong number of arguments: want=1, got=0"},
+		{`try { fn() { 1 }(1) } catch (e) { e["message"] }`, "wrong number of arguments: want=0, got=1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			runVmTests(t, tt)
+			for _, level := range []int{compiler.OptimizeConstant, compiler.OptimizeJumps, compiler.OptimizeFused} {
+				result, _, err := runOptimized(t, tt.input, level)
+				if err != nil {
+					t.Fatalf("-O%d: vm error: %s", level, err)
+				}
+				testExpectedObject(t, tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestMatchExpression(t *testing.T) {
+	tests := []vmTestCase{
+		{"match (1) { 0 => 10, 1 => 11, _ => 12 }", 11},
+		{"match (5) { 0 => 10, n => n * 2 }", 10},
+		{"match (-1) { -1 => true, _ => false }", true},
+		{`match ("b") { "a" => 1, "b" => 2 }`, 2},
+		{`match (1) { "1" => 1, true => 2, 1 => 3 }`, 3},
+		{"match (true) { false => 0, true => 1 }", 1},
+		{"match (7) { 0 => 1 }", Null},
+		{"match (1) { }", Null},
+		{"match ([1, 2]) { [a] => a, [a, b] => a + b, _ => 0 }", 3},
+		{"match ([1, 2, 3]) { [a, b] => 0, [a, ...rest] => rest }", []int{2, 3}},
+		{"match ([1]) { [a, ...rest] => rest }", []int{}},
+		{"match ([1, 2, 3]) { [a, ...r] if a > 1 => 0, [a, b, ...r] => r, [...r] => 1 }", []int{3}},
+		{"match ([]) { [] => 1, _ => 2 }", 1},
+		{"match ([[1, 2], 3]) { [[1, x], y] => x + y }", 5},
+		{"match ([0, 5]) { [1, x] => x, [0, x] => -x }", -5},
+		{`match ({"kind": "circle", "r": 2}) { {"kind": "square", "side": s} => s * s, {"kind": "circle", "r": r} => 3 * r * r }`, 12},
+		{`match ({"kind": "circle"}) { {"kind": "circle", "r": r} => r, {"kind": k} => k }`, "circle"},
+		{`match ({1: true}) { {1: true} => "yes", _ => "no" }`, "yes"},
+		{`match ([1, 2]) { {"a": a} => a, {} => 1, _ => 2 }`, 2},
+		{"match (3) { n if n > 5 => 1, n if n > 1 => 2, _ => 3 }", 2},
+		{"match ([4, 5]) { [a, b] if a > b => a, [a, b] => b }", 5},
+		{"let x = 1; match (2) { x => x }; x", 1},
+		{"let x = 5; match (1) { 1 => x, [x] => x }", 5},
+		{"let f = fn(p) { match (p) { [x, y] => x * y, {\"n\": n} => n, _ => 0 } }; [f([2, 3]), f({\"n\": 4}), f(5)]", []int{6, 4, 0}},
+		{"let f = fn(n) { match (n) { 0 => 1, _ => n * f(n - 1) } }; f(5)", 120},
+		{"match (match (1) { 1 => [2] }) { [x] => match (x) { 2 => 20 } }", 20},
+		{"try { match (1) { n if n + true => 1 } } catch (e) { e[\"type\"] }", "RuntimeError"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			runVmTests(t, tt)
+			for _, level := range []int{compiler.OptimizeConstant, compiler.OptimizeJumps, compiler.OptimizeFused} {
+				result, _, err := runOptimized(t, tt.input, level)
+				if err != nil {
+					t.Fatalf("-O%d: vm error: %s", level, err)
+				}
+				testExpectedObject(t, tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestDestructuringLet(t *testing.T) {
+	tests := []vmTestCase{
+		{"let [a, b] = [1, 2]; a + b", 3},
+		{"let [a, _, c] = [1, 2, 3]; [a, c]", []int{1, 3}},
+		{"let [first, ...rest] = [1, 2, 3]; rest", []int{2, 3}},
+		{"let [x, ...rest] = [1]; rest", []int{}},
+		{"let [[a, b], c] = [[1, 2], 3]; a + b + c", 6},
+		{`let {name, age} = {"name": "ann", "age": 30}; name`, "ann"},
+		{`let {"name": n, "tags": [t]} = {"name": "x", "tags": ["y"]}; t`, "y"},
+		{`let {1: one} = {1: "a", 2: "b"}; one`, "a"},
+		{"let [1, x] = [1, 2]; x", 2},
+		{"let f = fn() { [1, 2] }; let g = fn() { let [a, b] = f(); a * 10 + b }; g()", 12},
+		{"let a = 1; let [a, b] = [a + 1, a]; [a, b]", []int{2, 1}},
+		{"try { let [a, b] = [1]; a } catch (e) { e[\"message\"] }", "destructuring mismatch: expected array of 2 elements, got 1"},
+		{"try { let [a, b, ...r] = [1]; a } catch (e) { e[\"message\"] }", "destructuring mismatch: expected array of at least 2 elements, got 1"},
+		{"try { let [a] = 1; a } catch (e) { e[\"message\"] }", "destructuring mismatch: expected array, got INTEGER"},
+		{`try { let {name} = [1]; name } catch (e) { e["message"] }`, "destructuring mismatch: expected hash, got ARRAY"},
+		{`try { let {name} = {"age": 1}; name } catch (e) { e["message"] }`, `destructuring mismatch: missing key "name"`},
+		{"try { let [1, x] = [2, 3]; x } catch (e) { e[\"message\"] }", "destructuring mismatch: expected 1, got 2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			runVmTests(t, tt)
+			for _, level := range []int{compiler.OptimizeConstant, compiler.OptimizeJumps, compiler.OptimizeFused} {
+				result, _, err := runOptimized(t, tt.input, level)
+				if err != nil {
+					t.Fatalf("-O%d: vm error: %s", level, err)
+				}
+				testExpectedObject(t, tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestAssignment(t *testing.T) {
+	tests := []vmTestCase{
+		{"var x = 1; x = x + 1; x", 2},
+		{"var x = 1; x = 5", 5},
+		{"var a = 0; var b = 0; a = b = 3; [a, b]", []int{3, 3}},
+		{"var x = 1; let f = fn() { x = 10 }; f(); x", 10},
+		{"let counter = fn() { var n = 0; fn() { n = n + 1; n } }; let c = counter(); c(); c(); c()", 3},
+		{"let make = fn() { var n = 0; [fn() { n = n + 1 }, fn() { n }] }; let fs = make(); fs[0](); fs[0](); fs[1]()", 2},
+		{"let f = fn() { var n = 1; let g = fn() { fn() { n = n * 2 } }; g()(); g()(); n }; f()", 4},
+		{"let f = fn() { var [a, b] = [1, 2]; let g = fn() { a = a + b }; g(); a }; f()", 3},
+		{"let f = fn() { var n = 1; match (n) { 1 => n = 5 }; n }; f()", 5},
+		{"var [a, b] = [1, 2]; a = 10; [a, b]", []int{10, 2}},
+		{`var {name} = {"name": "x"}; name = "y"; name`, "y"},
+		{"var x = 1; let x = 2; x", 2},
+		{"let xs = [1, 2, 3]; xs[1] = 5; xs", []int{1, 5, 3}},
+		{`let h = {"a": 1}; h["b"] = 2; [h["a"], h["b"]]`, []int{1, 2}},
+		{"let xs = [[1], [2]]; xs[1][0] = 3; xs[1]", []int{3}},
+		{"let xs = [1]; let ys = xs; ys[0] = 2; xs", []int{2}},
+		{"let xs = freeze([1, [2]]); xs[1][0] = 3; xs[1]", []int{3}},
+		{`let key = [1]; let hk = {key: "v"}; key[0] = 2; hk[[1]]`, "v"},
+		{`let key = [1]; let hk = {key: "v"}; key[0] = 2; hk[key]`, Null},
+		{`let key = [1]; let hk = {}; hk[key] = "v"; key[0] = 2; hk[[1]]`, "v"},
+		{`let inner = {"a": [1]}; let hk = {inner: "v"}; inner["a"][0] = 2; hk[{"a": [1]}]`, "v"},
+		{`try { let xs = [1]; xs[1] = 2 } catch (e) { e["message"] }`, "index 1 out of range for array of length 1"},
+		{`try { let xs = freeze([1]); xs[0] = 2 } catch (e) { e["message"] }`, "cannot modify frozen ARRAY"},
+		{`try { let h = freeze({"a": 1}); h["a"] = 2 } catch (e) { e["message"] }`, "cannot modify frozen HASH"},
+		{`try { "abc"[0] = "x" } catch (e) { e["message"] }`, "index assignment not supported: STRING"},
+		{`try { freeze(1) } catch (e) { e["message"] }`, "argument to `freeze` must be ARRAY or HASH, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			runVmTests(t, tt)
+			for _, level := range []int{compiler.OptimizeConstant, compiler.OptimizeJumps, compiler.OptimizeFused} {
+				result, _, err := runOptimized(t, tt.input, level)
+				if err != nil {
+					t.Fatalf("-O%d: vm error: %s", level, err)
+				}
+				testExpectedObject(t, tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestUncaughtErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`throw("boom")`, "boom"},
+		{`try { throw(1) } catch (e) { throw(e["value"] + 1) }`, "2"},
+		{`try { now() } catch (e) { 1 }`, `permission denied: now requires the "time" capability`},
+		{"let f = fn() { f() }; try { f() } catch (e) { 1 }", "stack overflow"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			comp := compiler.New()
+			if err := comp.Compile(parse(tt.input)); err != nil {
+				t.Fatalf("compiler fail.%s", err)
+			}
+			machine := New(comp.Bytecode())
+			machine.SetHost(&object.Host{})
+			machine.SetMaxStackSize(1000)
+			err := machine.Run(context.Background())
+			if err == nil || err.Error() != tt.expected {
+				t.Fatalf("wrong error. want=%q, got=%v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		input    string
+		limits   object.Limits
+		expected string
+	}{
+		{"let f = fn(x) { f(x + 1) }; f(0)", object.Limits{MaxCallDepth: 50}, "call depth"},
+		{"let f = fn(x) { f(x + 1) }; f(0)", object.Limits{MaxInstructions: 1000}, "instructions"},
+		{"let f = fn(x) { f([x]) }; f(0)", object.Limits{MaxAllocations: 100}, "allocations"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			comp := compiler.New()
+			if err := comp.Compile(parse(tt.input)); err != nil {
+				t.Fatalf("compiler fail.%s", err)
+			}
+			vm := New(comp.Bytecode())
+			vm.SetLimits(tt.limits)
+
+			err := vm.Run(context.Background())
+			limitErr, ok := err.(*object.LimitError)
+			if !ok {
+				t.Fatalf("error is not LimitError. got=%T (%v)", err, err)
+			}
+			if limitErr.Limit != tt.expected {
+				t.Fatalf("wrong limit. want=%q, got=%q", tt.expected, limitErr.Limit)
+			}
+		})
+	}
+
+	comp := compiler.New()
+	if err := comp.Compile(parse("let f = fn(x) { x + 1 }; f(1)")); err != nil {
+		t.Fatalf("compiler fail.%s", err)
+	}
+	vm := New(comp.Bytecode())
+	vm.SetLimits(object.Limits{MaxInstructions: 100, MaxCallDepth: 2, MaxAllocations: 10})
+	if err := vm.Run(context.Background()); err != nil {
+		t.Fatalf("program within limits failed: %s", err)
+	}
+}
+
+func TestSmallIntegerCache(t *testing.T) {
+	comp := compiler.New()
+	if err := comp.Compile(parse("let f = fn(x) { if (x == 0) { [0, -x, 1023 + 1] } else { f(x - 1) } }; f(1000)")); err != nil {
+		t.Fatalf("compiler fail.%s", err)
+	}
+	vm := New(comp.Bytecode())
+	// 闭包、数组和1024各分配一次，递归中计算的小整数都来自缓存
+	vm.SetLimits(object.Limits{MaxAllocations: 3})
+	if err := vm.Run(context.Background()); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, []int{0, 0, 1024}, vm.LastPoppedStackElem())
+}
+
+func TestRunCancel(t *testing.T) {
+	comp := compiler.New()
+	if err := comp.Compile(parse("let f = fn(x) { if (x == 0) { 0 } else { f(x - 1) + f(x - 1) } }; f(40)")); err != nil {
+		t.Fatalf("compiler fail.%s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := New(comp.Bytecode()).Run(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error, got=%v", err)
+	}
+}
+
+func TestHostCapabilities(t *testing.T) {
+	tests := []struct {
+		input      string
+		capability object.Capability
+	}{
+		{`println("x")`, object.CapabilityIO},
+		{`readFile("x")`, object.CapabilityFS},
+		{`writeFile("x", "y")`, object.CapabilityFS},
+		{"now()", object.CapabilityTime},
+		{`getenv("HOME")`, object.CapabilityEnv},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			comp := compiler.New()
+			if err := comp.Compile(parse(tt.input)); err != nil {
+				t.Fatalf("compiler fail.%s", err)
+			}
+			vm := New(comp.Bytecode())
+			vm.SetHost(&object.Host{})
+
+			err := vm.Run(context.Background())
+			permErr, ok := err.(*object.PermissionError)
+			if !ok {
+				t.Fatalf("error is not PermissionError. got=%T (%v)", err, err)
+			}
+			if permErr.Capability != tt.capability {
+				t.Fatalf("wrong capability. want=%q, got=%q", tt.capability, permErr.Capability)
+			}
+		})
+	}
+
+	var out bytes.Buffer
+	comp := compiler.New()
+	if err := comp.Compile(parse(`println(1 + 2); len("ok")`)); err != nil {
+		t.Fatalf("compiler fail.%s", err)
+	}
+	vm := New(comp.Bytecode())
+	vm.SetHost(&object.Host{Stdout: &out, Capabilities: []object.Capability{object.CapabilityIO}})
+	if err := vm.Run(context.Background()); err != nil {
+		t.Fatalf("vm error:%s", err)
+	}
+	if out.String() != "3\n" {
+		t.Fatalf("wrong output. got=%q", out.String())
+	}
+}
+
+// writeModules 在临时目录中创建模块文件，返回该目录
+func writeModules(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func runModuleProgram(t *testing.T, dir string, input string) (object.Object, error) {
+	t.Helper()
+	comp := compiler.New()
+	comp.SetDir(dir)
+	if err := comp.Compile(parse(input)); err != nil {
+		return nil, err
+	}
+	vm := New(comp.Bytecode())
+	if err := vm.Run(context.Background()); err != nil {
+		return nil, err
+	}
+	return vm.LastPoppedStackElem(), nil
+}
+
+func TestImport(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"lib/math.mk": `
+let helper = import("util.mk");
+let double = fn(x) { helper["twice"](x) };
+let _hidden = 1;
+let square = fn(x) { x * x };
+`,
+		"lib/util.mk": `
+let calls = [];
+let twice = fn(x) { x + x };
+`,
+	})
+
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`let m = import("lib/math.mk"); m["double"](4) + m["square"](3)`, 17},
+		{`let m = import("lib/math.mk"); m["_hidden"]`, Null},
+		{`let m = import("lib/math.mk"); m["missing"]`, Null},
+		{`let f = fn() { import("lib/math.mk")["square"](5) }; f()`, 25},
+		{`import("lib/math.mk") == import("lib/math.mk")`, true},
+		{`let a = import("lib/util.mk"); let b = import("lib/math.mk")["helper"]; a == b`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := runModuleProgram(t, dir, tt.input)
+			if err != nil {
+				t.Fatalf("error: %s", err)
+			}
+			testExpectedObject(t, tt.expected, result)
+		})
+	}
+}
+
+func TestImportEvaluatesOnce(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"counter.mk": `println("loading"); let value = 1;`,
+	})
+
+	comp := compiler.New()
+	comp.SetDir(dir)
+	input := `let a = import("counter.mk"); let b = import("counter.mk"); a["value"] + b["value"]`
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler fail.%s", err)
+	}
+	var out bytes.Buffer
+	vm := New(comp.Bytecode())
+	vm.SetHost(&object.Host{Stdout: &out, Capabilities: []object.Capability{object.CapabilityIO}})
+	if err := vm.Run(context.Background()); err != nil {
+		t.Fatalf("vm error:%s", err)
+	}
+	if out.String() != "loading\n" {
+		t.Fatalf("module evaluated more than once. output=%q", out.String())
+	}
+	testExpectedObject(t, 2, vm.LastPoppedStackElem())
+}
+
+func TestImportErrors(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"a.mk":      `let b = import("b.mk");`,
+		"b.mk":      `let a = import("a.mk");`,
+		"self.mk":   `let me = import("./self.mk");`,
+		"bad.mk":    `let = 1;`,
+		"return.mk": `return 1;`,
+		"scope.mk":  `let y = x;`,
+	})
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`import("a.mk")`, "import cycle: "},
+		{`import("self.mk")`, "import cycle: "},
+		{`import("missing.mk")`, `import "missing.mk": open `},
+		{`import("bad.mk")`, `import "bad.mk": parse error`},
+		{`import("return.mk")`, `import "return.mk": return at module top level`},
+		{`let x = 1; import("scope.mk")`, `import "scope.mk": undefined variable: x`},
+		{`let p = "a.mk"; import(p)`, "import path must be a string literal"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := runModuleProgram(t, dir, tt.input)
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Fatalf("wrong error. want %q, got=%v", tt.expected, err)
+			}
+		})
+	}
+
+	a, b := filepath.Join(dir, "a.mk"), filepath.Join(dir, "b.mk")
+	_, err := runModuleProgram(t, dir, `import("a.mk")`)
+	if want := "import cycle: " + a + " -> " + b + " -> " + a; err == nil || !strings.HasSuffix(err.Error(), want) {
+		t.Fatalf("expected cycle %q, got=%v", want, err)
+	}
+
+	// 入口文件本身参与的循环导入
+	comp := compiler.New()
+	comp.SetFile(a)
+	comp.SetDir(dir)
+	if err := comp.Compile(parse(`let b = import("b.mk");`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	err = New(comp.Bytecode()).Run(context.Background())
+	if want := "import cycle: " + a + " -> " + b + " -> " + a; err == nil || !strings.HasSuffix(err.Error(), want) {
+		t.Fatalf("expected cycle %q, got=%v", want, err)
+	}
+
+	// 模块在编译期加载，宿主未授予CapabilityFS时编译失败
+	comp = compiler.New()
+	comp.SetDir(dir)
+	comp.SetHost(&object.Host{})
+	err = comp.Compile(parse(`import("bad.mk")`))
+	var permErr *object.PermissionError
+	if !errors.As(err, &permErr) || permErr.Capability != object.CapabilityFS {
+		t.Fatalf("expected permission error, got=%v", err)
+	}
+}
+
+// recordingHook 记录每条指令执行前的调用深度，执行到第stopAfter条指令时返回错误
+type recordingHook struct {
+	depths    []int
+	stopAfter int
+}
+
+var errHookStop = errors.New("stopped by hook")
+
+func (h *recordingHook) BeforeInstruction(vm *VM) error {
+	h.depths = append(h.depths, vm.Depth())
+	if h.stopAfter > 0 && len(h.depths) == h.stopAfter {
+		return errHookStop
+	}
+	return nil
+}
+
+func TestHook(t *testing.T) {
+	comp := compiler.New()
+	if err := comp.Compile(parse("let f = fn(x) { x * 2 }; f(1);")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	hook := &recordingHook{}
+	machine := New(comp.Bytecode())
+	machine.SetHook(hook)
+	if err := machine.Run(context.Background()); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	// OpClosure OpSetGlobal OpGetGlobal OpConstant OpCall，函数体4条，OpPop
+	want := []int{1, 1, 1, 1, 1, 2, 2, 2, 2, 1}
+	if fmt.Sprint(hook.depths) != fmt.Sprint(want) {
+		t.Errorf("wrong depths. want=%v, got=%v", want, hook.depths)
+	}
+
+	hook = &recordingHook{stopAfter: 3}
+	machine = New(comp.Bytecode())
+	machine.SetHook(hook)
+	if err := machine.Run(context.Background()); err != errHookStop {
+		t.Fatalf("expected hook error, got %v", err)
+	}
+}
+
+func TestTracer(t *testing.T) {
+	comp := compiler.New()
+	if err := comp.Compile(parse("let f = fn(x) { x }; f(1);")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	var out bytes.Buffer
+	machine := New(comp.Bytecode())
+	machine.SetHook(Hooks(&recordingHook{}, &Tracer{Out: &out}))
+	if err := machine.Run(context.Background()); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 8 {
+		t.Fatalf("expected 8 traced instructions, got %q", lines)
+	}
+	if lines[0] != "depth=1 sp=0 <main> 0000 OpClosure 0 0" {
+		t.Errorf("wrong first line: %q", lines[0])
+	}
+	if lines[5] != "depth=2 sp=2 f 0000 OpGetLocal 0" {
+		t.Errorf("wrong line in call: %q", lines[5])
+	}
+}
+
+// runOptimized 以优化级别level编译并运行input，返回结果、字节码的长度和运行时的错误
+func runOptimized(t *testing.T, input string, level int) (object.Object, int, error) {
+	t.Helper()
+	comp := compiler.New()
+	comp.SetOptimization(level)
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := comp.Bytecode()
+	if err := Verify(bytecode); err != nil {
+		t.Fatalf("-O%d: verify error: %s", level, err)
+	}
+	machine := New(bytecode)
+	err := machine.Run(context.Background())
+	if err != nil {
+		return nil, len(bytecode.Instructions), err
+	}
+	return machine.LastPoppedStackElem(), len(bytecode.Instructions), nil
+}
+
+func TestOptimizedBytecodeMatches(t *testing.T) {
+	inputs := []string{
+		"1 + 2 * 3 - 4 / 2",
+		"-(5 - 10) * 2",
+		"9223372036854775807 + 1",
+		"-7 / 2",
+		"1 < 2 == true",
+		"!5; !!0; !true",
+		`"a" == "a"; "a" != "b"`,
+		`1 == "1"`,
+		"1 == true",
+		"-true",
+		`"a" + "b"`,
+		"if (true) { 10 } else { 20 }",
+		"if (1 > 2) { 10 }",
+		"if (!(1 == 1)) { 10 } else { 20 + 1 }",
+		"if (0) { 1 } else { 2 }",
+		`let x = 3; if ("x") { x * (2 + 2) }`,
+		"let f = fn(a) { if (a) { if (a > 1) { 1 } else { 2 } } else { 3 } }; [f(2), f(1), f(false)]",
+		"let g = fn(n) { if (n > 0) { return n * (1 + 1); } 4 - 4 }; g(3) + g(-1)",
+		`let h = {"a": 1 + 1, "b": 2}; [h["a"], h["b"], h["c"]]`,
+		"let a = [1, 1 + 0, 2 - 1]; a[3 - 3] + a[1] + a[2]",
+		"let x = 1000; [x + 23, x + 24, x - 2000, x - -5]",
+		`let s = "a"; s + 1`,
+		`let s = "a"; if (s == "a") { 1 } else { 2 }`,
+		"let b = true; if (b != false) { 1 } else { 2 }",
+		`let s = "a"; if (s > 1) { 1 }`,
+		"let n = 5; if (n < 10) { n - 20 } else { n + 1 }",
+		"let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(15)",
+	}
+
+	for _, input := range inputs {
+		t.Run(input, func(t *testing.T) {
+			want, plainSize, wantErr := runOptimized(t, input, compiler.OptimizeNone)
+			for _, level := range []int{compiler.OptimizeConstant, compiler.OptimizeJumps, compiler.OptimizeFused} {
+				got, size, gotErr := runOptimized(t, input, level)
+				if fmt.Sprint(gotErr) != fmt.Sprint(wantErr) {
+					t.Fatalf("-O%d: wrong error. want=%v, got=%v", level, wantErr, gotErr)
+				}
+				if wantErr == nil && !got.Equals(want) {
+					t.Errorf("-O%d: wrong result. want=%s, got=%s", level, want.Inspect(), got.Inspect())
+				}
+				if size > plainSize {
+					t.Errorf("-O%d: bytecode grew from %d to %d bytes", level, plainSize, size)
+				}
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	fn := func(numLocals int, ins ...[]byte) *object.CompiledFunction {
+		return &object.CompiledFunction{Instructions: concat(ins...), NumLocals: numLocals}
+	}
+	tests := []struct {
+		name     string
+		bytecode *compiler.Bytecode
+		expected string
+	}{
+		{
+			"local out of range",
+			&compiler.Bytecode{
+				Instructions: concat(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+				Constants:    []object.Object{fn(1, code.Make(code.OpGetLocal, 1), code.Make(code.OpReturnValue))},
+			},
+			"invalid bytecode in function (constant 0): 0000 OpGetLocal: local 1 out of range, have 1",
+		},
+		{
+			"free variable not captured",
+			&compiler.Bytecode{
+				Instructions: concat(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+				Constants:    []object.Object{fn(0, code.Make(code.OpGetFree, 0), code.Make(code.OpReturnValue))},
+			},
+			"invalid bytecode in function (constant 0): 0000 OpGetFree: free variable 0 out of range, have 0",
+		},
+		{
+			"different captures",
+			&compiler.Bytecode{
+				Instructions: concat(
+					code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop),
+					code.Make(code.OpTrue), code.Make(code.OpClosure, 0, 1), code.Make(code.OpPop),
+				),
+				Constants: []object.Object{fn(0, code.Make(code.OpReturn))},
+			},
+			"invalid bytecode: closures of constant 0 capture both 0 and 1 variables",
+		},
+		{
+			"main",
+			&compiler.Bytecode{Instructions: concat(code.Make(code.OpConstant, 0))},
+			"invalid bytecode in main program: 0000 OpConstant: constant 0 out of range, have 0",
+		},
+	}
+
+	for _, tt := range tests {
+		err := Verify(tt.bytecode)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%s: wrong error. want=%q, got=%v", tt.name, tt.expected, err)
+		}
+	}
+}
+
+func concat(ins ...[]byte) code.Instructions {
+	out := code.Instructions{}
+	for _, in := range ins {
+		out = append(out, in...)
+	}
+	return out
+}
+
+func TestGrowableStack(t *testing.T) {
+	input := "let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(5000)"
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler fail.%s", err)
+	}
+	bytecode := comp.Bytecode()
+
+	machine := New(bytecode)
+	if err := machine.Run(context.Background()); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 5000, machine.LastPoppedStackElem())
+	if len(machine.Globals()) != 1 {
+		t.Errorf("globals not sized from the symbol table. got=%d", len(machine.Globals()))
+	}
+	machine.Release()
+
+	machine = New(bytecode)
+	machine.SetMaxStackSize(1000)
+	err := machine.Run(context.Background())
+	if err == nil || err.Error() != "stack overflow" {
+		t.Fatalf("expected stack overflow, got=%v", err)
+	}
+	machine.Release()
+}
+
+func TestRelease(t *testing.T) {
+	for i := 0; i < 3; i++ {
+		comp := compiler.New()
+		if err := comp.Compile(parse(fmt.Sprintf("let a = [%d]; let b = a; b[0] + 1", i))); err != nil {
+			t.Fatalf("compiler fail.%s", err)
+		}
+		// 放回池中的虚拟机被复用时不应残留上一次执行的状态
+		machine := New(comp.Bytecode())
+		if err := machine.Run(context.Background()); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		testExpectedObject(t, i+1, machine.LastPoppedStackElem())
+		if machine.Depth() != 1 {
+			t.Fatalf("wrong depth after run. got=%d", machine.Depth())
+		}
+		machine.Release()
+	}
+}